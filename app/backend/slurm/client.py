@@ -263,8 +263,17 @@ try:
     stdout = (p.stdout or "")[:4000]
     stderr = (p.stderr or "")[:4000]
 
-    if p.returncode != 0:
-        raise RuntimeError(f"ls_worker failed rc={{p.returncode}}\\nSTDOUT:\\n{{stdout}}\\nSTDERR:\\n{{stderr}}")
+    # коды выхода ls_worker: при 0 (done), 3 (timeout/node_limit) и 4
+    # (no_solution) задача отработала и ответ лежит в out.json; остальные —
+    # сбой, причину берём из кода, а подробности — из stdout/stderr воркера
+    if p.returncode not in (0, 3, 4):
+        reason = {{
+            1: "failed",
+            2: "rejected the request",
+            # ответ посчитан, но out.json не записан (каталог, права, место)
+            5: f"could not write {{out_path}}",
+        }}.get(p.returncode, "failed")
+        raise RuntimeError(f"ls_worker {{reason}} rc={{p.returncode}}\\nSTDOUT:\\n{{stdout}}\\nSTDERR:\\n{{stderr}}")
 
     out = json.loads(open(out_path, "r", encoding="utf-8").read())
 
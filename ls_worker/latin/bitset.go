@@ -0,0 +1,31 @@
+package latin
+
+import "math/bits"
+
+// bitset: набор символов 0..n-1, без ограничения n <= 64
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) Set(v int) {
+	b[v>>6] |= 1 << uint(v&63)
+}
+
+func (b bitset) Clear(v int) {
+	b[v>>6] &^= 1 << uint(v&63)
+}
+
+func (b bitset) Test(v int) bool {
+	return b[v>>6]&(1<<uint(v&63)) != 0
+}
+
+// OrCount returns the number of symbols set in b|o.
+func (b bitset) OrCount(o bitset) int {
+	c := 0
+	for w := range b {
+		c += bits.OnesCount64(b[w] | o[w])
+	}
+	return c
+}
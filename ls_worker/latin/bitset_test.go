@@ -0,0 +1,40 @@
+package latin
+
+import "testing"
+
+func TestBitsetBeyondOneWord(t *testing.T) {
+	tests := []struct {
+		n    int
+		set  []int
+		want int // OrCount с набором {0, n-1}
+	}{
+		{n: 1, set: []int{0}, want: 1},
+		{n: 64, set: []int{0, 63}, want: 2},
+		{n: 65, set: []int{63, 64}, want: 3},
+		{n: 130, set: []int{1, 64, 127, 129}, want: 5},
+	}
+	for _, tt := range tests {
+		b, o := newBitset(tt.n), newBitset(tt.n)
+		for _, v := range tt.set {
+			b.Set(v)
+		}
+		o.Set(0)
+		o.Set(tt.n - 1)
+		for v := 0; v < tt.n; v++ {
+			want := false
+			for _, x := range tt.set {
+				want = want || x == v
+			}
+			if b.Test(v) != want {
+				t.Errorf("n=%d: Test(%d) = %v, want %v", tt.n, v, !want, want)
+			}
+		}
+		if got := b.OrCount(o); got != tt.want {
+			t.Errorf("n=%d: OrCount = %d, want %d", tt.n, got, tt.want)
+		}
+		b.Clear(tt.set[len(tt.set)-1])
+		if b.Test(tt.set[len(tt.set)-1]) {
+			t.Errorf("n=%d: Clear(%d) left the bit set", tt.n, tt.set[len(tt.set)-1])
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package latin
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Counter tallies completions without keeping them. Count is exact while it
+// fits in int64; past math.MaxInt64 it stays there and Overflow is set. With
+// a Modulus (a prime, set before counting) Mod holds the exact count modulo
+// it, overflow or not.
+type Counter struct {
+	Modulus  int64
+	Count    int64
+	Mod      int64
+	Overflow bool
+}
+
+// Add adds k ≥ 0 completions.
+func (c *Counter) Add(k int64) {
+	if c.Count > math.MaxInt64-k {
+		c.Count, c.Overflow = math.MaxInt64, true
+	} else if !c.Overflow {
+		c.Count += k
+	}
+	if c.Modulus > 0 {
+		c.Mod = int64((uint64(c.Mod) + uint64(k%c.Modulus)) % uint64(c.Modulus))
+	}
+}
+
+// Mul multiplies the tally by k ≥ 0, e.g. to undo a symmetry reduction.
+// A product by 0 is exactly 0 even after an overflow.
+func (c *Counter) Mul(k int64) {
+	if k == 0 {
+		c.Count, c.Mod, c.Overflow = 0, 0, false
+		return
+	}
+	hi, lo := bits.Mul64(uint64(c.Count), uint64(k))
+	if hi != 0 || lo > math.MaxInt64 {
+		c.Count, c.Overflow = math.MaxInt64, true
+	} else if !c.Overflow {
+		c.Count = int64(lo)
+	}
+	if c.Modulus > 0 {
+		hi, lo = bits.Mul64(uint64(c.Mod), uint64(k%c.Modulus))
+		_, rem := bits.Div64(hi%uint64(c.Modulus), lo, uint64(c.Modulus))
+		c.Mod = int64(rem)
+	}
+}
@@ -0,0 +1,52 @@
+package latin
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestCounterSaturates(t *testing.T) {
+	const p = 1_000_000_007
+	type op struct {
+		mul bool
+		k   int64
+	}
+	tests := []struct {
+		name     string
+		start    int64
+		ops      []op
+		count    int64
+		overflow bool
+	}{
+		{"add to the edge", math.MaxInt64 - 3, []op{{false, 3}}, math.MaxInt64, false},
+		{"add past the edge", math.MaxInt64 - 3, []op{{false, 4}}, math.MaxInt64, true},
+		{"add after saturation", math.MaxInt64 - 3, []op{{false, 4}, {false, 1}, {false, 0}}, math.MaxInt64, true},
+		{"mul past the edge", 1 << 62, []op{{true, 2}}, math.MaxInt64, true},
+		{"mul by 1", 7, []op{{true, 1}}, 7, false},
+		{"mul by 0", 7, []op{{true, 0}}, 0, false},
+		{"mul by 1 after saturation", 1 << 62, []op{{true, 2}, {true, 1}}, math.MaxInt64, true},
+		// ноль известен точно — переполнение сбрасывается
+		{"mul by 0 after saturation", 1 << 62, []op{{true, 2}, {true, 0}}, 0, false},
+		{"add after mul by 0", 1 << 62, []op{{true, 3}, {true, 0}, {false, 5}}, 5, false},
+	}
+	for _, tt := range tests {
+		c := Counter{Modulus: p, Count: tt.start, Mod: tt.start % p}
+		exact := big.NewInt(tt.start) // полное число — по нему сверяем Mod
+		for _, o := range tt.ops {
+			if o.mul {
+				c.Mul(o.k)
+				exact.Mul(exact, big.NewInt(o.k))
+			} else {
+				c.Add(o.k)
+				exact.Add(exact, big.NewInt(o.k))
+			}
+		}
+		if c.Count != tt.count || c.Overflow != tt.overflow {
+			t.Errorf("%s: count %d, overflow %v; want %d, %v", tt.name, c.Count, c.Overflow, tt.count, tt.overflow)
+		}
+		if want := new(big.Int).Mod(exact, big.NewInt(p)).Int64(); c.Mod != want {
+			t.Errorf("%s: mod %d, want %d", tt.name, c.Mod, want)
+		}
+	}
+}
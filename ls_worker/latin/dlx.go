@@ -0,0 +1,362 @@
+package latin
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// DLX completes a partial Latin square with Knuth's Algorithm X over dancing
+// links. The completion is an exact cover: every empty cell, every missing
+// (row, value) and (column, value) pair, and in the optional modes every
+// missing diagonal or box value is covered by exactly one placement. The
+// exported fields mirror Solver; set them before Solve.
+type DLX struct {
+	Ctx          context.Context
+	Rng          *rand.Rand // порядок строк матрицы; nil — без перемешивания
+	Deadline     time.Time  // нулевое значение — без дедлайна
+	MaxNodes     int64
+	MaxSolutions int
+	CheckEvery   int64
+	// OnSolution, если задан, получает каждое решение сразу (sq нельзя сохранять);
+	// тогда в Solutions остаётся только первое
+	OnSolution func(index int, sq [][]int)
+	// CountOnly: перебрать все решения, только считая их в Found
+	CountOnly bool
+	// OnTick — как у Solver: число узлов с прошлой сверки с часами
+	OnTick func(nodes int64)
+	// Trace: записать в Path размещения первого решения в порядке выбора
+	Trace bool
+	// Distinct — как у Solver: решения с повторным ключом пропускаются
+	Distinct func(sq [][]int) string
+
+	Nodes     int64
+	Found     int
+	Solutions [][][]int
+	Tally     Counter // как у Solver
+	Path      [][3]int
+
+	board    [][]int
+	n        int
+	diagonal bool
+	boxes    bool
+	forbid   map[[3]int]bool // (i, j, v), запрещённые Forbid
+
+	// узлы матрицы; 0 — корень, 1..cols — заголовки столбцов
+	left, right, up, down, col []int
+	size                       []int // число узлов в столбце
+	place                      []int // узел -> индекс размещения в places
+	places                     [][3]int
+	chosen                     []int // выбранные узлы по глубине
+
+	untilCheck int64
+	tickNodes  int64
+	timedOut   bool
+	cancelled  bool
+	stopped    bool
+	seen       map[string]bool
+}
+
+// NewDLX prepares an exact-cover solver for board, where -1 marks an empty
+// cell. The board must not already contain duplicates.
+func NewDLX(board [][]int) *DLX {
+	return &DLX{
+		board:      DeepCopy(board),
+		n:          len(board),
+		CheckEvery: defaultCheckEvery,
+	}
+}
+
+// EnableDiagonals adds the main and anti diagonals as constraints.
+func (d *DLX) EnableDiagonals() { d.diagonal = true }
+
+// EnableBoxes adds the √n × √n boxes as constraints; n must be a perfect square.
+func (d *DLX) EnableBoxes() { d.boxes = true }
+
+// Forbid excludes v from the empty cell (i,j).
+func (d *DLX) Forbid(i, j, v int) {
+	if d.forbid == nil {
+		d.forbid = make(map[[3]int]bool)
+	}
+	d.forbid[[3]int{i, j, v}] = true
+}
+
+// Solve runs Algorithm X and returns whether a solution was found, the status
+// (done | no_solution | timeout | node_limit | cancelled) and the node count.
+func (d *DLX) Solve() (bool, string, int64) {
+	d.build()
+	d.search(0)
+	if len(d.Solutions) > 0 {
+		return true, "done", d.Nodes
+	}
+	if r := d.stopReason(); r != "" {
+		return false, r, d.Nodes
+	}
+	return false, "no_solution", d.Nodes
+}
+
+// Exhausted reports whether the last Solve walked the whole search tree.
+func (d *DLX) Exhausted() bool {
+	return d.stopReason() == ""
+}
+
+func (d *DLX) stopReason() string {
+	switch {
+	case d.cancelled:
+		return "cancelled"
+	case d.timedOut || pastDeadline(d.Deadline, time.Now()):
+		return "timeout"
+	case d.MaxNodes > 0 && d.Nodes >= d.MaxNodes:
+		return "node_limit"
+	}
+	return ""
+}
+
+// build lays out the matrix: one column per constraint the prefix leaves
+// open, one row per value that may still go to an empty cell.
+func (d *DLX) build() {
+	n := d.n
+	side, _ := BoxSide(n)
+	// номера ограничений: клетка, (строка,v), (столбец,v), диагонали, блоки
+	const (
+		kCell = iota
+		kRow
+		kCol
+		kDiag
+		kAnti
+		kBox
+		kinds
+	)
+	id := func(kind, a, v int) int { return (kind*n+a)*n + v }
+	used := make([]bool, kinds*n*n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			v := d.board[i][j]
+			if v < 0 {
+				continue
+			}
+			used[id(kCell, i, j)] = true
+			used[id(kRow, i, v)] = true
+			used[id(kCol, j, v)] = true
+			if d.diagonal && i == j {
+				used[id(kDiag, 0, v)] = true
+			}
+			if d.diagonal && i+j == n-1 {
+				used[id(kAnti, 0, v)] = true
+			}
+			if d.boxes {
+				used[id(kBox, i/side*side+j/side, v)] = true
+			}
+		}
+	}
+	// открытые ограничения становятся столбцами
+	colOf := make([]int, len(used))
+	cols := 0
+	for k := 0; k < len(used); k++ {
+		kind, a := k/(n*n), k/n%n
+		open := !used[k]
+		switch kind {
+		case kDiag, kAnti:
+			open = open && d.diagonal && a == 0
+		case kBox:
+			open = open && d.boxes
+		}
+		if open {
+			cols++
+			colOf[k] = cols
+		}
+	}
+
+	d.places = d.places[:0]
+	var rows [][]int
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if d.board[i][j] >= 0 {
+				continue
+			}
+			for v := 0; v < n; v++ {
+				if d.forbid[[3]int{i, j, v}] {
+					continue
+				}
+				ks := []int{id(kCell, i, j), id(kRow, i, v), id(kCol, j, v)}
+				if d.diagonal && i == j {
+					ks = append(ks, id(kDiag, 0, v))
+				}
+				if d.diagonal && i+j == n-1 {
+					ks = append(ks, id(kAnti, 0, v))
+				}
+				if d.boxes {
+					ks = append(ks, id(kBox, i/side*side+j/side, v))
+				}
+				row := make([]int, 0, len(ks))
+				for _, k := range ks {
+					if colOf[k] == 0 {
+						row = nil // v уже стоит в этой строке/столбце/диагонали/блоке
+						break
+					}
+					row = append(row, colOf[k])
+				}
+				if row != nil {
+					d.places = append(d.places, [3]int{i, j, v})
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+	if d.Rng != nil {
+		d.Rng.Shuffle(len(rows), func(a, b int) {
+			rows[a], rows[b] = rows[b], rows[a]
+			d.places[a], d.places[b] = d.places[b], d.places[a]
+		})
+	}
+
+	total := cols + 1
+	for _, row := range rows {
+		total += len(row)
+	}
+	d.left, d.right = make([]int, total), make([]int, total)
+	d.up, d.down = make([]int, total), make([]int, total)
+	d.col, d.place = make([]int, total), make([]int, total)
+	d.size = make([]int, cols+1)
+	for c := 0; c <= cols; c++ {
+		d.left[c], d.right[c] = (c+cols)%(cols+1), (c+1)%(cols+1)
+		d.up[c], d.down[c], d.col[c] = c, c, c
+	}
+	node := cols + 1
+	for r, row := range rows {
+		first := node
+		for _, c := range row {
+			d.col[node], d.place[node] = c, r
+			d.up[node], d.down[node] = d.up[c], c
+			d.down[d.up[c]] = node
+			d.up[c] = node
+			d.size[c]++
+			d.left[node], d.right[node] = node-1, first
+			if node > first {
+				d.right[node-1] = node
+			}
+			node++
+		}
+		d.left[first] = node - 1
+	}
+	d.chosen = make([]int, 0, n*n)
+}
+
+func (d *DLX) cover(c int) {
+	d.right[d.left[c]], d.left[d.right[c]] = d.right[c], d.left[c]
+	for i := d.down[c]; i != c; i = d.down[i] {
+		for j := d.right[i]; j != i; j = d.right[j] {
+			d.down[d.up[j]], d.up[d.down[j]] = d.down[j], d.up[j]
+			d.size[d.col[j]]--
+		}
+	}
+}
+
+func (d *DLX) uncover(c int) {
+	for i := d.up[c]; i != c; i = d.up[i] {
+		for j := d.left[i]; j != i; j = d.left[j] {
+			d.size[d.col[j]]++
+			d.down[d.up[j]], d.up[d.down[j]] = j, j
+		}
+	}
+	d.right[d.left[c]], d.left[d.right[c]] = c, c
+}
+
+// search returns true once enough solutions are collected; d.stopped means
+// a budget ran out.
+func (d *DLX) search(depth int) bool {
+	if d.expired() || (d.MaxNodes > 0 && d.Nodes >= d.MaxNodes) {
+		d.stopped = true
+		return false
+	}
+	if d.right[0] == 0 {
+		return d.solution()
+	}
+	// столбец с наименьшим числом вариантов (эвристика S Кнута)
+	c := d.right[0]
+	for k := d.right[c]; k != 0; k = d.right[k] {
+		if d.size[k] < d.size[c] {
+			c = k
+		}
+	}
+	if d.size[c] == 0 {
+		return false
+	}
+	d.cover(c)
+	done := false
+	for r := d.down[c]; r != c && !done && !d.stopped; r = d.down[r] {
+		d.Nodes++
+		d.chosen = append(d.chosen, r)
+		for j := d.right[r]; j != r; j = d.right[j] {
+			d.cover(d.col[j])
+		}
+		done = d.search(depth + 1)
+		for j := d.left[r]; j != r; j = d.left[j] {
+			d.uncover(d.col[j])
+		}
+		d.chosen = d.chosen[:depth]
+	}
+	d.uncover(c)
+	return done
+}
+
+// solution records the board given by the chosen rows.
+func (d *DLX) solution() bool {
+	if d.CountOnly && d.Distinct == nil {
+		d.Found++
+		d.Tally.Add(1)
+		return false
+	}
+	sq := DeepCopy(d.board)
+	for _, r := range d.chosen {
+		p := d.places[d.place[r]]
+		sq[p[0]][p[1]] = p[2]
+	}
+	if d.Distinct != nil {
+		key := d.Distinct(sq)
+		if d.seen[key] {
+			return false
+		}
+		if d.seen == nil {
+			d.seen = map[string]bool{}
+		}
+		d.seen[key] = true
+	}
+	d.Found++
+	if d.CountOnly {
+		d.Tally.Add(1)
+		return false
+	}
+	if d.Trace && len(d.Solutions) == 0 {
+		for _, r := range d.chosen {
+			d.Path = append(d.Path, d.places[d.place[r]])
+		}
+	}
+	if d.OnSolution != nil {
+		d.OnSolution(d.Found-1, sq)
+	}
+	if d.OnSolution == nil || len(d.Solutions) == 0 {
+		d.Solutions = append(d.Solutions, sq)
+	}
+	return d.Found >= max(d.MaxSolutions, 1)
+}
+
+// expired reports whether the deadline has passed or ctx was cancelled,
+// consulting the clock only once per CheckEvery calls.
+func (d *DLX) expired() bool {
+	if d.timedOut || d.cancelled {
+		return true
+	}
+	d.untilCheck--
+	if d.untilCheck > 0 {
+		return false
+	}
+	d.untilCheck = d.CheckEvery
+	if d.OnTick != nil {
+		d.OnTick(d.Nodes - d.tickNodes)
+		d.tickNodes = d.Nodes
+	}
+	d.cancelled = d.Ctx != nil && d.Ctx.Err() != nil
+	d.timedOut = pastDeadline(d.Deadline, time.Now())
+	return d.timedOut || d.cancelled
+}
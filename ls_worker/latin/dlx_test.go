@@ -0,0 +1,104 @@
+package latin
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDLXAgreesWithDFS(t *testing.T) {
+	withPrefix := func(n int, cells ...[3]int) [][]int {
+		b := emptyBoard(n)
+		for _, c := range cells {
+			b[c[0]][c[1]] = c[2]
+		}
+		return b
+	}
+	tests := []struct {
+		name     string
+		board    [][]int
+		diagonal bool
+		boxes    bool
+		forbid   [][3]int
+	}{
+		{name: "empty 4x4", board: emptyBoard(4)},
+		{name: "first row 5x5", board: withPrefix(5, [3]int{0, 0, 0}, [3]int{0, 1, 1}, [3]int{0, 2, 2}, [3]int{0, 3, 3}, [3]int{0, 4, 4})},
+		{name: "scattered 5x5", board: withPrefix(5, [3]int{0, 0, 1}, [3]int{2, 3, 1}, [3]int{4, 1, 3})},
+		// (0,3) может быть только 3, а 3 уже стоит в столбце
+		{name: "no completion", board: withPrefix(4, [3]int{0, 0, 0}, [3]int{0, 1, 1}, [3]int{0, 2, 2}, [3]int{1, 3, 3})},
+		{name: "diagonal 5x5", board: emptyBoard(5), diagonal: true},
+		{name: "diagonal 3x3", board: emptyBoard(3), diagonal: true},
+		{name: "boxes 4x4", board: withPrefix(4, [3]int{0, 0, 0}), boxes: true},
+		{name: "forbidden 4x4", board: emptyBoard(4), forbid: [][3]int{{0, 0, 0}, {1, 1, 1}, {2, 2, 2}, {3, 3, 3}}},
+	}
+	for _, tt := range tests {
+		s := NewSolver(tt.board, nil)
+		d := NewDLX(tt.board)
+		if tt.diagonal {
+			s.EnableDiagonals()
+			d.EnableDiagonals()
+		}
+		if tt.boxes {
+			s.EnableBoxes()
+			d.EnableBoxes()
+		}
+		for _, f := range tt.forbid {
+			s.Forbid(f[0], f[1], f[2])
+			d.Forbid(f[0], f[1], f[2])
+		}
+		s.CountOnly, d.CountOnly = true, true
+		s.Solve()
+		d.Solve()
+		if s.Found != d.Found {
+			t.Errorf("%s: DFS counted %d, DLX %d", tt.name, s.Found, d.Found)
+		}
+
+		// одно решение: существование совпадает, квадрат корректен
+		one := NewDLX(tt.board)
+		if tt.diagonal {
+			one.EnableDiagonals()
+		}
+		if tt.boxes {
+			one.EnableBoxes()
+		}
+		for _, f := range tt.forbid {
+			one.Forbid(f[0], f[1], f[2])
+		}
+		ok, status, _ := one.Solve()
+		if ok != (s.Found > 0) {
+			t.Errorf("%s: DLX found=%v (%s), DFS counted %d", tt.name, ok, status, s.Found)
+			continue
+		}
+		if ok && !IsLatinSquare(one.Solutions[0]) {
+			t.Errorf("%s: DLX square %v is not Latin", tt.name, one.Solutions[0])
+		}
+	}
+}
+
+func TestDLXTrace(t *testing.T) {
+	tests := []struct {
+		name  string
+		board [][]int
+		boxes bool
+	}{
+		{name: "empty 5x5", board: emptyBoard(5)},
+		{name: "sparse 8x8", board: nearlyComplete(8, 0.3, 3)},
+		{name: "boxes 9x9", board: emptyBoard(9), boxes: true},
+	}
+	for _, tt := range tests {
+		d := NewDLX(tt.board)
+		d.Trace = true
+		if tt.boxes {
+			d.EnableBoxes()
+		}
+		if ok, _, _ := d.Solve(); !ok {
+			t.Fatalf("%s: no solution", tt.name)
+		}
+		got, err := replayPath(tt.board, d.Path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !slices.EqualFunc(got, d.Solutions[0], slices.Equal) {
+			t.Errorf("%s: replayed %v, solution %v", tt.name, got, d.Solutions[0])
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package latin
+
+// GF(p^m): конструкция полного набора MOLS
+
+// GaloisMOLS returns the squares L_a[i][j] = a*i + j over GF(n) for the
+// nonzero elements a = 1..k. It reports false if n is not a prime power.
+func GaloisMOLS(n, k int) ([][][]int, bool) {
+	f, ok := newGaloisField(n)
+	if !ok {
+		return nil, false
+	}
+	L := make([][][]int, k)
+	for a := 1; a <= k; a++ {
+		sq := make([][]int, n)
+		for i := 0; i < n; i++ {
+			ai := f.mul(a, i)
+			sq[i] = make([]int, n)
+			for j := 0; j < n; j++ {
+				sq[i][j] = f.add(ai, j)
+			}
+		}
+		L[a-1] = sq
+	}
+	return L, true
+}
+
+// galoisField is GF(p^m); an element e is the polynomial whose i-th
+// coefficient is the i-th base-p digit of e.
+type galoisField struct {
+	p, m int
+	irr  []int // монический неприводимый многочлен степени m, коэффициенты от младшего
+}
+
+func newGaloisField(n int) (*galoisField, bool) {
+	p, m, ok := primePower(n)
+	if !ok {
+		return nil, false
+	}
+	f := &galoisField{p: p, m: m}
+	// перебираем монические многочлены степени m до первого неприводимого
+	for low := 0; low < n; low++ {
+		cand := append(f.digits(low), 1)
+		if polyIrreducible(cand, p) {
+			f.irr = cand
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// primePower reports whether n = p^m for a prime p and m >= 1.
+func primePower(n int) (p, m int, ok bool) {
+	if n < 2 {
+		return 0, 0, false
+	}
+	for p = 2; p*p <= n; p++ {
+		if n%p == 0 {
+			break
+		}
+	}
+	if n%p != 0 {
+		p = n // n простое
+	}
+	for n%p == 0 {
+		n /= p
+		m++
+	}
+	return p, m, n == 1
+}
+
+func (f *galoisField) digits(e int) []int {
+	d := make([]int, f.m)
+	for i := 0; i < f.m; i++ {
+		d[i] = e % f.p
+		e /= f.p
+	}
+	return d
+}
+
+func (f *galoisField) value(d []int) int {
+	e := 0
+	for i := f.m - 1; i >= 0; i-- {
+		e = e*f.p + d[i]
+	}
+	return e
+}
+
+func (f *galoisField) add(a, b int) int {
+	da, db := f.digits(a), f.digits(b)
+	for i := range da {
+		da[i] = (da[i] + db[i]) % f.p
+	}
+	return f.value(da)
+}
+
+func (f *galoisField) mul(a, b int) int {
+	da, db := f.digits(a), f.digits(b)
+	prod := make([]int, 2*f.m-1)
+	for i, x := range da {
+		for j, y := range db {
+			prod[i+j] = (prod[i+j] + x*y) % f.p
+		}
+	}
+	// редукция по модулю irr (старший коэффициент 1)
+	for d := len(prod) - 1; d >= f.m; d-- {
+		c := prod[d]
+		if c == 0 {
+			continue
+		}
+		for t := 0; t <= f.m; t++ {
+			prod[d-f.m+t] = ((prod[d-f.m+t]-c*f.irr[t])%f.p + f.p) % f.p
+		}
+	}
+	return f.value(prod[:f.m])
+}
+
+// polyIrreducible reports whether the monic polynomial a over GF(p) has no
+// monic divisor of degree 1..deg(a)/2 (пробное деление).
+func polyIrreducible(a []int, p int) bool {
+	deg := len(a) - 1
+	for dg := 1; dg <= deg/2; dg++ {
+		// все монические делители степени dg
+		count := 1
+		for i := 0; i < dg; i++ {
+			count *= p
+		}
+		for low := 0; low < count; low++ {
+			g := make([]int, dg+1)
+			for i, x := 0, low; i < dg; i++ {
+				g[i] = x % p
+				x /= p
+			}
+			g[dg] = 1
+			if polyDivides(g, a, p) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// polyDivides reports whether the monic polynomial g divides a over GF(p).
+func polyDivides(g, a []int, p int) bool {
+	r := append([]int(nil), a...)
+	dg := len(g) - 1
+	for d := len(r) - 1; d >= dg; d-- {
+		c := r[d]
+		if c == 0 {
+			continue
+		}
+		for t := 0; t <= dg; t++ {
+			r[d-dg+t] = ((r[d-dg+t]-c*g[t])%p + p) % p
+		}
+	}
+	for _, x := range r[:dg] {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
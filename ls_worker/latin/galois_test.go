@@ -0,0 +1,62 @@
+package latin
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestGaloisMOLS(t *testing.T) {
+	tests := []struct {
+		n, k int
+		ok   bool
+	}{
+		{n: 2, k: 1, ok: true},
+		{n: 5, k: 4, ok: true}, // простое
+		{n: 8, k: 7, ok: true}, // 2^3
+		{n: 9, k: 8, ok: true}, // 3^2
+		{n: 16, k: 3, ok: true},
+		{n: 6, k: 2, ok: false},
+		{n: 10, k: 2, ok: false},
+		{n: 12, k: 2, ok: false},
+	}
+	for _, tt := range tests {
+		L, ok := GaloisMOLS(tt.n, tt.k)
+		if ok != tt.ok {
+			t.Errorf("n=%d: ok = %v, want %v", tt.n, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if len(L) != tt.k {
+			t.Errorf("n=%d: %d squares, want %d", tt.n, len(L), tt.k)
+		}
+		if ok, msg := VerifyMOLS(L); !ok {
+			t.Errorf("n=%d k=%d: %s", tt.n, tt.k, msg)
+		}
+	}
+}
+
+func TestSearchMOLSGaloisNoSteps(t *testing.T) {
+	tests := []struct {
+		n, k     int
+		fallback bool
+	}{
+		{n: 5, k: 4},
+		{n: 8, k: 7},
+		{n: 10, k: 2, fallback: true},
+	}
+	for _, tt := range tests {
+		res := SearchMOLS(tt.n, tt.k, MOLSOptions{Rng: rand.New(rand.NewSource(1)), Method: "galois", MaxSteps: 100})
+		if tt.fallback {
+			// не степень простого — обычный поиск и заметка о нём
+			if res.Steps == 0 || len(res.Notes) == 0 {
+				t.Errorf("n=%d: steps %d, notes %q: want a hill_climb fallback", tt.n, res.Steps, res.Notes)
+			}
+			continue
+		}
+		if res.Steps != 0 || res.Conflicts != 0 || len(res.Squares) != tt.k {
+			t.Errorf("n=%d k=%d: steps %d, conflicts %d, %d squares", tt.n, tt.k, res.Steps, res.Conflicts, len(res.Squares))
+		}
+	}
+}
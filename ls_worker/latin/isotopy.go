@@ -0,0 +1,295 @@
+package latin
+
+import "sort"
+
+// maxCanonicalWork bounds CanonicalForm in cell writes; squares with a large
+// autotopism group (e.g. Cayley tables of Z_2^k) exceed it.
+const maxCanonicalWork = 1 << 27
+
+// CanonicalForm returns a canonical representative of L's isotopy class:
+// two Latin squares get the same form iff one turns into the other by
+// permuting rows, columns and symbols. With conjugates the six conjugates of
+// L (transpose included) are tried as well, which gives the main class
+// instead. The form is reduced: row 0 reads 0..n-1. ok is false when the
+// search would take more than maxCanonicalWork steps.
+//
+// Row 0 and row 1 of a form come from some ordered pair of rows (r0, r1):
+// row 0 is made the identity, which ties the column order to the symbol
+// relabeling γ, and then row 1 reads γσγ⁻¹, where σ maps each symbol of row
+// r0 to the one below it in row r1. The cycle type of σ does not change under
+// isotopy, so only pairs whose σ has the smallest centralizer are used, and
+// γ runs over the relabelings that turn σ into a fixed permutation of that
+// cycle type. The remaining rows are sorted; the smallest grid wins.
+func CanonicalForm(L [][]int, conjugates bool) ([][]int, bool) {
+	n := len(L)
+	views := [][][]int{L}
+	if conjugates {
+		views = Conjugates(L)
+	}
+	budget := int64(maxCanonicalWork)
+	var best []int
+	for _, v := range views {
+		f, ok := isotopyForm(v, &budget)
+		if !ok {
+			return nil, false
+		}
+		if best == nil || lessInts(f, best) {
+			best = f
+		}
+	}
+	form := make([][]int, n)
+	for i := range form {
+		form[i] = best[i*n : (i+1)*n]
+	}
+	return form, true
+}
+
+// ConjugateRoles lists the six role permutations, identity first and then
+// the transpose; see Conjugate.
+var ConjugateRoles = [6][3]int{{0, 1, 2}, {1, 0, 2}, {0, 2, 1}, {2, 1, 0}, {1, 2, 0}, {2, 0, 1}}
+
+// Conjugate returns the conjugate of the (partial) square L under roles:
+// the triple t = (row, column, symbol) of each filled cell becomes the cell
+// (t[roles[0]], t[roles[1]]) holding t[roles[2]]; -1 marks empty cells. The
+// conjugate of a partial Latin square is one too, and InverseRoles maps it
+// back.
+func Conjugate(L [][]int, roles [3]int) [][]int {
+	n := len(L)
+	M := make([][]int, n)
+	for i := range M {
+		M[i] = make([]int, n)
+		for j := range M[i] {
+			M[i][j] = -1
+		}
+	}
+	for r := 0; r < n; r++ {
+		for c := 0; c < n; c++ {
+			if L[r][c] < 0 {
+				continue
+			}
+			t := [3]int{r, c, L[r][c]}
+			M[t[roles[0]]][t[roles[1]]] = t[roles[2]]
+		}
+	}
+	return M
+}
+
+// InverseRoles returns the roles that undo Conjugate(·, roles).
+func InverseRoles(roles [3]int) [3]int {
+	var inv [3]int
+	for k, r := range roles {
+		inv[r] = k
+	}
+	return inv
+}
+
+// Conjugates returns the six conjugates of the Latin square L, in the order
+// of ConjugateRoles.
+func Conjugates(L [][]int) [][][]int {
+	out := make([][][]int, 0, len(ConjugateRoles))
+	for _, roles := range ConjugateRoles {
+		out = append(out, Conjugate(L, roles))
+	}
+	return out
+}
+
+// isotopyForm returns the flattened isotopy form of L, spending budget.
+func isotopyForm(L [][]int, budget *int64) ([]int, bool) {
+	n := len(L)
+	if n <= 2 {
+		// для n <= 2 квадрат один с точностью до изотопии
+		f := make([]int, 0, n*n)
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				f = append(f, (i+j)%n)
+			}
+		}
+		return f, true
+	}
+	*budget -= int64(n) * int64(n) * int64(n)
+	if *budget < 0 {
+		return nil, false
+	}
+
+	// inv[r][s] — столбец символа s в строке r
+	inv := make([][]int, n)
+	for r := range L {
+		inv[r] = make([]int, n)
+		for c, s := range L[r] {
+			inv[r][s] = c
+		}
+	}
+	sigma := func(r0, r1 int, out []int) {
+		for s := 0; s < n; s++ {
+			out[s] = L[r1][inv[r0][s]]
+		}
+	}
+
+	// пары строк с наименьшим централизатором σ (и наименьшим типом при равенстве)
+	var pairs [][2]int
+	var bestType []int
+	bestCent := int64(-1)
+	sg := make([]int, n)
+	for r0 := 0; r0 < n; r0++ {
+		for r1 := 0; r1 < n; r1++ {
+			if r0 == r1 {
+				continue
+			}
+			sigma(r0, r1, sg)
+			typ := cycleType(sg)
+			cent := centralizer(typ, *budget+1)
+			switch {
+			case bestCent < 0 || cent < bestCent || (cent == bestCent && lessType(typ, bestType)):
+				bestCent, bestType = cent, typ
+				pairs = pairs[:0]
+			case cent != bestCent || !equalInts(typ, bestType):
+				continue
+			}
+			pairs = append(pairs, [2]int{r0, r1})
+		}
+	}
+	if bestCent > *budget || int64(len(pairs))*bestCent > *budget/int64(n*n) {
+		return nil, false
+	}
+	*budget -= int64(len(pairs)) * bestCent * int64(n*n)
+
+	// τ: циклы длин bestType подряд: (0 1 .. l1-1)(l1 .. l1+l2-1)...
+	starts := make([]int, len(bestType))
+	for d := 1; d < len(bestType); d++ {
+		starts[d] = starts[d-1] + bestType[d-1]
+	}
+
+	var best []int
+	gamma := make([]int, n)
+	beta := make([]int, n)
+	flat := make([]int, n*n)
+	rows := make([][]int, n)
+	rest := make([][]int, 0, n)
+	for _, pr := range pairs {
+		r0, r1 := pr[0], pr[1]
+		sigma(r0, r1, sg)
+		cycles := cyclesOf(sg)
+		used := make([]bool, len(cycles))
+
+		emit := func() {
+			for j := 0; j < n; j++ {
+				beta[j] = gamma[L[r0][j]]
+			}
+			for i := 0; i < n; i++ {
+				rows[i] = flat[i*n : (i+1)*n]
+				for j := 0; j < n; j++ {
+					rows[i][beta[j]] = gamma[L[i][j]]
+				}
+			}
+			// r0, r1 — строки 0 и 1, остальные по первому столбцу
+			rest = rest[:0]
+			for i, row := range rows {
+				if i != r0 && i != r1 {
+					rest = append(rest, row)
+				}
+			}
+			sort.Slice(rest, func(a, b int) bool { return rest[a][0] < rest[b][0] })
+			cand := make([]int, 0, n*n)
+			cand = append(append(cand, rows[r0]...), rows[r1]...)
+			for _, row := range rest {
+				cand = append(cand, row...)
+			}
+			if best == nil || lessInts(cand, best) {
+				best = cand
+			}
+		}
+		// assign отдаёт τ-циклу d ещё не занятый σ-цикл той же длины с любым сдвигом
+		var assign func(d int)
+		assign = func(d int) {
+			if d == len(bestType) {
+				emit()
+				return
+			}
+			l := bestType[d]
+			for ci, cyc := range cycles {
+				if used[ci] || len(cyc) != l {
+					continue
+				}
+				used[ci] = true
+				for shift := 0; shift < l; shift++ {
+					for m := 0; m < l; m++ {
+						gamma[cyc[(shift+m)%l]] = starts[d] + m
+					}
+					assign(d + 1)
+				}
+				used[ci] = false
+			}
+		}
+		assign(0)
+	}
+	return best, true
+}
+
+// cycleType returns the sorted cycle lengths of the permutation p.
+func cycleType(p []int) []int {
+	var typ []int
+	for _, c := range cyclesOf(p) {
+		typ = append(typ, len(c))
+	}
+	sort.Ints(typ)
+	return typ
+}
+
+// cyclesOf splits the permutation p into cycles.
+func cyclesOf(p []int) [][]int {
+	seen := make([]bool, len(p))
+	var out [][]int
+	for s := range p {
+		if seen[s] {
+			continue
+		}
+		var cyc []int
+		for x := s; !seen[x]; x = p[x] {
+			seen[x] = true
+			cyc = append(cyc, x)
+		}
+		out = append(out, cyc)
+	}
+	return out
+}
+
+// centralizer returns the order of the centralizer of a permutation with
+// the sorted cycle type typ, ∏ l^{m_l}·m_l!, saturating at limit.
+func centralizer(typ []int, limit int64) int64 {
+	c := int64(1)
+	for k := 0; k < len(typ); {
+		l := typ[k]
+		m := 0
+		for k < len(typ) && typ[k] == l {
+			m++
+			k++
+			c *= int64(l) * int64(m)
+			if c > limit {
+				return limit
+			}
+		}
+	}
+	return c
+}
+
+// lessType orders cycle types lexicographically, a prefix first.
+func lessType(a, b []int) bool {
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+	return true
+}
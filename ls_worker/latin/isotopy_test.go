@@ -0,0 +1,129 @@
+package latin
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// cayley returns the Cayley table of Z_2^k: L[i][j] = i xor j.
+func cayley(k int) [][]int {
+	n := 1 << k
+	L := make([][]int, n)
+	for i := range L {
+		L[i] = make([]int, n)
+		for j := range L[i] {
+			L[i][j] = i ^ j
+		}
+	}
+	return L
+}
+
+func TestCanonicalForm(t *testing.T) {
+	// Z_4 и Z_2^2 — два разных класса изотопии 4x4
+	klein := cayley(2)
+	tests := []struct {
+		name       string
+		a, b       [][]int
+		conjugates bool
+		same       bool
+	}{
+		{"isotopic cyclic 5x5", MakeCyclic(5, 1), MakeCyclic(5, 2), false, true},
+		{"isotopic cyclic 7x7", MakeCyclic(7, 1), MakeCyclic(7, 1), false, true},
+		{"isotopic klein", klein, klein, false, true},
+		{"Z4 vs Z2^2", MakeCyclic(4, 1), klein, false, false},
+		{"Z4 vs Z2^2 main class", MakeCyclic(4, 1), klein, true, false},
+		{"conjugate, main class", sixByBase(), Conjugate(sixByBase(), [3]int{2, 0, 1}), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// b — случайный изотоп: строки, столбцы и символы переставлены
+			b := DeepCopy(tt.b)
+			RandomPermute(b, rand.New(rand.NewSource(7)))
+			fa, ok := CanonicalForm(tt.a, tt.conjugates)
+			if !ok {
+				t.Fatal("a: over the work limit")
+			}
+			fb, ok := CanonicalForm(b, tt.conjugates)
+			if !ok {
+				t.Fatal("b: over the work limit")
+			}
+			for _, f := range [][][]int{fa, fb} {
+				if !IsLatinSquare(f) || !slices.Equal(f[0], MakeCyclic(len(f), 1)[0]) {
+					t.Fatalf("form %v is not a reduced Latin square", f)
+				}
+			}
+			if same := slices.EqualFunc(fa, fb, slices.Equal); same != tt.same {
+				t.Errorf("forms %v and %v: same %v, want %v", fa, fb, same, tt.same)
+			}
+		})
+	}
+}
+
+// sixByBase is a 6x6 Latin square that is not a group table, so its
+// conjugates need not be isotopic to it.
+func sixByBase() [][]int {
+	return [][]int{
+		{0, 1, 2, 3, 4, 5},
+		{1, 0, 3, 2, 5, 4},
+		{2, 4, 0, 5, 1, 3},
+		{3, 5, 4, 0, 2, 1},
+		{4, 2, 5, 1, 3, 0},
+		{5, 3, 1, 4, 0, 2},
+	}
+}
+
+func TestCanonicalFormWorkLimit(t *testing.T) {
+	// у таблицы Кэли Z_2^k огромная группа автотопий: для больших k перебор
+	// упирается в maxCanonicalWork
+	if _, ok := CanonicalForm(cayley(3), false); !ok {
+		t.Error("Z_2^3: over the work limit")
+	}
+	if _, ok := CanonicalForm(cayley(6), false); ok {
+		t.Error("Z_2^6: canonicalized, want the work limit")
+	}
+}
+
+func TestConjugateRoundTrip(t *testing.T) {
+	full := MakeCyclic(5, 2)
+	RandomPermute(full, rand.New(rand.NewSource(1)))
+	// частичный: клетки с чётной суммой индексов
+	partial := DeepCopy(full)
+	for i := range partial {
+		for j := range partial[i] {
+			if (i+j)%2 == 1 {
+				partial[i][j] = -1
+			}
+		}
+	}
+	for _, roles := range ConjugateRoles {
+		for _, L := range [][][]int{full, partial} {
+			c := Conjugate(L, roles)
+			if filledCount(c) != filledCount(L) {
+				t.Errorf("roles %v: %d filled cells, want %d", roles, filledCount(c), filledCount(L))
+			}
+			if filledCount(L) == len(L)*len(L) && !IsLatinSquare(c) {
+				t.Errorf("roles %v: conjugate %v is not Latin", roles, c)
+			}
+			if back := Conjugate(c, InverseRoles(roles)); !slices.EqualFunc(back, L, slices.Equal) {
+				t.Errorf("roles %v: round trip gave %v, want %v", roles, back, L)
+			}
+		}
+	}
+	// транспонирование — вторая роль
+	if c := Conjugate(full, ConjugateRoles[1]); c[1][3] != full[3][1] {
+		t.Errorf("roles %v is not the transpose", ConjugateRoles[1])
+	}
+}
+
+func filledCount(L [][]int) int {
+	k := 0
+	for _, row := range L {
+		for _, v := range row {
+			if v >= 0 {
+				k++
+			}
+		}
+	}
+	return k
+}
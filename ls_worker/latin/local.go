@@ -0,0 +1,306 @@
+package latin
+
+import (
+	"context"
+	"math/bits"
+	"math/rand"
+	"time"
+)
+
+// LocalOptions configures LocalComplete.
+type LocalOptions struct {
+	Ctx      context.Context
+	Rng      *rand.Rand // обязателен: старт и выбор ходов случайные
+	Deadline time.Time  // нулевой — без срока
+	MaxSteps int64
+	// Noise: вероятность сделать случайный обмен вместо лучшего, как шум в
+	// WalkSAT; 0 — defaultLocalNoise
+	Noise float64
+	// OnTick, если задан, раз в progressCheckSteps шагов получает число шагов
+	// и лучшее число конфликтов (для логов; должен быть дешёвым)
+	OnTick func(steps int64, bestConf int)
+}
+
+const defaultLocalNoise = 0.02
+
+// LocalResult is the outcome of LocalComplete. Status is done | no_solution
+// | timeout | cancelled; no_solution only means MaxSteps ran out.
+type LocalResult struct {
+	Square    [][]int // только при Status == done
+	Conflicts int     // наименьшее за поиск число повторов в столбцах
+	Steps     int64
+	Status    string
+}
+
+// LocalComplete completes board (-1 marks an empty cell) by local search
+// instead of backtracking. Every row is kept a permutation whose prefix
+// cells never move, and what is minimized is the number of repeated symbols
+// in the columns. The start is built row by row: each row's missing symbols
+// go to its empty cells by a maximum matching that avoids the symbols
+// already in their columns, which on an empty board is already a Latin
+// square. Then, WalkSAT-style, each step takes a random repeat and a row
+// holding it in an empty cell, and either rematches that whole row against
+// the rest of the square or, with probability Noise, swaps the repeated
+// cell with a random empty cell of the row.
+//
+// The search is incomplete: running out of MaxSteps proves nothing, and a
+// prefix with no completion simply never reaches zero. It pays off for
+// large, sparse boards, where DFS drowns in the size of the tree.
+func LocalComplete(board [][]int, opt LocalOptions) LocalResult {
+	ctx := opt.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	noise := opt.Noise
+	if noise <= 0 {
+		noise = defaultLocalNoise
+	}
+	ls := &localSearch{rng: opt.Rng, n: len(board), L: DeepCopy(board)}
+	n := ls.n
+	ls.free = make([][]int, n)
+	ls.missing = make([][]int, n)
+	inRow := newBitset(n)
+	for r := 0; r < n; r++ {
+		clear(inRow)
+		for c, v := range board[r] {
+			if v >= 0 {
+				inRow.Set(v)
+			} else {
+				ls.free[r] = append(ls.free[r], c)
+			}
+		}
+		for v := 0; v < n; v++ {
+			if !inRow.Test(v) {
+				ls.missing[r] = append(ls.missing[r], v)
+			}
+		}
+	}
+	ls.cnt = make([]int32, n*n)
+	ls.pos = make([]int32, n*n)
+	for k := range ls.pos {
+		ls.pos[k] = -1
+	}
+	ls.avail = make([]bitset, n)
+	for c := range ls.avail {
+		ls.avail[c] = newBitset(n)
+		for v := 0; v < n; v++ {
+			ls.avail[c].Set(v)
+		}
+	}
+	for r := 0; r < n; r++ {
+		for c, v := range board[r] {
+			if v >= 0 {
+				ls.inc(c*n + v)
+			}
+		}
+	}
+	ls.owner = make([]int, n)
+	ls.sym = make([]int, n)
+	ls.miss, ls.seen = newBitset(n), newBitset(n)
+
+	// старт: строки в случайном порядке, каждая — паросочетанием с уже готовыми
+	rows := ls.rng.Perm(n)
+	for x, r := range rows {
+		if x%64 == 0 && (ctx.Err() != nil || pastDeadline(opt.Deadline, time.Now())) {
+			// не успели даже начать — досыпаем оставшиеся строки как попало
+			for _, r := range rows[x:] {
+				for y, c := range ls.free[r] {
+					ls.L[r][c] = ls.missing[r][y]
+					ls.inc(c*n + ls.L[r][c])
+				}
+			}
+			break
+		}
+		ls.matchRow(r)
+	}
+
+	best := ls.conf
+	steps := int64(0)
+	status := ""
+	for ls.conf > 0 && steps < opt.MaxSteps {
+		if steps%progressCheckSteps == 0 {
+			if opt.OnTick != nil {
+				opt.OnTick(steps, best)
+			}
+			if ctx.Err() != nil {
+				status = "cancelled"
+				break
+			}
+			if pastDeadline(opt.Deadline, time.Now()) {
+				status = "timeout"
+				break
+			}
+		}
+		steps++
+
+		k := int(ls.bad[ls.rng.Intn(len(ls.bad))])
+		c, x := k/n, k%n
+		// строка, где повтор x в столбце c стоит в пустой клетке и есть с чем меняться
+		r, seen := -1, 0
+		for i := 0; i < n; i++ {
+			if ls.L[i][c] == x && board[i][c] < 0 && len(ls.free[i]) >= 2 {
+				if seen++; ls.rng.Intn(seen) == 0 {
+					r = i
+				}
+			}
+		}
+		if r < 0 {
+			continue // повтор держат клетки префикса и вынужденные — ход в другом месте
+		}
+		if ls.rng.Float64() < noise {
+			b := c
+			for b == c {
+				b = ls.free[r][ls.rng.Intn(len(ls.free[r]))]
+			}
+			y := ls.L[r][b]
+			ls.dec(c*n + x)
+			ls.dec(b*n + y)
+			ls.L[r][c], ls.L[r][b] = y, x
+			ls.inc(c*n + y)
+			ls.inc(b*n + x)
+		} else {
+			ls.matchRow(r)
+		}
+		best = min(best, ls.conf)
+	}
+
+	res := LocalResult{Conflicts: best, Steps: steps, Status: status}
+	switch {
+	case ls.conf == 0:
+		res.Square, res.Conflicts, res.Status = ls.L, 0, "done"
+	case status == "":
+		res.Status = "no_solution"
+	}
+	return res
+}
+
+// localSearch is the state of one LocalComplete run.
+type localSearch struct {
+	rng     *rand.Rand
+	n       int
+	L       [][]int
+	free    [][]int // free[r] — пустые в префиксе столбцы строки r
+	missing [][]int // missing[r] — символы, которых нет в префиксе строки r
+	// cnt[c*n+v] — сколько раз v стоит в столбце c; avail[c] — символы с
+	// cnt == 0; bad — пары (c, v) с cnt >= 2, pos — их места в bad (-1 —
+	// нет); conf — сумма повторов
+	cnt   []int32
+	avail []bitset
+	pos   []int32
+	bad   []int32
+	conf  int
+	// паросочетание строки: owner[v] — индекс клетки в free[r] или -1,
+	// sym[x] — символ клетки x; miss — ещё не занятые символы строки,
+	// seen — символы, пройденные текущим поиском пути
+	owner, sym []int
+	miss, seen bitset
+}
+
+func (ls *localSearch) inc(k int) {
+	if ls.cnt[k] == 0 {
+		ls.avail[k/ls.n].Clear(k % ls.n)
+	}
+	ls.cnt[k]++
+	if ls.cnt[k] >= 2 {
+		ls.conf++
+	}
+	ls.mark(k)
+}
+
+func (ls *localSearch) dec(k int) {
+	if ls.cnt[k] >= 2 {
+		ls.conf--
+	}
+	ls.cnt[k]--
+	if ls.cnt[k] == 0 {
+		ls.avail[k/ls.n].Set(k % ls.n)
+	}
+	ls.mark(k)
+}
+
+func (ls *localSearch) mark(k int) {
+	switch {
+	case ls.cnt[k] >= 2 && ls.pos[k] < 0:
+		ls.pos[k] = int32(len(ls.bad))
+		ls.bad = append(ls.bad, int32(k))
+	case ls.cnt[k] < 2 && ls.pos[k] >= 0:
+		last := ls.bad[len(ls.bad)-1]
+		ls.bad[ls.pos[k]], ls.pos[last] = last, ls.pos[k]
+		ls.bad = ls.bad[:len(ls.bad)-1]
+		ls.pos[k] = -1
+	}
+}
+
+// matchRow refills the empty cells of row r: as many as possible get a
+// missing symbol not yet in their column (greedy first, then augmenting
+// paths; cells in random order), the rest take the leftover symbols at
+// random.
+func (ls *localSearch) matchRow(r int) {
+	n, free, miss := ls.n, ls.free[r], ls.missing[r]
+	for _, c := range free {
+		if v := ls.L[r][c]; v >= 0 {
+			ls.dec(c*n + v)
+		}
+	}
+	ls.rng.Shuffle(len(free), func(a, b int) { free[a], free[b] = free[b], free[a] })
+	clear(ls.miss)
+	for _, v := range miss {
+		ls.owner[v] = -1
+		ls.miss.Set(v)
+	}
+	// жадно: первый свободный символ, которого нет в столбце
+	var open []int
+	for x, c := range free {
+		ls.sym[x] = -1
+		for w, a := range ls.avail[c] {
+			if m := a & ls.miss[w]; m != 0 {
+				v := w<<6 | bits.TrailingZeros64(m)
+				ls.owner[v], ls.sym[x] = x, v
+				ls.miss.Clear(v)
+				break
+			}
+		}
+		if ls.sym[x] < 0 {
+			open = append(open, x)
+		}
+	}
+	// miss дальше — все символы строки: пути идут и через занятые
+	for _, v := range miss {
+		ls.miss.Set(v)
+	}
+	for _, x := range open {
+		clear(ls.seen)
+		ls.augment(x, free)
+	}
+	var left []int
+	for _, v := range miss {
+		if ls.owner[v] < 0 {
+			left = append(left, v)
+		}
+	}
+	ls.rng.Shuffle(len(left), func(a, b int) { left[a], left[b] = left[b], left[a] })
+	for x, c := range free {
+		v := ls.sym[x]
+		if v < 0 {
+			v, left = left[len(left)-1], left[:len(left)-1]
+		}
+		ls.L[r][c] = v
+		ls.inc(c*n + v)
+	}
+}
+
+// augment looks for an augmenting path from cell x of the row.
+func (ls *localSearch) augment(x int, free []int) bool {
+	a := ls.avail[free[x]]
+	for w := range a {
+		for m := a[w] & ls.miss[w] &^ ls.seen[w]; m != 0; m = a[w] & ls.miss[w] &^ ls.seen[w] {
+			v := w<<6 | bits.TrailingZeros64(m)
+			ls.seen.Set(v)
+			if ls.owner[v] < 0 || ls.augment(ls.owner[v], free) {
+				ls.owner[v], ls.sym[x] = x, v
+				return true
+			}
+		}
+	}
+	return false
+}
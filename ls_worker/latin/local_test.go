@@ -0,0 +1,45 @@
+package latin
+
+import "testing"
+
+func TestLocalComplete(t *testing.T) {
+	tests := []struct {
+		name   string
+		board  [][]int
+		steps  int64
+		status string
+	}{
+		{"1x1", emptyBoard(1), 100, "done"},
+		// на пустой доске старт по паросочетаниям — уже латинский квадрат
+		{"empty 200x200", emptyBoard(200), 1000, "done"},
+		{"sparse 40x40", nearlyComplete(40, 0.1, 1), 1_000_000, "done"},
+		{"half-filled 20x20", nearlyComplete(20, 0.5, 2), 1_000_000, "done"},
+		// (0,1) и (1,0) обязаны взять 1 и 0 — повтор в столбцах не уходит;
+		// no_solution тут значит лишь, что шаги кончились
+		{"no completion", sudokuBoard("1.", ".2"), 1000, "no_solution"},
+	}
+	for _, tt := range tests {
+		res := LocalComplete(tt.board, LocalOptions{Rng: NewRand(RNGLegacy, 1), MaxSteps: tt.steps})
+		if res.Status != tt.status {
+			t.Errorf("%s: status %q after %d steps, %d conflicts; want %s", tt.name, res.Status, res.Steps, res.Conflicts, tt.status)
+			continue
+		}
+		if res.Status != "done" {
+			if res.Square != nil || res.Conflicts == 0 || res.Steps != tt.steps {
+				t.Errorf("%s: square %v, %d conflicts, %d steps", tt.name, res.Square, res.Conflicts, res.Steps)
+			}
+			continue
+		}
+		if res.Conflicts != 0 || !IsLatinSquare(res.Square) {
+			t.Errorf("%s: %d conflicts, square not Latin", tt.name, res.Conflicts)
+			continue
+		}
+		for i := range tt.board {
+			for j, v := range tt.board[i] {
+				if v >= 0 && res.Square[i][j] != v {
+					t.Fatalf("%s: (%d,%d) = %d, prefix says %d", tt.name, i, j, res.Square[i][j], v)
+				}
+			}
+		}
+	}
+}
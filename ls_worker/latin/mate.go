@@ -0,0 +1,175 @@
+package latin
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// MateOptions configures FindOrthogonalMate.
+type MateOptions struct {
+	Ctx      context.Context
+	Rng      *rand.Rand // порядок трансверсалей; nil — без перемешивания
+	Deadline time.Time  // нулевое значение — без дедлайна
+	MaxNodes int64
+}
+
+// MateResult is the outcome of FindOrthogonalMate. Status is done |
+// no_solution | timeout | node_limit | cancelled.
+type MateResult struct {
+	Mate         [][]int
+	Status       string
+	Nodes        int64
+	Transversals int // сколько трансверсалей у base
+}
+
+// FindOrthogonalMate looks for a Latin square orthogonal to the Latin square
+// base. The cells holding one symbol of a mate form a transversal of base
+// (one cell per row and column, all symbols distinct), so the search lists
+// all transversals of base and then picks n disjoint ones by backtracking.
+// The mate's first row is fixed to 0..n-1, which loses no generality:
+// relabeling the mate's symbols keeps it orthogonal.
+func FindOrthogonalMate(base [][]int, opt MateOptions) MateResult {
+	m := &mateSearch{opt: opt, base: base, n: len(base), untilCheck: defaultCheckEvery}
+	if m.opt.Ctx == nil {
+		m.opt.Ctx = context.Background()
+	}
+	res := MateResult{}
+
+	m.byFirst = make([][][]int, m.n)
+	m.transversals(0, make([]int, m.n), newBitset(m.n), newBitset(m.n))
+	for _, ts := range m.byFirst {
+		res.Transversals += len(ts)
+		if opt.Rng != nil {
+			opt.Rng.Shuffle(len(ts), func(a, b int) { ts[a], ts[b] = ts[b], ts[a] })
+		}
+	}
+
+	if m.stop == "" {
+		m.used = make([]bitset, m.n)
+		for r := range m.used {
+			m.used[r] = newBitset(m.n)
+		}
+		m.chosen = make([][]int, m.n)
+		if m.cover(0) {
+			res.Mate = make([][]int, m.n)
+			for r := range res.Mate {
+				res.Mate[r] = make([]int, m.n)
+			}
+			for s, t := range m.chosen {
+				for r, c := range t {
+					res.Mate[r][c] = s
+				}
+			}
+		}
+	}
+
+	res.Nodes = m.nodes
+	switch {
+	case res.Mate != nil:
+		res.Status = "done"
+	case m.stop != "":
+		res.Status = m.stop
+	default:
+		res.Status = "no_solution"
+	}
+	return res
+}
+
+type mateSearch struct {
+	opt  MateOptions
+	base [][]int
+	n    int
+	// byFirst[c] — трансверсали (t[r] — столбец в строке r) с t[0] == c
+	byFirst [][][]int
+	used    []bitset // used[r] — столбцы строки r, уже занятые выбранными трансверсалями
+	chosen  [][]int  // chosen[s] — трансверсаль символа s
+
+	nodes      int64
+	untilCheck int64
+	stop       string // cancelled | timeout | node_limit
+}
+
+// transversals enumerates transversals row by row: t[r] is the column taken
+// in row r, cols and syms are the columns and symbols already used.
+func (m *mateSearch) transversals(r int, t []int, cols, syms bitset) {
+	if m.expired() {
+		return
+	}
+	if r == m.n {
+		m.byFirst[t[0]] = append(m.byFirst[t[0]], append([]int(nil), t...))
+		return
+	}
+	for c := 0; c < m.n; c++ {
+		v := m.base[r][c]
+		if cols.Test(c) || syms.Test(v) {
+			continue
+		}
+		m.nodes++
+		t[r] = c
+		cols.Set(c)
+		syms.Set(v)
+		m.transversals(r+1, t, cols, syms)
+		cols.Clear(c)
+		syms.Clear(v)
+		if m.stop != "" {
+			return
+		}
+	}
+}
+
+// cover gives symbol s a transversal through (0,s) disjoint from those of
+// the symbols before it.
+func (m *mateSearch) cover(s int) bool {
+	if s == m.n {
+		return true
+	}
+	for _, t := range m.byFirst[s] {
+		if m.expired() {
+			return false
+		}
+		free := true
+		for r := 1; r < m.n && free; r++ {
+			free = !m.used[r].Test(t[r])
+		}
+		if !free {
+			continue
+		}
+		m.nodes++
+		for r, c := range t {
+			m.used[r].Set(c)
+		}
+		m.chosen[s] = t
+		if m.cover(s + 1) {
+			return true
+		}
+		for r, c := range t {
+			m.used[r].Clear(c)
+		}
+	}
+	return false
+}
+
+// expired records why the search has to stop, consulting the clock only
+// once per defaultCheckEvery calls.
+func (m *mateSearch) expired() bool {
+	if m.stop != "" {
+		return true
+	}
+	if m.opt.MaxNodes > 0 && m.nodes >= m.opt.MaxNodes {
+		m.stop = "node_limit"
+		return true
+	}
+	m.untilCheck--
+	if m.untilCheck > 0 {
+		return false
+	}
+	m.untilCheck = defaultCheckEvery
+	switch {
+	case m.opt.Ctx.Err() != nil:
+		m.stop = "cancelled"
+	case pastDeadline(m.opt.Deadline, time.Now()):
+		m.stop = "timeout"
+	}
+	return m.stop != ""
+}
@@ -0,0 +1,42 @@
+package latin
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestFindOrthogonalMate(t *testing.T) {
+	klein := make([][]int, 4)
+	for i := range klein {
+		klein[i] = []int{i, i ^ 1, i ^ 2, i ^ 3}
+	}
+	tests := []struct {
+		name         string
+		base         [][]int
+		status       string
+		transversals int
+	}{
+		{"cyclic 5", MakeCyclic(5, 1), "done", 15},
+		{"cyclic 7", MakeCyclic(7, 1), "done", 133},
+		{"Klein 4", klein, "done", 8},
+		// у циклического квадрата чётного порядка трансверсалей нет
+		{"cyclic 4", MakeCyclic(4, 1), "no_solution", 0},
+		{"cyclic 6", MakeCyclic(6, 1), "no_solution", 0},
+	}
+	for _, tt := range tests {
+		res := FindOrthogonalMate(tt.base, MateOptions{Rng: rand.New(rand.NewSource(1))})
+		if res.Status != tt.status || res.Transversals != tt.transversals {
+			t.Errorf("%s: status %q with %d transversals, want %q with %d", tt.name, res.Status, res.Transversals, tt.status, tt.transversals)
+			continue
+		}
+		if tt.status != "done" {
+			if res.Mate != nil {
+				t.Errorf("%s: mate %v without a solution", tt.name, res.Mate)
+			}
+			continue
+		}
+		if ok, msg := VerifyMOLS([][][]int{tt.base, res.Mate}); !ok {
+			t.Errorf("%s: %s", tt.name, msg)
+		}
+	}
+}
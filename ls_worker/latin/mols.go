@@ -0,0 +1,615 @@
+package latin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	annealT0   = 2.0
+	annealTEnd = 0.05
+)
+
+// VerifyMOLS checks that every square is Latin and every pair is orthogonal,
+// i.e. yields exactly n^2 distinct ordered pairs. On failure the string says
+// which square or pair is at fault.
+func VerifyMOLS(squares [][][]int) (bool, string) {
+	if len(squares) == 0 {
+		return false, "no squares"
+	}
+	n := len(squares[0])
+	for m, L := range squares {
+		if len(L) != n {
+			return false, fmt.Sprintf("square %d has %d rows, want %d", m, len(L), n)
+		}
+		if !IsLatinSquare(L) {
+			return false, fmt.Sprintf("square %d is not Latin", m)
+		}
+	}
+	for a := 0; a < len(squares); a++ {
+		for b := a + 1; b < len(squares); b++ {
+			if _, uniq := OrthConflicts(squares[a], squares[b]); uniq != n*n {
+				return false, fmt.Sprintf("pair (%d,%d) has %d unique pairs, want %d", a, b, uniq, n*n)
+			}
+		}
+	}
+	return true, ""
+}
+
+// Overlay superimposes A and B into a grid of ordered pairs. For a set of
+// more than two squares callers overlay a chosen pair; see ConflictMatrix for
+// how the pairs compare.
+func Overlay(A, B [][]int) [][][2]int {
+	n := len(A)
+	out := make([][][2]int, n)
+	for i := 0; i < n; i++ {
+		out[i] = make([][2]int, n)
+		for j := 0; j < n; j++ {
+			out[i][j] = [2]int{A[i][j], B[i][j]}
+		}
+	}
+	return out
+}
+
+// OrthogonalArray writes the squares L[0..k-1] as an orthogonal array
+// OA(n, k+2): one row (i, j, L[0][i][j], ..., L[k-1][i][j]) per cell, cells
+// in row-major order. Any two columns hold every ordered pair of symbols
+// exactly once iff the squares are mutually orthogonal.
+func OrthogonalArray(L [][][]int) [][]int {
+	if len(L) == 0 {
+		return nil
+	}
+	n := len(L[0])
+	oa := make([][]int, 0, n*n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			row := make([]int, 0, len(L)+2)
+			row = append(row, i, j)
+			for _, sq := range L {
+				row = append(row, sq[i][j])
+			}
+			oa = append(oa, row)
+		}
+	}
+	return oa
+}
+
+// OrthConflicts counts repeated ordered pairs (A[i][j], B[i][j]); A and B
+// are orthogonal iff conflicts == 0.
+func OrthConflicts(A, B [][]int) (conflicts int, uniquePairs int) {
+	return OrthConflictsBuf(A, B, nil)
+}
+
+// OrthConflictsBuf is OrthConflicts with a caller-owned scratch buffer of
+// length >= n^2, so the local-search loop doesn't allocate on every step.
+func OrthConflictsBuf(A, B [][]int, seen []bool) (conflicts int, uniquePairs int) {
+	n := len(A)
+	if len(seen) < n*n {
+		seen = make([]bool, n*n)
+	} else {
+		seen = seen[:n*n]
+		clear(seen)
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			key := A[i][j]*n + B[i][j]
+			if !seen[key] {
+				seen[key] = true
+				uniquePairs++
+			}
+		}
+	}
+	conflicts = n*n - uniquePairs
+	return
+}
+
+// ConflictMatrix returns the k x k matrix of OrthConflicts between every
+// pair of L: symmetric, with a zero diagonal.
+func ConflictMatrix(L [][][]int) [][]int {
+	k := len(L)
+	m := make([][]int, k)
+	for a := range m {
+		m[a] = make([]int, k)
+	}
+	var seen []bool
+	if k > 0 {
+		seen = make([]bool, len(L[0])*len(L[0]))
+	}
+	for a := 0; a < k; a++ {
+		for b := a + 1; b < k; b++ {
+			c, _ := OrthConflictsBuf(L[a], L[b], seen)
+			m[a][b], m[b][a] = c, c
+		}
+	}
+	return m
+}
+
+// ConflictCells lists, in row-major order, every cell (i,j) whose ordered
+// pair (A[i][j], B[i][j]) also occurs at some other cell. It is empty iff A
+// and B are orthogonal; a pair seen c times contributes c cells and c-1 to
+// OrthConflicts.
+func ConflictCells(A, B [][]int) [][2]int {
+	n := len(A)
+	count := make([]int, n*n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			count[A[i][j]*n+B[i][j]]++
+		}
+	}
+	var cells [][2]int
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if count[A[i][j]*n+B[i][j]] > 1 {
+				cells = append(cells, [2]int{i, j})
+			}
+		}
+	}
+	return cells
+}
+
+// SetConflictCells is ConflictCells over every pair of L: a cell is listed,
+// in row-major order, if its pair repeats in at least one (L[a], L[b]). It is
+// empty iff L is mutually orthogonal; for two squares it is ConflictCells.
+func SetConflictCells(L [][][]int) [][2]int {
+	if len(L) == 0 {
+		return nil
+	}
+	n := len(L[0])
+	hit := make([]bool, n*n)
+	for a := 0; a < len(L); a++ {
+		for b := a + 1; b < len(L); b++ {
+			for _, c := range ConflictCells(L[a], L[b]) {
+				hit[c[0]*n+c[1]] = true
+			}
+		}
+	}
+	var cells [][2]int
+	for x, h := range hit {
+		if h {
+			cells = append(cells, [2]int{x / n, x % n})
+		}
+	}
+	return cells
+}
+
+// pairTable counts, for squares A and B, the cells holding each ordered
+// symbol pair (A[i][j], B[i][j]), so the pair's conflicts are n*n minus the
+// distinct pairs. A move that rewrites c cells of one square updates it in
+// O(c) instead of the O(n^2) of OrthConflicts.
+type pairTable struct {
+	n      int
+	count  []uint16 // пара встречается не больше n раз
+	unique int
+}
+
+func newPairTable(A, B [][]int) *pairTable {
+	n := len(A)
+	t := &pairTable{n: n, count: make([]uint16, n*n)}
+	t.fill(A, B)
+	return t
+}
+
+// fill recounts the table from scratch, e.g. after a restart.
+func (t *pairTable) fill(A, B [][]int) {
+	clear(t.count)
+	t.unique = 0
+	for i := range A {
+		for j := range A[i] {
+			t.add(A[i][j]*t.n + B[i][j])
+		}
+	}
+}
+
+func (t *pairTable) add(key int) {
+	if t.count[key] == 0 {
+		t.unique++
+	}
+	t.count[key]++
+}
+
+func (t *pairTable) remove(key int) {
+	t.count[key]--
+	if t.count[key] == 0 {
+		t.unique--
+	}
+}
+
+func (t *pairTable) conflicts() int {
+	return t.n*t.n - t.unique
+}
+
+// maxPairTableCells caps the pair tables SearchMOLS keeps (k*(k-1)/2 of n*n
+// counters); past it each step recomputes the touched pairs with
+// OrthConflictsBuf instead.
+const maxPairTableCells = 1 << 27
+
+// MOLSOptions configures SearchMOLS.
+type MOLSOptions struct {
+	Ctx      context.Context
+	Rng      *rand.Rand
+	Deadline time.Time // нулевое значение — без дедлайна
+	MaxSteps int64
+	Method   string // hill_climb | anneal | galois
+	// StallSteps: столько шагов без улучшения — и L[1..k-1] перезапускаются
+	// со случайных квадратов; 0 — defaultStallSteps, < 0 — без рестартов
+	StallSteps int64
+	// SidewaysProb: hill_climb принимает неулучшающий ход с этой вероятностью;
+	// 0 — строго жадный поиск
+	SidewaysProb float64
+	// Seed: стартовые L[0..len(Seed)-1] (латинские n x n) вместо случайных;
+	// для galois игнорируется, если n — степень простого
+	Seed [][][]int
+	// OnProgress, если задан, не чаще раза в ProgressEvery получает лучший
+	// на данный момент набор и его конфликты (сохранять best нельзя)
+	OnProgress    func(best [][][]int, conf int)
+	ProgressEvery time.Duration
+	// OnTick, если задан, раз в progressCheckSteps шагов получает число шагов
+	// и лучшие конфликты (для логов; должен быть дешёвым)
+	OnTick func(steps int64, bestConf int)
+}
+
+// progressCheckSteps: как часто (в шагах) поиск MOLS зовёт OnTick и смотрит, пора ли звать OnProgress
+const progressCheckSteps = 1024
+
+const defaultStallSteps = 50_000
+
+// DefaultSidewaysProb is the usual MOLSOptions.SidewaysProb.
+const DefaultSidewaysProb = 0.001
+
+// MOLSResult is the best set of squares found by SearchMOLS.
+type MOLSResult struct {
+	Squares     [][][]int
+	Conflicts   int     // суммарно по всем парам
+	UniquePairs int     // суммарно по всем парам
+	PairConf    [][]int // PairConf[a][b] — конфликты пары (Squares[a], Squares[b])
+	Steps       int64
+	Accepted    int64
+	Temperature float64 // anneal: финальная температура
+	Restarts    int     // сколько было рестартов
+	BestRestart int     // в каком рестарте найден лучший набор (0 — исходный запуск)
+	// MaxFoundK — размер наибольшего попарно ортогонального поднабора, встреченного
+	// за поиск (k, если набор найден целиком); Subset — сами эти квадраты
+	MaxFoundK int
+	Subset    [][][]int
+	Notes     []string
+}
+
+// SearchMOLS looks for k mutually orthogonal Latin squares of order n by
+// local search over Latin-preserving moves, minimizing the total number of
+// conflicts over all k*(k-1)/2 pairs. Method "galois" constructs the set
+// directly when n is a prime power and falls back to hill_climb otherwise.
+func SearchMOLS(n, k int, opt MOLSOptions) MOLSResult {
+	ctx := opt.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	rng := opt.Rng
+	method := opt.Method
+
+	var L [][][]int
+	var notes []string
+	if method == "galois" {
+		// n = p^m: готовый полный набор над GF(n), поиск не нужен
+		var ok bool
+		if L, ok = GaloisMOLS(n, k); !ok {
+			notes = append(notes, fmt.Sprintf("n=%d is not a prime power, fell back to hill_climb", n))
+			method = "hill_climb"
+		}
+	}
+	if L == nil {
+		// старт: все L[m] = cyclic latin, потом мутируем перестановками.
+		// Мутируем и L[0]: для чётного n циклический квадрат не имеет ортогонального партнёра
+		L = make([][][]int, k)
+		for m := range L {
+			if m < len(opt.Seed) {
+				L[m] = DeepCopy(opt.Seed[m])
+				continue
+			}
+			L[m] = MakeCyclic(n, 1)
+			// рандомные перестановки (сохраняют латинскость)
+			RandomPermute(L[m], rng)
+		}
+	}
+
+	// pairConf[a][b] — конфликты пары (L[a], L[b]) для текущего набора
+	pairConf := make([][]int, k)
+	for a := range pairConf {
+		pairConf[a] = make([]int, k)
+	}
+	curConf := 0
+	for a := 0; a < k; a++ {
+		for b := a + 1; b < k; b++ {
+			c, _ := OrthConflicts(L[a], L[b])
+			pairConf[a][b], pairConf[b][a] = c, c
+			curConf += c
+		}
+	}
+	// наибольший поднабор с нулевыми конфликтами во всех парах; пересчитывается,
+	// только когда какая-то пара становится ортогональной
+	subK := 0
+	var subset [][][]int
+	noteSubset := func() {
+		idx := orthClique(pairConf)
+		if len(idx) <= subK {
+			return
+		}
+		subK = len(idx)
+		subset = make([][][]int, len(idx))
+		for x, m := range idx {
+			subset[x] = DeepCopy(L[m])
+		}
+	}
+	noteSubset()
+
+	// всего пар квадратов k*(k-1)/2, у каждой n*n упорядоченных пар символов
+	totalPairs := k * (k - 1) / 2 * n * n
+
+	// tables[a][b] (a < b) — счётчики пар символов (L[a], L[b]); ход по L[m]
+	// пересчитывает только изменённые клетки. Слишком большие не заводим
+	var tables [][]*pairTable
+	if totalPairs <= maxPairTableCells {
+		tables = make([][]*pairTable, k)
+		for a := range tables {
+			tables[a] = make([]*pairTable, k)
+			for b := a + 1; b < k; b++ {
+				tables[a][b] = newPairTable(L[a], L[b])
+			}
+		}
+	}
+	// key — индекс пары (L[m], L[o]) в таблице пары {m, o}
+	key := func(m, o, vm, vo int) int {
+		if m < o {
+			return vm*n + vo
+		}
+		return vo*n + vm
+	}
+	table := func(m, o int) *pairTable { return tables[min(m, o)][max(m, o)] }
+	var changes []cellChange
+
+	bestConf, bestUnique := curConf, totalPairs-curConf
+	best := make([][][]int, k)
+	for m := range L {
+		best[m] = DeepCopy(L[m])
+	}
+	bestPairConf := DeepCopy(pairConf)
+	steps := int64(0)
+	newConf := make([]int, k)
+	seen := make([]bool, n*n)
+
+	// anneal: геометрическое охлаждение от annealT0 до annealTEnd за MaxSteps шагов
+	anneal := method == "anneal"
+	temp := annealT0
+	cooling := math.Pow(annealTEnd/annealT0, 1/float64(opt.MaxSteps))
+	accepted := int64(0)
+
+	// рестарты: runConf — лучшее в текущем запуске, best* — глобально лучшее
+	stall := opt.StallSteps
+	if stall == 0 {
+		stall = defaultStallSteps
+	}
+	runConf, lastImprove := curConf, int64(0)
+	restarts, bestRestart := 0, 0
+	bestDirty, lastProgress := false, time.Now()
+
+	// локальный поиск: пробуем случайные операции, принимаем если лучше
+	for bestConf > 0 && steps < opt.MaxSteps && !pastDeadline(opt.Deadline, time.Now()) && ctx.Err() == nil {
+		steps++
+
+		if opt.OnTick != nil && steps%progressCheckSteps == 0 {
+			opt.OnTick(steps, bestConf)
+		}
+		if bestDirty && steps%progressCheckSteps == 0 && time.Since(lastProgress) >= opt.ProgressEvery {
+			opt.OnProgress(best, bestConf)
+			bestDirty, lastProgress = false, time.Now()
+		}
+
+		if stall > 0 && steps-lastImprove > stall {
+			// застряли в локальном минимуме: L[0] оставляем, остальные заново
+			restarts++
+			for m := 1; m < k; m++ {
+				L[m] = MakeCyclic(n, 1)
+				RandomPermute(L[m], rng)
+			}
+			curConf = 0
+			for a := 0; a < k; a++ {
+				for b := a + 1; b < k; b++ {
+					var c int
+					if tables != nil {
+						tables[a][b].fill(L[a], L[b])
+						c = tables[a][b].conflicts()
+					} else {
+						c, _ = OrthConflictsBuf(L[a], L[b], seen)
+					}
+					pairConf[a][b], pairConf[b][a] = c, c
+					curConf += c
+				}
+			}
+			runConf, lastImprove = curConf, steps
+			noteSubset()
+		}
+
+		// какой квадрат мутируем
+		m := rng.Intn(k)
+
+		// случайная операция прямо в L[m]; не приняли — откатываем по changes
+		changes = randomMove(L[m], rng, changes[:0])
+
+		conf := curConf
+		for o := 0; o < k; o++ {
+			if o == m {
+				continue
+			}
+			if tables == nil {
+				newConf[o], _ = OrthConflictsBuf(L[o], L[m], seen)
+			} else {
+				t := table(m, o)
+				for _, ch := range changes {
+					vo := L[o][ch.i][ch.j]
+					t.remove(key(m, o, ch.old, vo))
+					t.add(key(m, o, L[m][ch.i][ch.j], vo))
+				}
+				newConf[o] = t.conflicts()
+			}
+			conf += newConf[o] - pairConf[m][o]
+		}
+		uniq := totalPairs - conf
+
+		// принимаем если лучше лучшего; иначе hill_climb — редкий “шаг в сторону”,
+		// anneal — ухудшение с вероятностью exp(-delta/T) относительно текущего.
+		// uniq = totalPairs - conf, так что равенство conf означает и равенство uniq
+		improved := conf < bestConf || (conf == bestConf && uniq > bestUnique)
+		accept := improved
+		if !accept {
+			if anneal {
+				delta := conf - curConf
+				accept = delta <= 0 || rng.Float64() < math.Exp(-float64(delta)/temp)
+			} else {
+				accept = rng.Float64() < opt.SidewaysProb
+			}
+		}
+		if anneal {
+			temp *= cooling
+		}
+		if !accept {
+			if tables != nil {
+				for o := 0; o < k; o++ {
+					if o == m {
+						continue
+					}
+					t := table(m, o)
+					for _, ch := range changes {
+						vo := L[o][ch.i][ch.j]
+						t.remove(key(m, o, L[m][ch.i][ch.j], vo))
+						t.add(key(m, o, ch.old, vo))
+					}
+				}
+			}
+			undoMove(L[m], changes)
+			continue
+		}
+		accepted++
+		if improved {
+			bestConf, bestUnique = conf, uniq
+			bestRestart = restarts
+		}
+		if conf < runConf {
+			runConf, lastImprove = conf, steps
+		}
+
+		curConf = conf
+		fresh := false // появилась новая ортогональная пара
+		for o := 0; o < k; o++ {
+			if o != m {
+				fresh = fresh || (newConf[o] == 0 && pairConf[m][o] > 0)
+				pairConf[m][o], pairConf[o][m] = newConf[o], newConf[o]
+			}
+		}
+		if fresh {
+			noteSubset()
+		}
+		// ничья с лучшим: оставляем лексикографически меньший набор, чтобы
+		// выбор best не зависел от того, какой из равных попался первым;
+		// сравнение на месте, копия — только если L победил
+		tie := !improved && conf == bestConf && lessSquares(L, best)
+		if tie {
+			bestRestart = restarts
+		}
+		if improved || tie {
+			for q := range L {
+				for r := range L[q] {
+					copy(best[q][r], L[q][r])
+				}
+			}
+			for a := range pairConf {
+				copy(bestPairConf[a], pairConf[a])
+			}
+			bestDirty = opt.OnProgress != nil
+			if bestConf == 0 {
+				break
+			}
+		}
+	}
+
+	res := MOLSResult{
+		Squares:     best,
+		Conflicts:   bestConf,
+		UniquePairs: bestUnique,
+		PairConf:    bestPairConf,
+		Steps:       steps,
+		Accepted:    accepted,
+		Restarts:    restarts,
+		BestRestart: bestRestart,
+		MaxFoundK:   subK,
+		Subset:      subset,
+		Notes:       notes,
+	}
+	if anneal {
+		res.Temperature = temp
+	}
+	return res
+}
+
+// orthClique returns the indices of a largest set of squares whose pairs all
+// have zero conflicts: a maximum clique by branch and bound, cheap for the
+// small k SearchMOLS runs with. One square alone always qualifies.
+func orthClique(pairConf [][]int) []int {
+	var best, cur []int
+	var grow func(cands []int)
+	grow = func(cands []int) {
+		if len(cur) > len(best) {
+			best = append(best[:0], cur...)
+		}
+		for x, a := range cands {
+			if len(cur)+len(cands)-x <= len(best) {
+				return // даже все оставшиеся не дадут больше
+			}
+			next := make([]int, 0, len(cands)-x-1)
+			for _, b := range cands[x+1:] {
+				if pairConf[a][b] == 0 {
+					next = append(next, b)
+				}
+			}
+			cur = append(cur, a)
+			grow(next)
+			cur = cur[:len(cur)-1]
+		}
+	}
+	all := make([]int, len(pairConf))
+	for m := range all {
+		all[m] = m
+	}
+	grow(all)
+	return best
+}
+
+// lessSquares orders sets of squares lexicographically, square by square and
+// row by row; SearchMOLS uses it to break ties between equally good sets.
+func lessSquares(a, b [][][]int) bool {
+	for m := range a {
+		for r := range a[m] {
+			if lessInts(a[m][r], b[m][r]) {
+				return true
+			}
+			if lessInts(b[m][r], a[m][r]) {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// PairConflictsNote formats the per-pair conflict counts for debug output.
+func PairConflictsNote(pairConf [][]int) string {
+	var sb strings.Builder
+	sb.WriteString("pair_conflicts:")
+	for a := 0; a < len(pairConf); a++ {
+		for b := a + 1; b < len(pairConf); b++ {
+			fmt.Fprintf(&sb, " (%d,%d)=%d", a, b, pairConf[a][b])
+		}
+	}
+	return sb.String()
+}
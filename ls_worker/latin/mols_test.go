@@ -0,0 +1,641 @@
+package latin
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestSearchMOLSSameSeedSameBest(t *testing.T) {
+	tests := []struct {
+		n, k     int
+		method   string
+		maxSteps int64
+	}{
+		// n=6: ортогональной пары нет — до конца бюджета идут ничьи с лучшим
+		{n: 6, k: 2, method: "hill_climb", maxSteps: 20_000},
+		{n: 6, k: 2, method: "anneal", maxSteps: 20_000},
+		{n: 10, k: 3, method: "hill_climb", maxSteps: 5_000},
+	}
+	for _, tt := range tests {
+		hashes := func() []string {
+			res := SearchMOLS(tt.n, tt.k, MOLSOptions{
+				Rng:          rand.New(rand.NewSource(42)),
+				MaxSteps:     tt.maxSteps,
+				Method:       tt.method,
+				SidewaysProb: DefaultSidewaysProb,
+			})
+			out := make([]string, len(res.Squares))
+			for m, sq := range res.Squares {
+				out[m] = HashSquare(sq)
+			}
+			return out
+		}
+		first, second := hashes(), hashes()
+		if !slices.Equal(first, second) {
+			t.Errorf("n=%d k=%d %s: same seed gave %v and %v", tt.n, tt.k, tt.method, first, second)
+		}
+	}
+}
+
+func TestLessSquaresIsStrict(t *testing.T) {
+	a := [][][]int{MakeCyclic(3, 1)}
+	b := [][][]int{MakeCyclic(3, 2)} // вторая строка 2 0 1 против 1 2 0
+	tests := []struct {
+		x, y [][][]int
+		want bool
+	}{
+		{a, b, true},
+		{b, a, false},
+		{a, a, false},
+	}
+	for _, tt := range tests {
+		if got := lessSquares(tt.x, tt.y); got != tt.want {
+			t.Errorf("lessSquares(%v, %v) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestSearchMOLSBeyondPairs(t *testing.T) {
+	tests := []struct {
+		n, k   int
+		method string
+		seed   int64
+	}{
+		// n=4: 3 MOLS — полный набор; n=5: 4
+		{n: 4, k: 3, method: "hill_climb", seed: 1},
+		{n: 4, k: 3, method: "anneal", seed: 1},
+		{n: 5, k: 3, method: "hill_climb", seed: 2},
+		{n: 5, k: 4, method: "anneal", seed: 1},
+	}
+	for _, tt := range tests {
+		res := SearchMOLS(tt.n, tt.k, MOLSOptions{
+			Rng:          rand.New(rand.NewSource(tt.seed)),
+			MaxSteps:     200_000,
+			Method:       tt.method,
+			SidewaysProb: DefaultSidewaysProb,
+		})
+		if res.Conflicts != 0 || len(res.Squares) != tt.k {
+			t.Errorf("n=%d k=%d %s: %d conflicts, %d squares", tt.n, tt.k, tt.method, res.Conflicts, len(res.Squares))
+			continue
+		}
+		if ok, msg := VerifyMOLS(res.Squares); !ok {
+			t.Errorf("n=%d k=%d %s: %s", tt.n, tt.k, tt.method, msg)
+		}
+		for a := range res.PairConf {
+			for b := range res.PairConf[a] {
+				if res.PairConf[a][b] != 0 {
+					t.Errorf("n=%d k=%d %s: pair (%d,%d) has %d conflicts", tt.n, tt.k, tt.method, a, b, res.PairConf[a][b])
+				}
+			}
+		}
+	}
+}
+
+func TestVerifyMOLS(t *testing.T) {
+	galois, _ := GaloisMOLS(5, 4)
+	// rows/cols дают все n^2 пар, но латинскими не являются — ровно тот
+	// случай, когда счётчик конфликтов говорит 0
+	rows, cols := make([][]int, 3), make([][]int, 3)
+	for i := range rows {
+		rows[i], cols[i] = []int{i, i, i}, []int{0, 1, 2}
+	}
+	if c, _ := OrthConflicts(rows, cols); c != 0 {
+		t.Fatalf("rows/cols: %d conflicts, want 0", c)
+	}
+	tests := []struct {
+		name    string
+		squares [][][]int
+		want    bool
+		msg     string
+	}{
+		{"galois n=5 k=4", galois, true, ""},
+		{"single square", [][][]int{MakeCyclic(4, 1)}, true, ""},
+		{"none", nil, false, "no squares"},
+		{"non-Latin with zero conflicts", [][][]int{rows, cols}, false, "square 0 is not Latin"},
+		{"not orthogonal", [][][]int{MakeCyclic(3, 1), MakeCyclic(3, 1)}, false, "pair (0,1) has 3 unique pairs, want 9"},
+		{"size mismatch", [][][]int{MakeCyclic(3, 1), MakeCyclic(4, 1)}, false, "square 1 has 4 rows, want 3"},
+	}
+	for _, tt := range tests {
+		ok, msg := VerifyMOLS(tt.squares)
+		if ok != tt.want || msg != tt.msg {
+			t.Errorf("%s: VerifyMOLS = %v, %q; want %v, %q", tt.name, ok, msg, tt.want, tt.msg)
+		}
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	galois, _ := GaloisMOLS(5, 2)
+	tests := []struct {
+		name      string
+		A, B      [][]int
+		conflicts int // повторы пар; 0 — все n^2 пар различны
+	}{
+		{"orthogonal", galois[0], galois[1], 0},
+		// квадрат с самим собой: только n пар (v,v), каждая n раз
+		{"same square", galois[0], galois[0], 20},
+		// одна и та же перестановка строк ортогональность сохраняет
+		{"rows swapped in both", swapRows(galois[0], 0, 1), swapRows(galois[1], 0, 1), 0},
+		{"rows swapped in B only", galois[0], swapRows(galois[1], 0, 1), 10},
+	}
+	for _, tt := range tests {
+		grid := Overlay(tt.A, tt.B)
+		seen := map[[2]int]int{}
+		for i := range grid {
+			for j, p := range grid[i] {
+				if p != [2]int{tt.A[i][j], tt.B[i][j]} {
+					t.Fatalf("%s: overlay (%d,%d) = %v, want (%d,%d)", tt.name, i, j, p, tt.A[i][j], tt.B[i][j])
+				}
+				seen[p]++
+			}
+		}
+		if dup := 25 - len(seen); dup != tt.conflicts {
+			t.Errorf("%s: %d repeated pairs in the overlay, want %d", tt.name, dup, tt.conflicts)
+		}
+		if c, _ := OrthConflicts(tt.A, tt.B); c != tt.conflicts {
+			t.Errorf("%s: OrthConflicts %d, overlay says %d", tt.name, c, tt.conflicts)
+		}
+	}
+}
+
+// swapRows returns L with rows a and b exchanged.
+func swapRows(L [][]int, a, b int) [][]int {
+	out := DeepCopy(L)
+	out[a], out[b] = out[b], out[a]
+	return out
+}
+
+func TestSearchMOLSRestarts(t *testing.T) {
+	// seed 5 без рестартов застревает в локальном минимуме; с рестартом
+	// после 2000 шагов без улучшения находит пару — в последнем рестарте
+	run := func(seed, stall int64) MOLSResult {
+		return SearchMOLS(7, 2, MOLSOptions{
+			Rng:        rand.New(rand.NewSource(seed)),
+			MaxSteps:   100_000,
+			Method:     "hill_climb",
+			StallSteps: stall,
+		})
+	}
+	stuck, recovered := run(5, -1), run(5, 2000)
+	if stuck.Conflicts == 0 || stuck.Restarts != 0 {
+		t.Fatalf("no restarts: %d conflicts, %d restarts; want stuck with none", stuck.Conflicts, stuck.Restarts)
+	}
+	if recovered.Conflicts != 0 || recovered.Restarts == 0 || recovered.BestRestart != recovered.Restarts {
+		t.Errorf("stall_steps=2000: %d conflicts, %d restarts, best in restart %d; want found in the last restart",
+			recovered.Conflicts, recovered.Restarts, recovered.BestRestart)
+	}
+	if ok, msg := VerifyMOLS(recovered.Squares); !ok {
+		t.Errorf("recovered pair: %s", msg)
+	}
+
+	// рестарт — не раньше чем через stall+1 шагов после прошлого улучшения
+	for _, seed := range []int64{2, 3} {
+		r := run(seed, 2000)
+		if r.Conflicts == 0 || r.Restarts == 0 || int64(r.Restarts) > r.Steps/2001 {
+			t.Errorf("seed %d: %d conflicts, %d restarts in %d steps", seed, r.Conflicts, r.Restarts, r.Steps)
+		}
+	}
+}
+
+func TestConflictCells(t *testing.T) {
+	galois, _ := GaloisMOLS(5, 2)
+	swapped := DeepCopy(galois[1])
+	swapped[0], swapped[1] = swapped[1], swapped[0] // всё ещё латинский
+	tests := []struct {
+		name string
+		A, B [][]int
+	}{
+		{"orthogonal", galois[0], galois[1]},
+		{"same square", MakeCyclic(3, 1), MakeCyclic(3, 1)},
+		{"rows swapped", galois[0], swapped},
+		{"cyclic n=4", MakeCyclic(4, 1), MakeCyclic(4, 3)},
+	}
+	for _, tt := range tests {
+		n := len(tt.A)
+		// эталон: клетка в списке, если её пара встречается больше одного раза
+		count := map[[2]int]int{}
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				count[[2]int{tt.A[i][j], tt.B[i][j]}]++
+			}
+		}
+		var want [][2]int
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if count[[2]int{tt.A[i][j], tt.B[i][j]}] > 1 {
+					want = append(want, [2]int{i, j})
+				}
+			}
+		}
+		got := ConflictCells(tt.A, tt.B)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: ConflictCells = %v, want %v", tt.name, got, want)
+		}
+		// пара, встреченная c раз: c клеток и c-1 конфликтов
+		repeated := 0
+		for _, c := range count {
+			if c > 1 {
+				repeated++
+			}
+		}
+		if conf, _ := OrthConflicts(tt.A, tt.B); len(got)-conf != repeated {
+			t.Errorf("%s: %d cells for %d conflicts over %d repeated pairs", tt.name, len(got), conf, repeated)
+		}
+	}
+}
+
+func TestSetConflictCells(t *testing.T) {
+	galois, _ := GaloisMOLS(5, 3)
+	swapped := DeepCopy(galois[1])
+	swapped[0], swapped[1] = swapped[1], swapped[0]
+	tests := []struct {
+		name string
+		L    [][][]int
+	}{
+		{"orthogonal set", galois},
+		{"pair", [][][]int{galois[0], swapped}},
+		// (L[0], L[1]) ортогональны — конфликты только в последней паре
+		{"last pair repeats", [][][]int{galois[0], galois[1], galois[1]}},
+		{"rows swapped", [][][]int{galois[0], galois[1], swapped}},
+	}
+	for _, tt := range tests {
+		n := len(tt.L[0])
+		// эталон: клетка в списке, если её пара повторяется хоть в одной паре квадратов
+		var want [][2]int
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				hit := false
+				for a := range tt.L {
+					for b := a + 1; b < len(tt.L); b++ {
+						count := 0
+						for x := 0; x < n; x++ {
+							for y := 0; y < n; y++ {
+								if tt.L[a][x][y] == tt.L[a][i][j] && tt.L[b][x][y] == tt.L[b][i][j] {
+									count++
+								}
+							}
+						}
+						hit = hit || count > 1
+					}
+				}
+				if hit {
+					want = append(want, [2]int{i, j})
+				}
+			}
+		}
+		if got := SetConflictCells(tt.L); !slices.Equal(got, want) {
+			t.Errorf("%s: SetConflictCells = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestSearchMOLSSeedSquares(t *testing.T) {
+	g5, _ := GaloisMOLS(5, 4)
+	g7, _ := GaloisMOLS(7, 2)
+	tests := []struct {
+		name string
+		n, k int
+		seed [][][]int
+	}{
+		{"orthogonal pair", 7, 2, g7},
+		{"full set n=5", 5, 4, g5},
+	}
+	for _, tt := range tests {
+		res := SearchMOLS(tt.n, tt.k, MOLSOptions{Rng: rand.New(rand.NewSource(1)), MaxSteps: 200_000, Method: "hill_climb", Seed: tt.seed})
+		if res.Conflicts != 0 {
+			t.Errorf("%s: %d conflicts", tt.name, res.Conflicts)
+			continue
+		}
+		// набор уже ортогонален — искать нечего
+		if res.Steps != 0 {
+			t.Errorf("%s: %d steps for an already orthogonal seed", tt.name, res.Steps)
+		}
+		for m := range tt.seed {
+			if HashSquare(res.Squares[m]) != HashSquare(tt.seed[m]) {
+				t.Errorf("%s: square %d moved away from the seed", tt.name, m)
+			}
+		}
+	}
+}
+
+func TestPairTableMatchesOrthConflicts(t *testing.T) {
+	for _, n := range []int{2, 3, 5, 7, 10} {
+		rng := rand.New(rand.NewSource(int64(n)))
+		A, B := MakeCyclic(n, 1), MakeCyclic(n, 1)
+		RandomPermute(A, rng)
+		RandomPermute(B, rng)
+		tab := newPairTable(A, B)
+		var changes []cellChange
+		for step := 0; step < 2000; step++ {
+			// ходим то по A, то по B; часть ходов откатываем, как отвергнутые
+			moveA := rng.Intn(2) == 0
+			sq := B
+			if moveA {
+				sq = A
+			}
+			changes = randomMove(sq, rng, changes[:0])
+			update := func(undo bool) {
+				for _, ch := range changes {
+					from, to := ch.old, sq[ch.i][ch.j]
+					if undo {
+						from, to = to, from
+					}
+					a, b := from, B[ch.i][ch.j]
+					na, nb := to, B[ch.i][ch.j]
+					if !moveA {
+						a, b = A[ch.i][ch.j], from
+						na, nb = A[ch.i][ch.j], to
+					}
+					tab.remove(a*n + b)
+					tab.add(na*n + nb)
+				}
+			}
+			update(false)
+			if want, _ := OrthConflicts(A, B); tab.conflicts() != want {
+				t.Fatalf("n=%d step %d: incremental %d, full %d", n, step, tab.conflicts(), want)
+			}
+			if rng.Intn(3) == 0 {
+				update(true)
+				undoMove(sq, changes)
+				if want, _ := OrthConflicts(A, B); tab.conflicts() != want {
+					t.Fatalf("n=%d step %d undo: incremental %d, full %d", n, step, tab.conflicts(), want)
+				}
+			}
+			if !IsLatinSquare(A) || !IsLatinSquare(B) {
+				t.Fatalf("n=%d step %d: move broke a square", n, step)
+			}
+		}
+		// fill с нуля даёт то же, что накопленные правки
+		inc := tab.conflicts()
+		tab.fill(A, B)
+		if tab.conflicts() != inc {
+			t.Errorf("n=%d: fill %d, incremental %d", n, tab.conflicts(), inc)
+		}
+	}
+}
+
+func TestSearchMOLSSidewaysProb(t *testing.T) {
+	// старт — две одинаковые циклические: n*n - n конфликтов; без рестартов
+	// жадный поиск принимает только ходы, уменьшающие конфликты. n=6:
+	// ортогональной пары нет, поиск идёт все steps шагов
+	const n, steps = 6, 5_000
+	start := n*n - n
+	tests := []struct {
+		prob   float64
+		greedy bool
+	}{
+		{0, true},
+		{0.5, false},
+		{1, false},
+	}
+	for _, tt := range tests {
+		run := func() MOLSResult {
+			return SearchMOLS(n, 2, MOLSOptions{
+				Rng:          rand.New(rand.NewSource(5)),
+				MaxSteps:     steps,
+				Method:       "hill_climb",
+				StallSteps:   -1,
+				SidewaysProb: tt.prob,
+				Seed:         [][][]int{MakeCyclic(n, 1), MakeCyclic(n, 1)},
+			})
+		}
+		first, second := run(), run()
+		if first.Accepted != second.Accepted || first.Conflicts != second.Conflicts || HashSquare(first.Squares[1]) != HashSquare(second.Squares[1]) {
+			t.Errorf("prob %v: same seed gave different runs", tt.prob)
+		}
+		greedy := first.Accepted <= int64(start-first.Conflicts)
+		if greedy != tt.greedy {
+			t.Errorf("prob %v: %d moves accepted for %d conflicts removed", tt.prob, first.Accepted, start-first.Conflicts)
+		}
+		if tt.prob == 1 && first.Accepted != first.Steps {
+			t.Errorf("prob 1: %d of %d moves accepted", first.Accepted, first.Steps)
+		}
+	}
+}
+
+func TestOrthogonalArray(t *testing.T) {
+	// в любых двух столбцах каждая упорядоченная пара символов — ровно раз
+	pairsOnce := func(oa [][]int, n int) bool {
+		for a := range oa[0] {
+			for b := a + 1; b < len(oa[0]); b++ {
+				seen := make([]bool, n*n)
+				for _, row := range oa {
+					if seen[row[a]*n+row[b]] {
+						return false
+					}
+					seen[row[a]*n+row[b]] = true
+				}
+			}
+		}
+		return true
+	}
+	galois := func(n, k int) [][][]int {
+		L, ok := GaloisMOLS(n, k)
+		if !ok {
+			t.Fatalf("GaloisMOLS(%d, %d) failed", n, k)
+		}
+		return L
+	}
+	tests := []struct {
+		name string
+		L    [][][]int
+		mols bool
+	}{
+		{"one square", [][][]int{MakeCyclic(4, 1)}, true},
+		{"GF(4) complete set", galois(4, 3), true},
+		{"GF(5) pair", galois(5, 2), true},
+		{"GF(7) k=4", galois(7, 4), true},
+		{"same square twice", [][][]int{MakeCyclic(5, 1), MakeCyclic(5, 1)}, false},
+	}
+	for _, tt := range tests {
+		n, k := len(tt.L[0]), len(tt.L)
+		oa := OrthogonalArray(tt.L)
+		if len(oa) != n*n {
+			t.Fatalf("%s: %d rows, want %d", tt.name, len(oa), n*n)
+		}
+		for r, row := range oa {
+			// столбцы: строка, столбец, L[0]..L[k-1] — по порядку клеток
+			i, j := r/n, r%n
+			if len(row) != k+2 || row[0] != i || row[1] != j {
+				t.Fatalf("%s: row %d = %v", tt.name, r, row)
+			}
+			for m := range tt.L {
+				if row[m+2] != tt.L[m][i][j] {
+					t.Fatalf("%s: row %d = %v, L[%d][%d][%d] = %d", tt.name, r, row, m, i, j, tt.L[m][i][j])
+				}
+			}
+		}
+		if got := pairsOnce(oa, n); got != tt.mols {
+			t.Errorf("%s: every pair once %v, want %v", tt.name, got, tt.mols)
+		}
+	}
+	if OrthogonalArray(nil) != nil {
+		t.Error("OrthogonalArray(nil) is not nil")
+	}
+}
+
+func TestOrthClique(t *testing.T) {
+	// conf[a][b] == 0 — пара ортогональна
+	table := func(k int, zero ...[2]int) [][]int {
+		conf := make([][]int, k)
+		for a := range conf {
+			conf[a] = make([]int, k)
+			for b := range conf[a] {
+				if a != b {
+					conf[a][b] = 1
+				}
+			}
+		}
+		for _, p := range zero {
+			conf[p[0]][p[1]], conf[p[1]][p[0]] = 0, 0
+		}
+		return conf
+	}
+	tests := []struct {
+		name string
+		conf [][]int
+		want int
+	}{
+		{"no pairs", table(3), 1},
+		{"one pair", table(4, [2]int{1, 3}), 2},
+		{"triangle beside a pair", table(5, [2]int{0, 1}, [2]int{2, 3}, [2]int{3, 4}, [2]int{2, 4}), 3},
+		{"path is not a clique", table(4, [2]int{0, 1}, [2]int{1, 2}, [2]int{2, 3}), 2},
+		{"all", table(4, [2]int{0, 1}, [2]int{0, 2}, [2]int{0, 3}, [2]int{1, 2}, [2]int{1, 3}, [2]int{2, 3}), 4},
+	}
+	for _, tt := range tests {
+		idx := orthClique(tt.conf)
+		if len(idx) != tt.want {
+			t.Errorf("%s: clique %v, want size %d", tt.name, idx, tt.want)
+			continue
+		}
+		for x, a := range idx {
+			for _, b := range idx[x+1:] {
+				if tt.conf[a][b] != 0 {
+					t.Errorf("%s: %d and %d are not orthogonal", tt.name, a, b)
+				}
+			}
+		}
+	}
+}
+
+func TestSearchMOLSPartialSubset(t *testing.T) {
+	gf4, _ := GaloisMOLS(4, 3)
+	tests := []struct {
+		name  string
+		n, k  int
+		seed  [][][]int
+		found bool
+		minK  int // не меньше стольких квадратов в поднаборе
+		maxK  int
+	}{
+		// порядка 4 — не больше 3 MOLS: четыре не найти, пара есть в затравке
+		{"n=4 k=4 from a pair", 4, 4, gf4[:2], false, 2, 3},
+		{"n=4 k=4 from a triple", 4, 4, gf4, false, 3, 3},
+		// порядка 6 ортогональных пар нет
+		{"n=6 k=2", 6, 2, nil, false, 1, 1},
+		{"n=5 k=2 found", 5, 2, nil, true, 2, 2},
+	}
+	for _, tt := range tests {
+		res := SearchMOLS(tt.n, tt.k, MOLSOptions{
+			Rng:          NewRand(RNGLegacy, 1),
+			MaxSteps:     20_000,
+			Method:       "hill_climb",
+			SidewaysProb: DefaultSidewaysProb,
+			Seed:         tt.seed,
+		})
+		if found := res.Conflicts == 0; found != tt.found {
+			t.Errorf("%s: %d conflicts, want found=%v", tt.name, res.Conflicts, tt.found)
+		}
+		if res.MaxFoundK < tt.minK || res.MaxFoundK > tt.maxK || len(res.Subset) != res.MaxFoundK {
+			t.Errorf("%s: max_found_k %d with %d squares, want %d..%d", tt.name, res.MaxFoundK, len(res.Subset), tt.minK, tt.maxK)
+			continue
+		}
+		if ok, msg := VerifyMOLS(res.Subset); !ok {
+			t.Errorf("%s: subset %s", tt.name, msg)
+		}
+	}
+}
+
+func TestConflictMatrix(t *testing.T) {
+	gf5, _ := GaloisMOLS(5, 4)
+	rng := NewRand(RNGLegacy, 1)
+	random := make([][][]int, 4)
+	for m := range random {
+		random[m] = MakeCyclic(6, 1)
+		RandomPermute(random[m], rng)
+	}
+	tests := []struct {
+		name string
+		L    [][][]int
+		zero bool // полный набор: все пары ортогональны
+	}{
+		{"empty", nil, true},
+		{"one square", [][][]int{MakeCyclic(4, 1)}, true},
+		{"galois 5", gf5, true},
+		{"same square twice", [][][]int{MakeCyclic(5, 1), MakeCyclic(5, 1), MakeCyclic(5, 2)}, false},
+		{"random 6", random, false},
+	}
+	for _, tt := range tests {
+		m := ConflictMatrix(tt.L)
+		if len(m) != len(tt.L) {
+			t.Errorf("%s: %d rows, want %d", tt.name, len(m), len(tt.L))
+			continue
+		}
+		for a := range m {
+			if len(m[a]) != len(tt.L) || m[a][a] != 0 {
+				t.Errorf("%s: row %d = %v", tt.name, a, m[a])
+				continue
+			}
+			for b := range m[a] {
+				want, _ := OrthConflicts(tt.L[a], tt.L[b])
+				if a != b && m[a][b] != want || m[a][b] != m[b][a] {
+					t.Errorf("%s: m[%d][%d] = %d, m[%d][%d] = %d, recomputed %d", tt.name, a, b, m[a][b], b, a, m[b][a], want)
+				}
+				if tt.zero && m[a][b] != 0 {
+					t.Errorf("%s: m[%d][%d] = %d, want 0", tt.name, a, b, m[a][b])
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkSearchMOLS12 reports local-search steps per second for a pair of
+// order 12, and what one conflict count costs with the reused flat buffer
+// against the map it replaced.
+func BenchmarkSearchMOLS12(b *testing.B) {
+	const n, steps = 12, 20_000
+	b.Run("search", func(b *testing.B) {
+		b.ReportAllocs()
+		var total int64
+		for k := 0; k < b.N; k++ {
+			res := SearchMOLS(n, 2, MOLSOptions{Rng: rand.New(rand.NewSource(int64(k))), MaxSteps: steps, StallSteps: -1})
+			total += res.Steps
+		}
+		b.ReportMetric(float64(total)/b.Elapsed().Seconds(), "steps/s")
+	})
+
+	rng := rand.New(rand.NewSource(1))
+	A, B := MakeCyclic(n, 1), MakeCyclic(n, 1)
+	RandomPermute(A, rng)
+	RandomPermute(B, rng)
+	b.Run("conflicts/buffer", func(b *testing.B) {
+		b.ReportAllocs()
+		seen := make([]bool, n*n)
+		for k := 0; k < b.N; k++ {
+			OrthConflictsBuf(A, B, seen)
+		}
+	})
+	b.Run("conflicts/map", func(b *testing.B) {
+		b.ReportAllocs()
+		for k := 0; k < b.N; k++ {
+			// прежняя реализация: map на n^2 ключей на каждый вызов
+			seen := make(map[int]bool, n*n)
+			for i := 0; i < n; i++ {
+				for j := 0; j < n; j++ {
+					seen[A[i][j]*n+B[i][j]] = true
+				}
+			}
+			_ = n*n - len(seen)
+		}
+	})
+}
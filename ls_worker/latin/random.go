@@ -0,0 +1,81 @@
+package latin
+
+import "math/rand"
+
+// MaxMCMCN bounds n for JacobsonMatthews: the chain keeps an n^3 incidence cube.
+const MaxMCMCN = 256
+
+// JacobsonMatthews runs the Jacobson–Matthews Markov chain on the Latin
+// square L in place for the given number of moves and returns how many were
+// made. The chain is uniform in the limit; n^3 moves is the usual mixing
+// budget. expired is polled every few thousand moves and may cut the run
+// short; L is always left a proper Latin square. For n < 2 there is only
+// one square and all moves count as made.
+func JacobsonMatthews(L [][]int, rng *rand.Rand, moves int64, expired func() bool) int64 {
+	n := len(L)
+	if n < 2 {
+		return moves
+	}
+	// m[(r*n+c)*n+s] — кратность символа s в клетке (r,c): 0/1, в «неправильном»
+	// квадрате одна клетка куба равна -1
+	m := make([]int8, n*n*n)
+	at := func(r, c, s int) *int8 { return &m[(r*n+c)*n+s] }
+	for r := range L {
+		for c, s := range L[r] {
+			*at(r, c, s) = 1
+		}
+	}
+	// pick returns a random index k with *get(k) == 1; in a proper square it is unique
+	pick := func(get func(k int) *int8) int {
+		found, chosen := 0, -1
+		for k := 0; k < n; k++ {
+			if *get(k) == 1 {
+				found++
+				if rng.Intn(found) == 0 {
+					chosen = k
+				}
+			}
+		}
+		return chosen
+	}
+
+	var done int64
+	improper := false
+	var r, c, s int
+	for done < moves || improper {
+		if !improper {
+			if done%defaultCheckEvery == 0 && expired != nil && expired() {
+				break
+			}
+			done++
+			// случайная пустая позиция куба: клетка и символ, которого в ней нет
+			r, c = rng.Intn(n), rng.Intn(n)
+			for s = rng.Intn(n); *at(r, c, s) != 0; s = rng.Intn(n) {
+			}
+		}
+		r1 := pick(func(k int) *int8 { return at(k, c, s) })
+		c1 := pick(func(k int) *int8 { return at(r, k, s) })
+		s1 := pick(func(k int) *int8 { return at(r, c, k) })
+		*at(r, c, s)++
+		*at(r, c1, s)--
+		*at(r1, c, s)--
+		*at(r, c, s1)--
+		*at(r1, c1, s)++
+		*at(r1, c, s1)++
+		*at(r, c1, s1)++
+		*at(r1, c1, s1)--
+		improper = *at(r1, c1, s1) < 0
+		r, c, s = r1, c1, s1
+	}
+
+	for r := range L {
+		for c := range L[r] {
+			for s := 0; s < n; s++ {
+				if *at(r, c, s) == 1 {
+					L[r][c] = s
+				}
+			}
+		}
+	}
+	return done
+}
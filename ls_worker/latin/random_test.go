@@ -0,0 +1,72 @@
+package latin
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestJacobsonMatthews(t *testing.T) {
+	tests := []struct {
+		n     int
+		moves int64
+	}{
+		{n: 1, moves: 10},
+		{n: 2, moves: 10},
+		{n: 3, moves: 100},
+		{n: 6, moves: 216},
+		{n: 10, moves: 1000},
+	}
+	for _, tt := range tests {
+		L := MakeCyclic(tt.n, 1)
+		if made := JacobsonMatthews(L, rand.New(rand.NewSource(1)), tt.moves, func() bool { return false }); made != tt.moves {
+			t.Errorf("n=%d: %d moves made, want %d", tt.n, made, tt.moves)
+		}
+		if !IsLatinSquare(L) {
+			t.Errorf("n=%d: %v is not Latin after the chain", tt.n, L)
+		}
+	}
+}
+
+func TestJacobsonMatthewsMixes(t *testing.T) {
+	// из одного циклического старта цепь должна разойтись по многим из 576
+	// квадратов порядка 4, включая класс группы Клейна (не изотопный Z4)
+	xor := [][]int{{0, 1, 2, 3}, {1, 0, 3, 2}, {2, 3, 0, 1}, {3, 2, 1, 0}}
+	if isKleinType(MakeCyclic(4, 1)) || !isKleinType(xor) {
+		t.Fatal("isKleinType misclassifies Z4 or Klein")
+	}
+	const runs = 300
+	seen := map[string]bool{}
+	klein := false
+	for seed := int64(0); seed < runs; seed++ {
+		L := MakeCyclic(4, 1)
+		JacobsonMatthews(L, rand.New(rand.NewSource(seed)), 64, func() bool { return false })
+		seen[fmt.Sprint(L)] = true
+		klein = klein || isKleinType(L)
+	}
+	if len(seen) < runs/2 {
+		t.Errorf("%d distinct squares from %d runs", len(seen), runs)
+	}
+	if !klein {
+		t.Error("chain never left the Z4 isotopy class")
+	}
+}
+
+// isKleinType reports whether the 4x4 Latin square L is isotopic to the
+// Klein group table: it has 12 intercalates (2x2 Latin subsquares), a Z4
+// square has 4.
+func isKleinType(L [][]int) bool {
+	intercalates := 0
+	for a := 0; a < 4; a++ {
+		for b := a + 1; b < 4; b++ {
+			for c := 0; c < 4; c++ {
+				for d := c + 1; d < 4; d++ {
+					if L[a][c] == L[b][d] && L[a][d] == L[b][c] {
+						intercalates++
+					}
+				}
+			}
+		}
+	}
+	return intercalates == 12
+}
@@ -0,0 +1,1493 @@
+package latin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Solver completes a partial Latin square by DFS with MRV cell selection and
+// forward checking. Exported fields configure the search (set them before
+// Solve) and report its statistics afterwards.
+type Solver struct {
+	Ctx          context.Context
+	Rng          *rand.Rand // порядок кандидатов; nil — без перемешивания
+	RandKind     RNG        // генератор клонов SolveParallel, сиды — из Rng
+	Deadline     time.Time  // нулевое значение — без дедлайна
+	MaxNodes     int64
+	MaxSolutions int
+	// CheckEvery: time.Now() дорогой — проверяем дедлайн не чаще раза в CheckEvery
+	// вызовов dfs (и не реже checkGap)
+	CheckEvery int64
+	// OnSolution, если задан, получает каждое решение сразу (sq нельзя сохранять);
+	// тогда в Solutions остаётся только первое
+	OnSolution func(index int, sq [][]int)
+	// CountOnly: перебрать все решения, только считая их в Found (Solutions пуст)
+	CountOnly bool
+	// Sample: перебрать все решения и оставить в Solutions одно, выбранное
+	// reservoir sampling'ом (равномерно среди найденных)
+	Sample bool
+	// LCV: пробовать сначала значения, которые отнимают кандидата у меньшего
+	// числа пустых соседей (least-constraining value); ничьи — в порядке Rng
+	LCV bool
+	// RowDense: ничьи MRV — сначала по более заполненной из двух линий клетки
+	// (строки или столбца), а не по их сумме; для почти полных префиксов
+	RowDense bool
+	// LexMin: клетки строго по строкам (без MRV), кандидаты по возрастанию,
+	// без перемешивания — первое решение лексикографически наименьшее.
+	// Без MRV перебор бывает на порядки дольше; Rng, LCV и RowDense не действуют
+	LexMin bool
+	// OnProgress, если задан, не чаще раза в ProgressEvery получает самую
+	// глубокую согласованную частичную доску (-1 — пусто; сохранять нельзя).
+	// В SolveParallel вызывается из нескольких горутин
+	OnProgress    func(partial [][]int)
+	ProgressEvery time.Duration
+	// OnTick, если задан, вызывается при каждой сверке с часами с числом узлов
+	// с прошлого вызова (для логов; должен быть дешёвым)
+	OnTick func(nodes int64)
+	// KeepBest: запоминать самую глубокую согласованную частичную доску и
+	// без OnProgress — её отдаёт Best, когда решение не найдено
+	KeepBest bool
+	// Distinct, если задан, даёт ключ решения: решение с уже встречавшимся
+	// ключом пропускается и не входит ни в Found, ни в Solutions (sq нельзя
+	// сохранять)
+	Distinct func(sq [][]int) string
+	// Trace: записывать в Path присваивания, которые привели к Solutions[0]
+	Trace bool
+	// Cost, если задан: Cost[i][j][v] — цена символа v в клетке (i,j). Solve
+	// обходит дерево branch-and-bound'ом и оставляет в Solutions одно самое
+	// дешёвое дополнение (цена — в BestCost); SolveParallel его не поддерживает
+	Cost [][][]float64
+
+	// Nodes — присваивания, пережившие forward checking; отсечённые им
+	// считаются в Prunes, а не в Nodes (без него было бы Nodes+Prunes узлов)
+	Nodes     int64
+	Prunes    int64
+	Stats     SearchStats
+	Found     int
+	Solutions [][][]int
+	// BestCost — цена Solutions[0] в режиме Cost (сумма по всем клеткам)
+	BestCost float64
+	// Tally — счёт в режиме CountOnly: с насыщением и по модулю (см. Counter)
+	Tally Counter
+	// Path (при Trace) — (i, j, v) в порядке присваивания: вынужденные
+	// ArcConsistency, затем ветвления DFS до решения; откаты и клетки
+	// префикса в него не входят
+	Path [][3]int
+
+	board   [][]int
+	fixed   [][]bool
+	n       int
+	rowMask []bitset
+	colMask []bitset
+	// candCount[i][j] — число кандидатов пустой клетки (forward checking)
+	candCount [][]int
+	// пустые клетки по строкам/столбцам — для ничьих MRV в selectCell
+	rowEmpty []int
+	colEmpty []int
+	// lcvCost: буфер orderCands для LCV, индекс — значение
+	lcvCost []int
+
+	untilCheck int64
+	// checkWindow: текущее окно между сверками с часами, растёт до CheckEvery
+	checkWindow int64
+	lastCheck   time.Time
+	timedOut    bool
+	cancelled   bool
+
+	// присваивания вне стека DFS (ArcConsistency, корень SolveParallel) — для Path
+	forced [][3]int
+
+	// стек DFS для checkpoint: клетка, порядок кандидатов, текущий индекс
+	stack      []Frame
+	checkpoint *Checkpoint
+	resume     []Frame
+	resumed    int   // сколько кадров resume уже развернули
+	nodesBase  int64 // Nodes на момент Resume — MaxNodes считается от него
+
+	// диагональный режим: маски главной и побочной диагоналей
+	diagonal bool
+	diagMask bitset
+	antiMask bitset
+
+	// режим судоку: маски блоков boxSide x boxSide, блок (i/boxSide, j/boxSide)
+	boxes   bool
+	boxSide int
+	boxMask []bitset
+
+	// forbid[i][j] — значения, запрещённые в клетке (i,j); nil — запретов нет
+	forbid [][]bitset
+
+	symmetric bool // L[i][j] == L[j][i]: ветвимся по верхнему треугольнику, ставим парами
+
+	// links[i][j] — клетки, с которыми (i,j) связана Relate; nil — связей нет
+	links [][][]link
+
+	// orderPos[i] — позиция строки i в цепочке BreakRowSymmetry, -1 — вне её
+	orderPos  []int
+	orderRows []int
+
+	seen map[string]bool // ключи Distinct найденных решений
+
+	// для OnProgress: самая глубокая доска и глубина стека, на которой она была
+	best         [][]int
+	bestDepth    int
+	bestDirty    bool
+	lastProgress time.Time
+	tickNodes    int64 // Nodes на момент прошлого OnTick
+}
+
+// link is one Relate constraint as seen from one of its cells.
+type link struct {
+	r, c  int
+	equal bool
+}
+
+// Frame is one level of the DFS stack: the cell being branched on, its
+// candidates in the order tried and the index of the one being explored.
+type Frame struct {
+	I     int   `json:"i"`
+	J     int   `json:"j"`
+	Cands []int `json:"cands"`
+	Next  int   `json:"next"`
+}
+
+// Checkpoint is the DFS frontier at the moment the deadline hit. Board is
+// the board the search started from; Resume rejects any other board.
+type Checkpoint struct {
+	Board  [][]int `json:"board"`
+	Frames []Frame `json:"frames"`
+	Nodes  int64   `json:"nodes"`
+	Prunes int64   `json:"prunes"`
+}
+
+const defaultCheckEvery = 4096
+
+// checkGap bounds the time between two clock checks whatever CheckEvery is:
+// on large boards a node is expensive enough that a fixed window of CheckEvery
+// nodes would overrun the deadline by seconds.
+const checkGap = 20 * time.Millisecond
+
+// SearchStats describes the shape of a DFS: how deep it branched, how often
+// it backed out of a branch and how many candidates a branching cell had on
+// average.
+type SearchStats struct {
+	MaxDepth   int   // наибольшее число клеток на стеке ветвления
+	Backtracks int64 // ветви, вернувшиеся без решения (не по бюджету)
+	Branched   int64 // клетки, на которых ветвились
+	Cands      int64 // их кандидатов всего
+}
+
+// Add merges o into st: counts add up, depths take the maximum.
+func (st *SearchStats) Add(o SearchStats) {
+	st.MaxDepth = max(st.MaxDepth, o.MaxDepth)
+	st.Backtracks += o.Backtracks
+	st.Branched += o.Branched
+	st.Cands += o.Cands
+}
+
+// AvgBranch is the mean number of candidates per branching cell.
+func (st SearchStats) AvgBranch() float64 {
+	if st.Branched == 0 {
+		return 0
+	}
+	return float64(st.Cands) / float64(st.Branched)
+}
+
+// NewSolver prepares a solver for board, where -1 marks an empty cell.
+func NewSolver(board [][]int, fixed [][]bool) *Solver {
+	n := len(board)
+	s := &Solver{
+		n:          n,
+		board:      DeepCopy(board),
+		fixed:      fixed,
+		rowMask:    make([]bitset, n),
+		colMask:    make([]bitset, n),
+		rowEmpty:   make([]int, n),
+		colEmpty:   make([]int, n),
+		CheckEvery: defaultCheckEvery,
+	}
+	for i := 0; i < n; i++ {
+		s.rowMask[i] = newBitset(n)
+		s.colMask[i] = newBitset(n)
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			v := s.board[i][j]
+			if v >= 0 {
+				s.rowMask[i].Set(v)
+				s.colMask[j].Set(v)
+			}
+		}
+	}
+	s.candCount = make([][]int, n)
+	for i := 0; i < n; i++ {
+		s.candCount[i] = make([]int, n)
+		for j := 0; j < n; j++ {
+			if s.board[i][j] == -1 {
+				s.candCount[i][j] = n - s.rowMask[i].OrCount(s.colMask[j])
+				s.rowEmpty[i]++
+				s.colEmpty[j]++
+			}
+		}
+	}
+	return s
+}
+
+// ArcConsistency propagates the fixed cells before the search: a cell left
+// with a single candidate is filled, as is a value that fits only one cell
+// of its row or column, until nothing changes. It returns the number of
+// filled cells and false if some cell or value ran out of options, in which
+// case the board has no completion. Forced cells hold in every completion,
+// so this never loses solutions.
+func (s *Solver) ArcConsistency() (filled int, ok bool) {
+	before := s.emptyCells()
+	for changed := true; changed; {
+		changed = false
+		// клетки с единственным кандидатом
+		for i := 0; i < s.n; i++ {
+			for j := 0; j < s.n; j++ {
+				if s.board[i][j] != -1 {
+					continue
+				}
+				switch s.candidateCount(i, j) {
+				case 0:
+					return before - s.emptyCells(), false
+				case 1:
+					changed = true
+					s.logForced(i, j, s.candidates(i, j)[0])
+					if !s.assign(i, j, s.candidates(i, j)[0]) {
+						return before - s.emptyCells(), false
+					}
+				}
+			}
+		}
+		// значения с единственным местом в строке / столбце
+		for line := 0; line < s.n; line++ {
+			for _, byRow := range []bool{true, false} {
+				for v := 0; v < s.n; v++ {
+					i, j, places := s.placesFor(line, v, byRow)
+					switch places {
+					case -1:
+						continue // v уже стоит в этой строке/столбце
+					case 0:
+						return before - s.emptyCells(), false
+					case 1:
+						changed = true
+						s.logForced(i, j, v)
+						if !s.assign(i, j, v) {
+							return before - s.emptyCells(), false
+						}
+					}
+				}
+			}
+		}
+	}
+	return before - s.emptyCells(), true
+}
+
+// CandidateGrid returns the current candidates of every empty cell; filled
+// cells get nil, so a dead cell stands out as an empty, non-nil slice.
+func (s *Solver) CandidateGrid() [][][]int {
+	grid := make([][][]int, s.n)
+	for i := range grid {
+		grid[i] = make([][]int, s.n)
+		for j := range grid[i] {
+			if s.board[i][j] == -1 {
+				grid[i][j] = s.candidates(i, j)
+			}
+		}
+	}
+	return grid
+}
+
+// HallViolation looks for a row or column whose empty cells cannot take its
+// missing values one each. By Hall's theorem that happens iff some k of the
+// cells admit fewer than k values between them; the board then has no
+// completion even though every cell alone may still have candidates. It
+// runs a bipartite matching per line and returns the first failing line as
+// ("row" | "col", index), or ("", -1) if there is none.
+func (s *Solver) HallViolation() (string, int) {
+	n := s.n
+	match := make([]int, n) // значение -> номер клетки в cells, -1 — свободно
+	seen := make([]bool, n)
+	cells := make([][2]int, 0, n)
+	for _, kind := range []string{"row", "col"} {
+		for line := 0; line < n; line++ {
+			empty := s.rowEmpty[line]
+			if kind == "col" {
+				empty = s.colEmpty[line]
+			}
+			// клетки линии; если каждой подходят все недостающие значения,
+			// паросочетание очевидно есть
+			cells = cells[:0]
+			full := true
+			for k := 0; k < n; k++ {
+				i, j := line, k
+				if kind == "col" {
+					i, j = k, line
+				}
+				if s.board[i][j] == -1 {
+					cells = append(cells, [2]int{i, j})
+					full = full && s.candCount[i][j] == empty
+				}
+			}
+			if full {
+				continue
+			}
+			for v := range match {
+				match[v] = -1
+			}
+			var augment func(c int) bool
+			augment = func(c int) bool {
+				i, j := cells[c][0], cells[c][1]
+				// сначала свободное значение, потом чередующийся путь (Кун)
+				for v := 0; v < n; v++ {
+					if match[v] < 0 && !seen[v] && !s.blocked(i, j, v) {
+						match[v] = c
+						return true
+					}
+				}
+				for v := 0; v < n; v++ {
+					if seen[v] || s.blocked(i, j, v) {
+						continue
+					}
+					seen[v] = true
+					if augment(match[v]) {
+						match[v] = c
+						return true
+					}
+				}
+				return false
+			}
+			for c := range cells {
+				clear(seen)
+				if !augment(c) {
+					return kind, line
+				}
+			}
+		}
+	}
+	return "", -1
+}
+
+// emptyCells returns the number of empty cells on the board.
+func (s *Solver) emptyCells() int {
+	empty := 0
+	for _, e := range s.rowEmpty {
+		empty += e
+	}
+	return empty
+}
+
+// placesFor counts the empty cells of row (byRow) or column line that can
+// take v, returning the last such cell; -1 means v is already placed there.
+func (s *Solver) placesFor(line, v int, byRow bool) (i, j, places int) {
+	if (byRow && s.rowMask[line].Test(v)) || (!byRow && s.colMask[line].Test(v)) {
+		return -1, -1, -1
+	}
+	for k := 0; k < s.n; k++ {
+		r, c := line, k
+		if !byRow {
+			r, c = k, line
+		}
+		if s.board[r][c] == -1 && s.allowed(r, c, v) {
+			i, j = r, c
+			places++
+		}
+	}
+	return i, j, places
+}
+
+// EnableDiagonals additionally forbids repeats on the main and anti
+// diagonals. The board must not already repeat a symbol on them; call it
+// right after NewSolver.
+func (s *Solver) EnableDiagonals() {
+	s.diagonal = true
+	s.diagMask = newBitset(s.n)
+	s.antiMask = newBitset(s.n)
+	for i := 0; i < s.n; i++ {
+		if v := s.board[i][i]; v >= 0 {
+			s.diagMask.Set(v)
+		}
+		if v := s.board[i][s.n-1-i]; v >= 0 {
+			s.antiMask.Set(v)
+		}
+	}
+	// пересчитываем кандидатов клеток на диагоналях
+	for i := 0; i < s.n; i++ {
+		for _, j := range []int{i, s.n - 1 - i} {
+			if s.board[i][j] != -1 {
+				continue
+			}
+			s.candCount[i][j] = 0
+			for v := 0; v < s.n; v++ {
+				if !s.blocked(i, j, v) {
+					s.candCount[i][j]++
+				}
+			}
+		}
+	}
+}
+
+// EnableBoxes additionally forbids repeats in each √n × √n box, which makes
+// the completion a Sudoku. n must be a perfect square and the board must not
+// already repeat a symbol in a box; call it right after NewSolver.
+func (s *Solver) EnableBoxes() {
+	s.boxes = true
+	s.boxSide, _ = BoxSide(s.n)
+	s.boxMask = make([]bitset, s.n)
+	for b := range s.boxMask {
+		s.boxMask[b] = newBitset(s.n)
+	}
+	for i := 0; i < s.n; i++ {
+		for j := 0; j < s.n; j++ {
+			if v := s.board[i][j]; v >= 0 {
+				s.boxMask[s.boxOf(i, j)].Set(v)
+			}
+		}
+	}
+	for i := 0; i < s.n; i++ {
+		for j := 0; j < s.n; j++ {
+			if s.board[i][j] != -1 {
+				continue
+			}
+			s.candCount[i][j] = 0
+			for v := 0; v < s.n; v++ {
+				if !s.blocked(i, j, v) {
+					s.candCount[i][j]++
+				}
+			}
+		}
+	}
+}
+
+// Forbid removes v from the candidates of the empty cell (i,j), for
+// list-coloring style constraints. Call it before ArcConsistency and Solve;
+// in symmetric mode forbid the mirror cell too.
+func (s *Solver) Forbid(i, j, v int) {
+	if s.forbid == nil {
+		s.forbid = make([][]bitset, s.n)
+		for r := range s.forbid {
+			s.forbid[r] = make([]bitset, s.n)
+			for c := range s.forbid[r] {
+				s.forbid[r][c] = newBitset(s.n)
+			}
+		}
+	}
+	if s.forbid[i][j].Test(v) {
+		return
+	}
+	if s.board[i][j] == -1 && !s.blocked(i, j, v) {
+		s.candCount[i][j]--
+	}
+	s.forbid[i][j].Set(v)
+}
+
+// Relate requires the cells a and b to hold the same symbol (equal) or
+// different ones. Call it before ArcConsistency and Solve. In symmetric mode
+// a cell below the diagonal stands for its mirror; relating a cell to itself
+// is a no-op. The check is made when a cell's candidates are listed, so
+// related cells leave the mask-based candidate counting for the slower
+// per-value scan.
+func (s *Solver) Relate(a, b [2]int, equal bool) {
+	if s.symmetric {
+		if a[0] > a[1] {
+			a[0], a[1] = a[1], a[0]
+		}
+		if b[0] > b[1] {
+			b[0], b[1] = b[1], b[0]
+		}
+	}
+	if a == b {
+		return
+	}
+	if s.links == nil {
+		s.links = make([][][]link, s.n)
+		for r := range s.links {
+			s.links[r] = make([][]link, s.n)
+		}
+	}
+	add := func(x, y [2]int) {
+		s.links[x[0]][x[1]] = append(s.links[x[0]][x[1]], link{y[0], y[1], equal})
+		if s.symmetric && x[0] != x[1] {
+			// ArcConsistency ставит и клетки под диагональю — связь видна и оттуда
+			s.links[x[1]][x[0]] = append(s.links[x[1]][x[0]], link{y[0], y[1], equal})
+		}
+	}
+	add(a, b)
+	add(b, a)
+}
+
+// linked reports whether (i,j) takes part in some Relate constraint.
+func (s *Solver) linked(i, j int) bool {
+	return s.links != nil && len(s.links[i][j]) > 0
+}
+
+// related reports whether v at (i,j) agrees with every filled cell it is
+// related to.
+func (s *Solver) related(i, j, v int) bool {
+	for _, l := range s.links[i][j] {
+		if w := s.board[l.r][l.c]; w >= 0 && (w == v) != l.equal {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Solver) boxOf(i, j int) int {
+	return i/s.boxSide*s.boxSide + j/s.boxSide
+}
+
+// EnableSymmetry restricts the search to symmetric squares: every value
+// placed at (i,j) is mirrored to (j,i). The board must already be symmetric
+// (empty cells included); call it right after NewSolver.
+func (s *Solver) EnableSymmetry() {
+	s.symmetric = true
+}
+
+// BreakRowSymmetry restricts the search to completions whose first column
+// strictly increases down rows (in the given order). Permuting rows that are
+// entirely empty in the prefix maps completions to completions, so with k
+// such rows every class of k! completions keeps exactly one representative:
+// Found then counts completions up to permutations of those rows. rows must
+// be empty in the board; call it before ArcConsistency.
+func (s *Solver) BreakRowSymmetry(rows []int) {
+	if len(rows) < 2 {
+		return
+	}
+	s.orderRows = rows
+	s.orderPos = make([]int, s.n)
+	for i := range s.orderPos {
+		s.orderPos[i] = -1
+	}
+	for k, r := range rows {
+		s.orderPos[r] = k
+	}
+}
+
+// Checkpoint returns the frontier saved when the search ran out of time,
+// or nil if it did not.
+func (s *Solver) Checkpoint() *Checkpoint {
+	return s.checkpoint
+}
+
+// Resume makes the next Solve continue the search recorded in cp instead of
+// starting over; node and prune counts carry on from cp. Call it after
+// ArcConsistency and before Solve. SolveParallel ignores it.
+func (s *Solver) Resume(cp *Checkpoint) error {
+	if len(cp.Board) != s.n {
+		return fmt.Errorf("checkpoint is for n=%d, board has n=%d", len(cp.Board), s.n)
+	}
+	for i := 0; i < s.n; i++ {
+		if len(cp.Board[i]) != s.n {
+			return fmt.Errorf("checkpoint board row %d has length %d", i, len(cp.Board[i]))
+		}
+		for j := 0; j < s.n; j++ {
+			if cp.Board[i][j] != s.board[i][j] {
+				return fmt.Errorf("checkpoint board differs from the prefix at (%d,%d)", i, j)
+			}
+		}
+	}
+	for d, f := range cp.Frames {
+		if f.I < 0 || f.I >= s.n || f.J < 0 || f.J >= s.n || f.Next < 0 || f.Next >= len(f.Cands) {
+			return fmt.Errorf("checkpoint frame %d is out of range", d)
+		}
+	}
+	s.resume = cp.Frames
+	s.resumed = 0
+	s.Nodes, s.Prunes = cp.Nodes, cp.Prunes
+	s.nodesBase = cp.Nodes
+	return nil
+}
+
+// snapshot records the current frontier; the board it stores has the stack
+// cells cleared, i.e. it is the board the search started from.
+func (s *Solver) snapshot() *Checkpoint {
+	cp := &Checkpoint{
+		Board:  DeepCopy(s.board),
+		Frames: make([]Frame, len(s.stack)),
+		Nodes:  s.Nodes,
+		Prunes: s.Prunes,
+	}
+	for d, f := range s.stack {
+		f.Cands = append([]int(nil), f.Cands...)
+		cp.Frames[d] = f
+		cp.Board[f.I][f.J] = -1
+	}
+	return cp
+}
+
+// Solve runs the DFS and returns whether a solution was found, the status
+// (done | no_solution | timeout | node_limit | cancelled) and the node count.
+func (s *Solver) Solve() (bool, string, int64) {
+	s.lastProgress = time.Now()
+	s.dfs()
+	if s.Sample && s.OnSolution != nil && len(s.Solutions) > 0 {
+		s.OnSolution(0, s.Solutions[0])
+	}
+	if len(s.Solutions) > 0 {
+		return true, "done", s.Nodes
+	}
+	if r := s.stopReason(); r != "" {
+		return false, r, s.Nodes
+	}
+	return false, "no_solution", s.Nodes
+}
+
+// Exhausted reports whether the last Solve walked the whole search tree
+// instead of stopping on a budget. It is meaningful for CountOnly, Sample
+// and Cost, which do not stop at the first solution.
+func (s *Solver) Exhausted() bool {
+	return s.stopReason() == ""
+}
+
+// stopReason returns cancelled, timeout or node_limit if the search was cut
+// short, or "" if it ended on its own.
+func (s *Solver) stopReason() string {
+	if s.cancelled {
+		return "cancelled"
+	}
+	// если остановились по времени/лимиту
+	if s.timedOut || pastDeadline(s.Deadline, time.Now()) {
+		return "timeout"
+	}
+	if s.MaxNodes > 0 && s.Nodes-s.nodesBase >= s.MaxNodes {
+		return "node_limit"
+	}
+	return ""
+}
+
+func (s *Solver) dfs() bool {
+	if s.expired() {
+		if s.timedOut && s.checkpoint == nil {
+			s.checkpoint = s.snapshot()
+		}
+		return false
+	}
+	if s.MaxNodes > 0 && s.Nodes-s.nodesBase >= s.MaxNodes {
+		return false
+	}
+	if s.Cost != nil && len(s.Solutions) > 0 && s.costBound() >= s.BestCost {
+		// даже по нижней оценке ветка не дешевле лучшего дополнения
+		s.Prunes++
+		return false
+	}
+
+	var iBest, jBest int
+	var candBest []int
+	start, replay := 0, false
+	if d := len(s.stack); d == s.resumed && d < len(s.resume) {
+		// продолжаем с checkpoint: та же клетка, тот же порядок, с прерванного кандидата
+		f := s.resume[d]
+		iBest, jBest, candBest, start = f.I, f.J, f.Cands, f.Next
+		s.resumed++
+		replay = true
+	} else {
+		var ok bool
+		iBest, jBest, candBest, ok = s.selectCell()
+		if !ok {
+			return false
+		}
+
+		if iBest == -1 {
+			// filled: сохраняем решение и продолжаем DFS, пока не наберём maxSolutions
+			if s.Distinct != nil && s.duplicate() {
+				return false
+			}
+			s.Found++
+			if s.CountOnly {
+				s.Tally.Add(1)
+				return false
+			}
+			if s.Cost != nil {
+				// на полной доске оценка точна; дороже лучшего сюда не дойти
+				s.BestCost = s.costBound()
+				if len(s.Solutions) == 0 {
+					s.Solutions = append(s.Solutions, DeepCopy(s.board))
+				} else {
+					s.Solutions[0] = DeepCopy(s.board)
+				}
+				s.recordPath()
+				return false
+			}
+			if s.Sample {
+				// reservoir: k-е решение заменяет выбранное с вероятностью 1/k
+				if len(s.Solutions) == 0 {
+					s.Solutions = append(s.Solutions, DeepCopy(s.board))
+					s.recordPath()
+				} else if s.Rng == nil || s.Rng.Intn(s.Found) == 0 {
+					s.Solutions[0] = DeepCopy(s.board)
+					s.recordPath()
+				}
+				return false
+			}
+			if s.OnSolution != nil {
+				s.OnSolution(s.Found-1, s.board)
+			}
+			if len(s.Solutions) == 0 {
+				s.recordPath()
+			}
+			if s.OnSolution == nil || len(s.Solutions) == 0 {
+				s.Solutions = append(s.Solutions, DeepCopy(s.board))
+			}
+			return s.Found >= max(s.MaxSolutions, 1)
+		}
+
+		// randomize candidate order using seed
+		s.orderCands(iBest, jBest, candBest)
+	}
+
+	top := len(s.stack)
+	s.stack = append(s.stack, Frame{I: iBest, J: jBest, Cands: candBest})
+	s.Stats.Branched++
+	s.Stats.Cands += int64(len(candBest))
+	s.Stats.MaxDepth = max(s.Stats.MaxDepth, top+1)
+	found := false
+	for k := start; k < len(candBest); k++ {
+		v := candBest[k]
+		s.stack[top].Next = k
+		// первый кандидат кадра из checkpoint уже посчитан в cp.Nodes
+		again := replay
+		replay = false
+		if !s.assign(iBest, jBest, v) {
+			// forward checking: у соседней клетки не осталось кандидатов
+			s.Prunes++
+			s.unassign(iBest, jBest, v)
+			continue
+		}
+		if !again {
+			s.Nodes++
+		}
+		if (s.OnProgress != nil || s.KeepBest) && top >= s.bestDepth {
+			s.recordBest(top + 1)
+		}
+		before := s.Found
+		if s.dfs() {
+			found = true
+			break
+		}
+		s.unassign(iBest, jBest, v)
+		if s.timedOut || s.cancelled || (s.MaxNodes > 0 && s.Nodes-s.nodesBase >= s.MaxNodes) {
+			// бюджет кончился: остальные кандидаты всё равно вернулись бы сразу,
+			// а на больших n перебор их при раскрутке стека стоит секунды
+			break
+		}
+		if s.Found == before {
+			s.Stats.Backtracks++ // в режиме подсчёта ветка с решениями — не откат
+		}
+	}
+	s.stack = s.stack[:top]
+	return found
+}
+
+// duplicate reports whether the full board has a Distinct key seen before,
+// remembering the key otherwise.
+func (s *Solver) duplicate() bool {
+	key := s.Distinct(s.board)
+	if s.seen[key] {
+		return true
+	}
+	if s.seen == nil {
+		s.seen = map[string]bool{}
+	}
+	s.seen[key] = true
+	return false
+}
+
+// SolveParallel splits the first branching level across worker goroutines,
+// each running DFS on its own clone of the solver. The lowest-index branch
+// with a solution wins and cancels the branches after it, so the result for
+// a given seed does not depend on scheduling. MaxNodes is enforced per
+// clone; the returned node count is the sum over all clones.
+func (s *Solver) SolveParallel(workers int) (bool, string, int64) {
+	i, j, cands, ok := s.selectCell()
+	if !ok {
+		return false, "no_solution", s.Nodes
+	}
+	if i == -1 {
+		s.Solutions = append(s.Solutions, DeepCopy(s.board))
+		return true, "done", s.Nodes
+	}
+	if workers > len(cands) {
+		workers = len(cands)
+	}
+	if workers <= 1 {
+		return s.Solve()
+	}
+	s.lastProgress = time.Now()
+
+	s.orderCands(i, j, cands)
+	s.Stats.Branched++
+	s.Stats.Cands += int64(len(cands))
+	s.Stats.MaxDepth = max(s.Stats.MaxDepth, 1)
+	// сиды для клонов берём из общего rng заранее — порядок не зависит от планировщика
+	seeds := make([]int64, len(cands))
+	for k := range seeds {
+		if s.Rng != nil {
+			seeds[k] = s.Rng.Int63()
+		} else {
+			seeds[k] = int64(k)
+		}
+	}
+
+	parent := s.Ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	// у каждой ветки свой ctx: нашедшая решение ветка k отменяет только ветки > k,
+	// поэтому побеждает первая по порядку ветка с решением, а не самая быстрая —
+	// результат не зависит от планировщика
+	ctxs := make([]context.Context, len(cands))
+	cancels := make([]context.CancelFunc, len(cands))
+	for k := range cands {
+		ctxs[k], cancels[k] = context.WithCancel(parent)
+	}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+	var mu sync.Mutex
+	first := len(cands) // наименьший индекс ветки, нашедшей решение
+
+	jobs := make(chan int)
+	clones := make([]*Solver, len(cands))
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				c := s.clone()
+				c.Ctx = ctxs[k]
+				c.OnSolution = nil // клоны копят решения, в поток отдаёт только победитель
+				c.Rng = NewRand(s.RandKind, seeds[k])
+				clones[k] = c
+				c.forced = append([][3]int(nil), s.forced...)
+				c.logForced(i, j, cands[k])
+				if !c.assign(i, j, cands[k]) {
+					c.Prunes++
+					continue
+				}
+				c.Nodes++
+				c.dfs()
+				if len(c.Solutions) > 0 {
+					mu.Lock()
+					if k < first {
+						for later := k + 1; later < first; later++ {
+							cancels[later]()
+						}
+						first = k
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for k := range cands {
+		mu.Lock()
+		stop := k > first
+		mu.Unlock()
+		if stop || parent.Err() != nil {
+			break
+		}
+		jobs <- k
+	}
+	close(jobs)
+	wg.Wait()
+
+	var winner *Solver
+	timedOut, nodeLimit := false, false
+	for _, c := range clones {
+		if c == nil {
+			continue
+		}
+		s.Nodes += c.Nodes
+		s.Prunes += c.Prunes
+		cs := c.Stats
+		cs.MaxDepth++ // у клона глубина считается под веткой корня
+		s.Stats.Add(cs)
+		if c.Found == 0 && !c.timedOut && !c.cancelled {
+			s.Stats.Backtracks++ // ветка корня исчерпана без решения
+		}
+		if c.best != nil && (s.best == nil || c.bestDepth > s.bestDepth) {
+			// у всех клонов глубина считается от ветки корня — сравнимы
+			s.best, s.bestDepth = c.best, c.bestDepth
+		}
+		if winner == nil && len(c.Solutions) > 0 {
+			winner = c
+		}
+		timedOut = timedOut || c.timedOut
+		nodeLimit = nodeLimit || (c.MaxNodes > 0 && c.Nodes-c.nodesBase >= c.MaxNodes)
+	}
+
+	if winner != nil {
+		s.Solutions = winner.Solutions
+		s.Found = winner.Found
+		s.Path = winner.Path
+		if s.OnSolution != nil {
+			for k, sq := range winner.Solutions {
+				s.OnSolution(k, sq)
+			}
+		}
+		return true, "done", s.Nodes
+	}
+	if parent.Err() != nil {
+		return false, "cancelled", s.Nodes
+	}
+	if timedOut || pastDeadline(s.Deadline, time.Now()) {
+		return false, "timeout", s.Nodes
+	}
+	if nodeLimit {
+		return false, "node_limit", s.Nodes
+	}
+	return false, "no_solution", s.Nodes
+}
+
+// clone returns an independent copy of the search state; fixed is shared
+// since it is never written after construction.
+func (s *Solver) clone() *Solver {
+	c := *s
+	c.board = DeepCopy(s.board)
+	c.candCount = DeepCopy(s.candCount)
+	c.rowEmpty = append([]int(nil), s.rowEmpty...)
+	c.colEmpty = append([]int(nil), s.colEmpty...)
+	if s.diagonal {
+		c.diagMask = append(bitset(nil), s.diagMask...)
+		c.antiMask = append(bitset(nil), s.antiMask...)
+	}
+	if s.boxes {
+		c.boxMask = make([]bitset, s.n)
+		for b := range s.boxMask {
+			c.boxMask[b] = append(bitset(nil), s.boxMask[b]...)
+		}
+	}
+	c.rowMask = make([]bitset, s.n)
+	c.colMask = make([]bitset, s.n)
+	for k := 0; k < s.n; k++ {
+		c.rowMask[k] = append(bitset(nil), s.rowMask[k]...)
+		c.colMask[k] = append(bitset(nil), s.colMask[k]...)
+	}
+	c.Solutions = nil
+	c.Found = 0
+	c.BestCost = 0
+	c.Tally = Counter{Modulus: s.Tally.Modulus}
+	c.Nodes, c.Prunes, c.Stats = 0, 0, SearchStats{}
+	c.untilCheck, c.checkWindow, c.lastCheck = 0, 0, time.Time{}
+	c.stack, c.checkpoint, c.resume, c.resumed, c.nodesBase = nil, nil, nil, 0, 0
+	c.best, c.bestDepth, c.bestDirty, c.tickNodes = nil, 0, false, 0
+	c.seen, c.lcvCost = nil, nil
+	return &c
+}
+
+// logForced notes an assignment made outside the DFS stack for Path.
+func (s *Solver) logForced(i, j, v int) {
+	if s.Trace {
+		s.forced = s.appendTrace(s.forced, i, j, v)
+	}
+}
+
+// recordPath sets Path to the assignments behind the current board.
+func (s *Solver) recordPath() {
+	if !s.Trace {
+		return
+	}
+	s.Path = append(s.Path[:0:0], s.forced...)
+	for _, f := range s.stack {
+		s.Path = s.appendTrace(s.Path, f.I, f.J, f.Cands[f.Next])
+	}
+}
+
+// appendTrace appends (i,j,v) and, in symmetric mode, its mirror (j,i,v).
+func (s *Solver) appendTrace(path [][3]int, i, j, v int) [][3]int {
+	path = append(path, [3]int{i, j, v})
+	if s.symmetric && i != j {
+		path = append(path, [3]int{j, i, v})
+	}
+	return path
+}
+
+// selectCell picks the next empty cell by MRV (min candidates), breaking
+// ties toward the cell with the fewest empty peers in its row and column.
+// That keeps the search filling one line at a time; the opposite, most
+// empty peers first, scatters it over the board and on an empty 70x70 turns
+// a few thousand nodes into a timeout. With RowDense the fuller of the
+// two lines is compared first, then their sum. A cell with a single
+// candidate is taken at once: it is forced, so no tiebreak can shrink the
+// tree there, and the scan stops early. Cells are scanned
+// row-major and only a strictly better cell replaces the current one, so
+// among equal cells the first in row-major order wins. The seeded output
+// depends on this order: keep it when touching the loops. The scan only
+// counts candidates; the slice is built for the chosen cell alone.
+// It returns iBest == -1 when the board is full and ok == false on a dead
+// cell.
+func (s *Solver) selectCell() (iBest, jBest int, candBest []int, ok bool) {
+	if s.LexMin {
+		return s.firstCell()
+	}
+	iBest, jBest = -1, -1
+	bestLen := math.MaxInt32
+	bestPeers := math.MaxInt32
+
+	for i := 0; i < s.n; i++ {
+		for j := 0; j < s.n; j++ {
+			if s.board[i][j] != -1 || (s.symmetric && i > j) {
+				continue
+			}
+			count := s.candidateCount(i, j)
+			if count == 0 {
+				return -1, -1, nil, false
+			}
+			peers := s.rowEmpty[i] + s.colEmpty[j]
+			if s.RowDense {
+				peers += min(s.rowEmpty[i], s.colEmpty[j]) * 2 * s.n
+			}
+			if count < bestLen || (count == bestLen && peers < bestPeers) {
+				bestLen, bestPeers = count, peers
+				iBest, jBest = i, j
+				if count == 1 {
+					return i, j, s.candidates(i, j), true
+				}
+			}
+		}
+	}
+	if iBest >= 0 {
+		candBest = s.candidates(iBest, jBest)
+	}
+	return iBest, jBest, candBest, true
+}
+
+// firstCell is selectCell for LexMin: the first empty cell in row-major
+// order (upper triangle in symmetric mode, where the rest is mirrored).
+// Only this cell is counted: a dead cell further on is caught by forward
+// checking in assign, or once the scan reaches it.
+func (s *Solver) firstCell() (i, j int, cands []int, ok bool) {
+	for i = 0; i < s.n; i++ {
+		for j = 0; j < s.n; j++ {
+			if s.board[i][j] != -1 || (s.symmetric && i > j) {
+				continue
+			}
+			if s.candidateCount(i, j) == 0 {
+				return -1, -1, nil, false
+			}
+			return i, j, s.candidates(i, j), true
+		}
+	}
+	return -1, -1, nil, true
+}
+
+// pastDeadline reports whether deadline is set and now is after it: the
+// zero time, which an embedder gets by not setting Deadline, means none.
+func pastDeadline(deadline, now time.Time) bool {
+	return !deadline.IsZero() && now.After(deadline)
+}
+
+// expired reports whether the deadline has passed or ctx was cancelled,
+// consulting the clock at most once per CheckEvery calls and at least once
+// per checkGap.
+func (s *Solver) expired() bool {
+	if s.timedOut || s.cancelled {
+		return true
+	}
+	s.untilCheck--
+	if s.untilCheck > 0 {
+		return false
+	}
+	now := time.Now()
+	// окно удваивается до CheckEvery, пока сверки чаще checkGap, и делится
+	// пополам, когда узлы дорогие
+	switch {
+	case s.checkWindow == 0:
+		s.checkWindow = 1
+	case now.Sub(s.lastCheck) > checkGap && s.checkWindow > 1:
+		s.checkWindow /= 2
+	case s.checkWindow < s.CheckEvery:
+		s.checkWindow = min(2*s.checkWindow, s.CheckEvery)
+	}
+	s.lastCheck, s.untilCheck = now, s.checkWindow
+	s.cancelled = s.Ctx != nil && s.Ctx.Err() != nil
+	s.timedOut = pastDeadline(s.Deadline, now)
+	if s.OnTick != nil {
+		s.OnTick(s.Nodes - s.tickNodes)
+		s.tickNodes = s.Nodes
+	}
+	if s.OnProgress != nil && s.bestDirty && now.Sub(s.lastProgress) >= s.ProgressEvery {
+		s.OnProgress(s.best)
+		s.bestDirty, s.lastProgress = false, now
+	}
+	return s.timedOut || s.cancelled
+}
+
+// Best returns the deepest consistent partial board the search reached
+// (KeepBest or OnProgress), or the root board if it never got past it.
+func (s *Solver) Best() [][]int {
+	if s.best == nil {
+		return DeepCopy(s.board)
+	}
+	return DeepCopy(s.best)
+}
+
+// recordBest remembers the current board as the deepest one reached.
+func (s *Solver) recordBest(depth int) {
+	if s.best == nil {
+		s.best = DeepCopy(s.board)
+	} else {
+		for i := range s.board {
+			copy(s.best[i], s.board[i])
+		}
+	}
+	s.bestDepth, s.bestDirty = depth, true
+}
+
+func (s *Solver) candidates(i, j int) []int {
+	row, col := s.rowMask[i], s.colMask[j]
+	cands := make([]int, 0, s.n-row.OrCount(col))
+	ordered, linked := s.ordered(i, j), s.linked(i, j)
+	for v := 0; v < s.n; v++ {
+		if s.blocked(i, j, v) || (linked && !s.related(i, j, v)) {
+			continue
+		}
+		if !ordered || s.inOrder(i, v) {
+			cands = append(cands, v)
+		}
+	}
+	return cands
+}
+
+// candidateCount is len(s.candidates(i, j)) without building the slice:
+// n minus the popcount of the union of every mask that applies to the cell,
+// a word at a time. The MRV scan calls it for every empty cell; only the
+// chosen cell gets its candidates listed.
+func (s *Solver) candidateCount(i, j int) int {
+	if s.ordered(i, j) || s.linked(i, j) {
+		return len(s.candidates(i, j)) // порядок BreakRowSymmetry и связи Relate масками не выразить
+	}
+	row, col := s.rowMask[i], s.colMask[j]
+	var forbid, box, diag, anti bitset
+	if s.forbid != nil {
+		forbid = s.forbid[i][j]
+	}
+	if s.boxes {
+		box = s.boxMask[s.boxOf(i, j)]
+	}
+	if s.diagonal {
+		if i == j {
+			diag = s.diagMask
+		}
+		if i+j == s.n-1 {
+			anti = s.antiMask
+		}
+	}
+	blocked := 0
+	for w := range row {
+		x := row[w] | col[w]
+		if forbid != nil {
+			x |= forbid[w]
+		}
+		if box != nil {
+			x |= box[w]
+		}
+		if diag != nil {
+			x |= diag[w]
+		}
+		if anti != nil {
+			x |= anti[w]
+		}
+		blocked += bits.OnesCount64(x)
+	}
+	return s.n - blocked
+}
+
+// allowed reports whether v can go to the empty cell (i,j).
+func (s *Solver) allowed(i, j, v int) bool {
+	return !s.blocked(i, j, v) && (!s.ordered(i, j) || s.inOrder(i, v)) && (!s.linked(i, j) || s.related(i, j, v))
+}
+
+// blocked reports whether v is forbidden at (i,j) or already used in its
+// row, column, (in diagonal mode) a diagonal or (in box mode) its box.
+func (s *Solver) blocked(i, j, v int) bool {
+	if s.rowMask[i].Test(v) || s.colMask[j].Test(v) {
+		return true
+	}
+	if s.forbid != nil && s.forbid[i][j].Test(v) {
+		return true
+	}
+	if s.boxes && s.boxMask[s.boxOf(i, j)].Test(v) {
+		return true
+	}
+	return s.diagonal && s.onBlockedDiagonal(i, j, v)
+}
+
+func (s *Solver) onBlockedDiagonal(i, j, v int) bool {
+	return (i == j && s.diagMask.Test(v)) || (i+j == s.n-1 && s.antiMask.Test(v))
+}
+
+// ordered reports whether (i,j) is a first-column cell of a BreakRowSymmetry row.
+func (s *Solver) ordered(i, j int) bool {
+	return j == 0 && s.orderPos != nil && s.orderPos[i] >= 0
+}
+
+// inOrder checks v at (i,0) against the filled first-column cells of the
+// nearest ordered rows above and below: the rows between them still need
+// distinct unused values of column 0 on each side of v.
+func (s *Solver) inOrder(i, v int) bool {
+	pos := s.orderPos[i]
+	lo, loPos := -1, -1
+	for k := pos - 1; k >= 0; k-- {
+		if w := s.board[s.orderRows[k]][0]; w >= 0 {
+			lo, loPos = w, k
+			break
+		}
+	}
+	hi, hiPos := s.n, len(s.orderRows)
+	for k := pos + 1; k < len(s.orderRows); k++ {
+		if w := s.board[s.orderRows[k]][0]; w >= 0 {
+			hi, hiPos = w, k
+			break
+		}
+	}
+	if v <= lo || v >= hi {
+		return false
+	}
+	return s.freeInCol0(lo, v) >= pos-loPos-1 && s.freeInCol0(v, hi) >= hiPos-pos-1
+}
+
+// freeInCol0 counts values strictly between a and b not yet used in column 0.
+func (s *Solver) freeInCol0(a, b int) int {
+	free := 0
+	for w := a + 1; w < b; w++ {
+		if !s.colMask[0].Test(w) {
+			free++
+		}
+	}
+	return free
+}
+
+// assign places v at (i,j) and, in symmetric mode, its mirror at (j,i).
+// Like place, it returns false on a dead peer and must then be undone.
+func (s *Solver) assign(i, j, v int) bool {
+	ok := s.place(i, j, v)
+	if s.symmetric && i != j {
+		ok = s.place(j, i, v) && ok
+	}
+	return ok
+}
+
+func (s *Solver) unassign(i, j, v int) {
+	if s.symmetric && i != j {
+		s.unplace(j, i, v)
+	}
+	s.unplace(i, j, v)
+}
+
+// place assigns v to (i,j) and updates candidate counts of the empty peers
+// in row i and column j (and its diagonals or box in those modes). It returns
+// false if some peer is left without candidates; the caller must still
+// unplace in that case.
+func (s *Solver) place(i, j, v int) bool {
+	ok := true
+	for c := 0; c < s.n; c++ {
+		if c != j && !s.dropCand(i, c, v) {
+			ok = false
+		}
+	}
+	for r := 0; r < s.n; r++ {
+		if r != i && !s.dropCand(r, j, v) {
+			ok = false
+		}
+	}
+	if s.diagonal {
+		for k := 0; k < s.n; k++ {
+			if i == j && k != i && !s.dropCand(k, k, v) {
+				ok = false
+			}
+			if i+j == s.n-1 && k != i && !s.dropCand(k, s.n-1-k, v) {
+				ok = false
+			}
+		}
+		if i == j {
+			s.diagMask.Set(v)
+		}
+		if i+j == s.n-1 {
+			s.antiMask.Set(v)
+		}
+	}
+	if s.boxes {
+		// клетки блока вне строки i и столбца j — остальные уже обработаны выше
+		r0, c0 := i/s.boxSide*s.boxSide, j/s.boxSide*s.boxSide
+		for r := r0; r < r0+s.boxSide; r++ {
+			for c := c0; c < c0+s.boxSide; c++ {
+				if r != i && c != j && !s.dropCand(r, c, v) {
+					ok = false
+				}
+			}
+		}
+		s.boxMask[s.boxOf(i, j)].Set(v)
+	}
+	s.board[i][j] = v
+	s.rowMask[i].Set(v)
+	s.colMask[j].Set(v)
+	s.rowEmpty[i]--
+	s.colEmpty[j]--
+	return ok
+}
+
+func (s *Solver) unplace(i, j, v int) {
+	s.board[i][j] = -1
+	s.rowMask[i].Clear(v)
+	s.colMask[j].Clear(v)
+	s.rowEmpty[i]++
+	s.colEmpty[j]++
+	if s.boxes {
+		s.boxMask[s.boxOf(i, j)].Clear(v)
+		r0, c0 := i/s.boxSide*s.boxSide, j/s.boxSide*s.boxSide
+		for r := r0; r < r0+s.boxSide; r++ {
+			for c := c0; c < c0+s.boxSide; c++ {
+				if r != i && c != j {
+					s.restoreCand(r, c, v)
+				}
+			}
+		}
+	}
+	if s.diagonal {
+		if i == j {
+			s.diagMask.Clear(v)
+		}
+		if i+j == s.n-1 {
+			s.antiMask.Clear(v)
+		}
+		for k := 0; k < s.n; k++ {
+			if i == j && k != i {
+				s.restoreCand(k, k, v)
+			}
+			if i+j == s.n-1 && k != i {
+				s.restoreCand(k, s.n-1-k, v)
+			}
+		}
+	}
+	for c := 0; c < s.n; c++ {
+		if c != j {
+			s.restoreCand(i, c, v)
+		}
+	}
+	for r := 0; r < s.n; r++ {
+		if r != i {
+			s.restoreCand(r, j, v)
+		}
+	}
+}
+
+// dropCand removes v from the candidates of the peer (r,c) if it is empty
+// and v was still open there; it returns false if no candidates are left.
+func (s *Solver) dropCand(r, c, v int) bool {
+	if s.board[r][c] != -1 || s.blocked(r, c, v) {
+		return true
+	}
+	s.candCount[r][c]--
+	return s.candCount[r][c] > 0
+}
+
+// restoreCand undoes dropCand once v is off the board again.
+func (s *Solver) restoreCand(r, c, v int) {
+	if s.board[r][c] == -1 && !s.blocked(r, c, v) {
+		s.candCount[r][c]++
+	}
+}
+
+// orderCands shuffles the candidates of (i,j) and, with LCV, stably sorts
+// them by how many empty peers each would constrain. In Cost mode the
+// cheapest value goes first instead, so a good bound is found early.
+// With LexMin it leaves them ascending.
+func (s *Solver) orderCands(i, j int, cands []int) {
+	if s.LexMin {
+		return // candidates и так по возрастанию
+	}
+	s.shuffleInts(cands)
+	if s.Cost != nil {
+		cost := s.Cost[i][j]
+		sort.SliceStable(cands, func(a, b int) bool { return cost[cands[a]] < cost[cands[b]] })
+		return
+	}
+	if !s.LCV {
+		return
+	}
+	// цена по значению в общем буфере: сортировка кончается до спуска в dfs
+	if s.lcvCost == nil {
+		s.lcvCost = make([]int, s.n)
+	}
+	cost := s.lcvCost
+	for _, v := range cands {
+		cost[v] = s.constrains(i, j, v)
+	}
+	sort.SliceStable(cands, func(a, b int) bool { return cost[cands[a]] < cost[cands[b]] })
+}
+
+// costBound is a lower bound on the cost of any completion of the current
+// board: filled cells at their cost plus the cheapest remaining candidate of
+// every empty cell. On a full board it is the exact cost; a dead cell gives
+// +Inf.
+func (s *Solver) costBound() float64 {
+	total := 0.0
+	for i := 0; i < s.n; i++ {
+		for j := 0; j < s.n; j++ {
+			if v := s.board[i][j]; v >= 0 {
+				total += s.Cost[i][j][v]
+				continue
+			}
+			cheapest := math.Inf(1)
+			for v := 0; v < s.n; v++ {
+				if s.allowed(i, j, v) {
+					cheapest = min(cheapest, s.Cost[i][j][v])
+				}
+			}
+			total += cheapest
+		}
+	}
+	return total
+}
+
+// constrains counts the empty row, column and (in box mode) box peers of
+// (i,j) that still have v as a candidate.
+func (s *Solver) constrains(i, j, v int) int {
+	count := 0
+	open := func(r, c int) {
+		if s.board[r][c] == -1 && !s.blocked(r, c, v) {
+			count++
+		}
+	}
+	for k := 0; k < s.n; k++ {
+		if k != j {
+			open(i, k)
+		}
+		if k != i {
+			open(k, j)
+		}
+	}
+	if s.boxes {
+		r0, c0 := i/s.boxSide*s.boxSide, j/s.boxSide*s.boxSide
+		for r := r0; r < r0+s.boxSide; r++ {
+			for c := c0; c < c0+s.boxSide; c++ {
+				if r != i && c != j {
+					open(r, c)
+				}
+			}
+		}
+	}
+	return count
+}
+
+func (s *Solver) shuffleInts(a []int) {
+	if s.Rng == nil {
+		return
+	}
+	for i := len(a) - 1; i > 0; i-- {
+		j := s.Rng.Intn(i + 1)
+		a[i], a[j] = a[j], a[i]
+	}
+}
@@ -0,0 +1,1154 @@
+package latin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestSolver is NewSolver with a fixed seed and a generous deadline.
+func newTestSolver(board [][]int, seed int64) *Solver {
+	s := NewSolver(board, nil)
+	s.Rng = rand.New(rand.NewSource(seed))
+	s.Deadline = time.Now().Add(30 * time.Second)
+	return s
+}
+
+func TestSelectCellTiebreakNodes(t *testing.T) {
+	// L-образный префикс: заполнены (с дырами) первые четыре строки и столбца
+	prefix := [][]int{
+		{8, 7, 3, 5, 2, -1, 1, 4, 0},
+		{3, 5, 2, 6, 1, 4, -1, 8, 7},
+		{2, -1, 1, 4, 0, 8, -1, 3, -1},
+		{1, 4, 0, -1, 7, 3, 5, -1, -1},
+		{0, 8, 7, 3, -1, -1, -1, -1, -1},
+		{7, 3, -1, -1, -1, -1, -1, -1, -1},
+		{-1, -1, 6, 1, -1, -1, -1, -1, -1},
+		{-1, -1, 4, 0, -1, -1, -1, -1, -1},
+		{4, -1, 8, 7, -1, -1, -1, -1, -1},
+	}
+	// полный перебор: дерево не зависит от порядка значений, только от клеток.
+	// Без тайбрейка MRV берёт первую по строкам клетку с минимумом кандидатов
+	s := newTestSolver(prefix, 1)
+	plainMRV := func() (int, int, []int, bool) {
+		iBest, jBest, bestLen := -1, -1, math.MaxInt32
+		for i := range prefix {
+			for j := range prefix {
+				if s.board[i][j] != -1 {
+					continue
+				}
+				count := s.candidateCount(i, j)
+				if count == 0 {
+					return -1, -1, nil, false
+				}
+				if count < bestLen {
+					iBest, jBest, bestLen = i, j, count
+				}
+			}
+		}
+		if iBest < 0 {
+			return -1, -1, nil, true
+		}
+		return iBest, jBest, s.candidates(iBest, jBest), true
+	}
+	beforeFound, before, _ := walkTree(s, plainMRV, true)
+	s.CountOnly = true
+	if _, status, _ := s.Solve(); status != "no_solution" {
+		t.Fatalf("status %q, want the tree walked to the end", status)
+	}
+	afterFound, after := s.Found, s.Nodes
+	if beforeFound != afterFound {
+		t.Fatalf("tiebreak changed the count: %d vs %d", afterFound, beforeFound)
+	}
+	if after >= before {
+		t.Errorf("tiebreak nodes = %d, plain MRV = %d; want fewer with the tiebreak", after, before)
+	}
+}
+
+func TestForwardCheckingNodes(t *testing.T) {
+	// полный перебор одних и тех же префиксов с forward checking и без него:
+	// отсечённое присваивание не становится узлом, а уходит в Prunes; без
+	// отсечения каждое из них было бы узлом, так что Nodes+Prunes не больше
+	// прежнего счёта
+	var nodesBefore, nodesAfter int64
+	for seed := int64(1); seed <= 5; seed++ {
+		prefix := nearlyComplete(8, 0.45, seed)
+		s := newTestSolver(prefix, seed)
+		beforeFound, before, _ := walkTree(s, s.selectCell, false)
+		s.CountOnly = true
+		if _, status, _ := s.Solve(); status != "no_solution" {
+			t.Fatalf("seed %d: status %q, want the tree walked to the end", seed, status)
+		}
+		if s.Found != beforeFound {
+			t.Fatalf("seed %d: forward checking changed the count: %d vs %d", seed, s.Found, beforeFound)
+		}
+		if s.Nodes+s.Prunes > before {
+			t.Errorf("seed %d: without fc %d nodes; with fc %d nodes + %d prunes, want no more in total",
+				seed, before, s.Nodes, s.Prunes)
+		}
+		nodesBefore += before
+		nodesAfter += s.Nodes
+	}
+	t.Logf("nodes over 5 prefixes: %d without forward checking, %d with", nodesBefore, nodesAfter)
+	if nodesAfter >= nodesBefore {
+		t.Errorf("forward checking cut nothing: %d nodes vs %d", nodesAfter, nodesBefore)
+	}
+}
+
+// walkTree walks the whole search tree of s the way dfs does, with pick in
+// place of selectCell. With forward set, an assignment that leaves a peer
+// without candidates is pruned as in dfs; without it, it becomes a node
+// whose subtree dies at the dead cell. The board is restored on return.
+func walkTree(s *Solver, pick func() (i, j int, cands []int, ok bool), forward bool) (found int, nodes, prunes int64) {
+	var walk func()
+	walk = func() {
+		i, j, cands, ok := pick()
+		if !ok {
+			return
+		}
+		if i == -1 {
+			found++
+			return
+		}
+		for _, v := range cands {
+			if !s.assign(i, j, v) && forward {
+				prunes++
+				s.unassign(i, j, v)
+				continue
+			}
+			nodes++
+			walk()
+			s.unassign(i, j, v)
+		}
+	}
+	walk()
+	return found, nodes, prunes
+}
+
+// emptyBoard returns an n x n board with every cell empty (-1).
+func emptyBoard(n int) [][]int {
+	b := make([][]int, n)
+	for i := range b {
+		b[i] = make([]int, n)
+		for j := range b[i] {
+			b[i][j] = -1
+		}
+	}
+	return b
+}
+
+func TestSolveEmptyBeyond64(t *testing.T) {
+	for _, n := range []int{65, 70, 80} {
+		s := newTestSolver(emptyBoard(n), 1)
+		ok, status, nodes := s.Solve()
+		if !ok || status != "done" {
+			t.Errorf("n=%d: ok=%v status=%q after %d nodes", n, ok, status, nodes)
+			continue
+		}
+		if !IsLatinSquare(s.Solutions[0]) {
+			t.Errorf("n=%d: completion is not a Latin square", n)
+		}
+	}
+}
+
+func TestCandidateCountTracksCandidates(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     int
+		setup func(s *Solver)
+	}{
+		{name: "plain", n: 7, setup: func(s *Solver) {}},
+		{name: "diagonal", n: 8, setup: func(s *Solver) { s.EnableDiagonals() }},
+		{name: "boxes", n: 9, setup: func(s *Solver) { s.EnableBoxes() }},
+		{name: "symmetric", n: 7, setup: func(s *Solver) { s.EnableSymmetry() }},
+		{name: "forbid", n: 7, setup: func(s *Solver) {
+			s.Forbid(0, 0, 1)
+			s.Forbid(3, 4, 2)
+			s.Forbid(6, 6, 0)
+		}},
+	}
+	for _, tt := range tests {
+		s := newTestSolver(emptyBoard(tt.n), 1)
+		tt.setup(s)
+		r := rand.New(rand.NewSource(2))
+		type move struct{ i, j, v int }
+		var moves []move
+		check := func(when string) {
+			for i := 0; i < tt.n; i++ {
+				for j := 0; j < tt.n; j++ {
+					if s.board[i][j] != -1 {
+						continue
+					}
+					if got, want := s.candidateCount(i, j), len(s.candidates(i, j)); got != want {
+						t.Fatalf("%s %s: candidateCount(%d,%d) = %d, candidates has %d", tt.name, when, i, j, got, want)
+					}
+					// счётчик forward checking обязан совпадать с popcount по маскам
+					if got := s.candCount[i][j]; got != s.candidateCount(i, j) {
+						t.Fatalf("%s %s: candCount[%d][%d] = %d, masks leave %d", tt.name, when, i, j, got, s.candidateCount(i, j))
+					}
+				}
+			}
+		}
+		// несколько присваиваний вперёд, потом откат: счётчики должны сойтись на каждом шаге
+		for step := 0; step < tt.n*2; step++ {
+			i, j, cands, ok := s.selectCell()
+			if !ok || i < 0 {
+				break
+			}
+			v := cands[r.Intn(len(cands))]
+			if !s.assign(i, j, v) {
+				s.unassign(i, j, v)
+				break
+			}
+			moves = append(moves, move{i, j, v})
+			check("after assign")
+		}
+		for k := len(moves) - 1; k >= 0; k-- {
+			s.unassign(moves[k].i, moves[k].j, moves[k].v)
+			check("after unassign")
+		}
+	}
+}
+
+// BenchmarkMRVScan20 compares one MRV pass over an empty 20x20 board with
+// popcount counting against listing every cell's candidates.
+func BenchmarkMRVScan20(b *testing.B) {
+	s := NewSolver(emptyBoard(20), nil)
+	b.Run("candidateCount", func(b *testing.B) {
+		b.ReportAllocs()
+		for k := 0; k < b.N; k++ {
+			s.selectCell()
+		}
+	})
+	b.Run("candidates", func(b *testing.B) {
+		b.ReportAllocs()
+		for k := 0; k < b.N; k++ {
+			for i := 0; i < s.n; i++ {
+				for j := 0; j < s.n; j++ {
+					_ = len(s.candidates(i, j))
+				}
+			}
+		}
+	})
+}
+
+func TestZeroDeadlineMeansNone(t *testing.T) {
+	board := emptyBoard(6)
+	cyclic := MakeCyclic(5, 1)
+	// ни один из решателей не получает Deadline — должен дойти до ответа, а не timeout
+	tests := []struct {
+		name string
+		run  func() string
+	}{
+		{"Solver", func() string { _, status, _ := NewSolver(board, nil).Solve(); return status }},
+		{"SolveParallel", func() string { _, status, _ := NewSolver(board, nil).SolveParallel(2); return status }},
+		{"DLX", func() string { _, status, _ := NewDLX(board).Solve(); return status }},
+		{"FindOrthogonalMate", func() string { return FindOrthogonalMate(cyclic, MateOptions{}).Status }},
+		{"SearchMOLS", func() string {
+			if SearchMOLS(5, 2, MOLSOptions{Rng: rand.New(rand.NewSource(1)), MaxSteps: 200_000}).Conflicts != 0 {
+				return "not found"
+			}
+			return "done"
+		}},
+	}
+	for _, tt := range tests {
+		if got := tt.run(); got != "done" {
+			t.Errorf("%s without a Deadline: status %q, want done", tt.name, got)
+		}
+	}
+}
+
+func TestSolveParallelLowestBranchWins(t *testing.T) {
+	// эталон — ветки корня по очереди, как их раздаёт SolveParallel:
+	// побеждает первая с решением, сколько бы горутин ни было
+	for seed := int64(1); seed <= 3; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		prefix := MakeCyclic(10, 1)
+		RandomPermute(prefix, rng)
+		for i := range prefix {
+			for j := range prefix[i] {
+				if rng.Float64() > 0.25 {
+					prefix[i][j] = -1
+				}
+			}
+		}
+		ref := newTestSolver(prefix, seed)
+		i, j, cands, _ := ref.selectCell()
+		if len(cands) < 2 {
+			t.Fatalf("seed %d: root has %d candidates, SolveParallel would not branch", seed, len(cands))
+		}
+		ref.orderCands(i, j, cands)
+		seeds := make([]int64, len(cands))
+		for k := range seeds {
+			seeds[k] = ref.Rng.Int63()
+		}
+		var want [][]int
+		for k, v := range cands {
+			c := ref.clone()
+			c.Rng = NewRand(RNGLegacy, seeds[k])
+			if !c.assign(i, j, v) {
+				continue
+			}
+			if c.dfs(); len(c.Solutions) > 0 {
+				want = c.Solutions[0]
+				break
+			}
+		}
+		if want == nil {
+			t.Fatalf("seed %d: no branch of the root has a solution", seed)
+		}
+		for _, workers := range []int{2, 4, 8, 8} {
+			s := newTestSolver(prefix, seed)
+			if ok, status, _ := s.SolveParallel(workers); !ok {
+				t.Fatalf("seed %d, %d workers: status %q", seed, workers, status)
+			}
+			if !slices.EqualFunc(s.Solutions[0], want, slices.Equal) {
+				t.Errorf("seed %d, %d workers: %v, want the lowest branch's %v", seed, workers, s.Solutions[0], want)
+			}
+		}
+	}
+}
+
+func TestSolveParallelCancelsLaterBranches(t *testing.T) {
+	// в (0,0) только 0 или 1 — это корень MRV. При 1 клетки (0,1)..(0,13)
+	// делят 12 значений 2..13: ловушка Холла, которую forward checking видит
+	// лишь на глубине, перебор ~12! узлов. При 0 дополнение находится сразу,
+	// и ветка 0 должна отменить ветку 1, а не ждать её до дедлайна
+	const n, trap = 20, 13
+	s := NewSolver(emptyBoard(n), nil)
+	s.Deadline = time.Now().Add(30 * time.Second)
+	for v := 2; v < n; v++ {
+		s.Forbid(0, 0, v)
+	}
+	for j := 1; j <= trap; j++ {
+		for v := trap + 1; v < n; v++ {
+			s.Forbid(0, j, v)
+		}
+		s.Forbid(0, j, 0)
+	}
+	start := time.Now()
+	ok, status, _ := s.SolveParallel(2)
+	if !ok || s.Solutions[0][0][0] != 0 || !IsLatinSquare(s.Solutions[0]) {
+		t.Fatalf("status %q; want a Latin completion from branch 0", status)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("SolveParallel took %v: the trapped branch was not cancelled", took)
+	}
+
+	// отмена снаружи останавливает все ветки
+	s = NewSolver(emptyBoard(n), nil)
+	s.Deadline = time.Now().Add(30 * time.Second)
+	for v := 2; v < n; v++ {
+		s.Forbid(0, 0, v)
+	}
+	for j := 1; j <= trap; j++ {
+		for v := trap + 1; v < n; v++ {
+			s.Forbid(0, j, v)
+		}
+		s.Forbid(0, j, 0)
+		s.Forbid(0, j, 1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s.Ctx = ctx
+	start = time.Now()
+	if ok, status, _ := s.SolveParallel(2); ok || status != "cancelled" {
+		t.Errorf("ok %v, status %q; want cancelled", ok, status)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("cancelled SolveParallel took %v", took)
+	}
+}
+
+func TestSelectCellRowMajorFirstWins(t *testing.T) {
+	// эталон: первая по строкам клетка с наименьшими (кандидаты, пустые соседи),
+	// а если есть клетка с одним кандидатом — первая такая
+	want := func(s *Solver) (int, int) {
+		bi, bj, best := -1, -1, [2]int{}
+		for i := 0; i < s.n; i++ {
+			for j := 0; j < s.n; j++ {
+				if s.board[i][j] != -1 {
+					continue
+				}
+				key := [2]int{len(s.candidates(i, j)), s.rowEmpty[i] + s.colEmpty[j]}
+				if key[0] == 1 {
+					return i, j
+				}
+				if bi < 0 || key[0] < best[0] || (key[0] == best[0] && key[1] < best[1]) {
+					bi, bj, best = i, j, key
+				}
+			}
+		}
+		return bi, bj
+	}
+	r := rand.New(rand.NewSource(3))
+	for trial := 0; trial < 200; trial++ {
+		n := 4 + r.Intn(5)
+		sq := MakeCyclic(n, 1)
+		RandomPermute(sq, r)
+		board := emptyBoard(n)
+		for i := range board {
+			for j := range board[i] {
+				if r.Intn(3) == 0 {
+					board[i][j] = sq[i][j]
+				}
+			}
+		}
+		s := NewSolver(board, nil)
+		i, j, cands, ok := s.selectCell()
+		if !ok {
+			continue // тупиковая клетка — порядок не при чём
+		}
+		if wi, wj := want(s); i != wi || j != wj {
+			t.Fatalf("board %v: selectCell = (%d,%d), want (%d,%d)", board, i, j, wi, wj)
+		}
+		if i >= 0 && len(cands) != len(s.candidates(i, j)) {
+			t.Fatalf("board %v: %d candidates returned for (%d,%d), want %d", board, len(cands), i, j, len(s.candidates(i, j)))
+		}
+	}
+}
+
+func TestSelectCellSequencePinned(t *testing.T) {
+	prefix := [][]int{
+		{0, -1, -1, 3, -1},
+		{-1, 2, -1, -1, -1},
+		{-1, -1, 4, -1, 1},
+		{-1, -1, -1, -1, -1},
+		{4, -1, -1, -1, 2},
+	}
+	// без Rng кандидаты идут по возрастанию: путь зависит только от порядка клеток
+	want := [][3]int{
+		{0, 4, 4}, {0, 1, 1}, {0, 2, 2}, {1, 4, 0}, {3, 4, 3}, {1, 0, 1},
+		{1, 2, 3}, {1, 3, 4}, {3, 0, 2}, {2, 0, 3}, {2, 1, 0}, {2, 3, 2},
+		{3, 1, 4}, {4, 1, 3}, {3, 2, 0}, {3, 3, 1}, {4, 2, 1}, {4, 3, 0},
+	}
+	s := NewSolver(prefix, nil)
+	s.Trace = true
+	if ok, status, _ := s.Solve(); !ok {
+		t.Fatalf("status %q", status)
+	}
+	if !slices.Equal(s.Path, want) {
+		t.Errorf("cell sequence changed:\n got %v\nwant %v", s.Path, want)
+	}
+}
+
+func TestBreakRowSymmetryCount(t *testing.T) {
+	tests := []struct {
+		n           int
+		full, fixed int // дополнений без редукции и с ней: full / (n-1)!
+	}{
+		{n: 3, full: 2, fixed: 1},
+		{n: 4, full: 24, fixed: 4},
+		{n: 5, full: 1344, fixed: 56},
+	}
+	for _, tt := range tests {
+		board := emptyBoard(tt.n)
+		var rows []int
+		for j := range board[0] {
+			board[0][j] = j
+		}
+		for i := 1; i < tt.n; i++ {
+			rows = append(rows, i)
+		}
+		count := func(breakSym bool) (int, int64) {
+			s := NewSolver(board, nil)
+			s.CountOnly = true
+			if breakSym {
+				s.BreakRowSymmetry(rows)
+			}
+			s.Solve()
+			return s.Found, s.Nodes
+		}
+		full, fullNodes := count(false)
+		fixed, fixedNodes := count(true)
+		if full != tt.full || fixed != tt.fixed {
+			t.Errorf("n=%d: found %d / %d with row ordering, want %d / %d", tt.n, full, fixed, tt.full, tt.fixed)
+		}
+		if tt.n >= 4 && fixedNodes >= fullNodes {
+			t.Errorf("n=%d: %d nodes with row ordering, %d without: no reduction", tt.n, fixedNodes, fullNodes)
+		}
+	}
+}
+
+func TestDiagonalSolve(t *testing.T) {
+	tests := []struct {
+		n     int
+		found bool
+		count int // все диагональные латинские квадраты порядка n; 0 — не считаем
+	}{
+		{n: 1, found: true, count: 1},
+		{n: 2, found: false, count: 0},
+		{n: 3, found: false, count: 0},
+		{n: 4, found: true, count: 48},
+		{n: 5, found: true, count: 960},
+		{n: 8, found: true},
+		{n: 12, found: true},
+	}
+	for _, tt := range tests {
+		s := NewSolver(emptyBoard(tt.n), nil)
+		s.EnableDiagonals()
+		ok, status, _ := s.Solve()
+		if ok != tt.found {
+			t.Errorf("n=%d: found %v (%s), want %v", tt.n, ok, status, tt.found)
+			continue
+		}
+		if ok && !IsDiagonalLatinSquare(s.Solutions[0]) {
+			t.Errorf("n=%d: %v is not diagonal Latin", tt.n, s.Solutions[0])
+		}
+		if tt.n > 5 {
+			continue
+		}
+		c := NewSolver(emptyBoard(tt.n), nil)
+		c.EnableDiagonals()
+		c.CountOnly = true
+		c.Solve()
+		if c.Found != tt.count {
+			t.Errorf("n=%d: counted %d diagonal squares, want %d", tt.n, c.Found, tt.count)
+		}
+	}
+}
+
+func TestIsDiagonalLatinSquare(t *testing.T) {
+	tests := []struct {
+		name string
+		sq   [][]int
+		want bool
+	}{
+		{"order 4", [][]int{{0, 1, 2, 3}, {2, 3, 0, 1}, {3, 2, 1, 0}, {1, 0, 3, 2}}, true},
+		{"cyclic: constant anti-diagonal", MakeCyclic(5, 1), false},
+		{"not Latin", [][]int{{0, 1}, {0, 1}}, false},
+	}
+	for _, tt := range tests {
+		if got := IsDiagonalLatinSquare(tt.sq); got != tt.want {
+			t.Errorf("%s: IsDiagonalLatinSquare = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSymmetricCount(t *testing.T) {
+	// симметричные латинские квадраты порядка n: 1, 2, 6, 96, 720
+	tests := []struct{ n, count int }{
+		{1, 1}, {2, 2}, {3, 6}, {4, 96}, {5, 720},
+	}
+	for _, tt := range tests {
+		s := NewSolver(emptyBoard(tt.n), nil)
+		s.EnableSymmetry()
+		s.CountOnly = true
+		s.Solve()
+		if s.Found != tt.count {
+			t.Errorf("n=%d: counted %d symmetric squares, want %d", tt.n, s.Found, tt.count)
+		}
+		s = NewSolver(emptyBoard(tt.n), nil)
+		s.EnableSymmetry()
+		if ok, _, _ := s.Solve(); !ok || !IsLatinSquare(s.Solutions[0]) || !IsSymmetric(s.Solutions[0]) {
+			t.Errorf("n=%d: solution %v", tt.n, s.Solutions)
+		}
+	}
+}
+
+func TestSampleCloserToUniform(t *testing.T) {
+	// дерево неравномерное: у первых ветвей разное число листьев, поэтому
+	// первое найденное решение смещено даже при перемешанных кандидатах
+	tests := []struct {
+		name  string
+		board [][]int
+	}{
+		{"first row", [][]int{{0, 1, 2, 3}, {-1, -1, -1, -1}, {-1, -1, -1, -1}, {-1, -1, -1, -1}}},
+		{"first row and a cell", [][]int{{0, 1, 2, 3}, {1, -1, -1, -1}, {-1, -1, -1, -1}, {-1, -1, -1, -1}}},
+		{"two cells", [][]int{{0, -1, -1, -1}, {-1, 1, -1, -1}, {-1, -1, -1, -1}, {-1, -1, -1, -1}}},
+	}
+	const runs = 2000
+	for _, tt := range tests {
+		c := NewSolver(tt.board, nil)
+		c.CountOnly = true
+		c.Solve()
+		k := c.Found
+		// chi2 по всем k дополнениям, в том числе ни разу не выпавшим
+		chi2 := func(sample bool) float64 {
+			hist := map[string]int{}
+			for seed := int64(0); seed < runs; seed++ {
+				s := NewSolver(tt.board, nil)
+				s.Rng = rand.New(rand.NewSource(seed))
+				s.Sample = sample
+				if ok, _, _ := s.Solve(); !ok {
+					t.Fatalf("%s: seed %d found nothing", tt.name, seed)
+				}
+				hist[HashSquare(s.Solutions[0])]++
+			}
+			exp := float64(runs) / float64(k)
+			chi := float64(k-len(hist)) * exp
+			for _, h := range hist {
+				chi += (float64(h) - exp) * (float64(h) - exp) / exp
+			}
+			return chi
+		}
+		first, sampled := chi2(false), chi2(true)
+		// k-1 степеней свободы: 2k+20 с запасом выше любого разумного квантиля
+		if sampled > float64(2*k+20) || sampled*10 > first {
+			t.Errorf("%s (%d completions): chi2 %.1f sampled vs %.1f first-found", tt.name, k, sampled, first)
+		}
+	}
+}
+
+// interrupted runs DFS on board until about stopAt nodes, then lets the
+// deadline hit, and returns the saved checkpoint.
+func interrupted(t *testing.T, board [][]int, stopAt int64) *Checkpoint {
+	t.Helper()
+	s := NewSolver(board, nil)
+	s.CheckEvery = 1
+	s.OnTick = func(int64) {
+		if s.Nodes >= stopAt {
+			s.Deadline = time.Now().Add(-time.Second)
+		}
+	}
+	if _, status, _ := s.Solve(); status != "timeout" {
+		t.Fatalf("stopAt=%d: status %q, want timeout", stopAt, status)
+	}
+	if s.Checkpoint() == nil {
+		t.Fatalf("stopAt=%d: no checkpoint on timeout", stopAt)
+	}
+	return s.Checkpoint()
+}
+
+func TestCheckpointResume(t *testing.T) {
+	diag := emptyBoard(7)
+	diag[0][0], diag[3][5] = 2, 6
+	tests := []struct {
+		name   string
+		board  [][]int
+		stopAt int64
+	}{
+		{"empty 12x12 early", emptyBoard(12), 5},
+		{"empty 12x12 late", emptyBoard(12), 100},
+		{"prefix 7x7", diag, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// без сида DFS детерминирован: продолжение должно прийти к тому же
+			// квадрату, что и поиск без остановки
+			straight := NewSolver(tt.board, nil)
+			if ok, _, _ := straight.Solve(); !ok {
+				t.Fatal("no solution without interruption")
+			}
+
+			cp := interrupted(t, tt.board, tt.stopAt)
+			if len(cp.Frames) == 0 || cp.Nodes < tt.stopAt {
+				t.Fatalf("checkpoint depth %d, nodes %d", len(cp.Frames), cp.Nodes)
+			}
+			b, err := json.Marshal(cp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var back Checkpoint
+			if err := json.Unmarshal(b, &back); err != nil {
+				t.Fatal(err)
+			}
+
+			s := NewSolver(tt.board, nil)
+			if err := s.Resume(&back); err != nil {
+				t.Fatal(err)
+			}
+			ok, status, nodes := s.Solve()
+			if !ok || status != "done" {
+				t.Fatalf("resumed: status %q", status)
+			}
+			sq := s.Solutions[0]
+			if !IsLatinSquare(sq) {
+				t.Errorf("resumed square %v is not Latin", sq)
+			}
+			if HashSquare(sq) != HashSquare(straight.Solutions[0]) {
+				t.Errorf("resumed square %v, straight DFS gave %v", sq, straight.Solutions[0])
+			}
+			if nodes != straight.Nodes {
+				t.Errorf("resumed run ended at %d nodes, straight DFS at %d", nodes, straight.Nodes)
+			}
+		})
+	}
+}
+
+func TestResumeRejectsOtherBoard(t *testing.T) {
+	cp := interrupted(t, emptyBoard(8), 10)
+	other := emptyBoard(8)
+	other[7][7] = 1
+	tests := []struct {
+		name  string
+		board [][]int
+	}{
+		{"other n", emptyBoard(9)},
+		{"other prefix", other},
+	}
+	for _, tt := range tests {
+		if err := NewSolver(tt.board, nil).Resume(cp); err == nil {
+			t.Errorf("%s: Resume accepted a checkpoint for another board", tt.name)
+		}
+	}
+}
+
+// sudokuBoard parses rows of digits 1..9 ('.' — пусто) into a 0-based board.
+func sudokuBoard(rows ...string) [][]int {
+	b := make([][]int, len(rows))
+	for i, r := range rows {
+		b[i] = make([]int, len(r))
+		for j, c := range r {
+			b[i][j] = -1
+			if c != '.' {
+				b[i][j] = int(c - '1')
+			}
+		}
+	}
+	return b
+}
+
+func TestSudoku(t *testing.T) {
+	tests := []struct {
+		name           string
+		givens, solved [][]int
+	}{
+		{"wikipedia", sudokuBoard(
+			"53..7....", "6..195...", ".98....6.",
+			"8...6...3", "4..8.3..1", "7...2...6",
+			".6....28.", "...419..5", "....8..79",
+		), sudokuBoard(
+			"534678912", "672195348", "198342567",
+			"859761423", "426853791", "713924856",
+			"961537284", "287419635", "345286179",
+		)},
+		{"4x4", sudokuBoard("1...", "..3.", ".4..", "...2"), sudokuBoard("1324", "4231", "2413", "3142")},
+	}
+	for _, tt := range tests {
+		s := NewSolver(tt.givens, nil)
+		s.EnableBoxes()
+		s.MaxSolutions = 2 // и заодно проверяем, что решение единственно
+		s.Solve()
+		if len(s.Solutions) != 1 {
+			t.Fatalf("%s: %d solutions, want exactly 1", tt.name, len(s.Solutions))
+		}
+		if got := s.Solutions[0]; !IsSudoku(got) || HashSquare(got) != HashSquare(tt.solved) {
+			t.Errorf("%s: solved to %v, want %v", tt.name, got, tt.solved)
+		}
+	}
+}
+
+func TestLCVNodes12(t *testing.T) {
+	// одни и те же 12x12 префиксы с порядком значений по умолчанию и по LCV;
+	// LCV кладёт первым значение, меньше всего урезающее соседей
+	var plain, lcv int64
+	for seed := int64(1); seed <= 10; seed++ {
+		prefix := nearlyComplete(12, 0.5, seed)
+		for _, useLCV := range []bool{false, true} {
+			s := newTestSolver(prefix, seed)
+			s.LCV = useLCV
+			ok, status, nodes := s.Solve()
+			if !ok || !IsLatinSquare(s.Solutions[0]) {
+				t.Fatalf("seed %d, lcv=%v: status %q, no Latin completion", seed, useLCV, status)
+			}
+			if useLCV {
+				lcv += nodes
+			} else {
+				plain += nodes
+			}
+		}
+	}
+	t.Logf("nodes over 10 prefixes: default %d, lcv %d", plain, lcv)
+	if lcv > plain {
+		t.Errorf("lcv took %d nodes, default %d; want no more with LCV", lcv, plain)
+	}
+}
+
+func TestForbid(t *testing.T) {
+	first := func(board [][]int, forbid [][3]int) ([][]int, bool) {
+		s := NewSolver(board, nil)
+		for _, f := range forbid {
+			s.Forbid(f[0], f[1], f[2])
+		}
+		ok, _, _ := s.Solve()
+		if !ok {
+			return nil, false
+		}
+		return s.Solutions[0], true
+	}
+	board := emptyBoard(4)
+	free, _ := first(board, nil)
+	tests := []struct {
+		name   string
+		forbid [][3]int
+		found  bool
+	}{
+		{"value of the free solution", [][3]int{{1, 0, free[1][0]}}, true},
+		{"main diagonal 0", [][3]int{{0, 0, 0}, {1, 1, 0}, {2, 2, 0}, {3, 3, 0}}, true},
+		{"whole cell", [][3]int{{2, 2, 0}, {2, 2, 1}, {2, 2, 2}, {2, 2, 3}}, false},
+		// 0 запрещён во всей строке 3, кроме столбца 3, и в столбце 3, кроме строки 3... и там тоже
+		{"symbol out of a row", [][3]int{{3, 0, 0}, {3, 1, 0}, {3, 2, 0}, {3, 3, 0}}, false},
+	}
+	for _, tt := range tests {
+		sq, ok := first(board, tt.forbid)
+		if ok != tt.found {
+			t.Errorf("%s: found %v, want %v", tt.name, ok, tt.found)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if !IsLatinSquare(sq) {
+			t.Errorf("%s: %v is not Latin", tt.name, sq)
+		}
+		for _, f := range tt.forbid {
+			if sq[f[0]][f[1]] == f[2] {
+				t.Errorf("%s: forbidden %d at (%d,%d)", tt.name, f[2], f[0], f[1])
+			}
+		}
+		if HashSquare(sq) == HashSquare(free) {
+			t.Errorf("%s: same completion as without forbidden values", tt.name)
+		}
+	}
+}
+
+func TestSolveParallelOneWorker(t *testing.T) {
+	// одна горутина — обычный Solve: те же решения и узлы
+	tests := []struct {
+		name      string
+		board     [][]int
+		countOnly bool
+	}{
+		{"complete 6x6", emptyBoard(6), false},
+		{"count 4x4", emptyBoard(4), true},
+		{"count 5x5", emptyBoard(5), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := func(parallel bool) *Solver {
+				s := newTestSolver(tt.board, 1)
+				s.CountOnly = tt.countOnly
+				if parallel {
+					s.SolveParallel(1)
+				} else {
+					s.Solve()
+				}
+				return s
+			}
+			seq, par := run(false), run(true)
+			if seq.Found != par.Found || seq.Nodes != par.Nodes {
+				t.Errorf("found %d, nodes %d; Solve gave %d, %d", par.Found, par.Nodes, seq.Found, seq.Nodes)
+			}
+			if !tt.countOnly && !slices.EqualFunc(seq.Solutions[0], par.Solutions[0], slices.Equal) {
+				t.Errorf("solution %v, Solve gave %v", par.Solutions[0], seq.Solutions[0])
+			}
+		})
+	}
+}
+
+func TestHallViolation(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []string
+		kind string
+		line int
+	}{
+		{"empty", []string{"....", "....", "....", "...."}, "", -1},
+		{"completable", []string{"12..", "..3.", "....", "...."}, "", -1},
+		// в строке 0 недостаёт 3 и 4, но 3 уже стоит в столбцах 2 и 3:
+		// у каждой клетки кандидат есть (4), а на двоих — один
+		{"row", []string{"12..", "..3.", "...3", "...."}, "row", 0},
+		{"col", []string{"1...", "2...", ".3..", "..3."}, "col", 0},
+		// то же на 6x6, где арк-консистентность молчит
+		{"row, arc consistent", []string{"1.....", ".4....", ".56...", ".645..", "..56..", "...4.."}, "row", 0},
+		{"last col", []string{"...1", "...2", "3...", ".3.."}, "col", 3},
+	}
+	for _, tt := range tests {
+		s := NewSolver(sudokuBoard(tt.rows...), nil)
+		kind, line := s.HallViolation()
+		if kind != tt.kind || line != tt.line {
+			t.Errorf("%s: violation (%q, %d), want (%q, %d)", tt.name, kind, line, tt.kind, tt.line)
+		}
+		if tt.kind == "" {
+			continue
+		}
+		// решателю без проверки Холла придётся это доказать перебором
+		if ok, status, _ := s.Solve(); ok || status != "no_solution" {
+			t.Errorf("%s: Solve %v, %s; want no_solution", tt.name, ok, status)
+		}
+	}
+}
+
+// nearlyComplete returns a random n x n Latin square with each cell kept
+// with probability keep and the rest emptied.
+func nearlyComplete(n int, keep float64, seed int64) [][]int {
+	rng := rand.New(rand.NewSource(seed))
+	L := MakeCyclic(n, 1)
+	JacobsonMatthews(L, rng, int64(n*n*n), nil)
+	for i := range L {
+		for j := range L[i] {
+			if rng.Float64() > keep {
+				L[i][j] = -1
+			}
+		}
+	}
+	return L
+}
+
+func TestRowDense(t *testing.T) {
+	tests := []struct {
+		n    int
+		keep float64
+		seed int64
+	}{
+		{8, 0.5, 1}, {9, 0.5, 2}, {9, 0.5, 15}, {9, 0.5, 17}, {9, 0.5, 20}, {10, 0.6, 3}, {10, 0.5, 4},
+	}
+	var nodes [2]int64
+	for _, tt := range tests {
+		board := nearlyComplete(tt.n, tt.keep, tt.seed)
+		var found [2]int
+		var run [2]int64
+		for k, rowDense := range []bool{false, true} {
+			s := NewSolver(board, nil)
+			s.CountOnly = true
+			s.RowDense = rowDense
+
+			s.Solve()
+			found[k], run[k] = s.Found, s.Nodes
+			nodes[k] += s.Nodes
+		}
+		// порядок клеток меняет дерево, но не число дополнений
+		if found[0] != found[1] {
+			t.Errorf("n=%d seed %d: %d completions with row_dense, %d with mrv", tt.n, tt.seed, found[1], found[0])
+		}
+		if run[1] > 2*run[0] || run[0] > 2*run[1] {
+			t.Errorf("n=%d seed %d: row_dense %d nodes, mrv %d", tt.n, tt.seed, run[1], run[0])
+		}
+	}
+	t.Logf("nodes: mrv %d, row_dense %d", nodes[0], nodes[1])
+
+	// первая клетка на редком префиксе: MRV, среди ничьих — с самой
+	// заполненной из двух линий
+	ties := 0
+	for seed := int64(1); seed <= 20; seed++ {
+		board := nearlyComplete(9, 0.3, seed)
+		s := NewSolver(board, nil)
+		s.RowDense = true
+		i, j, cands, ok := s.selectCell()
+		if !ok || i < 0 || len(cands) == 1 {
+			continue
+		}
+		for r := range board {
+			for c := range board[r] {
+				if board[r][c] != -1 || s.candidateCount(r, c) != len(cands) || r == i && c == j {
+					continue
+				}
+				ties++
+				if min(s.rowEmpty[r], s.colEmpty[c]) < min(s.rowEmpty[i], s.colEmpty[j]) {
+					t.Errorf("seed %d: row_dense took (%d,%d), (%d,%d) has a fuller line", seed, i, j, r, c)
+				}
+			}
+		}
+	}
+	if ties == 0 {
+		t.Error("no MRV ties to check")
+	}
+}
+
+// replayPath fills path into a copy of board one assignment at a time and
+// returns the result, or an error if a step lands on a filled cell or
+// repeats a symbol in its row or column.
+func replayPath(board [][]int, path [][3]int) ([][]int, error) {
+	b := DeepCopy(board)
+	for k, p := range path {
+		i, j, v := p[0], p[1], p[2]
+		if b[i][j] != -1 {
+			return nil, fmt.Errorf("step %d %v: cell already holds %d", k, p, b[i][j])
+		}
+		for x := range b {
+			if b[i][x] == v || b[x][j] == v {
+				return nil, fmt.Errorf("step %d %v: %d repeats in its row or column", k, p, v)
+			}
+		}
+		b[i][j] = v
+	}
+	return b, nil
+}
+
+func TestSolverTrace(t *testing.T) {
+	tests := []struct {
+		name      string
+		board     [][]int
+		symmetric bool
+		parallel  bool
+	}{
+		{name: "empty 6x6", board: emptyBoard(6)},
+		{name: "sparse 9x9", board: nearlyComplete(9, 0.3, 1)},
+		{name: "dense 9x9", board: nearlyComplete(9, 0.7, 2)},
+		{name: "symmetric 5x5", board: emptyBoard(5), symmetric: true},
+		{name: "parallel 7x7", board: emptyBoard(7), parallel: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSolver(tt.board, 1)
+			s.Trace = true
+			if tt.symmetric {
+				s.EnableSymmetry()
+			}
+			// вынужденные клетки тоже попадают в Path
+			s.ArcConsistency()
+			var ok bool
+			if tt.parallel {
+				ok, _, _ = s.SolveParallel(3)
+			} else {
+				ok, _, _ = s.Solve()
+			}
+			if !ok {
+				t.Fatal("no solution")
+			}
+			got, err := replayPath(tt.board, s.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(got, s.Solutions[0], slices.Equal) {
+				t.Errorf("replayed %v, solution %v", got, s.Solutions[0])
+			}
+		})
+	}
+}
+
+func TestSolverMinCost(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for _, board := range [][][]int{emptyBoard(4), nearlyComplete(5, 0.3, 3)} {
+		n := len(board)
+		cost := make([][][]float64, n)
+		for i := range cost {
+			cost[i] = make([][]float64, n)
+			for j := range cost[i] {
+				cost[i][j] = make([]float64, n)
+				for v := range cost[i][j] {
+					cost[i][j][v] = float64(rng.Intn(20))
+				}
+			}
+		}
+		price := func(sq [][]int) float64 {
+			total := 0.0
+			for i := range sq {
+				for j, v := range sq[i] {
+					total += cost[i][j][v]
+				}
+			}
+			return total
+		}
+
+		// полный перебор: минимум по всем дополнениям
+		all := newTestSolver(board, 1)
+		all.MaxSolutions = math.MaxInt32
+		want, count := math.Inf(1), 0
+		all.OnSolution = func(_ int, sq [][]int) {
+			want = min(want, price(sq))
+			count++
+		}
+		all.Solve()
+
+		s := newTestSolver(board, 1)
+		s.Cost = cost
+		if ok, status, _ := s.Solve(); !ok || !s.Exhausted() {
+			t.Fatalf("n=%d: status %q, exhausted %v", n, status, s.Exhausted())
+		}
+		if s.BestCost != want || price(s.Solutions[0]) != want {
+			t.Errorf("n=%d: best cost %v (square costs %v), brute force over %d completions gives %v", n, s.BestCost, price(s.Solutions[0]), count, want)
+		}
+		if !IsLatinSquare(s.Solutions[0]) {
+			t.Errorf("n=%d: %v is not Latin", n, s.Solutions[0])
+		}
+		if s.Prunes == 0 || s.Found >= count {
+			t.Errorf("n=%d: prunes %d, reached %d of %d completions; want the bound to cut branches", n, s.Prunes, s.Found, count)
+		}
+	}
+}
+
+func TestSolverLexMin(t *testing.T) {
+	less := func(a, b [][]int) bool {
+		return slices.CompareFunc(a, b, slices.Compare[[]int]) < 0
+	}
+	for _, tt := range []struct {
+		name      string
+		board     [][]int
+		symmetric bool
+	}{
+		{"empty 4x4", emptyBoard(4), false},
+		{"sparse 5x5", nearlyComplete(5, 0.3, 4), false},
+		{"dense 7x7", nearlyComplete(7, 0.5, 5), false},
+		{"symmetric 5x5", emptyBoard(5), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// наименьшее среди всех дополнений
+			all := newTestSolver(tt.board, 1)
+			all.MaxSolutions = math.MaxInt32
+			if tt.symmetric {
+				all.EnableSymmetry()
+			}
+			var want [][]int
+			all.OnSolution = func(_ int, sq [][]int) {
+				if want == nil || less(sq, want) {
+					want = DeepCopy(sq)
+				}
+			}
+			all.Solve()
+
+			// Rng задан, но порядок от него зависеть не должен
+			s := newTestSolver(tt.board, 9)
+			s.LexMin = true
+			if tt.symmetric {
+				s.EnableSymmetry()
+			}
+			s.ArcConsistency()
+			if ok, status, _ := s.Solve(); !ok {
+				t.Fatalf("status %q", status)
+			}
+			if !slices.EqualFunc(s.Solutions[0], want, slices.Equal) {
+				t.Errorf("got %v, lex-min of %d completions is %v", s.Solutions[0], all.Found, want)
+			}
+		})
+	}
+}
+
+func TestCountOnlyBacktracksSkipSolutions(t *testing.T) {
+	// в режиме подсчёта dfs возвращает false и после найденного решения —
+	// такие ветки откатами не считаются
+	tests := []struct {
+		name     string
+		board    [][]int
+		found    int
+		deadEnds bool // есть ли в дереве ветки без решений
+	}{
+		{"empty 2x2", emptyBoard(2), 2, false},
+		{"empty 3x3", emptyBoard(3), 12, false},
+		{"empty 4x4", emptyBoard(4), 576, false},
+		{"3x3 prefix", [][]int{{0, 1, -1}, {1, -1, -1}, {-1, -1, -1}}, 1, false},
+		{"5x5 prefix", [][]int{
+			{0, 1, -1, -1, -1},
+			{1, -1, -1, -1, -1},
+			{-1, -1, -1, -1, -1},
+			{-1, -1, -1, -1, -1},
+			{-1, -1, -1, -1, -1},
+		}, 2016, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewSolver(tc.board, nil)
+			s.CountOnly = true
+			s.Solve()
+			if s.Found != tc.found {
+				t.Fatalf("Found = %d, want %d", s.Found, tc.found)
+			}
+			bt := s.Stats.Backtracks
+			if !tc.deadEnds && bt != 0 {
+				t.Errorf("Backtracks = %d, want 0: every branch has a solution", bt)
+			}
+			if tc.deadEnds && bt == 0 {
+				t.Errorf("Backtracks = 0, want dead ends counted")
+			}
+			if bt+int64(s.Found) > s.Nodes {
+				t.Errorf("Backtracks %d + Found %d > Nodes %d", bt, s.Found, s.Nodes)
+			}
+		})
+	}
+}
@@ -0,0 +1,474 @@
+// Package latin содержит решатели и проверки для латинских квадратов:
+// дополнение частичного квадрата (DFS с forward checking), поиск MOLS
+// локальным поиском и конструкцию над GF(p^m).
+package latin
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Violation locates the first offending cell. Kind is "row" or "col"
+// for a duplicate and "range" for a symbol outside [0, n), with the symbol
+// in Value; "row_length" is a row of the wrong length, with Col = -1, no
+// Value, Expected = n and Got = the row's length.
+type Violation struct {
+	Row      int    `json:"row"`
+	Col      int    `json:"col"`
+	Value    *int   `json:"value,omitempty"`
+	Kind     string `json:"kind"`
+	Expected *int   `json:"expected,omitempty"`
+	Got      *int   `json:"got,omitempty"`
+}
+
+// ValidatePartial reports the first duplicate in a row or column of a
+// partial square (-1 = empty).
+func ValidatePartial(board [][]int) error {
+	n := len(board)
+	// rows
+	for i := 0; i < n; i++ {
+		seen := make([]bool, n)
+		for j := 0; j < n; j++ {
+			v := board[i][j]
+			if v < 0 {
+				continue
+			}
+			if seen[v] {
+				return fmt.Errorf("duplicate value %d in row %d", v, i)
+			}
+			seen[v] = true
+		}
+	}
+	// cols
+	for j := 0; j < n; j++ {
+		seen := make([]bool, n)
+		for i := 0; i < n; i++ {
+			v := board[i][j]
+			if v < 0 {
+				continue
+			}
+			if seen[v] {
+				return fmt.Errorf("duplicate value %d in col %d", v, j)
+			}
+			seen[v] = true
+		}
+	}
+	return nil
+}
+
+// ValidatePartialDiagonals reports the first duplicate on the main or anti
+// diagonal of a partial square (-1 = empty).
+func ValidatePartialDiagonals(board [][]int) error {
+	if diag, v := diagonalDuplicate(board); diag != "" {
+		return fmt.Errorf("duplicate value %d on the %s diagonal", v, diag)
+	}
+	return nil
+}
+
+// IsDiagonalLatinSquare reports whether board is a Latin square whose main
+// and anti diagonals also hold distinct symbols.
+func IsDiagonalLatinSquare(board [][]int) bool {
+	if !IsLatinSquare(board) {
+		return false
+	}
+	diag, _ := diagonalDuplicate(board)
+	return diag == ""
+}
+
+// diagonalDuplicate returns "main" or "anti" and the repeated value, or ""
+// if both diagonals are free of duplicates; empty cells (-1) are skipped.
+func diagonalDuplicate(board [][]int) (string, int) {
+	n := len(board)
+	mainSeen := make([]bool, n)
+	antiSeen := make([]bool, n)
+	for i := 0; i < n; i++ {
+		if v := board[i][i]; v >= 0 {
+			if mainSeen[v] {
+				return "main", v
+			}
+			mainSeen[v] = true
+		}
+		if v := board[i][n-1-i]; v >= 0 {
+			if antiSeen[v] {
+				return "anti", v
+			}
+			antiSeen[v] = true
+		}
+	}
+	return "", 0
+}
+
+// BoxSide returns m such that m*m == n, and false if n is not a perfect
+// square (then there are no Sudoku boxes).
+func BoxSide(n int) (int, bool) {
+	m := 0
+	for (m+1)*(m+1) <= n {
+		m++
+	}
+	return m, m*m == n
+}
+
+// ValidatePartialBoxes reports the first duplicate in a √n × √n box of a
+// partial square (-1 = empty); n must be a perfect square.
+func ValidatePartialBoxes(board [][]int) error {
+	if b, v := boxDuplicate(board); b >= 0 {
+		return fmt.Errorf("duplicate value %d in box %d", v, b)
+	}
+	return nil
+}
+
+// IsSudoku reports whether board is a Latin square whose √n × √n boxes also
+// hold distinct symbols.
+func IsSudoku(board [][]int) bool {
+	if _, ok := BoxSide(len(board)); !ok || !IsLatinSquare(board) {
+		return false
+	}
+	b, _ := boxDuplicate(board)
+	return b < 0
+}
+
+// boxDuplicate returns the index (row-major) of the first box with a repeated
+// value and that value, or -1 if there is none; empty cells (-1) are skipped.
+func boxDuplicate(board [][]int) (int, int) {
+	n := len(board)
+	m, _ := BoxSide(n)
+	for b := 0; b < n; b++ {
+		seen := make([]bool, n)
+		for k := 0; k < n; k++ {
+			v := board[b/m*m+k/m][b%m*m+k%m]
+			if v < 0 {
+				continue
+			}
+			if seen[v] {
+				return b, v
+			}
+			seen[v] = true
+		}
+	}
+	return -1, 0
+}
+
+// ValidatePartialSymmetric reports the first pair of filled cells (i,j),
+// (j,i) holding different values.
+func ValidatePartialSymmetric(board [][]int) error {
+	for i := range board {
+		for j := i + 1; j < len(board); j++ {
+			a, b := board[i][j], board[j][i]
+			if a >= 0 && b >= 0 && a != b {
+				return fmt.Errorf("not symmetric: (%d,%d)=%d but (%d,%d)=%d", i, j, a, j, i, b)
+			}
+		}
+	}
+	return nil
+}
+
+// IsSymmetric reports whether sq[i][j] == sq[j][i] for all i, j.
+func IsSymmetric(sq [][]int) bool {
+	for i := range sq {
+		for j := i + 1; j < len(sq); j++ {
+			if sq[i][j] != sq[j][i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsLatinSquare reports whether board is a complete Latin square on 0..n-1.
+func IsLatinSquare(board [][]int) bool {
+	return FindViolation(board) == nil
+}
+
+// FindViolation returns the first cell (rows first, then columns) that
+// breaks the Latin property, or nil if board is a Latin square.
+func FindViolation(board [][]int) *Violation {
+	n := len(board)
+	num := func(v int) *int { return &v }
+	for i := 0; i < n; i++ {
+		if len(board[i]) != n {
+			return &Violation{Row: i, Col: -1, Kind: "row_length", Expected: num(n), Got: num(len(board[i]))}
+		}
+		seen := make([]bool, n)
+		for j := 0; j < n; j++ {
+			v := board[i][j]
+			if v < 0 || v >= n {
+				return &Violation{Row: i, Col: j, Value: num(v), Kind: "range"}
+			}
+			if seen[v] {
+				return &Violation{Row: i, Col: j, Value: num(v), Kind: "row"}
+			}
+			seen[v] = true
+		}
+	}
+	for j := 0; j < n; j++ {
+		seen := make([]bool, n)
+		for i := 0; i < n; i++ {
+			v := board[i][j]
+			if seen[v] {
+				return &Violation{Row: i, Col: j, Value: num(v), Kind: "col"}
+			}
+			seen[v] = true
+		}
+	}
+	return nil
+}
+
+// DeepCopy returns a copy of a that shares no rows with it.
+func DeepCopy(a [][]int) [][]int {
+	n := len(a)
+	out := make([][]int, n)
+	for i := 0; i < n; i++ {
+		out[i] = make([]int, len(a[i]))
+		copy(out[i], a[i])
+	}
+	return out
+}
+
+// Relabel maps internal symbols 0..n-1 back to the caller's alphabet.
+func Relabel(sq [][]int, symbols []int) [][]int {
+	out := make([][]int, len(sq))
+	for i, row := range sq {
+		out[i] = make([]int, len(row))
+		for j, v := range row {
+			out[i][j] = symbols[v]
+		}
+	}
+	return out
+}
+
+// MakeCyclic returns the square L[i][j] = (a*i + j) mod n.
+func MakeCyclic(n int, a int) [][]int {
+	// L[i][j] = (a*i + j) mod n  (Latin если gcd(a,n)=1; но даже a=1 всегда ок)
+	L := make([][]int, n)
+	for i := 0; i < n; i++ {
+		L[i] = make([]int, n)
+		for j := 0; j < n; j++ {
+			L[i][j] = (a*i + j) % n
+		}
+	}
+	return L
+}
+
+// RandomPermute applies random row, column and symbol permutations to L in place.
+func RandomPermute(L [][]int, rng *rand.Rand) {
+	n := len(L)
+
+	// permute rows
+	rp := rng.Perm(n)
+	tmp := DeepCopy(L)
+	for i := 0; i < n; i++ {
+		L[i] = tmp[rp[i]]
+	}
+
+	// permute cols
+	cp := rng.Perm(n)
+	for i := 0; i < n; i++ {
+		row := make([]int, n)
+		for j := 0; j < n; j++ {
+			row[j] = L[i][cp[j]]
+		}
+		L[i] = row
+	}
+
+	// permute symbols
+	sp := rng.Perm(n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			L[i][j] = sp[L[i][j]]
+		}
+	}
+}
+
+// RandomMove applies one random Latin-preserving operation to L in place:
+// swap two rows, swap two columns, rename two symbols, or flip an intercalate.
+func RandomMove(L [][]int, rng *rand.Rand) {
+	randomMove(L, rng, nil)
+}
+
+// cellChange is a cell rewritten by a move: (i, j) held old before it.
+type cellChange struct{ i, j, old int }
+
+// randomMove is RandomMove that also appends every cell it rewrote to
+// changes: SearchMOLS updates its pair counts from them and undoes rejected
+// moves. Each cell appears at most once.
+func randomMove(L [][]int, rng *rand.Rand, changes []cellChange) []cellChange {
+	n := len(L)
+	switch rng.Intn(4) {
+	case 0:
+		// swap two rows
+		r1 := rng.Intn(n)
+		r2 := rng.Intn(n)
+		if r1 != r2 {
+			for c := 0; c < n; c++ {
+				changes = append(changes, cellChange{r1, c, L[r1][c]}, cellChange{r2, c, L[r2][c]})
+			}
+		}
+		L[r1], L[r2] = L[r2], L[r1]
+	case 1:
+		// swap two cols
+		c1 := rng.Intn(n)
+		c2 := rng.Intn(n)
+		if c1 == c2 {
+			break
+		}
+		for i := 0; i < n; i++ {
+			changes = append(changes, cellChange{i, c1, L[i][c1]}, cellChange{i, c2, L[i][c2]})
+			L[i][c1], L[i][c2] = L[i][c2], L[i][c1]
+		}
+	case 2:
+		// rename two symbols
+		a := rng.Intn(n)
+		b := rng.Intn(n)
+		if a != b {
+			for i := 0; i < n; i++ {
+				for j := 0; j < n; j++ {
+					if L[i][j] == a {
+						changes = append(changes, cellChange{i, j, a})
+						L[i][j] = b
+					} else if L[i][j] == b {
+						changes = append(changes, cellChange{i, j, b})
+						L[i][j] = a
+					}
+				}
+			}
+		}
+	case 3:
+		// flip an intercalate (2x2 подквадрат a b / b a) — единственный ход,
+		// который выводит из класса изотопии циклического квадрата
+		r1 := rng.Intn(n)
+		r2 := rng.Intn(n)
+		c1 := rng.Intn(n)
+		if r1 == r2 {
+			break
+		}
+		a, b := L[r1][c1], L[r2][c1]
+		for c2 := 0; c2 < n; c2++ {
+			if L[r1][c2] == b {
+				if L[r2][c2] == a {
+					changes = append(changes,
+						cellChange{r1, c1, a}, cellChange{r2, c1, b},
+						cellChange{r1, c2, b}, cellChange{r2, c2, a})
+					L[r1][c1], L[r2][c1] = b, a
+					L[r1][c2], L[r2][c2] = a, b
+				}
+				break
+			}
+		}
+	}
+	return changes
+}
+
+// undoMove restores the cells randomMove rewrote.
+func undoMove(L [][]int, changes []cellChange) {
+	for _, ch := range changes {
+		L[ch.i][ch.j] = ch.old
+	}
+}
+
+// CanonicalHash returns a SHA-256 hex digest of L's canonical form under
+// symbol relabeling and row permutation, so squares that differ only by
+// those hash equal. The form: for each row r, relabel symbols so row r reads
+// 0..n-1 and sort the rows lexicographically; keep the smallest grid.
+func CanonicalHash(L [][]int) string {
+	n := len(L)
+	var best []int
+	grid := make([]int, n*n)
+	sigma := make([]int, n)
+	rows := make([][]int, n)
+	for r := 0; r < n; r++ {
+		for j, v := range L[r] {
+			sigma[v] = j
+		}
+		for i := 0; i < n; i++ {
+			rows[i] = grid[i*n : (i+1)*n]
+			for j, v := range L[i] {
+				rows[i][j] = sigma[v]
+			}
+		}
+		sort.Slice(rows, func(a, b int) bool { return lessInts(rows[a], rows[b]) })
+		flat := make([]int, 0, n*n)
+		for _, row := range rows {
+			flat = append(flat, row...)
+		}
+		if best == nil || lessInts(flat, best) {
+			best = flat
+		}
+	}
+
+	return digest(n, best)
+}
+
+// DigestSquare returns a SHA-256 hex digest of L itself, cell by cell in row
+// order: unlike HashSquare, distinct squares do not collide in practice.
+func DigestSquare(L [][]int) string {
+	flat := make([]int, 0, len(L)*len(L))
+	for _, row := range L {
+		flat = append(flat, row...)
+	}
+	return digest(len(L), flat)
+}
+
+// digest hashes n and the flattened grid as uvarints.
+func digest(n int, flat []int) string {
+	h := sha256.New()
+	var buf [binary.MaxVarintLen64]byte
+	h.Write(buf[:binary.PutUvarint(buf[:], uint64(n))])
+	for _, v := range flat {
+		h.Write(buf[:binary.PutUvarint(buf[:], uint64(v))])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func lessInts(a, b []int) bool {
+	for k := range a {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return false
+}
+
+// HashSquare returns a short human-readable fingerprint of L for reports,
+// "n=<n> sum=<checksum> head=[<v> <v> ...]". Stored hashes are compared
+// across releases, so the string is assembled by hand rather than with %v,
+// whose rendering of slices is not a promise of the toolchain.
+func HashSquare(L [][]int) string {
+	// быстрый “хэш” для отчёта: первые N чисел + checksum
+	n := len(L)
+	var flat []int
+	for i := 0; i < n; i++ {
+		flat = append(flat, L[i]...)
+	}
+	sum := 0
+	for _, v := range flat {
+		sum = (sum*131 + v + 1) % 1000000007
+	}
+	// первые 12 элементов для читаемости
+	m := 12
+	if len(flat) < m {
+		m = len(flat)
+	}
+	head := make([]int, m)
+	copy(head, flat[:m])
+	sort.Ints(head)
+	var b strings.Builder
+	b.WriteString("n=")
+	b.WriteString(strconv.Itoa(n))
+	b.WriteString(" sum=")
+	b.WriteString(strconv.Itoa(sum))
+	b.WriteString(" head=[")
+	for k, v := range head {
+		if k > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.Itoa(v))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
@@ -0,0 +1,90 @@
+package latin
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCanonicalHash(t *testing.T) {
+	xor := make([][]int, 4) // таблица группы Клейна: не изотопна Z4
+	for i := range xor {
+		xor[i] = []int{i, i ^ 1, i ^ 2, i ^ 3}
+	}
+	permRows := func(L [][]int, p []int) [][]int {
+		out := make([][]int, len(L))
+		for i := range out {
+			out[i] = append([]int(nil), L[p[i]]...)
+		}
+		return out
+	}
+	relabel := func(L [][]int, sigma []int) [][]int {
+		out := DeepCopy(L)
+		for i := range out {
+			for j := range out[i] {
+				out[i][j] = sigma[out[i][j]]
+			}
+		}
+		return out
+	}
+	z4 := MakeCyclic(4, 1)
+	r := rand.New(rand.NewSource(1))
+	sq := MakeCyclic(7, 1)
+	RandomPermute(sq, r)
+	tests := []struct {
+		name string
+		a, b [][]int
+		same bool
+	}{
+		{"identical", z4, DeepCopy(z4), true},
+		{"rows permuted", z4, permRows(z4, []int{2, 0, 3, 1}), true},
+		{"relabeled", z4, relabel(z4, []int{3, 1, 0, 2}), true},
+		{"rows permuted and relabeled", sq, relabel(permRows(sq, r.Perm(7)), r.Perm(7)), true},
+		{"cyclic steps are row permutations", MakeCyclic(5, 1), MakeCyclic(5, 2), true},
+		{"Z4 vs Klein", z4, xor, false},
+		{"different n", MakeCyclic(3, 1), MakeCyclic(4, 1), false},
+	}
+	for _, tt := range tests {
+		if got := CanonicalHash(tt.a) == CanonicalHash(tt.b); got != tt.same {
+			t.Errorf("%s: equal hashes = %v, want %v", tt.name, got, tt.same)
+		}
+	}
+}
+
+func TestValidatePartialSymmetric(t *testing.T) {
+	tests := []struct {
+		name  string
+		board [][]int
+		ok    bool
+	}{
+		{"empty", sudokuBoard("...", "...", "..."), true},
+		{"one of the pair", sudokuBoard(".2.", "...", "..."), true},
+		{"matching pair", sudokuBoard(".2.", "2..", "..."), true},
+		{"matching pairs and diagonal", sudokuBoard("123", "2.1", "31."), true},
+		{"mismatched pair", sudokuBoard(".2.", "3..", "..."), false},
+		{"mismatch far from diagonal", sudokuBoard("...3", "....", "....", "2..."), false},
+	}
+	for _, tt := range tests {
+		if err := ValidatePartialSymmetric(tt.board); (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestHashSquareFormat(t *testing.T) {
+	// строки хэшей хранятся снаружи — формат не должен меняться
+	tests := []struct {
+		name string
+		sq   [][]int
+		want string
+	}{
+		{"1x1", [][]int{{0}}, "n=1 sum=1 head=[0]"},
+		{"2x2", sudokuBoard("12", "21"), "n=2 sum=2282676 head=[0 0 1 1]"},
+		{"cyclic 4x4", MakeCyclic(4, 1), "n=4 sum=546019914 head=[0 0 0 1 1 1 2 2 2 3 3 3]"},
+		{"cyclic 5x5 step 2", MakeCyclic(5, 2), "n=5 sum=550817439 head=[0 0 0 1 1 2 2 3 3 4 4 4]"},
+	}
+	for _, tt := range tests {
+		if got := HashSquare(tt.sq); got != tt.want {
+			t.Errorf("%s: HashSquare = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package latin
+
+import "context"
+
+// TransversalResult is the outcome of FindTransversal. Status is done |
+// no_solution | timeout | node_limit | cancelled.
+type TransversalResult struct {
+	Rows   []int // Rows[c] — строка клетки трансверсали в столбце c; nil — не найдена
+	Status string
+	Nodes  int64
+	// Cyclic: L[i][j] = r_i + c_j (mod n) при чётном n — трансверсали нет
+	// без всякого перебора
+	Cyclic bool
+}
+
+// FindTransversal looks for a transversal of the Latin square L: n cells,
+// one per row and column, holding all n symbols. It backtracks over the
+// columns, giving each a free row whose symbol is still unused, most
+// constrained column first; opt.Rng, if set, shuffles the order rows are
+// tried in. Before searching it rules out the classic case with none: if
+// L[i][j] = r_i + c_j (mod n), an isotope of the cyclic group, and n is
+// even, the symbols of any transversal would sum to sum(r) + sum(c) = 0
+// (mod n) instead of n(n-1)/2 = n/2 (mod n).
+func FindTransversal(L [][]int, opt MateOptions) TransversalResult {
+	n := len(L)
+	if n%2 == 0 && cyclicIsotope(L) {
+		return TransversalResult{Status: "no_solution", Cyclic: true}
+	}
+	t := &transversalSearch{
+		mateSearch: mateSearch{opt: opt, base: L, n: n, untilCheck: defaultCheckEvery},
+		rows:       make([]int, n),
+		usedCols:   newBitset(n),
+		usedRows:   newBitset(n),
+		usedSyms:   newBitset(n),
+		order:      make([]int, n),
+	}
+	if t.opt.Ctx == nil {
+		t.opt.Ctx = context.Background()
+	}
+	for r := range t.order {
+		t.order[r] = r
+	}
+	if opt.Rng != nil {
+		opt.Rng.Shuffle(n, func(a, b int) { t.order[a], t.order[b] = t.order[b], t.order[a] })
+	}
+
+	res := TransversalResult{}
+	found := t.column(0)
+	res.Nodes = t.nodes
+	switch {
+	case found:
+		res.Rows, res.Status = t.rows, "done"
+	case t.stop != "":
+		res.Status = t.stop
+	default:
+		res.Status = "no_solution"
+	}
+	return res
+}
+
+// transversalSearch reuses mateSearch's budget bookkeeping (nodes, stop,
+// expired) for the column-by-column search.
+type transversalSearch struct {
+	mateSearch
+	rows     []int // rows[c] — строка, выбранная в столбце c
+	usedCols bitset
+	usedRows bitset
+	usedSyms bitset
+	order    []int // порядок перебора строк
+}
+
+// column fills one more column: the open one with the fewest rows left
+// that fit (a free row whose symbol is unused), failing early on a column
+// with none.
+func (t *transversalSearch) column(depth int) bool {
+	if depth == t.n {
+		return true
+	}
+	if t.expired() {
+		return false
+	}
+	c, fits := -1, t.n+1
+	for j := 0; j < t.n && fits > 1; j++ {
+		if t.usedCols.Test(j) {
+			continue
+		}
+		k := 0
+		for r := 0; r < t.n && k < fits; r++ {
+			if !t.usedRows.Test(r) && !t.usedSyms.Test(t.base[r][j]) {
+				k++
+			}
+		}
+		if k < fits {
+			c, fits = j, k
+		}
+	}
+	if fits == 0 {
+		return false
+	}
+	t.usedCols.Set(c)
+	for _, r := range t.order {
+		v := t.base[r][c]
+		if t.usedRows.Test(r) || t.usedSyms.Test(v) {
+			continue
+		}
+		t.nodes++
+		t.rows[c] = r
+		t.usedRows.Set(r)
+		t.usedSyms.Set(v)
+		if t.column(depth + 1) {
+			return true
+		}
+		t.usedRows.Clear(r)
+		t.usedSyms.Clear(v)
+		if t.stop != "" {
+			break
+		}
+	}
+	t.usedCols.Clear(c)
+	return false
+}
+
+// cyclicIsotope reports whether L[i][j] = r_i + c_j (mod n) for some r, c,
+// taking r_i = L[i][0] - L[0][0] and c_j = L[0][j].
+func cyclicIsotope(L [][]int) bool {
+	n := len(L)
+	for i := 1; i < n; i++ {
+		ri := L[i][0] - L[0][0]
+		for j := 1; j < n; j++ {
+			if ((ri+L[0][j])%n+n)%n != L[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
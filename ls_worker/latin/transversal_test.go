@@ -0,0 +1,64 @@
+package latin
+
+import "testing"
+
+func TestFindTransversal(t *testing.T) {
+	// Z4 с переставленными символами: трансверсалей нет, но L[i][j] уже не
+	// r_i + c_j — ответ даёт перебор, а не короткий путь
+	relabeled := MakeCyclic(4, 1)
+	sigma := []int{0, 2, 1, 3}
+	for i := range relabeled {
+		for j := range relabeled[i] {
+			relabeled[i][j] = sigma[relabeled[i][j]]
+		}
+	}
+	klein := make([][]int, 4)
+	for i := range klein {
+		klein[i] = []int{i, i ^ 1, i ^ 2, i ^ 3}
+	}
+	// Z6 с переставленными строками и столбцами — всё ещё r_i + c_j
+	z6, shuffled := MakeCyclic(6, 1), make([][]int, 6)
+	rp, cp := NewRand(RNGLegacy, 1).Perm(6), NewRand(RNGLegacy, 2).Perm(6)
+	for i := range shuffled {
+		shuffled[i] = make([]int, 6)
+		for j := range shuffled[i] {
+			shuffled[i][j] = z6[rp[i]][cp[j]]
+		}
+	}
+	tests := []struct {
+		name   string
+		sq     [][]int
+		status string
+		cyclic bool // no_solution без перебора
+	}{
+		{"1x1", [][]int{{0}}, "done", false},
+		{"cyclic 5", MakeCyclic(5, 1), "done", false},
+		{"cyclic 9 step 2", MakeCyclic(9, 2), "done", false},
+		{"klein 4", klein, "done", false},
+		{"cyclic 2", MakeCyclic(2, 1), "no_solution", true},
+		{"cyclic 8", MakeCyclic(8, 1), "no_solution", true},
+		{"shuffled cyclic 6", shuffled, "no_solution", true},
+		{"relabeled cyclic 4", relabeled, "no_solution", false},
+	}
+	for _, tt := range tests {
+		res := FindTransversal(tt.sq, MateOptions{Rng: NewRand(RNGLegacy, 1)})
+		if res.Status != tt.status || res.Cyclic != tt.cyclic {
+			t.Errorf("%s: status %q cyclic %v, want %q cyclic %v", tt.name, res.Status, res.Cyclic, tt.status, tt.cyclic)
+			continue
+		}
+		if tt.cyclic && res.Nodes != 0 {
+			t.Errorf("%s: %d nodes searched, want none", tt.name, res.Nodes)
+		}
+		if res.Status != "done" {
+			continue
+		}
+		rows, syms := make([]bool, len(tt.sq)), make([]bool, len(tt.sq))
+		for c, r := range res.Rows {
+			if rows[r] || syms[tt.sq[r][c]] {
+				t.Errorf("%s: rows %v are not a transversal", tt.name, res.Rows)
+				break
+			}
+			rows[r], syms[tt.sq[r][c]] = true, true
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"time"
+)
+
+// errResourceExhausted is the cancel cause set when the worker runs into its
+// own memory or CPU limit; main reports it as status resource_exhausted.
+var errResourceExhausted = errors.New("resource limit exceeded")
+
+// cpuGraceSec: между мягким (SIGXCPU) и жёстким (SIGKILL) RLIMIT_CPU —
+// время, чтобы успеть записать out.json
+const cpuGraceSec = 5
+
+// watchRSS cancels ctx with errResourceExhausted once RSS exceeds maxKB.
+// This is the portable part of the memory limit: RLIMIT_AS is only a
+// backstop, because hitting it makes the Go runtime abort without output.
+// The returned func restores the previous GC memory limit.
+func watchRSS(ctx context.Context, cancel context.CancelCauseFunc, maxKB int64) (restore func()) {
+	// GC подстраиваем под лимит, чтобы мусор не съедал запас
+	prev := debug.SetMemoryLimit(maxKB * 1024)
+	go func() {
+		t := time.NewTicker(50 * time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			if kb, err := currentRSSKB(); err == nil && kb > maxKB {
+				cancel(errResourceExhausted)
+				return
+			}
+		}
+	}()
+	return func() { debug.SetMemoryLimit(prev) }
+}
@@ -0,0 +1,83 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// applyRLimits sets OS limits as a backstop for runaway tasks. RLIMIT_AS
+// gets the current virtual size plus twice maxRSSKB (Go reserves far more
+// address space than it touches, so the RSS watchdog normally fires first);
+// RLIMIT_CPU gets cpuSec, and SIGXCPU at the soft limit cancels ctx.
+// Zero values leave the corresponding limit alone.
+func applyRLimits(cancel context.CancelCauseFunc, maxRSSKB, cpuSec int64) error {
+	if maxRSSKB > 0 {
+		vsz, err := virtualSize()
+		if err != nil {
+			return err
+		}
+		if err := lowerRLimit(syscall.RLIMIT_AS, uint64(vsz+2*maxRSSKB*1024), 0); err != nil {
+			return fmt.Errorf("RLIMIT_AS: %w", err)
+		}
+	}
+	if cpuSec > 0 {
+		xcpu := make(chan os.Signal, 1)
+		signal.Notify(xcpu, syscall.SIGXCPU)
+		go func() {
+			<-xcpu
+			cancel(errResourceExhausted)
+		}()
+		if err := lowerRLimit(syscall.RLIMIT_CPU, uint64(cpuSec), cpuGraceSec); err != nil {
+			return fmt.Errorf("RLIMIT_CPU: %w", err)
+		}
+	}
+	return nil
+}
+
+// lowerRLimit sets the soft limit to cur and the hard one to cur+grace,
+// never above the existing hard limit (raising it needs privileges).
+func lowerRLimit(resource int, cur, grace uint64) error {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &lim); err != nil {
+		return err
+	}
+	lim.Cur = min(cur, lim.Max)
+	lim.Max = min(cur+grace, lim.Max)
+	return syscall.Setrlimit(resource, &lim)
+}
+
+// virtualSize returns the process address-space size from /proc/self/statm.
+func virtualSize() (int64, error) {
+	return statm(0)
+}
+
+// currentRSSKB returns the resident set size right now (not the peak), so a
+// batch request is not blamed for memory an earlier one already released.
+func currentRSSKB() (int64, error) {
+	b, err := statm(1)
+	return b / 1024, err
+}
+
+// statm reads the field-th page count from /proc/self/statm, in bytes.
+func statm(field int) (int64, error) {
+	b, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) <= field {
+		return 0, fmt.Errorf("unexpected /proc/self/statm: %q", b)
+	}
+	pages, err := strconv.ParseInt(fields[field], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * int64(os.Getpagesize()), nil
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaxRSSKBResourceExhausted(t *testing.T) {
+	// RSS тестового процесса заведомо больше 1 MB: watchRSS срабатывает на
+	// первом тике и отменяет ctx с errResourceExhausted
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	defer watchRSS(ctx, cancel, 1024)()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchRSS did not fire")
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, errResourceExhausted) {
+		t.Errorf("cause %v, want errResourceExhausted", cause)
+	}
+}
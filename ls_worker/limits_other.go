@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// applyRLimits is Linux-only; elsewhere only the RSS watchdog applies.
+func applyRLimits(cancel context.CancelCauseFunc, maxRSSKB, cpuSec int64) error {
+	return fmt.Errorf("rlimits are not supported on %s", runtime.GOOS)
+}
+
+// currentRSSKB falls back to the peak RSS: there is no portable way to read
+// the current one.
+func currentRSSKB() (int64, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, err
+	}
+	return maxRSSToKB(int64(ru.Maxrss), runtime.GOOS), nil
+}
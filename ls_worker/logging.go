@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// progressLog writes a JSON line about the running task to stderr every
+// -log-interval and/or to the Unix socket -progress-socket. The search only
+// bumps atomic counters; the writing happens in a separate goroutine, so a
+// slow reader never holds up the solver.
+type progressLog struct {
+	taskID  string
+	problem string
+	start   time.Time
+
+	nodes    atomic.Int64
+	steps    atomic.Int64
+	bestConf atomic.Int64 // -1 — ещё не было
+	stop     chan struct{}
+}
+
+// progressLine is one progress frame: a JSON object per line, the same on
+// stderr and on the socket. Fields are only ever added; nodes, steps and
+// best_conflicts are omitted while they are zero or unknown.
+type progressLine struct {
+	TS            string `json:"ts"`
+	TaskID        string `json:"task_id,omitempty"`
+	Problem       string `json:"problem"`
+	ElapsedMS     int64  `json:"elapsed_ms"`
+	Nodes         int64  `json:"nodes,omitempty"`
+	Steps         int64  `json:"steps,omitempty"`
+	BestConflicts *int64 `json:"best_conflicts,omitempty"`
+}
+
+// defaultSocketEvery — период кадров в сокет, если -log-interval не задан
+const defaultSocketEvery = time.Second
+
+// socketWriteTimeout: балансер, который не читает сокет, не должен
+// тормозить даже горутину прогресса
+const socketWriteTimeout = 100 * time.Millisecond
+
+// startProgressLog starts the frame writer: to stderr when logEvery > 0,
+// to the Unix socket at socketPath when it is set. A socket that cannot be
+// dialled or stops accepting writes is dropped silently; the other outputs
+// go on.
+func startProgressLog(req InRequest, logEvery time.Duration, socketPath string) *progressLog {
+	l := &progressLog{taskID: req.TaskID, problem: req.Problem, start: time.Now(), stop: make(chan struct{})}
+	l.bestConf.Store(-1)
+	var sock net.Conn
+	if socketPath != "" {
+		sock, _ = net.DialTimeout("unix", socketPath, time.Second)
+	}
+	every := logEvery
+	if every <= 0 {
+		every = defaultSocketEvery
+	}
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		if sock != nil {
+			defer sock.Close()
+		}
+		enc := json.NewEncoder(os.Stderr)
+		for {
+			select {
+			case <-l.stop:
+				return
+			case now := <-t.C:
+				line := progressLine{
+					TS:        now.UTC().Format(time.RFC3339Nano),
+					TaskID:    l.taskID,
+					Problem:   l.problem,
+					ElapsedMS: now.Sub(l.start).Milliseconds(),
+					Nodes:     l.nodes.Load(),
+					Steps:     l.steps.Load(),
+				}
+				if c := l.bestConf.Load(); c >= 0 {
+					line.BestConflicts = &c
+				}
+				if logEvery > 0 {
+					_ = enc.Encode(line)
+				}
+				if sock != nil {
+					b, _ := json.Marshal(line)
+					_ = sock.SetWriteDeadline(now.Add(socketWriteTimeout))
+					if _, err := sock.Write(append(b, '\n')); err != nil {
+						sock.Close()
+						sock = nil
+					}
+				}
+			}
+		}
+	}()
+	return l
+}
+
+// close stops the ticker without waiting for a pending write.
+func (l *progressLog) close() {
+	close(l.stop)
+}
+
+// addNodes is Solver.OnTick / DLX.OnTick.
+func (l *progressLog) addNodes(n int64) {
+	l.nodes.Add(n)
+}
+
+// molsTick is MOLSOptions.OnTick.
+func (l *progressLog) molsTick(steps int64, bestConf int) {
+	l.steps.Store(steps)
+	l.bestConf.Store(int64(bestConf))
+}
@@ -2,18 +2,31 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
 	"math/rand"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"syscall"
+
+	"ls_worker/latin"
 )
 
 type InBudget struct {
@@ -21,12 +34,112 @@ type InBudget struct {
 	TimeLimitSec  int   `json:"time_limit_sec"`
 	MaxSteps      int64 `json:"max_steps"`
 	MaxNodes      int64 `json:"max_nodes"`
+	MaxRSSKB      int64 `json:"max_rss_kb"` // 0 — без ограничения памяти
+	MaxCores      int   `json:"max_cores"`  // GOMAXPROCS на время задачи; 0 — все ядра
+	// MOLS: столько раз перезапустить поиск с seed+attempt, если max_steps
+	// кончились без результата (в пределах time_limit)
+	MaxRestarts int `json:"max_restarts"`
+}
+
+// UnmarshalJSON accepts max_steps and max_nodes written as floats too
+// (3e6, 3000000.0 — как их сериализуют некоторые оркестраторы), truncating
+// the fraction. Negative, out-of-range or quoted values fail with a
+// *budgetError. Unknown keys are ignored here; decodeIn decodes the budget
+// through decodeBudget itself so that -strict reaches them.
+func (b *InBudget) UnmarshalJSON(data []byte) error {
+	return decodeBudget(data, false, b)
+}
+
+// decodeBudget is InBudget.UnmarshalJSON with strict rejecting unknown keys.
+func decodeBudget(data []byte, strict bool, b *InBudget) error {
+	type plain InBudget
+	aux := struct {
+		*plain
+		MaxSteps json.RawMessage `json:"max_steps"`
+		MaxNodes json.RawMessage `json:"max_nodes"`
+	}{plain: (*plain)(b)}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&aux); err != nil {
+		return err
+	}
+	var err error
+	if b.MaxSteps, err = budgetInt("max_steps", aux.MaxSteps); err != nil {
+		return err
+	}
+	if b.MaxNodes, err = budgetInt("max_nodes", aux.MaxNodes); err != nil {
+		return err
+	}
+	return nil
+}
+
+// budgetError rejects a budget count that is negative or does not fit in int64.
+type budgetError struct {
+	field string
+	value json.Number
+}
+
+func (e *budgetError) Error() string {
+	return fmt.Sprintf("budget.%s=%s must be an integer in [0, 2^63)", e.field, e.value)
+}
+
+// budgetInt reads an integer or float JSON number as a non-negative int64;
+// an absent or null field is 0. json.Number would also take "5" in quotes,
+// so the raw value is checked to be a bare number first.
+func budgetInt(field string, raw json.RawMessage) (int64, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+	v := json.Number(raw)
+	if raw[0] == '"' {
+		return 0, &budgetError{field, v}
+	}
+	if k, err := v.Int64(); err == nil {
+		if k < 0 {
+			return 0, &budgetError{field, v}
+		}
+		return k, nil
+	}
+	f, err := v.Float64()
+	if err != nil || math.IsNaN(f) || f < 0 || f >= math.MaxInt64 {
+		return 0, &budgetError{field, v}
+	}
+	return int64(f), nil
 }
 
 type InOutput struct {
 	ReturnOneSolution bool `json:"return_one_solution"`
 	ReturnSquares     bool `json:"return_squares"`
 	MaxSolutions      int  `json:"max_solutions"`
+	// MOLS: при conflicts > 0 вернуть клетки (i,j), чья пара повторяется хоть
+	// в одной паре квадратов (L[a],L[b])
+	ReturnConflictCells bool `json:"return_conflict_cells"`
+	// MOLS: вернуть найденный набор как ортогональный массив OA(n, k+2)
+	ReturnOA bool `json:"return_oa"`
+	// completion: перебрать решения в пределах бюджета и вернуть одно
+	// случайное (reservoir sampling) вместо первого найденного
+	UniformRandom bool `json:"uniform_random"`
+	// completion: при no_solution вернуть в debug кандидатов каждой клетки
+	// префикса до ветвления — видно, какая клетка мертва
+	ReturnRootCandidates bool `json:"return_root_candidates"`
+	// completion: канонический представитель класса найденного квадрата —
+	// isotopy (строки/столбцы/символы) или main_class (плюс сопряжённые)
+	CanonicalForm string `json:"canonical_form"`
+	// completion: вернуть в result.trace путь присваиваний к square (без откатов)
+	ReturnTrace bool `json:"return_trace"`
+	// completion при max_solutions > 1: ключ, по которому отбрасываются
+	// повторы до счёта в max_solutions — canonical (по умолчанию: CanonicalHash,
+	// квадраты, отличные лишь переименованием символов и порядком строк, —
+	// один), exact (sha256 самого квадрата; DFS/DLX и так не выдают один
+	// квадрат дважды), isotopy или main_class (sha256 канонической формы: по
+	// квадрату на класс; у слишком симметричных квадратов форма не строится —
+	// ключом остаётся сам квадрат)
+	Distinct string `json:"distinct"`
+	// MaxResultBytes > 0: result в JSON не длиннее стольких байт — сетки
+	// убираются (см. capResult), вместо них <поле>_sha256 и truncated: true
+	MaxResultBytes int `json:"max_result_bytes"`
 }
 
 type InRequest struct {
@@ -36,8 +149,13 @@ type InRequest struct {
 	Seed    int64           `json:"seed"`
 	Output  InOutput        `json:"output"`
 	Payload json.RawMessage `json:"payload"`
+	// SchemaVersion: версия формата, под которую составлен запрос; нет — 1
+	SchemaVersion int `json:"schema_version"`
 }
 
+// schemaVersion — старшая версия формата запроса, которую понимает воркер
+const schemaVersion = 1
+
 type OutError struct {
 	Code    string                 `json:"code"`
 	Message string                 `json:"message"`
@@ -56,17 +174,22 @@ type OutMetrics struct {
 	GOOS           string `json:"goos"`
 	GOARCH         string `json:"goarch"`
 	CoresSeen      int    `json:"cores_seen"`
+	CoresUsed      int    `json:"cores_used"`       // GOMAXPROCS: budget.max_cores или все ядра
+	NodesPerSec    int64  `json:"nodes_per_sec"`    // за время решения, без дожигания min_runtime
+	CPUUtilPercent int    `json:"cpu_util_percent"` // (user+sys)/(wall*cores), 100 = все ядра заняты
+	SolveWallMS    int64  `json:"solve_wall_ms"`    // wall до дожигания min_runtime — сверять с time_limit_sec
 }
 
 type OutResponse struct {
-	Ok      bool        `json:"ok"`
-	Problem string      `json:"problem"`
-	TaskID  string      `json:"task_id,omitempty"`
-	Status  string      `json:"status"` // done | no_solution | timeout | invalid_input | error
-	Result  interface{} `json:"result,omitempty"`
-	Metrics OutMetrics  `json:"metrics"`
-	Debug   interface{} `json:"debug,omitempty"`
-	Error   *OutError   `json:"error,omitempty"`
+	Ok            bool        `json:"ok"`
+	Problem       string      `json:"problem"`
+	TaskID        string      `json:"task_id,omitempty"`
+	SchemaVersion int         `json:"schema_version,omitempty"` // эхо запроса
+	Status        string      `json:"status"`                   // done | solution_limit | no_solution | timeout | node_limit | cancelled | resource_exhausted | invalid_input | error | running (только снимки -flush-interval) | valid (-validate)
+	Result        interface{} `json:"result,omitempty"`
+	Metrics       OutMetrics  `json:"metrics"`
+	Debug         interface{} `json:"debug,omitempty"`
+	Error         *OutError   `json:"error,omitempty"`
 }
 
 // ---------------------------
@@ -78,42 +201,322 @@ type PayloadComplete struct {
 	PrefixFormat string   `json:"prefix_format"`
 	Prefix       [][]*int `json:"prefix"`
 	Constraints  struct {
-		Latin           bool `json:"latin"`
+		Latin     bool `json:"latin"`
+		Diagonal  bool `json:"diagonal"`  // без повторов и на главной, и на побочной диагонали
+		Symmetric bool `json:"symmetric"` // L[i][j] == L[j][i]
+		Boxes     bool `json:"boxes"`     // судоку: без повторов в блоках √n x √n
+		// приведённый квадрат: строка 0 и столбец 0 — 0..n-1 по порядку (в
+		// порядке symbols); дописываются в префикс
+		Reduced          bool `json:"reduced"`
 		SymmetryBreaking struct {
 			FixFirstRow bool `json:"fix_first_row"`
 		} `json:"symmetry_breaking"`
 	} `json:"constraints"`
+	// алфавит символов (например, 1..n); по умолчанию 0..n-1
+	Symbols []int `json:"symbols"`
+	// Forbidden[i][j] — символы, запрещённые в клетке (i,j) (n x n списков)
+	Forbidden [][][]int `json:"forbidden"`
+	// пары клеток {{i1,j1},{i2,j2}}: equal — в них один символ, not_equal — разные
+	Equal    [][2][2]int `json:"equal"`
+	NotEqual [][2][2]int `json:"not_equal"`
+	// распараллелить первый уровень ветвления по горутине на ядро (budget.max_cores)
+	Parallel bool `json:"parallel"`
+	// как часто (в узлах) DFS сверяется с часами; 0 — по умолчанию
+	CheckEvery int64 `json:"check_every"`
+	// порядок значений в DFS: random (по seed, по умолчанию) | lcv
+	ValueOrder string `json:"value_order"`
+	// решатель: dfs (по умолчанию) | dlx (Algorithm X, dancing links) |
+	// local (локальный поиск для больших n; неполный: no_solution не
+	// доказывает, что дополнения нет)
+	Engine string `json:"engine"`
+	// direct (по умолчанию) | conjugates: если прямой DFS упёрся в бюджет,
+	// пробовать сопряжённые квадраты (транспонированный и т.д.)
+	EngineHint string `json:"engine_hint"`
+	// count_latin_completions: простое, по модулю которого считать ещё и
+	// count_mod (точен и после переполнения int64); 0 — не считать
+	Modulus int64 `json:"modulus"`
+	// выбор клетки в DFS: mrv (по умолчанию; ничьи — к клетке, у которой в
+	// строке и столбце вместе меньше всего пустых) | row_dense (ничьи —
+	// сначала по более заполненной из её двух линий)
+	CellOrder string `json:"cell_order"`
+	// вместо полного квадрата вернуть частичный (result.partial), в котором
+	// пусто ровно столько клеток: клетки найденного дополнения стираются в
+	// случайном порядке, клетки префикса остаются
+	FillUntilEmpty *int `json:"fill_until_empty"`
+	// при fill_until_empty стирать только клетки, без которых дополнение
+	// остаётся единственным (головоломка с одним ответом)
+	FillUnique bool `json:"fill_unique"`
+	// лексикографически наименьшее дополнение (по строкам; символы — в
+	// порядке symbols): клетки строго по порядку, значения по возрастанию.
+	// Без MRV перебор может быть на порядки дольше; value_order, cell_order
+	// и seed не действуют
+	LexMin bool `json:"lex_min"`
+	// seed порядка кандидатов DFS/DLX отдельно от seed запроса; нет — seed
+	SearchSeed *int64 `json:"search_seed"`
 }
 
 type PayloadMOLS struct {
 	N      int    `json:"n"`
 	K      int    `json:"k"`
 	Method string `json:"method"`
+	// шагов без улучшения до рестарта; 0 — по умолчанию (50000), < 0 — без рестартов
+	StallSteps int64 `json:"stall_steps"`
+	// стартовые L[0], L[1], ... вместо случайных (например, L прошлого
+	// прогона); остальные квадраты — случайные
+	SeedSquares [][][]int `json:"seed_squares"`
+	// hill_climb: вероятность принять неулучшающий ход, [0, 1]; нет — 0.001
+	SidewaysProb *float64 `json:"sideways_prob"`
 }
 
 type ResultComplete struct {
-	N            int     `json:"n"`
-	SolutionFound bool    `json:"solution_found"`
-	Square       [][]int `json:"square,omitempty"`
-	VerifiedLatin bool   `json:"verified_latin"`
+	N             int       `json:"n"`
+	SolutionFound bool      `json:"solution_found"`
+	Square        [][]int   `json:"square,omitempty"`
+	Squares       [][][]int `json:"squares,omitempty"`
+	VerifiedLatin bool      `json:"verified_latin"`
+	// Partial/Filled — в промежуточных снимках -flush-interval (status
+	// running) и при timeout/node_limit: самая глубокая согласованная
+	// частичная доска, null — пусто;
+	// при payload.fill_until_empty — частичная доска, дополнимая до square,
+	// и Empty — сколько в ней пустых клеток
+	Partial [][]*int `json:"partial,omitempty"`
+	Filled  int      `json:"filled,omitempty"`
+	Empty   *int     `json:"empty,omitempty"`
+	// CanonicalForm — по output.canonical_form, над символами 0..n-1
+	CanonicalForm [][]int `json:"canonical_form,omitempty"`
+	// Trace — по output.return_trace: (i, j, символ) в порядке, в котором
+	// решатель заполнял пустые клетки до square; префикс (в symmetric —
+	// отражённый) плюс trace = square
+	Trace [][3]int `json:"trace,omitempty"`
+}
+
+// ResultCount: count — сколько дополнений перебрано. С fix_first_row
+// каждое стоит за k! перестановок строк, и total = count·k! — полное число.
+// Если count или total не влезает в int64, оно остаётся на 2^63-1 и
+// выставляется overflow; count_mod (при payload.modulus) — полное число по
+// модулю, точное и при переполнении.
+type ResultCount struct {
+	N        int    `json:"n"`
+	Count    int64  `json:"count"`
+	Exact    bool   `json:"exact"` // false — бюджет кончился, count — нижняя граница
+	Total    int64  `json:"total,omitempty"`
+	Overflow bool   `json:"overflow,omitempty"`
+	CountMod *int64 `json:"count_mod,omitempty"`
 }
 
 type ResultMOLS struct {
-	N          int         `json:"n"`
-	K          int         `json:"k"`
-	Found      bool        `json:"found"`
-	Conflicts  int         `json:"conflicts"`
+	N           int        `json:"n"`
+	K           int        `json:"k"`
+	Found       bool       `json:"found"`
+	Conflicts   int        `json:"conflicts"`
 	UniquePairs int        `json:"unique_pairs"`
-	L          [][][]int   `json:"L,omitempty"`
-	BestHash   []string    `json:"best_hash,omitempty"`
+	Verified    bool       `json:"verified"`
+	L           [][][]int  `json:"L,omitempty"`
+	Overlay     [][][2]int `json:"overlay,omitempty"` // Overlay[i][j] = {L[0][i][j], L[1][i][j]}; при k > 2 — только первая пара
+	BestHash    []string   `json:"best_hash,omitempty"`
+	// CanonicalHash: sha256 канонической формы (с точностью до переименования
+	// символов и перестановки строк) — для дедупликации
+	CanonicalHash []string `json:"canonical_hash,omitempty"`
+	// ConflictCells: клетки, где пара (L[a][i][j], L[b][i][j]) повторяется хоть
+	// для одной пары квадратов a < b; только при output.return_conflict_cells
+	ConflictCells [][2]int `json:"conflict_cells,omitempty"`
+	// OA: n*n строк (i, j, L[0][i][j], ..., L[k-1][i][j]) в порядке строк
+	// квадрата; только при output.return_oa и found
+	OA [][]int `json:"oa,omitempty"`
+	// MaxFoundK: сколько квадратов наибольшего попарно ортогонального поднабора
+	// нашёл поиск (k при found, 1 — ни одной ортогональной пары). Сам поднабор
+	// при !found и max_found_k >= 2: PartialL с return_squares, иначе PartialHash
+	MaxFoundK   int       `json:"max_found_k"`
+	PartialL    [][][]int `json:"partial_L,omitempty"`
+	PartialHash []string  `json:"partial_hash,omitempty"`
+	// ConflictMatrix[a][b] — конфликты пары (L[a], L[b]), пересчитанные по
+	// итоговому набору; только при k >= 3 (при k = 2 это просто conflicts)
+	ConflictMatrix [][]int `json:"conflict_matrix,omitempty"`
+}
+
+type PayloadMate struct {
+	N    int     `json:"n"`
+	Base [][]int `json:"base"` // полный латинский квадрат, к которому ищем ортогональный
+}
+type ResultMate struct {
+	N            int     `json:"n"`
+	Found        bool    `json:"found"`
+	Mate         [][]int `json:"mate,omitempty"`
+	Verified     bool    `json:"verified"`     // mate латинский и ортогонален base
+	Transversals int     `json:"transversals"` // число трансверсалей base
+}
+type PayloadRandom struct {
+	N      int    `json:"n"`
+	Method string `json:"method"` // cyclic_permute (по умолчанию) | mcmc (Jacobson–Matthews)
+	// mcmc: число ходов цепи; 0 — n^3
+	Steps int64 `json:"steps"`
+}
+type ResultRandom struct {
+	N             int     `json:"n"`
+	Method        string  `json:"method"`
+	Square        [][]int `json:"square"`
+	VerifiedLatin bool    `json:"verified_latin"`
+	Steps         int64   `json:"steps,omitempty"` // mcmc: сколько ходов сделано
+}
+type PayloadVerify struct {
+	Square [][]int `json:"square"`
+}
+
+type ResultVerify struct {
+	N         int              `json:"n"`
+	IsLatin   bool             `json:"is_latin"`
+	Violation *latin.Violation `json:"violation,omitempty"`
+}
+
+type PayloadOrthogonal struct {
+	A [][]int `json:"a"`
+	B [][]int `json:"b"`
+}
+
+// PayloadRectangle: латинский прямоугольник r x n (r <= n) — строки
+// перестановки 0..n-1, в столбцах без повторов; его всегда можно дополнить
+// до квадрата (теорема Холла)
+type PayloadRectangle struct {
+	N         int     `json:"n"`
+	Rectangle [][]int `json:"rectangle"`
+}
+
+// PayloadMinCost: поля min_cost_completion сверх PayloadComplete (префикс,
+// constraints и прочее разбирает parseComplete). Cost[i][j][k] — цена k-го
+// символа (symbols[k] или k) в клетке (i,j), n x n x n
+type PayloadMinCost struct {
+	Cost [][][]float64 `json:"cost"`
+}
+
+// ResultMinCost: самое дешёвое найденное дополнение; optimal — дерево
+// обойдено целиком, и дешевле дополнений нет
+type ResultMinCost struct {
+	N             int      `json:"n"`
+	SolutionFound bool     `json:"solution_found"`
+	Square        [][]int  `json:"square,omitempty"`
+	VerifiedLatin bool     `json:"verified_latin"`
+	Cost          *float64 `json:"cost,omitempty"`
+	Optimal       bool     `json:"optimal"`
+	Trace         [][3]int `json:"trace,omitempty"`
+}
+
+// PayloadBenchmark: n и окно в секундах; воркер весь window дополняет
+// пустые n x n случайными DFS и считает сделанное
+type PayloadBenchmark struct {
+	N         int     `json:"n"`
+	WindowSec float64 `json:"window_sec"`
+}
+
+type ResultBenchmark struct {
+	N           int   `json:"n"`
+	WindowMS    int64 `json:"window_ms"` // фактическое окно
+	Ops         int64 `json:"ops"`       // завершённых генераций квадрата
+	Nodes       int64 `json:"nodes"`     // узлов DFS, включая оборванную окном
+	NodesPerSec int64 `json:"nodes_per_sec"`
+}
+
+// PayloadSeedScan: search_mols (k=2) для каждого seed из [seed_from,
+// seed_to] со своим бюджетом шагов — какие seed дают самый трудный и самый
+// лёгкий прогон
+type PayloadSeedScan struct {
+	N            int    `json:"n"`
+	K            int    `json:"k"` // только 2; 0 — 2
+	SeedFrom     int64  `json:"seed_from"`
+	SeedTo       int64  `json:"seed_to"`        // включительно
+	StepsPerSeed int64  `json:"steps_per_seed"` // 0 — 200000
+	Method       string `json:"method"`         // hill_climb | anneal
+	Buckets      int    `json:"buckets"`        // корзин гистограммы; 0 — 10
+}
+
+// SeedSteps: seed и сколько шагов локальному поиску понадобилось с ним
+type SeedSteps struct {
+	Seed  int64 `json:"seed"`
+	Steps int64 `json:"steps"`
+}
+
+// StepsBucket: сколько решённых seed уложились в [lo, hi] шагов
+type StepsBucket struct {
+	Lo    int64 `json:"lo"`
+	Hi    int64 `json:"hi"`
+	Count int   `json:"count"`
+}
+
+// ResultSeedScan: распределение шагов до решения по решённым seed; seed,
+// не решённые за steps_per_seed, только перечисляются. Hardest/Easiest —
+// среди решённых; Scanned < числа seed в диапазоне, если кончилось время.
+type ResultSeedScan struct {
+	N           int           `json:"n"`
+	K           int           `json:"k"`
+	Scanned     int           `json:"scanned"`
+	Solved      int           `json:"solved"`
+	Unsolved    []int64       `json:"unsolved,omitempty"`
+	MinSteps    int64         `json:"min_steps"`
+	MaxSteps    int64         `json:"max_steps"`
+	MeanSteps   float64       `json:"mean_steps"`
+	MedianSteps int64         `json:"median_steps"`
+	Easiest     *SeedSteps    `json:"easiest,omitempty"`
+	Hardest     *SeedSteps    `json:"hardest,omitempty"`
+	Histogram   []StepsBucket `json:"histogram,omitempty"`
+}
+
+type ResultOrthogonal struct {
+	N           int  `json:"n"`
+	Orthogonal  bool `json:"orthogonal"`
+	Conflicts   int  `json:"conflicts"`    // n*n - unique_pairs
+	UniquePairs int  `json:"unique_pairs"` // различных пар (a[i][j], b[i][j])
+}
+
+type PayloadTransversal struct {
+	N      int     `json:"n"`
+	Square [][]int `json:"square"` // полный латинский квадрат
+}
+
+// ResultTransversal: Cells — (строка, столбец) трансверсали по столбцам,
+// symbols — их символы (все n различны)
+type ResultTransversal struct {
+	N        int      `json:"n"`
+	Found    bool     `json:"found"`
+	Cells    [][2]int `json:"cells,omitempty"`
+	Symbols  []int    `json:"symbols,omitempty"`
+	Verified bool     `json:"verified"`
 }
 
 type DebugInfo struct {
-	Attempts  int     `json:"attempts,omitempty"`
-	BestScore int     `json:"best_score,omitempty"`
-	Notes     string  `json:"notes,omitempty"`
-	Steps     int64   `json:"steps,omitempty"`
-	Nodes     int64   `json:"nodes,omitempty"`
+	Attempts    int     `json:"attempts,omitempty"`
+	BestScore   int     `json:"best_score,omitempty"`
+	Notes       string  `json:"notes,omitempty"`
+	Steps       int64   `json:"steps,omitempty"`
+	Nodes       int64   `json:"nodes,omitempty"`        // DFS: присваивания, пережившие forward checking
+	Prunes      int64   `json:"prunes,omitempty"`       // DFS: присваивания, отсечённые forward checking
+	AutoFilled  int     `json:"auto_filled,omitempty"`  // клетки, заполненные arc consistency до DFS
+	MaxDepth    int     `json:"max_depth,omitempty"`    // DFS: наибольшая глубина ветвления
+	Backtracks  int64   `json:"backtracks,omitempty"`   // DFS: ветви, откаченные без решения
+	AvgBranch   float64 `json:"avg_branch,omitempty"`   // DFS: среднее число кандидатов при ветвлении
+	Restarts    int     `json:"restarts,omitempty"`     // MOLS: рестарты после stall_steps без улучшения
+	BestRestart int     `json:"best_restart,omitempty"` // MOLS: рестарт, давший лучший набор
+	Temperature float64 `json:"temperature,omitempty"`  // anneal: финальная температура
+	AcceptRate  float64 `json:"accept_rate,omitempty"`  // anneal: доля принятых ходов
+	// hill_climb: действующая вероятность неулучшающего хода
+	SidewaysProb *float64 `json:"sideways_prob,omitempty"`
+	// RootCandidates[i][j] — кандидаты пустой клетки до ветвления, null у заполненной;
+	// только при output.return_root_candidates и no_solution
+	RootCandidates [][][]int `json:"root_candidates,omitempty"`
+	// BudgetHit — какой лимит бюджета оборвал поиск (none — закончился сам)
+	BudgetHit *BudgetHit `json:"budget_hit,omitempty"`
+}
+
+// withStats fills the DFS shape fields from st.
+func (d DebugInfo) withStats(st latin.SearchStats) DebugInfo {
+	d.MaxDepth, d.Backtracks, d.AvgBranch = st.MaxDepth, st.Backtracks, st.AvgBranch()
+	return d
+}
+
+// BudgetHit names the budget limit that stopped a search early — time
+// (seconds), nodes or steps — with its effective value; Kind none means the
+// search ended on its own.
+type BudgetHit struct {
+	Kind  string `json:"kind"`
+	Limit int64  `json:"limit"`
 }
 
 // ---------------------------
@@ -121,34 +524,198 @@ type DebugInfo struct {
 // ---------------------------
 
 func main() {
-	inPath := flag.String("in", "in.json", "input json path")
-	outPath := flag.String("out", "out.json", "output json path")
+	inPath := flag.String("in", "in.json", "input json path (- for stdin); gzip is detected by its magic bytes")
+	outPath := flag.String("out", "out.json", "output json path (- for stdout); a .gz suffix gzips it")
+	checkpoint := flag.String("checkpoint", "", "completion: resume DFS from this file if it exists, save the frontier to it on timeout, remove it once the search ends")
+	maxN := flag.Int("max-n", 2000, "reject payloads with n, or a square with more rows, above this before allocating anything")
+	strict := flag.Bool("strict", true, "reject unknown top-level fields in the request (false: ignore them, for rolling deploys)")
+	format := flag.String("format", "json", "output format: json | jsonl (one line per solution, then a summary line)")
+	logInterval := flag.Duration("log-interval", 0, "write a JSON progress line (nodes/steps, best conflicts) to stderr this often (0: off)")
+	progressSocket := flag.String("progress-socket", "", "also send the progress lines to this Unix socket, every -log-interval or 1s; skipped silently if it cannot be reached")
+	noMinRuntime := flag.Bool("no-min-runtime", false, "never pad a request up to min_runtime_sec, whatever the request or the 5s default says")
+	validate := flag.Bool("validate", false, "only check the request(s): status valid or invalid_input, nothing is solved")
+	serveAddr := flag.String("serve", "", "run as an HTTP service on this address (e.g. :8080): POST a request to /, scrape counters from /metrics; -in/-out are not used")
+	maxInflight := flag.Int("max-inflight", runtime.NumCPU(), "-serve: solve at most this many requests at once, answer the rest with HTTP 429")
+	rngName := flag.String("rng", "legacy", "random generator behind every seed: legacy (math/rand, the default) | pcg (math/rand/v2 PCG, for long sampling runs); the same seed gives different output under each")
+	flushInterval := flag.Duration("flush-interval", 0, "while running, replace -out with the best-so-far state at most this often (0: off; json format only)")
 	flag.Parse()
+	rngKind, err := latin.ParseRNG(*rngName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-rng: %v\n", err)
+		os.Exit(exitInvalid)
+	}
 
 	startWall := time.Now()
 	startUnix := startWall.Unix()
 
+	// SIGINT/SIGTERM (например, балансер снимает отстающих) отменяют поиск,
+	// но out.json всё равно пишется со статусом cancelled
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	host, _ := os.Hostname()
 
-	req, err := readIn(*inPath)
+	// -serve: долгоживущий процесс, запросы по HTTP; rlimit'ы процессные —
+	// их не ставим, -checkpoint/-format/-flush-interval к нему не относятся
+	if *serveAddr != "" {
+		env := runEnv{host: host, maxN: *maxN, logEvery: *logInterval, progressSocket: *progressSocket, validate: *validate, noMinRuntime: *noMinRuntime, shared: true, rng: rngKind}
+		if err := serve(ctx, *serveAddr, *strict, *maxInflight, env); err != nil {
+			fmt.Fprintf(os.Stderr, "serve %s: %v\n", *serveAddr, err)
+			os.Exit(exitError)
+		}
+		return
+	}
+
+	// jsonl: решения пишутся в -out по мере нахождения, итог — последней строкой
+	var stream *jsonlStream
+	switch *format {
+	case "json":
+	case "jsonl":
+		var err error
+		if stream, err = openJSONLStream(*outPath); err != nil {
+			fmt.Fprintf(os.Stderr, "open %s: %v\n", *outPath, err)
+			os.Exit(exitInvalid)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -format=%q (want json|jsonl)\n", *format)
+		os.Exit(exitInvalid)
+	}
+	finish := func(resp OutResponse) {
+		if stream != nil {
+			stream.writeSummary(resp)
+			stream.close()
+			return
+		}
+		mustWriteOut(*outPath, resp)
+	}
+
+	reqs, batch, err := readIn(*inPath, *strict)
 	if err != nil {
-		writeOut(*outPath, OutResponse{
+		code := "BAD_JSON"
+		var be *budgetError
+		if errors.As(err, &be) {
+			code = "BAD_BUDGET"
+		}
+		finish(OutResponse{
 			Ok:      false,
 			Problem: "",
 			Status:  "invalid_input",
 			Metrics: finishMetrics(startUnix, startWall, host),
 			Error: &OutError{
-				Code:    "BAD_JSON",
+				Code:    code,
 				Message: err.Error(),
 			},
 		})
-		os.Exit(2)
+		os.Exit(exitInvalid)
+	}
+
+	env := runEnv{host: host, maxN: *maxN, stream: stream, checkpoint: *checkpoint, rlimits: !batch, logEvery: *logInterval, progressSocket: *progressSocket, validate: *validate, noMinRuntime: *noMinRuntime, rng: rngKind}
+	if *flushInterval > 0 {
+		if stream != nil {
+			fmt.Fprintln(os.Stderr, "-flush-interval is ignored with -format=jsonl")
+		} else if *outPath != "-" {
+			env.outPath, env.flushEvery = *outPath, *flushInterval
+		}
+	}
+	if !batch {
+		resp := runRequest(ctx, reqs[0], env)
+		finish(resp)
+		os.Exit(exitCode(resp.Status))
+	}
+
+	// batch: один процесс на весь массив. rlimit'ы процессные и только
+	// понижаются, поэтому ставим их один раз — на сумму бюджетов
+	if *checkpoint != "" {
+		fmt.Fprintln(os.Stderr, "-checkpoint is ignored in batch mode")
+		env.checkpoint = ""
+	}
+	ctx, cancelLimits := context.WithCancelCause(ctx)
+	defer cancelLimits(nil)
+	maxRSS, cpuSec := batchLimits(reqs)
+	if err := applyRLimits(cancelLimits, maxRSS, cpuSec); err != nil {
+		fmt.Fprintf(os.Stderr, "resource limits not applied: %v\n", err)
+	}
+	resps := make([]OutResponse, 0, len(reqs))
+	code := exitSolved
+	for _, req := range reqs {
+		resp := runRequest(ctx, req, env)
+		if stream != nil {
+			stream.writeSummary(resp)
+		}
+		resps = append(resps, resp)
+		if code == exitSolved {
+			code = exitCode(resp.Status)
+		}
+	}
+	if stream != nil {
+		stream.close()
+	} else {
+		mustWriteOut(*outPath, resps)
+	}
+	os.Exit(code)
+}
+
+// Коды выхода по классу status; источник истины — status в JSON, код лишь
+// позволяет балансеру не читать файл. Batch выходит с кодом первой задачи,
+// не получившей exitSolved.
+const (
+	exitSolved      = 0 // done, solution_limit, valid
+	exitError       = 1 // error, cancelled, resource_exhausted
+	exitInvalid     = 2 // invalid_input, а также нечитаемый запрос и кривые флаги
+	exitTimeout     = 3 // timeout, node_limit: бюджет кончился раньше ответа
+	exitNoSolution  = 4 // no_solution
+	exitWriteFailed = 5 // ответ посчитан, но -out не записан (нет каталога, прав, места); причина в stderr
+)
+
+// exitCode maps a response status to the process exit code.
+func exitCode(status string) int {
+	switch status {
+	case "done", "solution_limit", "valid":
+		return exitSolved
+	case "invalid_input":
+		return exitInvalid
+	case "timeout", "node_limit":
+		return exitTimeout
+	case "no_solution":
+		return exitNoSolution
 	}
+	return exitError
+}
+
+// runEnv is what runRequest needs from the command line.
+type runEnv struct {
+	host           string
+	maxN           int
+	stream         *jsonlStream
+	checkpoint     string
+	rlimits        bool // false in batch mode: main sets them once for the whole batch
+	outPath        string
+	flushEvery     time.Duration // > 0: промежуточные снимки в outPath
+	logEvery       time.Duration // > 0: строки прогресса в stderr
+	progressSocket string        // те же строки в Unix-сокет (см. progressLog)
+	validate       bool          // только проверка входа, без решения
+	noMinRuntime   bool          // min_runtime_sec = 0 для всех задач
+	shared         bool          // -serve: запросы идут параллельно в одном процессе
+	rng            latin.RNG     // -rng: генератор за каждым сидом
+}
+
+// runRequest runs one request under its own budget, including min_runtime
+// padding, and returns the response with final metrics.
+func runRequest(ctx context.Context, req InRequest, env runEnv) OutResponse {
+	startWall := time.Now()
+	startUnix := startWall.Unix()
+	host := env.host
+	cpuStart := processCPU()
 
 	// Defaults
 	if req.Budget.MinRuntimeSec <= 0 {
 		req.Budget.MinRuntimeSec = 5
 	}
+	// в -serve дожигание лишь держало бы место -max-inflight: сервис, а не
+	// slurm-задача, и стабилизировать время тут нечего
+	if env.noMinRuntime || env.shared {
+		req.Budget.MinRuntimeSec = 0
+	}
 	if req.Budget.TimeLimitSec <= 0 {
 		req.Budget.TimeLimitSec = 60
 	}
@@ -158,19 +725,91 @@ func main() {
 	if req.Output.MaxSolutions <= 0 {
 		req.Output.MaxSolutions = 1
 	}
+	if req.SchemaVersion <= 0 {
+		req.SchemaVersion = 1
+	}
 
 	deadline := startWall.Add(time.Duration(req.Budget.TimeLimitSec) * time.Second)
-	rng := rand.New(rand.NewSource(req.Seed))
+
+	// max_cores: GOMAXPROCS на время задачи; в batch следующая получает прежний.
+	// В -serve GOMAXPROCS общий для параллельных запросов — max_cores не трогаем
+	if req.Budget.MaxCores > 0 && !env.shared {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(req.Budget.MaxCores))
+	}
+
+	// самоограничение: память — по budget.max_rss_kb, CPU — с запасом над
+	// time_limit на все ядра; упёрлись — ctx отменяется с errResourceExhausted.
+	// В -serve RSS и лимит памяти GC общие для всех запросов — max_rss_kb,
+	// как и max_cores, не трогаем: чужая память отменяла бы эту задачу
+	ctx, cancelLimits := context.WithCancelCause(ctx)
+	defer cancelLimits(nil)
+	if req.Budget.MaxRSSKB > 0 && !env.shared {
+		defer watchRSS(ctx, cancelLimits, req.Budget.MaxRSSKB)()
+	}
+	cpuSec := cpuBudgetSec(req.Budget)
+	if env.rlimits {
+		if err := applyRLimits(cancelLimits, req.Budget.MaxRSSKB, cpuSec); err != nil {
+			fmt.Fprintf(os.Stderr, "resource limits not applied: %v\n", err)
+		}
+	}
+	rng := latin.NewRand(env.rng, req.Seed)
+	var progress *progressFile
+	if env.flushEvery > 0 {
+		progress = &progressFile{path: env.outPath, every: env.flushEvery, score: math.MinInt}
+	}
+	var plog *progressLog
+	if env.logEvery > 0 || env.progressSocket != "" {
+		plog = startProgressLog(req, env.logEvery, env.progressSocket)
+		defer plog.close()
+	}
 
 	var resp OutResponse
 	resp.Problem = req.Problem
 	resp.TaskID = req.TaskID
 
-	switch req.Problem {
-	case "complete_latin_square_from_prefix":
-		resp = handleComplete(req, rng, deadline, startUnix, startWall, host)
-	case "search_mols":
-		resp = handleMOLS(req, rng, deadline, startUnix, startWall, host)
+	// n проверяем до обработчиков: они сразу выделяют n x n и больше
+	// у verify_latin_square и check_orthogonal n нет — это число строк квадрата
+	var probe struct {
+		N      int               `json:"n"`
+		Square []json.RawMessage `json:"square"`
+		A      []json.RawMessage `json:"a"`
+	}
+	_ = json.Unmarshal(req.Payload, &probe) // кривой payload разберёт обработчик
+	probe.N = max(probe.N, len(probe.Square), len(probe.A))
+
+	switch {
+	case req.SchemaVersion > schemaVersion:
+		resp = invalid("UNSUPPORTED_SCHEMA", fmt.Sprintf("schema_version=%d is newer than supported %d", req.SchemaVersion, schemaVersion), req, startUnix, startWall, host)
+	case probe.N > env.maxN:
+		resp = invalid("N_TOO_LARGE", fmt.Sprintf("n=%d exceeds -max-n=%d", probe.N, env.maxN), req, startUnix, startWall, host)
+	case req.Output.MaxResultBytes < 0:
+		resp = invalid("BAD_MAX_RESULT_BYTES", fmt.Sprintf("max_result_bytes=%d, want >= 0 (0: no cap)", req.Output.MaxResultBytes), req, startUnix, startWall, host)
+	case env.validate:
+		resp = validateRequest(req, startUnix, startWall, host)
+	case req.Problem == "complete_latin_square_from_prefix":
+		resp = handleComplete(ctx, req, rng, env.rng, deadline, startUnix, startWall, host, env.stream, env.checkpoint, progress, plog)
+	case req.Problem == "count_latin_completions":
+		resp = handleCount(ctx, req, deadline, startUnix, startWall, host, plog)
+	case req.Problem == "search_mols":
+		resp = handleMOLS(ctx, req, rng, env.rng, deadline, startUnix, startWall, host, progress, plog)
+	case req.Problem == "find_orthogonal_mate":
+		resp = handleMate(ctx, req, rng, deadline, startUnix, startWall, host)
+	case req.Problem == "random_latin_square":
+		resp = handleRandom(ctx, req, rng, deadline, startUnix, startWall, host)
+	case req.Problem == "verify_latin_square":
+		resp = handleVerify(req, startUnix, startWall, host)
+	case req.Problem == "check_orthogonal":
+		resp = handleOrthogonal(req, startUnix, startWall, host)
+	case req.Problem == "extend_latin_rectangle":
+		resp = handleRectangle(ctx, req, rng, deadline, startUnix, startWall, host)
+	case req.Problem == "min_cost_completion":
+		resp = handleMinCost(ctx, req, rng, env.rng, deadline, startUnix, startWall, host, plog)
+	case req.Problem == "benchmark":
+		resp = handleBenchmark(ctx, req, env.rng, deadline, startUnix, startWall, host)
+	case req.Problem == "mols_seed_scan":
+		resp = handleSeedScan(ctx, req, env.rng, deadline, startUnix, startWall, host)
+	case req.Problem == "find_transversal":
+		resp = handleTransversal(ctx, req, rng, deadline, startUnix, startWall, host)
 	default:
 		resp = OutResponse{
 			Ok:      false,
@@ -185,42 +824,439 @@ func main() {
 		}
 	}
 
-	// min_runtime: если закончили раньше — дожигаем
+	resp.SchemaVersion = req.SchemaVersion
+
+	if errors.Is(context.Cause(ctx), errResourceExhausted) {
+		// результат обрезан и может быть огромным — не сериализуем его у самого лимита
+		resp.Ok = false
+		resp.Status = "resource_exhausted"
+		resp.Result = nil
+		resp.Error = &OutError{
+			Code:    "RESOURCE_EXHAUSTED",
+			Message: fmt.Sprintf("worker hit its resource limit (max_rss_kb=%d, cpu_sec=%d)", req.Budget.MaxRSSKB, cpuSec),
+		}
+	}
+
+	if req.Output.MaxResultBytes > 0 {
+		capResult(&resp, req.Output.MaxResultBytes)
+	}
+
+	// min_runtime: если закончили раньше — дожигаем. Невалидные задачи и
+	// ошибки не дожигаем: работы не было, стабилизировать нечего.
+	// SIGINT/SIGTERM прерывают ожидание — готовый результат пишется сразу
 	minEnd := startWall.Add(time.Duration(req.Budget.MinRuntimeSec) * time.Second)
-	if time.Now().Before(minEnd) {
-		time.Sleep(time.Until(minEnd))
+	if padMinRuntime(resp.Status) && time.Now().Before(minEnd) {
+		pad := time.NewTimer(time.Until(minEnd))
+		select {
+		case <-pad.C:
+		case <-ctx.Done():
+			pad.Stop()
+		}
 	}
 
-	// перезапишем метрики после min_runtime sleep
+	// перезапишем метрики после min_runtime sleep; скорости считаем по
+	// метрикам обработчика, чтобы сон их не разбавлял
+	work := resp.Metrics
+	work.sinceCPU(cpuStart)
 	resp.Metrics = finishMetrics(startUnix, startWall, host)
-	writeOut(*outPath, resp)
+	resp.Metrics.sinceCPU(cpuStart)
+	var nodes int64
+	if d, ok := resp.Debug.(DebugInfo); ok {
+		nodes = d.Nodes
+	}
+	addThroughput(&resp.Metrics, work, nodes)
+	resp.Metrics.SolveWallMS = work.WallMS
+	return resp
+}
+
+// validateRequest runs the checks the problem's handler would run and stops
+// there: status valid, or the invalid_input response the handler would give.
+func validateRequest(req InRequest, startUnix int64, startWall time.Time, host string) OutResponse {
+	var bad *OutResponse
+	switch req.Problem {
+	case "complete_latin_square_from_prefix", "count_latin_completions":
+		_, _, _, bad = parseComplete(req, startUnix, startWall, host)
+	case "search_mols":
+		_, bad = parseMOLS(req, startUnix, startWall, host)
+	case "find_orthogonal_mate":
+		_, bad = parseMate(req, startUnix, startWall, host)
+	case "random_latin_square":
+		_, bad = parseRandom(req, startUnix, startWall, host)
+	case "verify_latin_square":
+		_, bad = parseVerify(req, startUnix, startWall, host)
+	case "check_orthogonal":
+		_, bad = parseOrthogonal(req, startUnix, startWall, host)
+	case "extend_latin_rectangle":
+		_, bad = parseRectangle(req, startUnix, startWall, host)
+	case "min_cost_completion":
+		_, _, _, _, bad = parseMinCost(req, startUnix, startWall, host)
+	case "benchmark":
+		_, bad = parseBenchmark(req, startUnix, startWall, host)
+	case "mols_seed_scan":
+		_, bad = parseSeedScan(req, startUnix, startWall, host)
+	case "find_transversal":
+		_, bad = parseTransversal(req, startUnix, startWall, host)
+	default:
+		resp := invalid("UNKNOWN_PROBLEM", fmt.Sprintf("unknown problem=%q", req.Problem), req, startUnix, startWall, host)
+		bad = &resp
+	}
+	if bad != nil {
+		return *bad
+	}
+	return OutResponse{
+		Ok:      true,
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  "valid",
+		Metrics: finishMetrics(startUnix, startWall, host),
+	}
+}
 
-	if resp.Ok {
-		os.Exit(0)
+// cpuBudgetSec is the RLIMIT_CPU for one request: time_limit on every core
+// it may use (budget.max_cores) plus a grace period.
+func cpuBudgetSec(b InBudget) int64 {
+	tl := b.TimeLimitSec
+	if tl <= 0 {
+		tl = 60
 	}
-	os.Exit(1)
+	cores := runtime.NumCPU()
+	if b.MaxCores > 0 {
+		cores = b.MaxCores
+	}
+	return int64(min(tl, 1800)*cores) + cpuGraceSec
 }
 
-func readIn(path string) (InRequest, error) {
-	var req InRequest
-	b, err := os.ReadFile(path)
+// batchLimits sums CPU budgets over the batch and takes the largest
+// max_rss_kb; one request without a memory limit leaves RLIMIT_AS alone.
+func batchLimits(reqs []InRequest) (maxRSSKB, cpuSec int64) {
+	unlimited := false
+	for _, req := range reqs {
+		cpuSec += cpuBudgetSec(req.Budget)
+		unlimited = unlimited || req.Budget.MaxRSSKB <= 0
+		maxRSSKB = max(maxRSSKB, req.Budget.MaxRSSKB)
+	}
+	if unlimited {
+		maxRSSKB = 0
+	}
+	return maxRSSKB, cpuSec
+}
+
+func padMinRuntime(status string) bool {
+	switch status {
+	case "cancelled", "resource_exhausted", "invalid_input", "error", "valid":
+		return false
+	}
+	return true
+}
+
+// readIn reads one request or, if the top-level JSON is an array, a batch.
+// With strict=false unknown top-level fields (from a newer orchestrator) are
+// ignored instead of failing with BAD_JSON.
+func readIn(path string, strict bool) (reqs []InRequest, batch bool, err error) {
+	var b []byte
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("read %s: %w", path, err)
+	}
+	// gzip узнаём по сигнатуре, а не по суффиксу — так работает и со stdin
+	if bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		if b, err = gunzip(b); err != nil {
+			return nil, false, fmt.Errorf("gunzip %s: %w", path, err)
+		}
+	}
+	return decodeIn(b, strict)
+}
+
+// decodeIn decodes one request or, if b is a JSON array, a batch of them.
+// With strict, unknown fields fail at every level, budget included.
+func decodeIn(b []byte, strict bool) (reqs []InRequest, batch bool, err error) {
+	batch = bytes.HasPrefix(bytes.TrimSpace(b), []byte("["))
+	raws := []json.RawMessage{nil}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if batch {
+		err = dec.Decode(&raws)
+		if err == nil && len(raws) == 0 {
+			err = errors.New("empty batch")
+		}
+	} else {
+		err = dec.Decode(&raws[0])
+	}
+	if err == nil {
+		reqs = make([]InRequest, len(raws))
+		for i := range raws {
+			if err = decodeRequest(raws[i], strict, &reqs[i]); err != nil {
+				break
+			}
+		}
+	}
 	if err != nil {
-		return req, fmt.Errorf("read %s: %w", path, err)
+		return nil, batch, fmt.Errorf("decode json: %w", err)
+	}
+	for i := range reqs {
+		reqs[i].Problem = strings.TrimSpace(reqs[i].Problem)
 	}
+	return reqs, batch, nil
+}
+
+// decodeRequest decodes one request object; the budget goes through
+// decodeBudget with the same strictness.
+func decodeRequest(b []byte, strict bool, req *InRequest) error {
+	type plain InRequest
+	aux := struct {
+		*plain
+		Budget json.RawMessage `json:"budget"`
+	}{plain: (*plain)(req)}
 	dec := json.NewDecoder(bytes.NewReader(b))
-	dec.DisallowUnknownFields() // чтобы ловить опечатки в ключах
-	if err := dec.Decode(&req); err != nil {
-		return req, fmt.Errorf("decode json: %w", err)
+	if strict {
+		dec.DisallowUnknownFields() // чтобы ловить опечатки в ключах
+	}
+	if err := dec.Decode(&aux); err != nil {
+		return err
+	}
+	if len(aux.Budget) == 0 || string(aux.Budget) == "null" {
+		return nil
+	}
+	return decodeBudget(aux.Budget, strict, &req.Budget)
+}
+
+// writeOut writes v to path as indented JSON (- for stdout, gzip for a .gz
+// suffix). On error main exits with exitWriteFailed: the answer exists but
+// the balancer will not find it.
+func writeOut(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		_, err = os.Stdout.Write(append(b, '\n'))
+		return err
+	}
+	if gzipped(path) {
+		b = gzipBytes(b)
+	}
+	return writeFileAtomic(path, b)
+}
+
+// mustWriteOut is writeOut for main: a failed write is reported on stderr
+// and ends the process with exitWriteFailed, whatever the status.
+func mustWriteOut(path string, v any) {
+	if err := writeOut(path, v); err != nil {
+		fmt.Fprintf(os.Stderr, "write %s: %v (result computed but not saved)\n", path, err)
+		os.Exit(exitWriteFailed)
+	}
+}
+
+// writeFileAtomic writes b to a temp file next to path and renames it over
+// path, so a reader polling path sees either the old or the complete new
+// file, never a truncated one.
+func writeFileAtomic(path string, b []byte) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// gzipped reports whether output to path should be gzip-compressed.
+func gzipped(path string) bool {
+	return strings.HasSuffix(path, ".gz")
+}
+
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(b)
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+func gunzip(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// progressFile holds the best-so-far snapshots written under -flush-interval.
+// A snapshot replaces the file only if it scores higher than the last one:
+// parallel clones report their own deepest boards.
+type progressFile struct {
+	path  string
+	every time.Duration
+	mu    sync.Mutex
+	score int
+}
+
+// write replaces the file via temp+rename, so a reaper never reads half of it.
+func (f *progressFile) write(score int, resp OutResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if score <= f.score {
+		return
+	}
+	f.score = score
+	b, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		return
+	}
+	if gzipped(f.path) {
+		b = gzipBytes(b)
+	}
+	_ = writeFileAtomic(f.path, b)
+}
+
+// partialCells converts a board with -1 for empty cells to the prefix
+// format, where empty cells are null.
+func partialCells(board [][]int) [][]*int {
+	out := make([][]*int, len(board))
+	for i, row := range board {
+		out[i] = make([]*int, len(row))
+		for j := range row {
+			if row[j] >= 0 {
+				out[i][j] = &row[j]
+			}
+		}
+	}
+	return out
+}
+
+// readCheckpoint loads a DFS frontier saved by writeCheckpoint; a missing
+// file is reported as os.ErrNotExist.
+func readCheckpoint(path string) (*latin.Checkpoint, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp latin.Checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, fmt.Errorf("decode checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// writeCheckpoint saves cp via writeFileAtomic, so a run killed mid-write
+// never leaves a truncated checkpoint behind.
+func writeCheckpoint(path string, cp *latin.Checkpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// jsonlStream writes one JSON object per line: a "solution" line for each
+// completion as it is found and a final "summary" line with the response.
+type jsonlStream struct {
+	mu  sync.Mutex
+	f   *os.File
+	zw  *gzip.Writer // -out *.gz; сбрасывается после каждой строки
+	enc *json.Encoder
+}
+
+type jsonlSolution struct {
+	Type   string  `json:"type"` // solution
+	TaskID string  `json:"task_id,omitempty"`
+	Index  int     `json:"index"`
+	Square [][]int `json:"square"`
+}
+
+type jsonlSummary struct {
+	Type string `json:"type"` // summary
+	OutResponse
+}
+
+func openJSONLStream(path string) (*jsonlStream, error) {
+	if path == "-" {
+		return &jsonlStream{f: os.Stdout, enc: json.NewEncoder(os.Stdout)}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if gzipped(path) {
+		zw := gzip.NewWriter(f)
+		return &jsonlStream{f: f, zw: zw, enc: json.NewEncoder(zw)}, nil
+	}
+	return &jsonlStream{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (w *jsonlStream) writeSolution(taskID string, index int, square [][]int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.enc.Encode(jsonlSolution{Type: "solution", TaskID: taskID, Index: index, Square: square})
+	w.flush()
+}
+
+func (w *jsonlStream) writeSummary(resp OutResponse) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.enc.Encode(jsonlSummary{Type: "summary", OutResponse: resp})
+	w.flush()
+}
+
+// flush pushes buffered gzip output to the file so a reader sees whole lines.
+func (w *jsonlStream) flush() {
+	if w.zw != nil {
+		_ = w.zw.Flush()
+	}
+}
+
+func (w *jsonlStream) close() {
+	if w.zw != nil {
+		_ = w.zw.Close()
 	}
-	req.Problem = strings.TrimSpace(req.Problem)
-	return req, nil
+	if w.f != os.Stdout {
+		_ = w.f.Close()
+	}
+}
+
+// cpuTimes is the process CPU time so far, in ms.
+type cpuTimes struct {
+	userMS, sysMS int64
+}
+
+func processCPU() cpuTimes {
+	ru := &syscall.Rusage{}
+	_ = syscall.Getrusage(syscall.RUSAGE_SELF, ru)
+	return cpuTimes{userMS: timevalToMS(ru.Utime), sysMS: timevalToMS(ru.Stime)}
 }
 
-func writeOut(path string, resp OutResponse) {
-	b, _ := json.MarshalIndent(resp, "", "  ")
-	_ = os.WriteFile(path, b, 0644)
+// sinceCPU makes the CPU figures of m count from start, so that a batch or
+// -serve request reports its own CPU rather than the process total. Under
+// -serve they still include whatever else was in flight.
+func (m *OutMetrics) sinceCPU(start cpuTimes) {
+	m.CPUUserMS -= start.userMS
+	m.CPUSysMS -= start.sysMS
 }
 
+// finishMetrics reports the process CPU total; see sinceCPU.
 func finishMetrics(startUnix int64, startWall time.Time, host string) OutMetrics {
 	endWall := time.Now()
 	endUnix := endWall.Unix()
@@ -231,8 +1267,7 @@ func finishMetrics(startUnix int64, startWall time.Time, host string) OutMetrics
 
 	cpuUserMS := timevalToMS(ru.Utime)
 	cpuSysMS := timevalToMS(ru.Stime)
-	// Linux: Maxrss в KB (обычно). Для курсовой норм как есть.
-	maxRSSKB := int64(ru.Maxrss)
+	maxRSSKB := maxRSSToKB(int64(ru.Maxrss), runtime.GOOS)
 
 	return OutMetrics{
 		StartedAtUnix:  startUnix,
@@ -246,565 +1281,2566 @@ func finishMetrics(startUnix int64, startWall time.Time, host string) OutMetrics
 		GOOS:           runtime.GOOS,
 		GOARCH:         runtime.GOARCH,
 		CoresSeen:      runtime.NumCPU(),
+		CoresUsed:      runtime.GOMAXPROCS(0),
 	}
 }
 
-func timevalToMS(tv syscall.Timeval) int64 {
-	// tv.Sec seconds + tv.Usec microseconds
-	return tv.Sec*1000 + int64(tv.Usec)/1000
+// addThroughput fills NodesPerSec and CPUUtilPercent in m from work, the
+// metrics taken when the handler returned. Both stay 0 when the work took
+// under a millisecond.
+func addThroughput(m *OutMetrics, work OutMetrics, nodes int64) {
+	if work.WallMS <= 0 {
+		return
+	}
+	m.NodesPerSec = nodes * 1000 / work.WallMS
+	cores := int64(max(work.CoresUsed, 1))
+	// rusage тикает грубо — на коротких задачах может выйти чуть больше 100
+	m.CPUUtilPercent = int(min((work.CPUUserMS+work.CPUSysMS)*100/(work.WallMS*cores), 100))
+}
+
+// maxRSSToKB normalizes rusage.Maxrss to KB: Linux/BSD report KB,
+// darwin reports bytes.
+func maxRSSToKB(maxrss int64, goos string) int64 {
+	switch goos {
+	case "darwin", "ios":
+		return maxrss / 1024
+	}
+	return maxrss
+}
+
+func timevalToMS(tv syscall.Timeval) int64 {
+	// tv.Sec seconds + tv.Usec microseconds
+	return tv.Sec*1000 + int64(tv.Usec)/1000
+}
+
+// ---------------------------
+// COMPLETE: Latin square completion
+// ---------------------------
+
+func handleComplete(ctx context.Context, req InRequest, rng *rand.Rand, rngKind latin.RNG, deadline time.Time, startUnix int64, startWall time.Time, host string, stream *jsonlStream, checkpointPath string, progress *progressFile, plog *progressLog) OutResponse {
+	p, board, fixed, bad := parseComplete(req, startUnix, startWall, host)
+	if bad != nil {
+		return *bad
+	}
+	n := p.N
+	if p.SearchSeed != nil {
+		rng = latin.NewRand(rngKind, *p.SearchSeed)
+	}
+
+	maxNodes := req.Budget.MaxNodes
+	if maxNodes <= 0 {
+		maxNodes = 3_000_000
+	}
+	if filled(board) == n*n {
+		// искать нечего: parseComplete уже проверил префикс на повторы
+		return prefixComplete(req, p, board, startUnix, startWall, host, stream)
+	}
+	if p.Engine == "dlx" {
+		return completeDLX(ctx, req, p, board, rng, deadline, maxNodes, startUnix, startWall, host, stream, checkpointPath, plog)
+	}
+	if p.Engine == "local" {
+		return completeLocal(ctx, req, p, board, rng, deadline, startUnix, startWall, host, stream, checkpointPath, plog)
+	}
+
+	// newSolver настраивает DFS над b; back переводит решения сопряжённого
+	// квадрата обратно (nil — b и есть исходная доска)
+	newSolver := func(b [][]int, fx [][]bool, back func([][]int) [][]int, deadline time.Time, maxNodes int64) *latin.Solver {
+		if back == nil {
+			back = func(sq [][]int) [][]int { return sq }
+		}
+		solver := constrainedSolver(p, b, fx)
+		solver.Ctx = ctx
+		solver.Rng, solver.RandKind = rng, rngKind
+		solver.Deadline = deadline
+		solver.MaxNodes = maxNodes
+		solver.MaxSolutions = req.Output.MaxSolutions
+		solver.Sample = req.Output.UniformRandom
+		if progress != nil {
+			solver.ProgressEvery = progress.every
+			solver.OnProgress = func(partial [][]int) {
+				partial = back(partial)
+				k := filled(partial)
+				progress.write(k, OutResponse{
+					Problem: req.Problem,
+					TaskID:  req.TaskID,
+					Status:  "running",
+					Result:  ResultComplete{N: n, Partial: partialCells(partial), Filled: k},
+					Metrics: finishMetrics(startUnix, startWall, host),
+				})
+			}
+		}
+		if p.CheckEvery > 0 {
+			solver.CheckEvery = p.CheckEvery
+		}
+		solver.LCV = p.ValueOrder == "lcv"
+		solver.RowDense = p.CellOrder == "row_dense"
+		solver.LexMin = p.LexMin
+		solver.KeepBest = true
+		solver.Distinct = distinctKey(req)
+		solver.Trace = req.Output.ReturnTrace
+		if plog != nil {
+			solver.OnTick = plog.addNodes
+		}
+		if stream != nil {
+			// решения уходят в поток сразу, в памяти держим только первое
+			solver.OnSolution = func(index int, sq [][]int) {
+				sq = back(sq)
+				if p.Symbols != nil {
+					sq = latin.Relabel(sq, p.Symbols)
+				}
+				stream.writeSolution(req.TaskID, index, sq)
+			}
+		}
+		return solver
+	}
+
+	var notes []string
+	conjugates, note := conjugatesAllowed(req, p, checkpointPath)
+	if note != "" {
+		notes = append(notes, note)
+	}
+	directDeadline, directNodes := deadline, maxNodes
+	if conjugates {
+		// прямой попытке — половина бюджета, остальное сопряжённым
+		directDeadline = time.Now().Add(time.Until(deadline) / 2)
+		directNodes = max(maxNodes/2, 1)
+	}
+	solver := newSolver(board, fixed, nil, directDeadline, directNodes)
+
+	if filled(board) == 0 {
+		notes = append(notes, "prefix is empty")
+	}
+	if note, _ := breakRowSymmetry(solver, p, board); note != "" {
+		notes = append(notes, note)
+	}
+
+	// до DFS протягиваем следствия префикса: вынужденные клетки заполняются,
+	// противоречие ловится сразу — плотные префиксы решаются почти без перебора.
+	// Затем паросочетания по строкам/столбцам (условие Холла)
+	autoFilled, consistent := solver.ArcConsistency()
+	var hall string
+	if consistent {
+		hall = hallNote(solver)
+		consistent = hall == ""
+	}
+
+	parallel := p.Parallel
+	if parallel && req.Output.UniformRandom {
+		// у клонов свои резервуары — выбор перестал бы быть равномерным
+		parallel = false
+		notes = append(notes, "parallel disabled with uniform_random")
+	}
+	if parallel && solver.Distinct != nil && req.Output.Distinct != "exact" {
+		// у клонов свои множества ключей — классы повторялись бы между ветками
+		parallel = false
+		key := req.Output.Distinct
+		if key == "" {
+			key = "canonical"
+		}
+		notes = append(notes, "parallel disabled with distinct="+key)
+	}
+	if checkpointPath != "" && consistent {
+		cp, err := readCheckpoint(checkpointPath)
+		switch {
+		case err == nil:
+			if err := solver.Resume(cp); err != nil {
+				return invalid("BAD_CHECKPOINT", err.Error(), req, startUnix, startWall, host)
+			}
+			notes = append(notes, fmt.Sprintf("resumed from checkpoint (depth=%d, nodes=%d)", len(cp.Frames), cp.Nodes))
+		case !errors.Is(err, os.ErrNotExist):
+			return invalid("BAD_CHECKPOINT", err.Error(), req, startUnix, startWall, host)
+		}
+		// фронтир сохраняется только для последовательного DFS
+		if parallel {
+			parallel = false
+			notes = append(notes, "parallel disabled with -checkpoint")
+		}
+	}
+
+	var ok bool
+	var status string
+	var nodes int64
+	switch {
+	case !consistent:
+		status = "no_solution"
+	case parallel:
+		ok, status, nodes = solver.SolveParallel(runtime.GOMAXPROCS(0))
+	default:
+		ok, status, nodes = solver.Solve()
+	}
+	prunes, stats := solver.Prunes, solver.Stats
+	if conjugates && (status == "timeout" || status == "node_limit") && ctx.Err() == nil {
+		// прямой обход застрял: пробуем сопряжённые квадраты, каждому половина
+		// оставшегося бюджета, последнему — всё. no_solution любого из них
+		// окончательный: сопряжение сохраняет число дополнений
+		rest := latin.ConjugateRoles[1:]
+		for k, roles := range rest {
+			attemptDeadline, attemptNodes := deadline, maxNodes-nodes
+			if k < len(rest)-1 {
+				attemptDeadline = time.Now().Add(time.Until(deadline) / 2)
+				attemptNodes = max(attemptNodes/2, 1)
+			}
+			inv := latin.InverseRoles(roles)
+			back := func(sq [][]int) [][]int { return latin.Conjugate(sq, inv) }
+			cb := latin.Conjugate(board, roles)
+			s := newSolver(cb, filledCells(cb), back, attemptDeadline, attemptNodes)
+			var cok bool
+			var cstatus string
+			var cnodes int64
+			if _, consistent := s.ArcConsistency(); !consistent || hallNote(s) != "" {
+				cstatus = "no_solution"
+			} else if parallel {
+				cok, cstatus, cnodes = s.SolveParallel(runtime.GOMAXPROCS(0))
+			} else {
+				cok, cstatus, cnodes = s.Solve()
+			}
+			nodes += cnodes
+			prunes += s.Prunes
+			stats.Add(s.Stats)
+			ok, status = cok, cstatus
+			if ok || status == "no_solution" {
+				for i, sq := range s.Solutions {
+					s.Solutions[i] = back(sq)
+				}
+				for k, t := range s.Path {
+					s.Path[k] = [3]int{t[inv[0]], t[inv[1]], t[inv[2]]}
+				}
+				solver = s
+				if ok {
+					notes = append(notes, fmt.Sprintf("solved on conjugate %s after the direct search ran out of budget", conjugateName(roles)))
+				} else {
+					notes = append(notes, fmt.Sprintf("conjugate %s shows the prefix has no completion", conjugateName(roles)))
+				}
+				break
+			}
+			if status == "cancelled" || nodes >= maxNodes || !time.Now().Before(deadline) {
+				break
+			}
+		}
+	}
+	status, limitNote := solutionLimit(req, status, solver.Found, solver.Exhausted())
+	if limitNote != "" {
+		notes = append(notes, limitNote)
+	}
+	res := ResultComplete{
+		N:             n,
+		SolutionFound: ok,
+		Square:        nil,
+		VerifiedLatin: false,
+	}
+	if ok {
+		res.Square = solver.Solutions[0]
+		res.VerifiedLatin = true
+		for _, sq := range solver.Solutions {
+			res.VerifiedLatin = res.VerifiedLatin && verifyComplete(p, sq)
+		}
+		var note string
+		if res.CanonicalForm, note = canonicalForm(req, res.Square); note != "" {
+			notes = append(notes, note)
+		}
+		if req.Output.MaxSolutions > 1 && stream == nil {
+			res.Squares = solver.Solutions
+		}
+		if req.Output.ReturnTrace {
+			res.Trace = tracePath(p, solver.Path)
+		}
+		if p.FillUntilEmpty != nil {
+			note = ""
+			partial := fillUntilEmpty(ctx, p, board, res.Square, *p.FillUntilEmpty, rng, deadline, &note)
+			if note != "" {
+				notes = append(notes, note)
+			}
+			empty := n*n - filled(partial)
+			if p.Symbols != nil {
+				partial = relabelPartial(partial, p.Symbols)
+			}
+			res.Partial, res.Filled, res.Empty = partialCells(partial), n*n-empty, &empty
+		}
+		if p.Symbols != nil {
+			res.Square = latin.Relabel(res.Square, p.Symbols)
+			for k := range res.Squares {
+				res.Squares[k] = latin.Relabel(res.Squares[k], p.Symbols)
+			}
+		}
+	}
+
+	if !ok && (status == "timeout" || status == "node_limit") {
+		// бюджет кончился: вместо пустого результата — самая глубокая
+		// согласованная частичная доска, до которой дошёл поиск
+		best := solver.Best()
+		k := filled(best)
+		if p.Symbols != nil {
+			best = relabelPartial(best, p.Symbols)
+		}
+		res.Partial, res.Filled = partialCells(best), k
+	}
+
+	if checkpointPath != "" {
+		switch {
+		case status == "timeout" && solver.Checkpoint() != nil:
+			if err := writeCheckpoint(checkpointPath, solver.Checkpoint()); err != nil {
+				notes = append(notes, fmt.Sprintf("checkpoint not saved: %v", err))
+			} else {
+				notes = append(notes, fmt.Sprintf("checkpoint saved to %s", checkpointPath))
+			}
+		case status == "done" || status == "solution_limit" || status == "no_solution":
+			// поиск завершён — продолжать нечего
+			_ = os.Remove(checkpointPath)
+		}
+	}
+
+	if req.Output.UniformRandom && ok {
+		if solver.Exhausted() {
+			notes = append(notes, fmt.Sprintf("uniform_random: sampled from all %d completions", solver.Found))
+		} else {
+			// перебор обрезан бюджетом: выбор равномерен только среди найденных
+			notes = append(notes, fmt.Sprintf("uniform_random: approximate, sampled from the first %d completions found before the budget ran out", solver.Found))
+		}
+	}
+
+	debug := DebugInfo{Nodes: nodes, Prunes: prunes, AutoFilled: autoFilled, BudgetHit: budgetHit(req, status, maxNodes)}.withStats(stats)
+	if status == "no_solution" && req.Output.ReturnRootCandidates {
+		debug.RootCandidates = rootCandidates(p, board, fixed)
+	}
+	switch {
+	case hall != "":
+		notes = append(notes, hall)
+	case !consistent:
+		notes = append(notes, "prefix has no completion (found by arc consistency before search)")
+	case status == "timeout":
+		notes = append(notes, fmt.Sprintf("time budget exhausted (time_limit_sec=%d)", req.Budget.TimeLimitSec))
+	case status == "node_limit":
+		notes = append(notes, fmt.Sprintf("node budget exhausted (max_nodes=%d)", maxNodes))
+	case status == "cancelled":
+		notes = append(notes, "search cancelled before completion")
+	}
+	debug.Notes = strings.Join(notes, "; ")
+
+	return OutResponse{
+		Ok:      ok || status == "timeout" || status == "node_limit", // timeout/node_limit тоже “валидный” результат попытки
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  status,
+		Result:  res,
+		Debug:   debug,
+		Metrics: finishMetrics(startUnix, startWall, host),
+		Error:   nil,
+	}
+}
+
+// conjugatesAllowed reports whether engine_hint=conjugates can be honoured:
+// conjugation swaps the roles of rows, columns and symbols, which the
+// diagonal/boxes/symmetric/forbidden constraints do not survive, and a retry
+// would repeat or skew solutions that were already streamed, sampled or
+// checkpointed. Otherwise it returns a note explaining the fallback.
+func conjugatesAllowed(req InRequest, p PayloadComplete, checkpointPath string) (bool, string) {
+	if p.EngineHint != "conjugates" {
+		return false, ""
+	}
+	var why []string
+	if p.Constraints.Diagonal || p.Constraints.Boxes || p.Constraints.Symmetric || p.Forbidden != nil || p.Equal != nil || p.NotEqual != nil {
+		why = append(why, "diagonal/boxes/symmetric/forbidden/equal/not_equal constraints")
+	}
+	if req.Output.UniformRandom {
+		why = append(why, "uniform_random")
+	}
+	if p.LexMin {
+		why = append(why, "lex_min")
+	}
+	if req.Output.MaxSolutions > 1 {
+		why = append(why, "max_solutions > 1")
+	}
+	if checkpointPath != "" {
+		why = append(why, "-checkpoint")
+	}
+	if len(why) > 0 {
+		return false, "engine_hint=conjugates ignored with " + strings.Join(why, ", ")
+	}
+	return true, ""
+}
+
+// conjugateName names the conjugate given by roles as the roles its rows,
+// columns and symbols play in the original square, e.g. (column, row, symbol)
+// for the transpose.
+func conjugateName(roles [3]int) string {
+	names := [3]string{"row", "column", "symbol"}
+	return fmt.Sprintf("(%s, %s, %s)", names[roles[0]], names[roles[1]], names[roles[2]])
+}
+
+// filledCells marks the non-empty cells of board.
+func filledCells(board [][]int) [][]bool {
+	fx := make([][]bool, len(board))
+	for i, row := range board {
+		fx[i] = make([]bool, len(row))
+		for j, v := range row {
+			fx[i][j] = v >= 0
+		}
+	}
+	return fx
+}
+
+// budgetHit maps a search status to the budget limit behind it; maxNodes is
+// the effective node limit.
+func budgetHit(req InRequest, status string, maxNodes int64) *BudgetHit {
+	switch status {
+	case "timeout":
+		return &BudgetHit{Kind: "time", Limit: int64(req.Budget.TimeLimitSec)}
+	case "node_limit":
+		return &BudgetHit{Kind: "nodes", Limit: maxNodes}
+	}
+	return &BudgetHit{Kind: "none"}
+}
+
+// canonicalForm computes the canonical form output.canonical_form asks for,
+// or returns a note when the square is too symmetric to canonicalize within
+// the work limit.
+func canonicalForm(req InRequest, sq [][]int) ([][]int, string) {
+	if req.Output.CanonicalForm == "" {
+		return nil, ""
+	}
+	form, ok := latin.CanonicalForm(sq, req.Output.CanonicalForm == "main_class")
+	if !ok {
+		return nil, "canonical_form skipped: the square has too many symmetries to canonicalize"
+	}
+	return form, ""
+}
+
+// distinctKey returns the output.distinct key for Solver.Distinct, or nil
+// when at most one solution is kept and there is nothing to dedupe.
+func distinctKey(req InRequest) func(sq [][]int) string {
+	if req.Output.MaxSolutions <= 1 || req.Output.UniformRandom {
+		return nil
+	}
+	switch req.Output.Distinct {
+	case "", "canonical":
+		return latin.CanonicalHash
+	case "exact":
+		return latin.DigestSquare
+	}
+	mainClass := req.Output.Distinct == "main_class"
+	return func(sq [][]int) string {
+		if form, ok := latin.CanonicalForm(sq, mainClass); ok {
+			return "c" + latin.DigestSquare(form) // не спутать с ключом самого квадрата
+		}
+		return latin.DigestSquare(sq)
+	}
+}
+
+// solutionLimit tells apart the two ways a multi-solution enumeration ends
+// with status done: it either walked the whole tree or stopped after
+// max_solutions completions, in which case there may be more and the status
+// becomes solution_limit. With max_solutions = 1 done stays done.
+func solutionLimit(req InRequest, status string, found int, exhausted bool) (string, string) {
+	if status != "done" || req.Output.MaxSolutions <= 1 || req.Output.UniformRandom {
+		return status, ""
+	}
+	if found >= req.Output.MaxSolutions {
+		return "solution_limit", fmt.Sprintf("stopped at max_solutions=%d, more completions may exist", req.Output.MaxSolutions)
+	}
+	if exhausted {
+		return status, fmt.Sprintf("all %d completions found", found)
+	}
+	return status, ""
+}
+
+// filled counts the non-empty cells of board.
+func filled(board [][]int) int {
+	k := 0
+	for _, row := range board {
+		for _, v := range row {
+			if v >= 0 {
+				k++
+			}
+		}
+	}
+	return k
+}
+
+// prefixComplete answers a completion request whose prefix has no empty
+// cells: the prefix itself is the only completion.
+func prefixComplete(req InRequest, p PayloadComplete, board [][]int, startUnix int64, startWall time.Time, host string, stream *jsonlStream) OutResponse {
+	notes := []string{"prefix already complete"}
+	form, note := canonicalForm(req, board)
+	if note != "" {
+		notes = append(notes, note)
+	}
+	sq := board
+	if p.Symbols != nil {
+		sq = latin.Relabel(sq, p.Symbols)
+	}
+	if stream != nil {
+		stream.writeSolution(req.TaskID, 0, sq)
+	}
+	return OutResponse{
+		Ok:      true,
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  "done",
+		Result:  ResultComplete{N: p.N, SolutionFound: true, Square: sq, VerifiedLatin: verifyComplete(p, board), CanonicalForm: form},
+		Debug:   DebugInfo{Notes: strings.Join(notes, "; ")},
+		Metrics: finishMetrics(startUnix, startWall, host),
+	}
+}
+
+// fillUntilEmpty erases cells of the completion sq that the prefix board
+// leaves empty, in rng order, until target cells are empty. With fill_unique
+// a cell is only erased if the partial board keeps sq as its only
+// completion; when that runs out before target, note says how far it got.
+// Every partial is a subset of sq, so it always stays completable.
+func fillUntilEmpty(ctx context.Context, p PayloadComplete, board, sq [][]int, target int, rng *rand.Rand, deadline time.Time, note *string) [][]int {
+	partial := latin.DeepCopy(sq)
+	var cells [][2]int
+	for i := range board {
+		for j := range board[i] {
+			if board[i][j] < 0 {
+				cells = append(cells, [2]int{i, j})
+			}
+		}
+	}
+	rng.Shuffle(len(cells), func(a, b int) { cells[a], cells[b] = cells[b], cells[a] })
+	empty, skipped := 0, 0
+	for _, c := range cells {
+		if empty == target {
+			break
+		}
+		v := partial[c[0]][c[1]]
+		partial[c[0]][c[1]] = -1
+		if p.FillUnique && !uniqueCompletion(ctx, p, partial, deadline) {
+			partial[c[0]][c[1]] = v
+			skipped++
+			continue
+		}
+		empty++
+	}
+	if empty < target {
+		*note = fmt.Sprintf("fill_until_empty: stopped at %d empty cells of %d asked, erasing any of the other %d filled cells would allow a second completion", empty, target, skipped)
+		if time.Now().After(deadline) || ctx.Err() != nil {
+			*note = fmt.Sprintf("fill_until_empty: stopped at %d empty cells of %d asked, budget ran out while checking uniqueness", empty, target)
+		}
+	}
+	return partial
+}
+
+// uniqueCompletion reports whether board has exactly one completion under
+// the payload constraints; a check cut short by the budget counts as not
+// unique.
+func uniqueCompletion(ctx context.Context, p PayloadComplete, board [][]int, deadline time.Time) bool {
+	solver := constrainedSolver(p, board, nil)
+	solver.Ctx = ctx
+	solver.Deadline = deadline
+	solver.MaxSolutions = 2
+	if _, ok := solver.ArcConsistency(); !ok {
+		return false
+	}
+	solver.Solve()
+	return solver.Found == 1 && solver.Exhausted()
+}
+
+// relabelPartial maps the filled cells of board into the payload symbols.
+func relabelPartial(board [][]int, symbols []int) [][]int {
+	out := latin.DeepCopy(board)
+	for _, row := range out {
+		for j, v := range row {
+			if v >= 0 {
+				row[j] = symbols[v]
+			}
+		}
+	}
+	return out
+}
+
+// tracePath relabels a solver's assignment path into the payload symbols.
+func tracePath(p PayloadComplete, path [][3]int) [][3]int {
+	out := make([][3]int, len(path))
+	for k, t := range path {
+		if p.Symbols != nil {
+			t[2] = p.Symbols[t[2]]
+		}
+		out[k] = t
+	}
+	return out
+}
+
+// forbidCells calls forbid for every forbidden (cell, value) of the payload;
+// in symmetric mode a ban on (i,j) also holds for (j,i).
+func forbidCells(p PayloadComplete, forbid func(i, j, v int)) {
+	for i, row := range p.Forbidden {
+		for j, vs := range row {
+			for _, v := range vs {
+				forbid(i, j, v)
+				if p.Constraints.Symmetric && i != j {
+					forbid(j, i, v)
+				}
+			}
+		}
+	}
+}
+
+// relateCells passes the equal / not_equal cell pairs to the solver.
+func relateCells(p PayloadComplete, solver *latin.Solver) {
+	for _, pr := range p.Equal {
+		solver.Relate(pr[0], pr[1], true)
+	}
+	for _, pr := range p.NotEqual {
+		solver.Relate(pr[0], pr[1], false)
+	}
+}
+
+// rootCandidates lists the candidates of every empty cell of the prefix
+// under the payload constraints, before any propagation or branching.
+func rootCandidates(p PayloadComplete, board [][]int, fixed [][]bool) [][][]int {
+	grid := constrainedSolver(p, board, fixed).CandidateGrid()
+	if p.Symbols != nil {
+		for _, row := range grid {
+			for _, cands := range row {
+				for k, v := range cands {
+					cands[k] = p.Symbols[v]
+				}
+			}
+		}
+	}
+	return grid
+}
+
+// constrainedSolver builds a Solver over board with every payload
+// constraint. The searches add their budgets on top; the probes (root
+// candidates, Hall's condition, uniqueness) use it as is, so they see the
+// same candidates the search does.
+func constrainedSolver(p PayloadComplete, board [][]int, fixed [][]bool) *latin.Solver {
+	solver := latin.NewSolver(board, fixed)
+	if p.Constraints.Diagonal {
+		solver.EnableDiagonals()
+	}
+	if p.Constraints.Boxes {
+		solver.EnableBoxes()
+	}
+	if p.Constraints.Symmetric {
+		// до Relate: в symmetric связи клеток под диагональю переносятся на зеркальные
+		solver.EnableSymmetry()
+	}
+	forbidCells(p, solver.Forbid)
+	relateCells(p, solver)
+	return solver
+}
+
+// hallNote runs Solver.HallViolation and names the row or column that
+// cannot be completed, or returns "" if every line passes.
+func hallNote(solver *latin.Solver) string {
+	kind, line := solver.HallViolation()
+	if kind == "" {
+		return ""
+	}
+	return fmt.Sprintf("prefix has no completion: the empty cells of %s %d cannot take its missing symbols (Hall's condition)", kind, line)
+}
+
+// newDLX sets up the exact-cover engine for the payload constraints.
+func newDLX(ctx context.Context, p PayloadComplete, board [][]int, deadline time.Time, maxNodes int64, plog *progressLog) *latin.DLX {
+	dlx := latin.NewDLX(board)
+	if p.Constraints.Diagonal {
+		dlx.EnableDiagonals()
+	}
+	if p.Constraints.Boxes {
+		dlx.EnableBoxes()
+	}
+	forbidCells(p, dlx.Forbid)
+	dlx.Ctx = ctx
+	dlx.Deadline = deadline
+	dlx.MaxNodes = maxNodes
+	if p.CheckEvery > 0 {
+		dlx.CheckEvery = p.CheckEvery
+	}
+	if plog != nil {
+		dlx.OnTick = plog.addNodes
+	}
+	return dlx
+}
+
+// engineNotes lists the DFS-only options that engine=dlx and engine=local
+// ignore.
+func engineNotes(p PayloadComplete, checkpointPath string) []string {
+	var ignored []string
+	if p.Constraints.SymmetryBreaking.FixFirstRow {
+		ignored = append(ignored, "fix_first_row row ordering")
+	}
+	if p.Parallel {
+		ignored = append(ignored, "parallel")
+	}
+	if p.ValueOrder == "lcv" {
+		ignored = append(ignored, "value_order=lcv")
+	}
+	if p.CellOrder == "row_dense" {
+		ignored = append(ignored, "cell_order=row_dense")
+	}
+	if checkpointPath != "" {
+		ignored = append(ignored, "-checkpoint")
+	}
+	if len(ignored) == 0 {
+		return nil
+	}
+	return []string{"engine=" + p.Engine + " ignores " + strings.Join(ignored, ", ")}
+}
+
+// completeDLX is handleComplete for engine=dlx.
+func completeDLX(ctx context.Context, req InRequest, p PayloadComplete, board [][]int, rng *rand.Rand, deadline time.Time, maxNodes int64, startUnix int64, startWall time.Time, host string, stream *jsonlStream, checkpointPath string, plog *progressLog) OutResponse {
+	dlx := newDLX(ctx, p, board, deadline, maxNodes, plog)
+	dlx.Rng = rng
+	dlx.MaxSolutions = req.Output.MaxSolutions
+	dlx.Trace = req.Output.ReturnTrace
+	dlx.Distinct = distinctKey(req)
+	if stream != nil {
+		dlx.OnSolution = func(index int, sq [][]int) {
+			if p.Symbols != nil {
+				sq = latin.Relabel(sq, p.Symbols)
+			}
+			stream.writeSolution(req.TaskID, index, sq)
+		}
+	}
+	hall := hallNote(constrainedSolver(p, board, nil))
+	ok, status, nodes := false, "no_solution", int64(0)
+	if hall == "" {
+		ok, status, nodes = dlx.Solve()
+	}
+	status, limitNote := solutionLimit(req, status, dlx.Found, dlx.Exhausted())
+
+	res := ResultComplete{N: p.N, SolutionFound: ok}
+	var formNote, fillNote string
+	if ok {
+		res.Square = dlx.Solutions[0]
+		res.VerifiedLatin = true
+		for _, sq := range dlx.Solutions {
+			res.VerifiedLatin = res.VerifiedLatin && verifyComplete(p, sq)
+		}
+		res.CanonicalForm, formNote = canonicalForm(req, res.Square)
+		if req.Output.MaxSolutions > 1 && stream == nil {
+			res.Squares = dlx.Solutions
+		}
+		if req.Output.ReturnTrace {
+			res.Trace = tracePath(p, dlx.Path)
+		}
+		if p.FillUntilEmpty != nil {
+			partial := fillUntilEmpty(ctx, p, board, res.Square, *p.FillUntilEmpty, rng, deadline, &fillNote)
+			empty := p.N*p.N - filled(partial)
+			if p.Symbols != nil {
+				partial = relabelPartial(partial, p.Symbols)
+			}
+			res.Partial, res.Filled, res.Empty = partialCells(partial), p.N*p.N-empty, &empty
+		}
+		if p.Symbols != nil {
+			res.Square = latin.Relabel(res.Square, p.Symbols)
+			for k := range res.Squares {
+				res.Squares[k] = latin.Relabel(res.Squares[k], p.Symbols)
+			}
+		}
+	}
+
+	notes := engineNotes(p, checkpointPath)
+	if filled(board) == 0 {
+		notes = append(notes, "prefix is empty")
+	}
+	if limitNote != "" {
+		notes = append(notes, limitNote)
+	}
+	if formNote != "" {
+		notes = append(notes, formNote)
+	}
+	if fillNote != "" {
+		notes = append(notes, fillNote)
+	}
+	if hall != "" {
+		notes = append(notes, hall)
+	}
+	switch status {
+	case "timeout":
+		notes = append(notes, fmt.Sprintf("time budget exhausted (time_limit_sec=%d)", req.Budget.TimeLimitSec))
+	case "node_limit":
+		notes = append(notes, fmt.Sprintf("node budget exhausted (max_nodes=%d)", maxNodes))
+	case "cancelled":
+		notes = append(notes, "search cancelled before completion")
+	}
+	debug := DebugInfo{Nodes: nodes, Notes: strings.Join(notes, "; "), BudgetHit: budgetHit(req, status, maxNodes)}
+	if status == "no_solution" && req.Output.ReturnRootCandidates {
+		debug.RootCandidates = rootCandidates(p, board, nil)
+	}
+
+	return OutResponse{
+		Ok:      ok || status == "timeout" || status == "node_limit",
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  status,
+		Result:  res,
+		Debug:   debug,
+		Metrics: finishMetrics(startUnix, startWall, host),
+	}
+}
+
+// completeLocal is handleComplete for engine=local: no search tree, so no
+// nodes, checkpoint or progress snapshots; budget.max_steps bounds the
+// local search steps instead.
+func completeLocal(ctx context.Context, req InRequest, p PayloadComplete, board [][]int, rng *rand.Rand, deadline time.Time, startUnix int64, startWall time.Time, host string, stream *jsonlStream, checkpointPath string, plog *progressLog) OutResponse {
+	maxSteps := req.Budget.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = 10_000_000
+	}
+	opt := latin.LocalOptions{Ctx: ctx, Rng: rng, Deadline: deadline, MaxSteps: maxSteps}
+	if plog != nil {
+		opt.OnTick = plog.molsTick
+	}
+	lr := latin.LocalComplete(board, opt)
+
+	ok := lr.Status == "done"
+	res := ResultComplete{N: p.N, SolutionFound: ok}
+	var formNote, fillNote string
+	if ok {
+		res.Square = lr.Square
+		res.VerifiedLatin = verifyComplete(p, res.Square)
+		res.CanonicalForm, formNote = canonicalForm(req, res.Square)
+		if p.FillUntilEmpty != nil {
+			partial := fillUntilEmpty(ctx, p, board, res.Square, *p.FillUntilEmpty, rng, deadline, &fillNote)
+			empty := p.N*p.N - filled(partial)
+			if p.Symbols != nil {
+				partial = relabelPartial(partial, p.Symbols)
+			}
+			res.Partial, res.Filled, res.Empty = partialCells(partial), p.N*p.N-empty, &empty
+		}
+		if p.Symbols != nil {
+			res.Square = latin.Relabel(res.Square, p.Symbols)
+		}
+		if stream != nil {
+			stream.writeSolution(req.TaskID, 0, res.Square)
+		}
+	}
+
+	notes := engineNotes(p, checkpointPath)
+	if filled(board) == 0 {
+		notes = append(notes, "prefix is empty")
+	}
+	if formNote != "" {
+		notes = append(notes, formNote)
+	}
+	if fillNote != "" {
+		notes = append(notes, fillNote)
+	}
+	debug := DebugInfo{Steps: lr.Steps, BestScore: lr.Conflicts, BudgetHit: budgetHit(req, lr.Status, 0)}
+	switch lr.Status {
+	case "no_solution":
+		notes = append(notes, fmt.Sprintf("engine=local is incomplete: %d column repeats left after max_steps=%d, a completion may still exist", lr.Conflicts, maxSteps))
+		debug.BudgetHit = &BudgetHit{Kind: "steps", Limit: maxSteps}
+	case "timeout":
+		notes = append(notes, fmt.Sprintf("time budget exhausted (time_limit_sec=%d), %d column repeats left", req.Budget.TimeLimitSec, lr.Conflicts))
+	case "cancelled":
+		notes = append(notes, "search cancelled before completion")
+	}
+	debug.Notes = strings.Join(notes, "; ")
+
+	return OutResponse{
+		Ok:      ok || lr.Status == "timeout",
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  lr.Status,
+		Result:  res,
+		Debug:   debug,
+		Metrics: finishMetrics(startUnix, startWall, host),
+	}
+}
+
+// countResult fills ResultCount from the tally; rows is the number of rows
+// ordered by fix_first_row, whose k! permutations go into total.
+func countResult(p PayloadComplete, tally latin.Counter, rows int, exact bool) ResultCount {
+	res := ResultCount{N: p.N, Count: tally.Count, Exact: exact, Overflow: tally.Overflow}
+	if rows >= 2 {
+		for k := int64(2); k <= int64(rows); k++ {
+			tally.Mul(k)
+		}
+		res.Total = tally.Count
+		res.Overflow = tally.Overflow
+	}
+	if p.Modulus > 0 {
+		res.CountMod = &tally.Mod
+	}
+	return res
+}
+
+// countDLX is handleCount for engine=dlx.
+func countDLX(ctx context.Context, req InRequest, p PayloadComplete, board [][]int, deadline time.Time, maxNodes int64, startUnix int64, startWall time.Time, host string, plog *progressLog) OutResponse {
+	dlx := newDLX(ctx, p, board, deadline, maxNodes, plog)
+	dlx.CountOnly = true
+	dlx.Tally.Modulus = p.Modulus
+	hall := hallNote(constrainedSolver(p, board, nil))
+	status, nodes := "no_solution", int64(0)
+	if hall == "" {
+		_, status, nodes = dlx.Solve()
+	}
+
+	exact := status == "no_solution"
+	if exact {
+		status = "done"
+	}
+	notes := engineNotes(p, "")
+	if hall != "" {
+		notes = append(notes, hall)
+	}
+	switch status {
+	case "timeout":
+		notes = append(notes, fmt.Sprintf("count is a lower bound: time budget exhausted (time_limit_sec=%d)", req.Budget.TimeLimitSec))
+	case "node_limit":
+		notes = append(notes, fmt.Sprintf("count is a lower bound: node budget exhausted (max_nodes=%d)", maxNodes))
+	case "cancelled":
+		notes = append(notes, "count is a lower bound: search cancelled before completion")
+	}
+
+	return OutResponse{
+		Ok:      status != "cancelled",
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  status,
+		Result:  countResult(p, dlx.Tally, 0, exact),
+		Debug:   DebugInfo{Nodes: nodes, Notes: strings.Join(notes, "; "), BudgetHit: budgetHit(req, status, maxNodes)},
+		Metrics: finishMetrics(startUnix, startWall, host),
+	}
+}
+
+// verifyComplete checks a solution against the payload constraints.
+func verifyComplete(p PayloadComplete, sq [][]int) bool {
+	for i, row := range p.Forbidden {
+		for j, vs := range row {
+			for _, v := range vs {
+				if sq[i][j] == v {
+					return false
+				}
+			}
+		}
+	}
+	if p.Constraints.Symmetric && !latin.IsSymmetric(sq) {
+		return false
+	}
+	for _, pr := range p.Equal {
+		if sq[pr[0][0]][pr[0][1]] != sq[pr[1][0]][pr[1][1]] {
+			return false
+		}
+	}
+	for _, pr := range p.NotEqual {
+		if sq[pr[0][0]][pr[0][1]] == sq[pr[1][0]][pr[1][1]] {
+			return false
+		}
+	}
+	if p.Constraints.Reduced {
+		for k := range sq {
+			if sq[0][k] != k || sq[k][0] != k {
+				return false
+			}
+		}
+	}
+	if p.Constraints.Boxes && !latin.IsSudoku(sq) {
+		return false
+	}
+	if p.Constraints.Diagonal {
+		return latin.IsDiagonalLatinSquare(sq)
+	}
+	return latin.IsLatinSquare(sq)
+}
+
+// symbolOf maps the internal symbol index v back to the payload alphabet.
+func symbolOf(p PayloadComplete, v int) int {
+	if p.Symbols != nil {
+		return p.Symbols[v]
+	}
+	return v
+}
+
+// breakRowSymmetry applies fix_first_row symmetry breaking: with row 0 fixed,
+// rows that are empty in the prefix may be permuted freely, so the solver
+// only searches completions whose first column increases down those rows.
+// It returns a note for DebugInfo, or "" if nothing was applied, and the
+// number of rows ordered (each result stands for that many factorial).
+func breakRowSymmetry(solver *latin.Solver, p PayloadComplete, board [][]int) (string, int) {
+	if !p.Constraints.SymmetryBreaking.FixFirstRow {
+		return "", 0
+	}
+	if p.Constraints.Diagonal || p.Constraints.Symmetric || p.Constraints.Boxes || p.Forbidden != nil || p.Equal != nil || p.NotEqual != nil {
+		// перестановка строк ломает диагонали, симметрию, блоки, запреты и связи клеток — редукция неприменима
+		return "fix_first_row: row ordering skipped, it does not preserve diagonal/symmetric/boxes/forbidden/equal/not_equal constraints", 0
+	}
+	var rows []int
+	for i := 1; i < len(board); i++ {
+		empty := true
+		for _, v := range board[i] {
+			empty = empty && v < 0
+		}
+		if empty {
+			rows = append(rows, i)
+		}
+	}
+	if len(rows) < 2 {
+		return "", 0
+	}
+	solver.BreakRowSymmetry(rows)
+	return fmt.Sprintf("fix_first_row: first column increasing over %d empty rows, results are up to permutations of those rows", len(rows)), len(rows)
+}
+
+// parseComplete decodes and validates a completion payload and builds the
+// board over 0..n-1 (-1 = empty). On bad input it returns the invalid_input
+// response to send instead.
+func parseComplete(req InRequest, startUnix int64, startWall time.Time, host string) (p PayloadComplete, board [][]int, fixed [][]bool, bad *OutResponse) {
+	fail := func(code, msg string) (PayloadComplete, [][]int, [][]bool, *OutResponse) {
+		resp := invalid(code, msg, req, startUnix, startWall, host)
+		return p, nil, nil, &resp
+	}
+
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return fail("BAD_PAYLOAD", err.Error())
+	}
+
+	// validate basic
+	if p.N <= 0 {
+		return fail("BAD_N", "n must be > 0")
+	}
+	switch p.ValueOrder {
+	case "", "random", "lcv":
+	default:
+		return fail("BAD_VALUE_ORDER", fmt.Sprintf("unknown value_order=%q (want random|lcv)", p.ValueOrder))
+	}
+	switch p.CellOrder {
+	case "", "mrv", "row_dense":
+	default:
+		return fail("BAD_CELL_ORDER", fmt.Sprintf("unknown cell_order=%q (want mrv|row_dense)", p.CellOrder))
+	}
+	switch p.Engine {
+	case "", "dfs":
+		p.Engine = "dfs"
+	case "dlx":
+		if p.Constraints.Symmetric {
+			return fail("BAD_ENGINE", "engine=dlx does not support symmetric")
+		}
+		if req.Output.UniformRandom {
+			return fail("BAD_ENGINE", "engine=dlx does not support output.uniform_random")
+		}
+		if p.Equal != nil || p.NotEqual != nil {
+			return fail("BAD_ENGINE", "engine=dlx does not support equal/not_equal")
+		}
+	case "local":
+		// один квадрат, только строки и столбцы
+		switch {
+		case req.Problem != "complete_latin_square_from_prefix":
+			return fail("BAD_ENGINE", "engine=local applies to complete_latin_square_from_prefix only")
+		case p.Constraints.Diagonal || p.Constraints.Boxes || p.Constraints.Symmetric:
+			return fail("BAD_ENGINE", "engine=local does not support diagonal, boxes or symmetric")
+		case p.Forbidden != nil || p.Equal != nil || p.NotEqual != nil:
+			return fail("BAD_ENGINE", "engine=local does not support forbidden/equal/not_equal")
+		case req.Output.MaxSolutions > 1 || req.Output.UniformRandom:
+			return fail("BAD_ENGINE", "engine=local returns one square, drop max_solutions/uniform_random")
+		case req.Output.ReturnTrace:
+			return fail("BAD_ENGINE", "engine=local has no assignment trace, drop output.return_trace")
+		}
+	default:
+		return fail("BAD_ENGINE", fmt.Sprintf("unknown engine=%q (want dfs|dlx|local)", p.Engine))
+	}
+	switch p.EngineHint {
+	case "", "direct":
+		p.EngineHint = "direct"
+	case "conjugates":
+		if p.Engine != "dfs" {
+			return fail("BAD_ENGINE_HINT", "engine_hint=conjugates needs engine=dfs")
+		}
+	default:
+		return fail("BAD_ENGINE_HINT", fmt.Sprintf("unknown engine_hint=%q (want direct|conjugates)", p.EngineHint))
+	}
+	if p.FillUntilEmpty != nil {
+		switch {
+		case req.Problem != "complete_latin_square_from_prefix":
+			return fail("BAD_FILL_UNTIL_EMPTY", "fill_until_empty applies to complete_latin_square_from_prefix only")
+		case p.Constraints.Symmetric:
+			return fail("BAD_FILL_UNTIL_EMPTY", "fill_until_empty does not support symmetric")
+		case *p.FillUntilEmpty < 0:
+			return fail("BAD_FILL_UNTIL_EMPTY", "fill_until_empty must be >= 0")
+		}
+	} else if p.FillUnique {
+		return fail("BAD_FILL_UNTIL_EMPTY", "fill_unique needs fill_until_empty")
+	}
+	if p.LexMin {
+		switch {
+		case req.Problem != "complete_latin_square_from_prefix":
+			return fail("BAD_LEX_MIN", "lex_min applies to complete_latin_square_from_prefix only")
+		case p.Engine != "dfs":
+			return fail("BAD_LEX_MIN", "lex_min needs engine=dfs")
+		case req.Output.UniformRandom:
+			return fail("BAD_LEX_MIN", "lex_min contradicts output.uniform_random")
+		case p.ValueOrder == "lcv" || p.CellOrder == "row_dense":
+			return fail("BAD_LEX_MIN", "lex_min fixes the search order, drop value_order/cell_order")
+		}
+	}
+	if p.Modulus != 0 {
+		if req.Problem != "count_latin_completions" {
+			return fail("BAD_MODULUS", "modulus applies to count_latin_completions only")
+		}
+		if p.Modulus < 2 || !big.NewInt(p.Modulus).ProbablyPrime(20) {
+			return fail("BAD_MODULUS", fmt.Sprintf("modulus=%d is not a prime", p.Modulus))
+		}
+	}
+	switch req.Output.CanonicalForm {
+	case "", "isotopy", "main_class":
+	default:
+		return fail("BAD_CANONICAL_FORM", fmt.Sprintf("unknown canonical_form=%q (want isotopy|main_class)", req.Output.CanonicalForm))
+	}
+	switch req.Output.Distinct {
+	case "", "canonical", "exact", "isotopy", "main_class":
+	default:
+		return fail("BAD_DISTINCT", fmt.Sprintf("unknown distinct=%q (want canonical|exact|isotopy|main_class)", req.Output.Distinct))
+	}
+	// форма префикса: отдельный код на каждую поломку, номер строки — в details
+	failRow := func(code, msg string, details map[string]interface{}) (PayloadComplete, [][]int, [][]bool, *OutResponse) {
+		resp := invalid(code, msg, req, startUnix, startWall, host)
+		resp.Error.Details = details
+		return p, nil, nil, &resp
+	}
+	if p.Prefix == nil {
+		return fail("PREFIX_NULL", "prefix is missing or null; pass an n x n array with null for empty cells")
+	}
+	if len(p.Prefix) != p.N {
+		return fail("BAD_PREFIX_SHAPE", fmt.Sprintf("prefix must be n x n, got %d rows for n=%d", len(p.Prefix), p.N))
+	}
+	for i := range p.Prefix {
+		switch {
+		case p.Prefix[i] == nil:
+			return failRow("PREFIX_ROW_NULL", fmt.Sprintf("prefix row %d is null", i), map[string]interface{}{"row": i})
+		case len(p.Prefix[i]) != p.N:
+			return failRow("PREFIX_ROW_LEN_MISMATCH", fmt.Sprintf("prefix row %d has %d cells, want n=%d", i, len(p.Prefix[i]), p.N),
+				map[string]interface{}{"row": i, "len": len(p.Prefix[i]), "n": p.N})
+		}
+	}
+
+	// алфавит: внутри решаем на 0..n-1, наружу отдаём исходные символы
+	n := p.N
+	var symIndex map[int]int
+	if p.Symbols != nil {
+		if len(p.Symbols) != n {
+			return fail("BAD_SYMBOLS", fmt.Sprintf("symbols must have exactly n=%d entries, got %d", n, len(p.Symbols)))
+		}
+		symIndex = make(map[int]int, n)
+		for idx, sym := range p.Symbols {
+			if _, dup := symIndex[sym]; dup {
+				return fail("BAD_SYMBOLS", fmt.Sprintf("duplicate symbol %d", sym))
+			}
+			symIndex[sym] = idx
+		}
+	}
+
+	// build board
+	board = make([][]int, n)
+	fixed = make([][]bool, n)
+	for i := 0; i < n; i++ {
+		board[i] = make([]int, n)
+		fixed[i] = make([]bool, n)
+		for j := 0; j < n; j++ {
+			if p.Prefix[i][j] == nil {
+				board[i][j] = -1
+			} else {
+				v := *p.Prefix[i][j]
+				if symIndex != nil {
+					idx, ok := symIndex[v]
+					if !ok {
+						return fail("BAD_VALUE", fmt.Sprintf("value %d at (%d,%d) is not in symbols", v, i, j))
+					}
+					v = idx
+				} else if v < 0 || v >= n {
+					return fail("BAD_VALUE", fmt.Sprintf("value out of range at (%d,%d)", i, j))
+				}
+				board[i][j] = v
+				fixed[i][j] = true
+			}
+		}
+	}
+
+	if p.Constraints.Reduced {
+		// строка 0 и столбец 0 по порядку; заданное в префиксе должно совпасть
+		for k := 0; k < n; k++ {
+			for _, c := range [][2]int{{0, k}, {k, 0}} {
+				i, j := c[0], c[1]
+				if board[i][j] >= 0 && board[i][j] != k {
+					return fail("INVALID_PREFIX", fmt.Sprintf("reduced: cell (%d,%d) must hold %d, prefix has %d", i, j, symbolOf(p, k), symbolOf(p, board[i][j])))
+				}
+				board[i][j], fixed[i][j] = k, true
+			}
+		}
+	}
+
+	if p.FillUntilEmpty != nil && *p.FillUntilEmpty > n*n-filled(board) {
+		return fail("BAD_FILL_UNTIL_EMPTY", fmt.Sprintf("fill_until_empty=%d exceeds the %d empty cells of the prefix", *p.FillUntilEmpty, n*n-filled(board)))
+	}
+
+	// forbidden переводим в индексы 0..n-1, как и префикс
+	if p.Forbidden != nil {
+		if len(p.Forbidden) != n {
+			return fail("BAD_FORBIDDEN", "forbidden must be n x n")
+		}
+		for i := range p.Forbidden {
+			if len(p.Forbidden[i]) != n {
+				return fail("BAD_FORBIDDEN", "forbidden must be n x n")
+			}
+			for j, vs := range p.Forbidden[i] {
+				for k, v := range vs {
+					if symIndex != nil {
+						idx, ok := symIndex[v]
+						if !ok {
+							return fail("BAD_FORBIDDEN", fmt.Sprintf("forbidden value %d at (%d,%d) is not in symbols", v, i, j))
+						}
+						v = idx
+					} else if v < 0 || v >= n {
+						return fail("BAD_FORBIDDEN", fmt.Sprintf("forbidden value out of range at (%d,%d)", i, j))
+					}
+					vs[k] = v
+				}
+			}
+		}
+	}
+
+	if p.Constraints.Boxes {
+		if _, ok := latin.BoxSide(n); !ok {
+			return fail("BAD_CONSTRAINTS", fmt.Sprintf("boxes needs n to be a perfect square, got n=%d", n))
+		}
+	}
+	if p.Constraints.Symmetric {
+		if p.Constraints.Diagonal {
+			// клетки (i,n-1-i) и (n-1-i,i) симметричны — на побочной диагонали всегда повтор
+			return fail("BAD_CONSTRAINTS", "symmetric and diagonal cannot both hold for n > 1: the anti-diagonal is symmetric to itself")
+		}
+		// заданы обе клетки пары (i,j), (j,i): одно значение — избыточно, но
+		// допустимо; разные — противоречие
+		if err := latin.ValidatePartialSymmetric(board); err != nil {
+			return fail("INVALID_PREFIX", err.Error())
+		}
+		if err := latin.ValidatePartial(board); err != nil {
+			return fail("INVALID_PREFIX", err.Error())
+		}
+		// отражаем префикс: заданная (i,j) задаёт и (j,i)
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if board[i][j] >= 0 && board[j][i] < 0 {
+					board[j][i] = board[i][j]
+					fixed[j][i] = true
+				}
+			}
+		}
+		// повтор мог появиться только из отражённых клеток — так и скажем
+		if err := latin.ValidatePartial(board); err != nil {
+			return fail("INVALID_PREFIX", err.Error()+" once the prefix is mirrored (symmetric)")
+		}
+	}
+
+	if p.Constraints.SymmetryBreaking.FixFirstRow {
+		// first row must be full permutation 0..n-1
+		seen := make([]bool, n)
+		for j := 0; j < n; j++ {
+			if board[0][j] < 0 {
+				return fail("FIX_FIRST_ROW", "first row must be fully specified when fix_first_row=true")
+			}
+			v := board[0][j]
+			if seen[v] {
+				return fail("FIX_FIRST_ROW", "first row must be a permutation (no duplicates)")
+			}
+			seen[v] = true
+		}
+	}
+
+	// check prefix consistency (no duplicates in row/col)
+	if err := latin.ValidatePartial(board); err != nil {
+		return fail("INVALID_PREFIX", err.Error())
+	}
+	if p.Constraints.Diagonal {
+		if err := latin.ValidatePartialDiagonals(board); err != nil {
+			return fail("INVALID_PREFIX", err.Error())
+		}
+	}
+	if p.Constraints.Boxes {
+		if err := latin.ValidatePartialBoxes(board); err != nil {
+			return fail("INVALID_PREFIX", err.Error())
+		}
+	}
+	for i, row := range p.Forbidden {
+		for j, vs := range row {
+			for _, v := range vs {
+				if board[i][j] == v {
+					return fail("INVALID_PREFIX", fmt.Sprintf("value at (%d,%d) is forbidden there", i, j))
+				}
+			}
+		}
+	}
+	if code, msg := checkCellPairs(p, board); code != "" {
+		return fail(code, msg)
+	}
+
+	return p, board, fixed, nil
+}
+
+// checkCellPairs validates equal / not_equal against each other and the
+// (mirrored) prefix: cells on the board, no not_equal between a cell and
+// itself, no chain of equal pairs putting one symbol twice in a row, column,
+// box or diagonal, no not_equal inside such a chain, and no pair the prefix
+// already breaks. It returns an error code and message, or "" if all is well.
+func checkCellPairs(p PayloadComplete, board [][]int) (string, string) {
+	n := p.N
+	sym := p.Constraints.Symmetric
+	// в symmetric (i,j) и (j,i) — одна клетка
+	norm := func(c [2]int) [2]int {
+		if sym && c[0] > c[1] {
+			c[0], c[1] = c[1], c[0]
+		}
+		return c
+	}
+	for _, list := range []struct {
+		name  string
+		equal bool
+		pairs [][2][2]int
+	}{{"equal", true, p.Equal}, {"not_equal", false, p.NotEqual}} {
+		for k, pr := range list.pairs {
+			for _, c := range pr {
+				if c[0] < 0 || c[0] >= n || c[1] < 0 || c[1] >= n {
+					return "BAD_CELL_PAIRS", fmt.Sprintf("%s[%d]: cell (%d,%d) is off the %dx%d board", list.name, k, c[0], c[1], n, n)
+				}
+			}
+			if !list.equal && norm(pr[0]) == norm(pr[1]) {
+				return "BAD_CELL_PAIRS", fmt.Sprintf("not_equal[%d] relates cell (%d,%d) to itself", k, pr[0][0], pr[0][1])
+			}
+			a, b := board[pr[0][0]][pr[0][1]], board[pr[1][0]][pr[1][1]]
+			if a >= 0 && b >= 0 && (a == b) != list.equal {
+				return "INVALID_PREFIX", fmt.Sprintf("prefix breaks %s[%d] at (%d,%d) and (%d,%d)", list.name, k, pr[0][0], pr[0][1], pr[1][0], pr[1][1])
+			}
+		}
+	}
+
+	// классы клеток, которые equal (с транзитивностью) делает равными
+	parent := map[[2]int][2]int{}
+	var find func(c [2]int) [2]int
+	find = func(c [2]int) [2]int {
+		c = norm(c)
+		if q, ok := parent[c]; ok && q != c {
+			r := find(q)
+			parent[c] = r
+			return r
+		}
+		return c
+	}
+	for _, pr := range p.Equal {
+		parent[find(pr[0])] = find(pr[1])
+	}
+	for k, pr := range p.NotEqual {
+		if find(pr[0]) == find(pr[1]) {
+			return "BAD_CELL_PAIRS", fmt.Sprintf("not_equal[%d] relates (%d,%d) and (%d,%d), which equal pairs force to be equal", k, pr[0][0], pr[0][1], pr[1][0], pr[1][1])
+		}
+	}
+	box, _ := latin.BoxSide(n)
+	// clash: один символ в a и b повторился бы в строке, столбце, блоке или
+	// на диагонали (в symmetric — и через отражённые клетки)
+	clash := func(a, b [2]int) bool {
+		as, bs := [][2]int{a}, [][2]int{b}
+		if sym {
+			as, bs = append(as, [2]int{a[1], a[0]}), append(bs, [2]int{b[1], b[0]})
+		}
+		for _, a := range as {
+			for _, b := range bs {
+				switch {
+				case a[0] == b[0] || a[1] == b[1]:
+					return true
+				case p.Constraints.Boxes && a[0]/box == b[0]/box && a[1]/box == b[1]/box:
+					return true
+				case p.Constraints.Diagonal && ((a[0] == a[1] && b[0] == b[1]) || (a[0]+a[1] == n-1 && b[0]+b[1] == n-1)):
+					return true
+				}
+			}
+		}
+		return false
+	}
+	classes := map[[2]int][][2]int{}
+	seen := map[[2]int]bool{}
+	for _, pr := range p.Equal {
+		for _, c := range pr {
+			if c = norm(c); !seen[c] {
+				seen[c] = true
+				classes[find(c)] = append(classes[find(c)], c)
+			}
+		}
+	}
+	for _, cells := range classes {
+		sort.Slice(cells, func(x, y int) bool { return cells[x][0]*n+cells[x][1] < cells[y][0]*n+cells[y][1] })
+		v := -1
+		for x, a := range cells {
+			for _, b := range cells[x+1:] {
+				if clash(a, b) {
+					return "BAD_CELL_PAIRS", fmt.Sprintf("equal pairs make (%d,%d) and (%d,%d) equal, which would repeat a symbol in a line", a[0], a[1], b[0], b[1])
+				}
+			}
+			if w := board[a[0]][a[1]]; w >= 0 {
+				if v >= 0 && w != v {
+					return "INVALID_PREFIX", fmt.Sprintf("equal pairs link (%d,%d) to a cell holding a different symbol", a[0], a[1])
+				}
+				v = w
+			}
+		}
+	}
+	return "", ""
+}
+
+func invalid(code, msg string, req InRequest, startUnix int64, startWall time.Time, host string) OutResponse {
+	return OutResponse{
+		Ok:      false,
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  "invalid_input",
+		Metrics: finishMetrics(startUnix, startWall, host),
+		Error: &OutError{
+			Code:    code,
+			Message: msg,
+		},
+	}
 }
 
 // ---------------------------
-// COMPLETE: Latin square completion
+// COUNT: exhaustive DFS over completions
+// ---------------------------
+
+func handleCount(ctx context.Context, req InRequest, deadline time.Time, startUnix int64, startWall time.Time, host string, plog *progressLog) OutResponse {
+	p, board, fixed, bad := parseComplete(req, startUnix, startWall, host)
+	if bad != nil {
+		return *bad
+	}
+
+	maxNodes := req.Budget.MaxNodes
+	if maxNodes <= 0 {
+		maxNodes = 3_000_000
+	}
+
+	if p.Engine == "dlx" {
+		return countDLX(ctx, req, p, board, deadline, maxNodes, startUnix, startWall, host, plog)
+	}
+
+	// порядок кандидатов на число решений не влияет — rng не нужен
+	solver := constrainedSolver(p, board, fixed)
+	solver.Ctx = ctx
+	solver.Deadline = deadline
+	solver.MaxNodes = maxNodes
+	solver.CountOnly = true
+	solver.Tally.Modulus = p.Modulus
+	solver.RowDense = p.CellOrder == "row_dense"
+	if p.CheckEvery > 0 {
+		solver.CheckEvery = p.CheckEvery
+	}
+	if plog != nil {
+		solver.OnTick = plog.addNodes
+	}
+
+	var notes []string
+	note, brokenRows := breakRowSymmetry(solver, p, board)
+	if note != "" {
+		notes = append(notes, note)
+	}
+
+	// вынужденные клетки одинаковы во всех решениях — счёт не меняется
+	autoFilled, consistent := solver.ArcConsistency()
+	if consistent {
+		if hall := hallNote(solver); hall != "" {
+			consistent = false
+			notes = append(notes, hall)
+		}
+	}
+	status := "no_solution"
+	var nodes int64
+	if consistent {
+		_, status, nodes = solver.Solve()
+	}
+
+	// no_solution у Solve значит «дерево обойдено целиком»
+	exact := status == "no_solution"
+	if exact {
+		status = "done"
+	}
+	debug := DebugInfo{Nodes: nodes, Prunes: solver.Prunes, AutoFilled: autoFilled, BudgetHit: budgetHit(req, status, maxNodes)}.withStats(solver.Stats)
+	switch status {
+	case "timeout":
+		notes = append(notes, fmt.Sprintf("count is a lower bound: time budget exhausted (time_limit_sec=%d)", req.Budget.TimeLimitSec))
+	case "node_limit":
+		notes = append(notes, fmt.Sprintf("count is a lower bound: node budget exhausted (max_nodes=%d)", maxNodes))
+	case "cancelled":
+		notes = append(notes, "count is a lower bound: search cancelled before completion")
+	}
+	debug.Notes = strings.Join(notes, "; ")
+
+	return OutResponse{
+		Ok:      status != "cancelled",
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  status,
+		Result:  countResult(p, solver.Tally, brokenRows, exact),
+		Debug:   debug,
+		Metrics: finishMetrics(startUnix, startWall, host),
+		Error:   nil,
+	}
+}
+
+// ---------------------------
+// MOLS: simple stochastic “best conflicts” search
 // ---------------------------
 
-func handleComplete(req InRequest, rng *rand.Rand, deadline time.Time, startUnix int64, startWall time.Time, host string) OutResponse {
-	var p PayloadComplete
+// parseMOLS decodes and validates a search_mols payload, filling in the
+// default method.
+func parseMOLS(req InRequest, startUnix int64, startWall time.Time, host string) (p PayloadMOLS, bad *OutResponse) {
+	fail := func(code, msg string) (PayloadMOLS, *OutResponse) {
+		resp := invalid(code, msg, req, startUnix, startWall, host)
+		return p, &resp
+	}
+
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return fail("BAD_PAYLOAD", err.Error())
+	}
+	if p.N <= 0 {
+		return fail("BAD_N", "n must be > 0")
+	}
+	if p.N < 3 {
+		// при n=1 и n=2 диапазон [2, n-1] пуст
+		return fail("BAD_K", fmt.Sprintf("k must be in [2, n-1], which is empty for n=%d", p.N))
+	}
+	if p.K < 2 || p.K > p.N-1 {
+		return fail("BAD_K", "k must be in [2, n-1]")
+	}
+	switch p.Method {
+	case "", "hill_climb":
+		p.Method = "hill_climb"
+	case "anneal", "galois":
+	default:
+		return fail("BAD_METHOD", fmt.Sprintf("unknown method=%q (want hill_climb|anneal|galois)", p.Method))
+	}
+	if p.SidewaysProb == nil {
+		prob := latin.DefaultSidewaysProb
+		p.SidewaysProb = &prob
+	} else if !(*p.SidewaysProb >= 0 && *p.SidewaysProb <= 1) {
+		return fail("BAD_SIDEWAYS_PROB", "sideways_prob="+strconv.FormatFloat(*p.SidewaysProb, 'g', -1, 64)+" must be in [0, 1]")
+	}
+	if len(p.SeedSquares) > p.K {
+		return fail("BAD_SEED_SQUARES", fmt.Sprintf("seed_squares has %d squares, k=%d", len(p.SeedSquares), p.K))
+	}
+	for m, sq := range p.SeedSquares {
+		if len(sq) != p.N {
+			return fail("BAD_SEED_SQUARES", fmt.Sprintf("seed_squares[%d] must be n x n", m))
+		}
+		if v := latin.FindViolation(sq); v != nil {
+			return fail("BAD_SEED_SQUARES", fmt.Sprintf("seed_squares[%d] is not a Latin square: %s at (%d,%d)", m, v.Kind, v.Row, v.Col))
+		}
+	}
+	return p, nil
+}
+
+func handleMOLS(ctx context.Context, req InRequest, rng *rand.Rand, rngKind latin.RNG, deadline time.Time, startUnix int64, startWall time.Time, host string, progress *progressFile, plog *progressLog) OutResponse {
+	p, bad := parseMOLS(req, startUnix, startWall, host)
+	if bad != nil {
+		return *bad
+	}
+	// быстрый теоретический стоп: для n=2 и n=6 нет даже ортогональной пары
+	if p.N == 2 || p.N == 6 {
+		res := ResultMOLS{N: p.N, K: p.K, Found: false, Conflicts: p.N * p.N, UniquePairs: 0, MaxFoundK: 1}
 		return OutResponse{
-			Ok:      false,
+			Ok:      true,
 			Problem: req.Problem,
 			TaskID:  req.TaskID,
-			Status:  "invalid_input",
+			Status:  "no_solution",
+			Result:  res,
+			Debug:   DebugInfo{Notes: fmt.Sprintf("No orthogonal pair exists for n=2 or n=6 (k=%d).", p.K)},
 			Metrics: finishMetrics(startUnix, startWall, host),
-			Error: &OutError{
-				Code:    "BAD_PAYLOAD",
-				Message: err.Error(),
-			},
 		}
 	}
 
-	// validate basic
+	n := p.N
+	k := p.K
+
+	maxSteps := req.Budget.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = 2_000_000
+	}
+
+	opt := latin.MOLSOptions{
+		Ctx:          ctx,
+		Rng:          rng,
+		Deadline:     deadline,
+		MaxSteps:     maxSteps,
+		Method:       p.Method,
+		StallSteps:   p.StallSteps,
+		SidewaysProb: *p.SidewaysProb,
+		Seed:         p.SeedSquares,
+	}
+	// шаги прошлых попыток — чтобы лог прогресса шёл сквозным счётом
+	var stepsBefore int64
+	if plog != nil {
+		opt.OnTick = func(steps int64, bestConf int) { plog.molsTick(stepsBefore+steps, bestConf) }
+	}
+	if progress != nil {
+		totalPairs := k * (k - 1) / 2 * n * n
+		opt.ProgressEvery = progress.every
+		opt.OnProgress = func(best [][][]int, conf int) {
+			progress.write(-conf, OutResponse{
+				Problem: req.Problem,
+				TaskID:  req.TaskID,
+				Status:  "running",
+				Result:  ResultMOLS{N: n, K: k, Conflicts: conf, UniquePairs: totalPairs - conf, L: best},
+				Metrics: finishMetrics(startUnix, startWall, host),
+			})
+		}
+	}
+	// попытки: 0 — с seed запроса, дальше с seed+attempt; лучший набор — общий
+	var sr latin.MOLSResult
+	var subset [][][]int // наибольший ортогональный поднабор по всем попыткам
+	attempts, bestAttempt := 0, 0
+	var lastSteps int64
+	for attempt := 0; attempt <= max(req.Budget.MaxRestarts, 0); attempt++ {
+		if attempt > 0 {
+			opt.Rng = latin.NewRand(rngKind, req.Seed+int64(attempt))
+		}
+		r := latin.SearchMOLS(n, k, opt)
+		attempts++
+		lastSteps = r.Steps
+		stepsBefore += r.Steps
+		if attempt == 0 || r.Conflicts < sr.Conflicts {
+			sr, bestAttempt = r, attempt
+		}
+		if len(r.Subset) > len(subset) {
+			subset = r.Subset
+		}
+		if sr.Conflicts == 0 || ctx.Err() != nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	best, bestConf, bestUnique, steps := sr.Squares, sr.Conflicts, sr.UniquePairs, stepsBefore
+	notes := sr.Notes
+	if attempts > 1 {
+		notes = append(notes, fmt.Sprintf("best set from attempt %d of %d (seed+%d)", bestAttempt+1, attempts, bestAttempt))
+	}
+
+	found := (bestConf == 0)
+	// независимая проверка: не доверяем счётчику конфликтов локального поиска
+	verified, verifyMsg := latin.VerifyMOLS(best)
+	res := ResultMOLS{
+		N:           n,
+		K:           k,
+		Found:       found,
+		Conflicts:   bestConf,
+		UniquePairs: bestUnique,
+		Verified:    verified,
+		MaxFoundK:   len(subset),
+	}
+	if found {
+		res.MaxFoundK = k
+	}
+	if !found && len(subset) >= 2 {
+		if ok, msg := latin.VerifyMOLS(subset); !ok {
+			notes = append(notes, "partial set verify failed: "+msg)
+		}
+		if req.Output.ReturnSquares {
+			res.PartialL = subset
+		} else {
+			res.PartialHash = make([]string, len(subset))
+			for m := range subset {
+				res.PartialHash[m] = latin.HashSquare(subset[m])
+			}
+		}
+	}
+
+	if k > 2 {
+		res.ConflictMatrix = latin.ConflictMatrix(best)
+	}
+
+	if req.Output.ReturnConflictCells && !found {
+		res.ConflictCells = latin.SetConflictCells(best)
+	}
+
+	if req.Output.ReturnOA && found {
+		res.OA = latin.OrthogonalArray(best)
+	}
+
+	if req.Output.ReturnSquares {
+		res.L = best
+		res.Overlay = latin.Overlay(best[0], best[1])
+	} else {
+		res.BestHash = make([]string, k)
+		res.CanonicalHash = make([]string, k)
+		for m := range best {
+			res.BestHash[m] = latin.HashSquare(best[m])
+			res.CanonicalHash[m] = latin.CanonicalHash(best[m])
+		}
+	}
+
+	debug := DebugInfo{Attempts: attempts, Steps: steps, BestScore: bestConf, Restarts: sr.Restarts, BestRestart: sr.BestRestart}
+	if p.Method != "anneal" {
+		// galois без степени простого тоже уходит в hill_climb
+		debug.SidewaysProb = p.SidewaysProb
+	}
+	if p.Method == "anneal" {
+		debug.Temperature = sr.Temperature
+		if sr.Steps > 0 {
+			debug.AcceptRate = float64(sr.Accepted) / float64(sr.Steps)
+		}
+	}
+	if k > 2 && !found {
+		// при found все пары нулевые — не раздуваем вывод
+		notes = append(notes, latin.PairConflictsNote(sr.PairConf))
+	}
+	if found && !verified {
+		notes = append(notes, "verify failed: "+verifyMsg)
+	}
+	debug.Notes = strings.Join(notes, "; ")
+
+	status := "done"
+	if !found && ctx.Err() != nil {
+		status = "cancelled"
+	} else if !found && time.Now().After(deadline) {
+		status = "timeout"
+	}
+	debug.BudgetHit = budgetHit(req, status, 0)
+	if !found && lastSteps >= maxSteps {
+		debug.BudgetHit = &BudgetHit{Kind: "steps", Limit: maxSteps}
+	}
+
+	return OutResponse{
+		Ok:      status != "cancelled", // даже если не нашли — попытка валидная
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  status,
+		Result:  res,
+		Debug:   debug,
+		Metrics: finishMetrics(startUnix, startWall, host),
+	}
+}
+
+// capResult enforces output.max_result_bytes. If the result's JSON is
+// longer than limit, its array fields are dropped, longest first, until it
+// fits; each leaves <field>_sha256, the SHA-256 of its compact JSON, and the
+// result gets truncated: true. Scalars always stay, so a result may end up
+// over the limit with no arrays left. The status is untouched: a truncated
+// square is still solved.
+func capResult(resp *OutResponse, limit int) {
+	if resp.Result == nil {
+		return
+	}
+	b, err := json.Marshal(resp.Result)
+	if err != nil || len(b) <= limit {
+		return
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(b, &fields) != nil {
+		return // не объект — резать нечего
+	}
+	var arrays []string
+	for k, v := range fields {
+		if len(v) > 0 && v[0] == '[' {
+			arrays = append(arrays, k)
+		}
+	}
+	sort.Slice(arrays, func(a, c int) bool {
+		la, lc := len(fields[arrays[a]]), len(fields[arrays[c]])
+		if la != lc {
+			return la > lc
+		}
+		return arrays[a] < arrays[c]
+	})
+	fields["truncated"] = json.RawMessage("true")
+	var dropped []string
+	for _, k := range arrays {
+		if len(b) <= limit {
+			break
+		}
+		sum := sha256.Sum256(fields[k])
+		delete(fields, k)
+		fields[k+"_sha256"] = json.RawMessage(`"` + hex.EncodeToString(sum[:]) + `"`)
+		dropped = append(dropped, k)
+		b, _ = json.Marshal(fields)
+	}
+	resp.Result = fields
+	if d, ok := resp.Debug.(DebugInfo); ok {
+		note := fmt.Sprintf("result over max_result_bytes=%d, dropped %s", limit, strings.Join(dropped, ", "))
+		if d.Notes != "" {
+			note = d.Notes + "; " + note
+		}
+		d.Notes = note
+		resp.Debug = d
+	}
+}
+
+// ---------------------------
+// VERIFY: проверка готового квадрата без решения
+// ---------------------------
+
+// parseMate decodes and validates a find_orthogonal_mate payload.
+func parseMate(req InRequest, startUnix int64, startWall time.Time, host string) (p PayloadMate, bad *OutResponse) {
+	fail := func(code, msg string) (PayloadMate, *OutResponse) {
+		resp := invalid(code, msg, req, startUnix, startWall, host)
+		return p, &resp
+	}
+
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return fail("BAD_PAYLOAD", err.Error())
+	}
 	if p.N <= 0 {
-		return invalid("BAD_N", "n must be > 0", req, startUnix, startWall, host)
+		return fail("BAD_N", "n must be > 0")
 	}
-	if len(p.Prefix) != p.N {
-		return invalid("BAD_PREFIX_SHAPE", "prefix must be n x n", req, startUnix, startWall, host)
+	if len(p.Base) != p.N {
+		return fail("BAD_BASE_SHAPE", "base must be n x n")
 	}
-	for i := range p.Prefix {
-		if len(p.Prefix[i]) != p.N {
-			return invalid("BAD_PREFIX_SHAPE", "prefix must be n x n", req, startUnix, startWall, host)
+	if v := latin.FindViolation(p.Base); v != nil {
+		return fail("INVALID_BASE", fmt.Sprintf("base is not a Latin square: %s at (%d,%d)", v.Kind, v.Row, v.Col))
+	}
+	return p, nil
+}
+
+func handleMate(ctx context.Context, req InRequest, rng *rand.Rand, deadline time.Time, startUnix int64, startWall time.Time, host string) OutResponse {
+	p, bad := parseMate(req, startUnix, startWall, host)
+	if bad != nil {
+		return *bad
+	}
+
+	maxNodes := req.Budget.MaxNodes
+	if maxNodes <= 0 {
+		maxNodes = 3_000_000
+	}
+	mr := latin.FindOrthogonalMate(p.Base, latin.MateOptions{
+		Ctx:      ctx,
+		Rng:      rng,
+		Deadline: deadline,
+		MaxNodes: maxNodes,
+	})
+
+	res := ResultMate{N: p.N, Found: mr.Mate != nil, Mate: mr.Mate, Transversals: mr.Transversals}
+	if res.Found {
+		// независимая проверка, как в search_mols
+		res.Verified, _ = latin.VerifyMOLS([][][]int{p.Base, mr.Mate})
+	}
+	var notes []string
+	switch mr.Status {
+	case "no_solution":
+		if mr.Transversals < p.N {
+			notes = append(notes, fmt.Sprintf("base has %d transversals, a mate needs %d disjoint ones", mr.Transversals, p.N))
 		}
+	case "timeout":
+		notes = append(notes, fmt.Sprintf("time budget exhausted (time_limit_sec=%d)", req.Budget.TimeLimitSec))
+	case "node_limit":
+		notes = append(notes, fmt.Sprintf("node budget exhausted (max_nodes=%d)", maxNodes))
+	case "cancelled":
+		notes = append(notes, "search cancelled before completion")
 	}
 
-	// build board
-	n := p.N
-	board := make([][]int, n)
-	fixed := make([][]bool, n)
-	for i := 0; i < n; i++ {
-		board[i] = make([]int, n)
-		fixed[i] = make([]bool, n)
-		for j := 0; j < n; j++ {
-			if p.Prefix[i][j] == nil {
-				board[i][j] = -1
-			} else {
-				v := *p.Prefix[i][j]
-				if v < 0 || v >= n {
-					return invalid("BAD_VALUE", fmt.Sprintf("value out of range at (%d,%d)", i, j), req, startUnix, startWall, host)
-				}
-				board[i][j] = v
-				fixed[i][j] = true
+	return OutResponse{
+		Ok:      res.Found || mr.Status == "timeout" || mr.Status == "node_limit",
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  mr.Status,
+		Result:  res,
+		Debug:   DebugInfo{Nodes: mr.Nodes, Notes: strings.Join(notes, "; "), BudgetHit: budgetHit(req, mr.Status, maxNodes)},
+		Metrics: finishMetrics(startUnix, startWall, host),
+	}
+}
+
+// parseRandom decodes and validates a random_latin_square payload, filling
+// in the default method.
+func parseRandom(req InRequest, startUnix int64, startWall time.Time, host string) (p PayloadRandom, bad *OutResponse) {
+	fail := func(code, msg string) (PayloadRandom, *OutResponse) {
+		resp := invalid(code, msg, req, startUnix, startWall, host)
+		return p, &resp
+	}
+
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return fail("BAD_PAYLOAD", err.Error())
+	}
+	if p.N <= 0 {
+		return fail("BAD_N", "n must be > 0")
+	}
+	switch p.Method {
+	case "", "cyclic_permute":
+		p.Method = "cyclic_permute"
+	case "mcmc":
+		if p.N > latin.MaxMCMCN {
+			return fail("N_TOO_LARGE", fmt.Sprintf("method=mcmc supports n <= %d, got n=%d", latin.MaxMCMCN, p.N))
+		}
+	default:
+		return fail("BAD_METHOD", fmt.Sprintf("unknown method=%q (want cyclic_permute|mcmc)", p.Method))
+	}
+	return p, nil
+}
+
+func handleRandom(ctx context.Context, req InRequest, rng *rand.Rand, deadline time.Time, startUnix int64, startWall time.Time, host string) OutResponse {
+	p, bad := parseRandom(req, startUnix, startWall, host)
+	if bad != nil {
+		return *bad
+	}
+
+	// старт у обоих методов — случайный изотоп циклического квадрата
+	sq := latin.MakeCyclic(p.N, 1)
+	latin.RandomPermute(sq, rng)
+	res := ResultRandom{N: p.N, Method: p.Method}
+	status := "done"
+	var notes []string
+	if p.Method == "mcmc" {
+		steps := p.Steps
+		if steps <= 0 {
+			steps = int64(p.N) * int64(p.N) * int64(p.N)
+		}
+		res.Steps = latin.JacobsonMatthews(sq, rng, steps, func() bool {
+			return ctx.Err() != nil || time.Now().After(deadline)
+		})
+		if res.Steps < steps {
+			// квадрат валиден, но цепь могла не успеть перемешаться
+			status = "timeout"
+			if ctx.Err() != nil {
+				status = "cancelled"
 			}
+			notes = append(notes, fmt.Sprintf("mcmc stopped after %d of %d steps", res.Steps, steps))
+		}
+	}
+	res.Square = sq
+	res.VerifiedLatin = latin.IsLatinSquare(sq)
+
+	return OutResponse{
+		Ok:      status != "cancelled",
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  status,
+		Result:  res,
+		Debug:   DebugInfo{Notes: strings.Join(notes, "; "), BudgetHit: budgetHit(req, status, 0)},
+		Metrics: finishMetrics(startUnix, startWall, host),
+	}
+}
+
+// parseVerify decodes and validates a verify_latin_square payload.
+func parseVerify(req InRequest, startUnix int64, startWall time.Time, host string) (p PayloadVerify, bad *OutResponse) {
+	fail := func(code, msg string) (PayloadVerify, *OutResponse) {
+		resp := invalid(code, msg, req, startUnix, startWall, host)
+		return p, &resp
+	}
+
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return fail("BAD_PAYLOAD", err.Error())
+	}
+	if len(p.Square) == 0 {
+		return fail("BAD_SQUARE", "square must be non-empty")
+	}
+	return p, nil
+}
+
+func handleVerify(req InRequest, startUnix int64, startWall time.Time, host string) OutResponse {
+	p, bad := parseVerify(req, startUnix, startWall, host)
+	if bad != nil {
+		return *bad
+	}
+
+	v := latin.FindViolation(p.Square)
+	res := ResultVerify{
+		N:         len(p.Square),
+		IsLatin:   v == nil,
+		Violation: v,
+	}
+	return OutResponse{
+		Ok:      true,
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  "done",
+		Result:  res,
+		Metrics: finishMetrics(startUnix, startWall, host),
+	}
+}
+
+// parseOrthogonal decodes and validates a check_orthogonal payload: a and b
+// must be Latin squares of the same order.
+func parseOrthogonal(req InRequest, startUnix int64, startWall time.Time, host string) (p PayloadOrthogonal, bad *OutResponse) {
+	fail := func(code, msg string) (PayloadOrthogonal, *OutResponse) {
+		resp := invalid(code, msg, req, startUnix, startWall, host)
+		return p, &resp
+	}
+
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return fail("BAD_PAYLOAD", err.Error())
+	}
+	if len(p.A) == 0 || len(p.B) == 0 {
+		return fail("BAD_SQUARE", "a and b must be non-empty")
+	}
+	if len(p.A) != len(p.B) {
+		return fail("SIZE_MISMATCH", fmt.Sprintf("a is %dx%d, b is %dx%d", len(p.A), len(p.A), len(p.B), len(p.B)))
+	}
+	for k, sq := range [][][]int{p.A, p.B} {
+		if v := latin.FindViolation(sq); v != nil {
+			return fail("INVALID_SQUARE", fmt.Sprintf("%s is not a Latin square: %s at (%d,%d)", []string{"a", "b"}[k], v.Kind, v.Row, v.Col))
 		}
 	}
+	return p, nil
+}
+
+func handleOrthogonal(req InRequest, startUnix int64, startWall time.Time, host string) OutResponse {
+	p, bad := parseOrthogonal(req, startUnix, startWall, host)
+	if bad != nil {
+		return *bad
+	}
+
+	conf, uniq := latin.OrthConflicts(p.A, p.B)
+	return OutResponse{
+		Ok:      true,
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  "done",
+		Result:  ResultOrthogonal{N: len(p.A), Orthogonal: conf == 0, Conflicts: conf, UniquePairs: uniq},
+		Metrics: finishMetrics(startUnix, startWall, host),
+	}
+}
+
+// ---------------------------
+// RECTANGLE: Latin rectangle extension
+// ---------------------------
+
+// parseRectangle decodes and validates an extend_latin_rectangle payload.
+func parseRectangle(req InRequest, startUnix int64, startWall time.Time, host string) (p PayloadRectangle, bad *OutResponse) {
+	fail := func(code, msg string) (PayloadRectangle, *OutResponse) {
+		resp := invalid(code, msg, req, startUnix, startWall, host)
+		return p, &resp
+	}
 
-	if p.Constraints.SymmetryBreaking.FixFirstRow {
-		// first row must be full permutation 0..n-1
-		seen := make([]bool, n)
-		for j := 0; j < n; j++ {
-			if board[0][j] < 0 {
-				return invalid("FIX_FIRST_ROW", "first row must be fully specified when fix_first_row=true", req, startUnix, startWall, host)
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return fail("BAD_PAYLOAD", err.Error())
+	}
+	if p.N <= 0 {
+		return fail("BAD_N", "n must be > 0")
+	}
+	if len(p.Rectangle) == 0 || len(p.Rectangle) > p.N {
+		return fail("BAD_RECTANGLE", fmt.Sprintf("rectangle must have 1..n rows, got %d", len(p.Rectangle)))
+	}
+	colSeen := make([][]bool, p.N)
+	for j := range colSeen {
+		colSeen[j] = make([]bool, p.N)
+	}
+	for i, row := range p.Rectangle {
+		if len(row) != p.N {
+			return fail("BAD_RECTANGLE", fmt.Sprintf("row %d has %d entries, want n=%d", i, len(row), p.N))
+		}
+		rowSeen := make([]bool, p.N)
+		for j, v := range row {
+			if v < 0 || v >= p.N {
+				return fail("BAD_RECTANGLE", fmt.Sprintf("value %d at (%d,%d) is out of range 0..%d", v, i, j, p.N-1))
 			}
-			v := board[0][j]
-			if seen[v] {
-				return invalid("FIX_FIRST_ROW", "first row must be a permutation (no duplicates)", req, startUnix, startWall, host)
+			if rowSeen[v] {
+				return fail("INVALID_RECTANGLE", fmt.Sprintf("value %d repeats in row %d at (%d,%d)", v, i, i, j))
 			}
-			seen[v] = true
+			if colSeen[j][v] {
+				return fail("INVALID_RECTANGLE", fmt.Sprintf("value %d repeats in column %d at (%d,%d)", v, j, i, j))
+			}
+			rowSeen[v], colSeen[j][v] = true, true
 		}
 	}
+	return p, nil
+}
 
-	// check prefix consistency (no duplicates in row/col)
-	if err := validatePartialLatin(board); err != nil {
-		return OutResponse{
-			Ok:      false,
-			Problem: req.Problem,
-			TaskID:  req.TaskID,
-			Status:  "invalid_input",
-			Metrics: finishMetrics(startUnix, startWall, host),
-			Error: &OutError{
-				Code:    "INVALID_PREFIX",
-				Message: err.Error(),
-			},
-		}
+// handleRectangle extends a Latin rectangle to a square: the DFS solver runs
+// with the given rows fixed. An extension always exists, so the only ways to
+// fail are the budgets.
+func handleRectangle(ctx context.Context, req InRequest, rng *rand.Rand, deadline time.Time, startUnix int64, startWall time.Time, host string) OutResponse {
+	p, bad := parseRectangle(req, startUnix, startWall, host)
+	if bad != nil {
+		return *bad
 	}
+	n, r := p.N, len(p.Rectangle)
 
 	maxNodes := req.Budget.MaxNodes
 	if maxNodes <= 0 {
 		maxNodes = 3_000_000
 	}
+	board := make([][]int, n)
+	fixed := make([][]bool, n)
+	for i := range board {
+		board[i] = make([]int, n)
+		fixed[i] = make([]bool, n)
+		for j := range board[i] {
+			board[i][j] = -1
+			if i < r {
+				board[i][j], fixed[i][j] = p.Rectangle[i][j], true
+			}
+		}
+	}
 
-	solver := newLSSolver(board, fixed)
-	solver.rng = rng
-	solver.deadline = deadline
-	solver.maxNodes = maxNodes
-
-	ok, status, nodes := solver.solve()
-	res := ResultComplete{
-		N:            n,
-		SolutionFound: ok,
-		Square:       nil,
-		VerifiedLatin: false,
+	solver := latin.NewSolver(board, fixed)
+	solver.Ctx = ctx
+	solver.Rng = rng
+	solver.Deadline = deadline
+	solver.MaxNodes = maxNodes
+	autoFilled, _ := solver.ArcConsistency()
+	ok, status, nodes := solver.Solve()
+
+	res := ResultComplete{N: n, SolutionFound: ok}
+	var notes []string
+	if r == n {
+		notes = append(notes, "rectangle is already a square")
 	}
 	if ok {
-		res.Square = solver.board
-		res.VerifiedLatin = isLatinSquare(solver.board)
+		res.Square = solver.Solutions[0]
+		res.VerifiedLatin = latin.IsLatinSquare(res.Square)
+	}
+	switch status {
+	case "timeout":
+		notes = append(notes, fmt.Sprintf("time budget exhausted (time_limit_sec=%d)", req.Budget.TimeLimitSec))
+	case "node_limit":
+		notes = append(notes, fmt.Sprintf("node budget exhausted (max_nodes=%d)", maxNodes))
+	case "cancelled":
+		notes = append(notes, "search cancelled before completion")
 	}
-
-	debug := DebugInfo{Nodes: nodes}
 
 	return OutResponse{
-		Ok:      ok || status == "timeout", // timeout тоже “валидный” результат попытки
+		Ok:      ok || status == "timeout" || status == "node_limit",
 		Problem: req.Problem,
 		TaskID:  req.TaskID,
 		Status:  status,
 		Result:  res,
-		Debug:   debug,
+		Debug:   DebugInfo{Nodes: nodes, Prunes: solver.Prunes, AutoFilled: autoFilled, Notes: strings.Join(notes, "; "), BudgetHit: budgetHit(req, status, maxNodes)}.withStats(solver.Stats),
 		Metrics: finishMetrics(startUnix, startWall, host),
-		Error:   nil,
 	}
 }
 
-func invalid(code, msg string, req InRequest, startUnix int64, startWall time.Time, host string) OutResponse {
-	return OutResponse{
-		Ok:      false,
-		Problem: req.Problem,
-		TaskID:  req.TaskID,
-		Status:  "invalid_input",
-		Metrics: finishMetrics(startUnix, startWall, host),
-		Error: &OutError{
-			Code:    code,
-			Message: msg,
-		},
+// ---------------------------
+// MIN COST: cheapest completion by branch-and-bound
+// ---------------------------
+
+// parseMinCost decodes a min_cost_completion payload: the completion fields
+// through parseComplete, then the n x n x n cost tensor.
+func parseMinCost(req InRequest, startUnix int64, startWall time.Time, host string) (p PayloadComplete, cost [][][]float64, board [][]int, fixed [][]bool, bad *OutResponse) {
+	fail := func(code, msg string) (PayloadComplete, [][][]float64, [][]int, [][]bool, *OutResponse) {
+		resp := invalid(code, msg, req, startUnix, startWall, host)
+		return p, nil, nil, nil, &resp
 	}
-}
 
-func validatePartialLatin(board [][]int) error {
-	n := len(board)
-	// rows
-	for i := 0; i < n; i++ {
-		seen := make([]bool, n)
-		for j := 0; j < n; j++ {
-			v := board[i][j]
-			if v < 0 {
-				continue
-			}
-			if seen[v] {
-				return fmt.Errorf("duplicate value %d in row %d", v, i)
-			}
-			seen[v] = true
-		}
+	p, board, fixed, bad = parseComplete(req, startUnix, startWall, host)
+	if bad != nil {
+		return p, nil, nil, nil, bad
 	}
-	// cols
-	for j := 0; j < n; j++ {
-		seen := make([]bool, n)
-		for i := 0; i < n; i++ {
-			v := board[i][j]
-			if v < 0 {
-				continue
-			}
-			if seen[v] {
-				return fmt.Errorf("duplicate value %d in col %d", v, j)
+	if p.Engine != "dfs" {
+		return fail("BAD_ENGINE", "min_cost_completion needs engine=dfs")
+	}
+	if req.Output.UniformRandom {
+		return fail("BAD_OUTPUT", "min_cost_completion does not support output.uniform_random")
+	}
+	var pc PayloadMinCost
+	if err := json.Unmarshal(req.Payload, &pc); err != nil {
+		return fail("BAD_PAYLOAD", err.Error())
+	}
+	n := p.N
+	if len(pc.Cost) != n {
+		return fail("BAD_COST", "cost must be n x n x n")
+	}
+	for i := range pc.Cost {
+		if len(pc.Cost[i]) != n {
+			return fail("BAD_COST", "cost must be n x n x n")
+		}
+		for j := range pc.Cost[i] {
+			if len(pc.Cost[i][j]) != n {
+				return fail("BAD_COST", fmt.Sprintf("cost at (%d,%d) has %d entries, want n=%d", i, j, len(pc.Cost[i][j]), n))
 			}
-			seen[v] = true
 		}
 	}
-	return nil
+	return p, pc.Cost, board, fixed, nil
 }
 
-func isLatinSquare(board [][]int) bool {
-	n := len(board)
-	for i := 0; i < n; i++ {
-		seen := make([]bool, n)
-		for j := 0; j < n; j++ {
-			v := board[i][j]
-			if v < 0 || v >= n || seen[v] {
-				return false
-			}
-			seen[v] = true
-		}
+// handleMinCost finds the completion with the least total cost: the DFS
+// solver walks the whole tree, cheapest values first, and drops a branch
+// once its filled cells plus the cheapest candidate of every empty cell
+// cost no less than the best completion so far. If a budget cuts the walk
+// short, the best completion found is returned with optimal=false.
+func handleMinCost(ctx context.Context, req InRequest, rng *rand.Rand, rngKind latin.RNG, deadline time.Time, startUnix int64, startWall time.Time, host string, plog *progressLog) OutResponse {
+	p, cost, board, fixed, bad := parseMinCost(req, startUnix, startWall, host)
+	if bad != nil {
+		return *bad
 	}
-	for j := 0; j < n; j++ {
-		seen := make([]bool, n)
-		for i := 0; i < n; i++ {
-			v := board[i][j]
-			if v < 0 || v >= n || seen[v] {
-				return false
-			}
-			seen[v] = true
-		}
+	if p.SearchSeed != nil {
+		rng = latin.NewRand(rngKind, *p.SearchSeed)
 	}
-	return true
-}
 
-type lsSolver struct {
-	board    [][]int
-	fixed    [][]bool
-	n        int
-	rowMask  []uint64
-	colMask  []uint64
-	deadline time.Time
-	maxNodes int64
-	nodes    int64
-	rng      *rand.Rand
-}
+	maxNodes := req.Budget.MaxNodes
+	if maxNodes <= 0 {
+		maxNodes = 3_000_000
+	}
 
-func newLSSolver(board [][]int, fixed [][]bool) *lsSolver {
-	n := len(board)
-	s := &lsSolver{
-		n:       n,
-		board:   deepCopy(board),
-		fixed:   fixed,
-		rowMask: make([]uint64, n),
-		colMask: make([]uint64, n),
+	solver := constrainedSolver(p, board, fixed)
+	solver.Ctx = ctx
+	solver.Rng = rng
+	solver.Deadline = deadline
+	solver.MaxNodes = maxNodes
+	solver.Cost = cost
+	solver.RowDense = p.CellOrder == "row_dense"
+	solver.Trace = req.Output.ReturnTrace
+	if p.CheckEvery > 0 {
+		solver.CheckEvery = p.CheckEvery
 	}
-	for i := 0; i < n; i++ {
-		for j := 0; j < n; j++ {
-			v := s.board[i][j]
-			if v >= 0 {
-				s.rowMask[i] |= (1 << uint(v))
-				s.colMask[j] |= (1 << uint(v))
-			}
-		}
+	if plog != nil {
+		solver.OnTick = plog.addNodes
 	}
-	return s
-}
 
-func deepCopy(a [][]int) [][]int {
-	n := len(a)
-	out := make([][]int, n)
-	for i := 0; i < n; i++ {
-		out[i] = make([]int, len(a[i]))
-		copy(out[i], a[i])
+	var notes []string
+	if p.Parallel {
+		notes = append(notes, "parallel ignored: branch-and-bound shares one bound")
+	}
+	if p.ValueOrder == "lcv" {
+		notes = append(notes, "value_order ignored: values are tried cheapest first")
+	}
+	// breakRowSymmetry не вызываем: переставленные строки стоят по-разному,
+	// и отброшенный представитель мог оказаться самым дешёвым
+
+	// вынужденные клетки одинаковы во всех дополнениях — на минимум не влияют
+	autoFilled, consistent := solver.ArcConsistency()
+	var hall string
+	if consistent {
+		hall = hallNote(solver)
+		consistent = hall == ""
+	}
+	var ok bool
+	status := "no_solution"
+	var nodes int64
+	if consistent {
+		ok, status, nodes = solver.Solve()
 	}
-	return out
-}
 
-func (s *lsSolver) solve() (bool, string, int64) {
-	ok := s.dfs()
+	res := ResultMinCost{N: p.N, SolutionFound: ok}
 	if ok {
-		return true, "done", s.nodes
+		best := solver.BestCost
+		res.Square = solver.Solutions[0]
+		res.VerifiedLatin = verifyComplete(p, res.Square)
+		res.Cost = &best
+		res.Optimal = solver.Exhausted()
+		if req.Output.ReturnTrace {
+			res.Trace = tracePath(p, solver.Path)
+		}
+		if p.Symbols != nil {
+			res.Square = latin.Relabel(res.Square, p.Symbols)
+		}
+		if !res.Optimal {
+			notes = append(notes, fmt.Sprintf("cost is an upper bound: best of %d completions found before the budget ran out", solver.Found))
+		}
 	}
-	// если остановились по времени/лимиту
-	if time.Now().After(s.deadline) || (s.maxNodes > 0 && s.nodes >= s.maxNodes) {
-		return false, "timeout", s.nodes
+	switch {
+	case hall != "":
+		notes = append(notes, hall)
+	case !consistent:
+		notes = append(notes, "prefix has no completion (found by arc consistency before search)")
+	case status == "timeout":
+		notes = append(notes, fmt.Sprintf("time budget exhausted (time_limit_sec=%d)", req.Budget.TimeLimitSec))
+	case status == "node_limit":
+		notes = append(notes, fmt.Sprintf("node budget exhausted (max_nodes=%d)", maxNodes))
+	case status == "cancelled":
+		notes = append(notes, "search cancelled before completion")
 	}
-	return false, "no_solution", s.nodes
-}
 
-func (s *lsSolver) dfs() bool {
-	if time.Now().After(s.deadline) {
-		return false
-	}
-	if s.maxNodes > 0 && s.nodes >= s.maxNodes {
-		return false
+	return OutResponse{
+		Ok:      ok || status == "timeout" || status == "node_limit",
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  status,
+		Result:  res,
+		Debug:   DebugInfo{Nodes: nodes, Prunes: solver.Prunes, AutoFilled: autoFilled, Notes: strings.Join(notes, "; "), BudgetHit: budgetHit(req, status, maxNodes)}.withStats(solver.Stats),
+		Metrics: finishMetrics(startUnix, startWall, host),
 	}
+}
 
-	// find next cell with MRV (min candidates)
-	iBest, jBest := -1, -1
-	var candBest []int
-	bestLen := math.MaxInt32
+// ---------------------------
+// BENCHMARK: throughput for machine calibration
+// ---------------------------
 
-	for i := 0; i < s.n; i++ {
-		for j := 0; j < s.n; j++ {
-			if s.board[i][j] != -1 {
-				continue
-			}
-			cands := s.candidates(i, j)
-			if len(cands) == 0 {
-				return false
-			}
-			if len(cands) < bestLen {
-				bestLen = len(cands)
-				iBest, jBest = i, j
-				candBest = cands
-				if bestLen == 1 {
-					break
-				}
-			}
-		}
+// parseBenchmark decodes and validates a benchmark payload.
+func parseBenchmark(req InRequest, startUnix int64, startWall time.Time, host string) (p PayloadBenchmark, bad *OutResponse) {
+	fail := func(code, msg string) (PayloadBenchmark, *OutResponse) {
+		resp := invalid(code, msg, req, startUnix, startWall, host)
+		return p, &resp
 	}
 
-	if iBest == -1 {
-		// filled
-		return true
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return fail("BAD_PAYLOAD", err.Error())
+	}
+	if p.N <= 0 {
+		return fail("BAD_N", "n must be > 0")
 	}
+	if !(p.WindowSec > 0) || math.IsInf(p.WindowSec, 0) {
+		return fail("BAD_WINDOW", "window_sec must be a positive number of seconds")
+	}
+	return p, nil
+}
 
-	// randomize candidate order using seed
-	s.shuffleInts(candBest)
+// handleBenchmark completes empty n x n boards by randomized DFS, one seed
+// per operation (seed, seed+1, ...), until the window closes, and reports
+// how much got done. No square is returned.
+func handleBenchmark(ctx context.Context, req InRequest, rngKind latin.RNG, deadline time.Time, startUnix int64, startWall time.Time, host string) OutResponse {
+	p, bad := parseBenchmark(req, startUnix, startWall, host)
+	if bad != nil {
+		return *bad
+	}
 
-	for _, v := range candBest {
-		s.nodes++
-		s.place(iBest, jBest, v)
-		if s.dfs() {
-			return true
+	start := time.Now()
+	end := start.Add(time.Duration(p.WindowSec * float64(time.Second)))
+	var notes []string
+	if end.After(deadline) {
+		end = deadline
+		notes = append(notes, fmt.Sprintf("window cut to time_limit_sec=%d", req.Budget.TimeLimitSec))
+	}
+	empty := make([][]int, p.N)
+	for i := range empty {
+		empty[i] = make([]int, p.N)
+		for j := range empty[i] {
+			empty[i][j] = -1
 		}
-		s.unplace(iBest, jBest, v)
 	}
-	return false
-}
 
-func (s *lsSolver) candidates(i, j int) []int {
-	used := s.rowMask[i] | s.colMask[j]
-	cands := make([]int, 0, s.n)
-	for v := 0; v < s.n; v++ {
-		if (used & (1 << uint(v))) == 0 {
-			cands = append(cands, v)
+	var ops, nodes int64
+	for ctx.Err() == nil && time.Now().Before(end) {
+		solver := latin.NewSolver(empty, nil)
+		solver.Ctx = ctx
+		solver.Rng = latin.NewRand(rngKind, req.Seed+ops)
+		solver.Deadline = end
+		solver.CheckEvery = 256 // на больших n узел дорогой — окно не должно переезжать
+		ok, _, k := solver.Solve()
+		nodes += k
+		if ok {
+			ops++
 		}
 	}
-	return cands
-}
-
-func (s *lsSolver) place(i, j, v int) {
-	s.board[i][j] = v
-	s.rowMask[i] |= (1 << uint(v))
-	s.colMask[j] |= (1 << uint(v))
-}
+	window := time.Since(start)
 
-func (s *lsSolver) unplace(i, j, v int) {
-	s.board[i][j] = -1
-	s.rowMask[i] &^= (1 << uint(v))
-	s.colMask[j] &^= (1 << uint(v))
-}
-
-func (s *lsSolver) shuffleInts(a []int) {
-	if s.rng == nil {
-		return
+	status := "done"
+	if ctx.Err() != nil {
+		status = "cancelled"
 	}
-	for i := len(a) - 1; i > 0; i-- {
-		j := s.rng.Intn(i + 1)
-		a[i], a[j] = a[j], a[i]
+	res := ResultBenchmark{N: p.N, WindowMS: window.Milliseconds(), Ops: ops, Nodes: nodes}
+	if window > 0 {
+		res.NodesPerSec = int64(float64(nodes) / window.Seconds())
+	}
+	return OutResponse{
+		Ok:      status == "done",
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  status,
+		Result:  res,
+		Debug:   DebugInfo{Nodes: nodes, Notes: strings.Join(notes, "; ")},
+		Metrics: finishMetrics(startUnix, startWall, host),
 	}
 }
 
 // ---------------------------
-// MOLS: simple stochastic “best conflicts” search
+// MOLS SEED SCAN: steps-to-solve distribution over a seed range
 // ---------------------------
 
-func handleMOLS(req InRequest, rng *rand.Rand, deadline time.Time, startUnix int64, startWall time.Time, host string) OutResponse {
-	var p PayloadMOLS
+// maxScanSeeds ограничивает диапазон seed одного mols_seed_scan
+const maxScanSeeds = 10_000
+
+// parseSeedScan decodes and validates a mols_seed_scan payload, filling in
+// the defaults.
+func parseSeedScan(req InRequest, startUnix int64, startWall time.Time, host string) (p PayloadSeedScan, bad *OutResponse) {
+	fail := func(code, msg string) (PayloadSeedScan, *OutResponse) {
+		resp := invalid(code, msg, req, startUnix, startWall, host)
+		return p, &resp
+	}
+
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
-		return invalid("BAD_PAYLOAD", err.Error(), req, startUnix, startWall, host)
+		return fail("BAD_PAYLOAD", err.Error())
 	}
 	if p.N <= 0 {
-		return invalid("BAD_N", "n must be > 0", req, startUnix, startWall, host)
+		return fail("BAD_N", "n must be > 0")
 	}
-	if p.K < 2 || p.K > p.N-1 {
-		return invalid("BAD_K", "k must be in [2, n-1]", req, startUnix, startWall, host)
+	if p.N == 2 || p.N == 6 {
+		return fail("BAD_N", "no orthogonal pair exists for n=2 or n=6")
 	}
-	// быстрый теоретический стоп для пары
-	if p.K == 2 && (p.N == 2 || p.N == 6) {
-		res := ResultMOLS{N: p.N, K: p.K, Found: false, Conflicts: p.N * p.N, UniquePairs: 0}
-		return OutResponse{
-			Ok:      true,
-			Problem: req.Problem,
-			TaskID:  req.TaskID,
-			Status:  "no_solution",
-			Result:  res,
-			Debug:   DebugInfo{Notes: "No orthogonal pair exists for n=2 or n=6 (k=2)."},
-			Metrics: finishMetrics(startUnix, startWall, host),
-		}
+	if p.K == 0 {
+		p.K = 2
 	}
-
-	n := p.N
-	k := p.K
-	if k != 2 {
-		// пока честно поддержим только k=2 (иначе усложнение резко)
-		return OutResponse{
-			Ok:      false,
-			Problem: req.Problem,
-			TaskID:  req.TaskID,
-			Status:  "error",
-			Metrics: finishMetrics(startUnix, startWall, host),
-			Error: &OutError{
-				Code:    "NOT_IMPLEMENTED",
-				Message: "currently supports only k=2",
-			},
-		}
+	if p.K != 2 {
+		return fail("BAD_K", "mols_seed_scan supports k=2 only")
+	}
+	if p.N < 3 {
+		return fail("BAD_K", fmt.Sprintf("k must be in [2, n-1], which is empty for n=%d", p.N))
+	}
+	switch p.Method {
+	case "", "hill_climb":
+		p.Method = "hill_climb"
+	case "anneal":
+	default:
+		return fail("BAD_METHOD", fmt.Sprintf("unknown method=%q (want hill_climb|anneal)", p.Method))
+	}
+	if p.SeedTo < p.SeedFrom {
+		return fail("BAD_SEED_RANGE", "seed_to must be >= seed_from")
+	}
+	if p.SeedTo-p.SeedFrom >= maxScanSeeds || p.SeedTo-p.SeedFrom < 0 {
+		return fail("BAD_SEED_RANGE", fmt.Sprintf("seed range must hold at most %d seeds", maxScanSeeds))
+	}
+	if p.StepsPerSeed < 0 {
+		return fail("BAD_STEPS_PER_SEED", "steps_per_seed must be >= 0")
+	}
+	if p.StepsPerSeed == 0 {
+		p.StepsPerSeed = 200_000
+	}
+	if p.Buckets < 0 {
+		return fail("BAD_BUCKETS", "buckets must be >= 0")
+	}
+	if p.Buckets == 0 {
+		p.Buckets = 10
 	}
+	return p, nil
+}
 
-	maxSteps := req.Budget.MaxSteps
-	if maxSteps <= 0 {
-		maxSteps = 2_000_000
+// handleSeedScan runs the k=2 local search once per seed of the range, each
+// capped at steps_per_seed steps, and aggregates the steps the solved seeds
+// needed. A seed cut short by the request deadline is not counted.
+func handleSeedScan(ctx context.Context, req InRequest, rngKind latin.RNG, deadline time.Time, startUnix int64, startWall time.Time, host string) OutResponse {
+	p, bad := parseSeedScan(req, startUnix, startWall, host)
+	if bad != nil {
+		return *bad
 	}
 
-	// старт: L0 = cyclic latin
-	L0 := makeCyclicLatin(n, 1)
-	// L1 стартуем как тоже cyclic, но потом мутируем перестановками
-	L1 := makeCyclicLatin(n, 1)
-	// рандомные перестановки (сохраняют латинскость)
-	randomPermuteLatin(L0, rng)
-	randomPermuteLatin(L1, rng)
-
-	bestConf, bestUnique := orthConflicts(L0, L1)
-	bestL1 := deepCopy(L1)
-	steps := int64(0)
-
-	// локальный поиск: пробуем случайные операции, принимаем если лучше
-	for steps < maxSteps && time.Now().Before(deadline) {
-		steps++
-
-		// копия текущего L1
-		cand := deepCopy(L1)
-
-		// случайная операция
-		switch rng.Intn(3) {
-		case 0:
-			// swap two rows
-			r1 := rng.Intn(n)
-			r2 := rng.Intn(n)
-			cand[r1], cand[r2] = cand[r2], cand[r1]
-		case 1:
-			// swap two cols
-			c1 := rng.Intn(n)
-			c2 := rng.Intn(n)
-			for i := 0; i < n; i++ {
-				cand[i][c1], cand[i][c2] = cand[i][c2], cand[i][c1]
-			}
-		case 2:
-			// rename two symbols
-			a := rng.Intn(n)
-			b := rng.Intn(n)
-			if a != b {
-				for i := 0; i < n; i++ {
-					for j := 0; j < n; j++ {
-						if cand[i][j] == a {
-							cand[i][j] = b
-						} else if cand[i][j] == b {
-							cand[i][j] = a
-						}
-					}
-				}
-			}
+	res := ResultSeedScan{N: p.N, K: p.K}
+	var solved []SeedSteps
+	var steps int64
+	status := "done"
+	for seed := p.SeedFrom; seed <= p.SeedTo; seed++ {
+		r := latin.SearchMOLS(p.N, p.K, latin.MOLSOptions{
+			Ctx:          ctx,
+			Rng:          latin.NewRand(rngKind, seed),
+			Deadline:     deadline,
+			MaxSteps:     p.StepsPerSeed,
+			Method:       p.Method,
+			SidewaysProb: latin.DefaultSidewaysProb,
+		})
+		steps += r.Steps
+		if r.Conflicts > 0 && ctx.Err() != nil {
+			status = "cancelled"
+			break
 		}
-
-		conf, uniq := orthConflicts(L0, cand)
-		// принимаем если лучше, или иногда если равно (чтобы двигаться)
-		if conf < bestConf || (conf == bestConf && uniq > bestUnique) {
-			L1 = cand
-			bestConf, bestUnique = conf, uniq
-			bestL1 = deepCopy(cand)
-			if bestConf == 0 {
-				break
-			}
-		} else if rng.Float64() < 0.001 {
-			L1 = cand // редкий “шаг в сторону”
+		if r.Conflicts > 0 && r.Steps < p.StepsPerSeed {
+			status = "timeout"
+			break
+		}
+		res.Scanned++
+		if r.Conflicts > 0 {
+			res.Unsolved = append(res.Unsolved, seed)
+		} else {
+			solved = append(solved, SeedSteps{Seed: seed, Steps: r.Steps})
+		}
+		if seed == p.SeedTo {
+			break // seed_to = MaxInt64: seed++ переполнился бы
 		}
 	}
 
-	found := (bestConf == 0)
-	res := ResultMOLS{
-		N:           n,
-		K:           2,
-		Found:       found,
-		Conflicts:   bestConf,
-		UniquePairs: bestUnique,
-	}
-
-	if req.Output.ReturnSquares {
-		res.L = [][][]int{L0, bestL1}
-	} else {
-		res.BestHash = []string{hashSquare(L0), hashSquare(bestL1)}
+	res.Solved = len(solved)
+	if len(solved) > 0 {
+		sorted := append([]SeedSteps(nil), solved...)
+		sort.SliceStable(sorted, func(a, b int) bool { return sorted[a].Steps < sorted[b].Steps })
+		easiest, hardest := sorted[0], sorted[len(sorted)-1]
+		res.Easiest, res.Hardest = &easiest, &hardest
+		res.MinSteps, res.MaxSteps = easiest.Steps, hardest.Steps
+		res.MedianSteps = sorted[len(sorted)/2].Steps
+		var sum int64
+		for _, s := range sorted {
+			sum += s.Steps
+		}
+		res.MeanSteps = float64(sum) / float64(len(sorted))
+		res.Histogram = stepsHistogram(sorted, p.Buckets)
 	}
 
-	status := "done"
-	if !found && time.Now().After(deadline) {
-		status = "timeout"
+	notes := []string{fmt.Sprintf("%d of %d seeds solved within %d steps each", res.Solved, res.Scanned, p.StepsPerSeed)}
+	if status != "done" {
+		notes = append(notes, fmt.Sprintf("stopped at seed %d of [%d, %d]", p.SeedFrom+int64(res.Scanned), p.SeedFrom, p.SeedTo))
 	}
-
 	return OutResponse{
-		Ok:      true, // даже если не нашли — попытка валидная
+		Ok:      status == "done",
 		Problem: req.Problem,
 		TaskID:  req.TaskID,
 		Status:  status,
 		Result:  res,
-		Debug:   DebugInfo{Steps: steps, BestScore: bestConf},
+		Debug:   DebugInfo{Attempts: res.Scanned, Steps: steps, Notes: strings.Join(notes, "; "), BudgetHit: budgetHit(req, status, 0)},
 		Metrics: finishMetrics(startUnix, startWall, host),
 	}
 }
 
-func makeCyclicLatin(n int, a int) [][]int {
-	// L[i][j] = (a*i + j) mod n  (Latin если gcd(a,n)=1; но даже a=1 всегда ок)
-	L := make([][]int, n)
-	for i := 0; i < n; i++ {
-		L[i] = make([]int, n)
-		for j := 0; j < n; j++ {
-			L[i][j] = (a*i + j) % n
-		}
+// stepsHistogram splits [min, max] of the sorted steps into at most buckets
+// equal-width integer ranges and counts the seeds in each.
+func stepsHistogram(sorted []SeedSteps, buckets int) []StepsBucket {
+	lo, hi := sorted[0].Steps, sorted[len(sorted)-1].Steps
+	width := (hi - lo + int64(buckets)) / int64(buckets) // ceil((hi-lo+1)/buckets)
+	hist := make([]StepsBucket, 0, buckets)
+	for b := lo; b <= hi; b += width {
+		hist = append(hist, StepsBucket{Lo: b, Hi: min(b+width-1, hi)})
 	}
-	return L
+	for _, s := range sorted {
+		hist[(s.Steps-lo)/width].Count++
+	}
+	return hist
 }
 
-func randomPermuteLatin(L [][]int, rng *rand.Rand) {
-	n := len(L)
+// ---------------------------
+// TRANSVERSAL: трансверсаль готового квадрата
+// ---------------------------
 
-	// permute rows
-	rp := rng.Perm(n)
-	tmp := deepCopy(L)
-	for i := 0; i < n; i++ {
-		L[i] = tmp[rp[i]]
+// parseTransversal decodes and validates a find_transversal payload.
+func parseTransversal(req InRequest, startUnix int64, startWall time.Time, host string) (p PayloadTransversal, bad *OutResponse) {
+	fail := func(code, msg string) (PayloadTransversal, *OutResponse) {
+		resp := invalid(code, msg, req, startUnix, startWall, host)
+		return p, &resp
 	}
 
-	// permute cols
-	cp := rng.Perm(n)
-	for i := 0; i < n; i++ {
-		row := make([]int, n)
-		for j := 0; j < n; j++ {
-			row[j] = L[i][cp[j]]
-		}
-		L[i] = row
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return fail("BAD_PAYLOAD", err.Error())
 	}
-
-	// permute symbols
-	sp := rng.Perm(n)
-	for i := 0; i < n; i++ {
-		for j := 0; j < n; j++ {
-			L[i][j] = sp[L[i][j]]
-		}
+	if p.N <= 0 {
+		return fail("BAD_N", "n must be > 0")
+	}
+	if len(p.Square) != p.N {
+		return fail("BAD_SQUARE_SHAPE", "square must be n x n")
 	}
+	if v := latin.FindViolation(p.Square); v != nil {
+		return fail("INVALID_SQUARE", fmt.Sprintf("square is not a Latin square: %s at (%d,%d)", v.Kind, v.Row, v.Col))
+	}
+	return p, nil
 }
 
-func orthConflicts(A, B [][]int) (conflicts int, uniquePairs int) {
-	n := len(A)
-	seen := make(map[int]bool, n*n)
-	for i := 0; i < n; i++ {
-		for j := 0; j < n; j++ {
-			key := A[i][j]*n + B[i][j]
-			seen[key] = true
-		}
+func handleTransversal(ctx context.Context, req InRequest, rng *rand.Rand, deadline time.Time, startUnix int64, startWall time.Time, host string) OutResponse {
+	p, bad := parseTransversal(req, startUnix, startWall, host)
+	if bad != nil {
+		return *bad
 	}
-	uniquePairs = len(seen)
-	conflicts = n*n - uniquePairs
-	return
-}
 
-func hashSquare(L [][]int) string {
-	// быстрый “хэш” для отчёта: первые N чисел + checksum
-	n := len(L)
-	var flat []int
-	for i := 0; i < n; i++ {
-		flat = append(flat, L[i]...)
+	maxNodes := req.Budget.MaxNodes
+	if maxNodes <= 0 {
+		maxNodes = 3_000_000
+	}
+	tr := latin.FindTransversal(p.Square, latin.MateOptions{
+		Ctx:      ctx,
+		Rng:      rng,
+		Deadline: deadline,
+		MaxNodes: maxNodes,
+	})
+
+	res := ResultTransversal{N: p.N, Found: tr.Rows != nil}
+	if res.Found {
+		// независимая проверка: по клетке на строку и столбец, все символы разные
+		rows, syms := make([]bool, p.N), make([]bool, p.N)
+		res.Verified = true
+		for c, r := range tr.Rows {
+			v := p.Square[r][c]
+			res.Verified = res.Verified && !rows[r] && !syms[v]
+			rows[r], syms[v] = true, true
+			res.Cells = append(res.Cells, [2]int{r, c})
+			res.Symbols = append(res.Symbols, v)
+		}
 	}
-	sum := 0
-	for _, v := range flat {
-		sum = (sum*131 + v + 1) % 1000000007
+	var notes []string
+	switch tr.Status {
+	case "no_solution":
+		if tr.Cyclic {
+			notes = append(notes, fmt.Sprintf("square is L[i][j] = r_i + c_j mod n with n=%d even: no transversal, no search needed", p.N))
+		}
+	case "timeout":
+		notes = append(notes, fmt.Sprintf("time budget exhausted (time_limit_sec=%d)", req.Budget.TimeLimitSec))
+	case "node_limit":
+		notes = append(notes, fmt.Sprintf("node budget exhausted (max_nodes=%d)", maxNodes))
+	case "cancelled":
+		notes = append(notes, "search cancelled before completion")
 	}
-	// первые 12 элементов для читаемости
-	m := 12
-	if len(flat) < m {
-		m = len(flat)
+
+	return OutResponse{
+		Ok:      res.Found || tr.Status == "timeout" || tr.Status == "node_limit",
+		Problem: req.Problem,
+		TaskID:  req.TaskID,
+		Status:  tr.Status,
+		Result:  res,
+		Debug:   DebugInfo{Nodes: tr.Nodes, Notes: strings.Join(notes, "; "), BudgetHit: budgetHit(req, tr.Status, maxNodes)},
+		Metrics: finishMetrics(startUnix, startWall, host),
 	}
-	head := make([]int, m)
-	copy(head, flat[:m])
-	sort.Ints(head)
-	return fmt.Sprintf("n=%d sum=%d head=%v", n, sum, head)
 }
@@ -0,0 +1,3606 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"ls_worker/latin"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// solve runs one JSON request in-process, straight through its handler with
+// the worker's defaults.
+func solve(t *testing.T, in string) OutResponse {
+	t.Helper()
+	var req InRequest
+	if err := json.Unmarshal([]byte(in), &req); err != nil {
+		t.Fatalf("decode %s: %v", in, err)
+	}
+	if req.Budget.TimeLimitSec <= 0 {
+		req.Budget.TimeLimitSec = 60
+	}
+	if req.Output.MaxSolutions <= 0 {
+		req.Output.MaxSolutions = 1
+	}
+	start := time.Now()
+	deadline := start.Add(time.Duration(req.Budget.TimeLimitSec) * time.Second)
+	rng := rand.New(rand.NewSource(req.Seed))
+	if req.Budget.MaxCores > 0 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(req.Budget.MaxCores))
+	}
+	ctx := context.Background()
+	switch req.Problem {
+	case "complete_latin_square_from_prefix":
+		return handleComplete(ctx, req, rng, latin.RNGLegacy, deadline, start.Unix(), start, "test", nil, "", nil, nil)
+	case "count_latin_completions":
+		return handleCount(ctx, req, deadline, start.Unix(), start, "test", nil)
+	case "search_mols":
+		return handleMOLS(ctx, req, rng, latin.RNGLegacy, deadline, start.Unix(), start, "test", nil, nil)
+	case "find_orthogonal_mate":
+		return handleMate(ctx, req, rng, deadline, start.Unix(), start, "test")
+	case "random_latin_square":
+		return handleRandom(ctx, req, rng, deadline, start.Unix(), start, "test")
+	case "verify_latin_square":
+		return handleVerify(req, start.Unix(), start, "test")
+	case "check_orthogonal":
+		return handleOrthogonal(req, start.Unix(), start, "test")
+	case "extend_latin_rectangle":
+		return handleRectangle(ctx, req, rng, deadline, start.Unix(), start, "test")
+	case "min_cost_completion":
+		return handleMinCost(ctx, req, rng, latin.RNGLegacy, deadline, start.Unix(), start, "test", nil)
+	case "benchmark":
+		return handleBenchmark(ctx, req, latin.RNGLegacy, deadline, start.Unix(), start, "test")
+	case "mols_seed_scan":
+		return handleSeedScan(ctx, req, latin.RNGLegacy, deadline, start.Unix(), start, "test")
+	case "find_transversal":
+		return handleTransversal(ctx, req, rng, deadline, start.Unix(), start, "test")
+	}
+	t.Fatalf("unknown problem=%q", req.Problem)
+	return OutResponse{}
+}
+
+// TestMain runs the worker's main instead of the tests when re-executed by
+// worker, so I/O and exit codes are tested on the real process.
+func TestMain(m *testing.M) {
+	if os.Getenv("LS_WORKER_MAIN") == "1" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// workerCmd is the test binary set up to run as the worker with args.
+func workerCmd(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "LS_WORKER_MAIN=1")
+	return cmd
+}
+
+// worker runs the test binary as the worker on request in (written to a
+// temp in.json) with args, and returns out.json (nil if
+// it was not written) and the exit code.
+func worker(t *testing.T, in string, args ...string) ([]byte, int) {
+	t.Helper()
+	dir := t.TempDir()
+	inPath, outPath := filepath.Join(dir, "in.json"), filepath.Join(dir, "out.json")
+	if err := os.WriteFile(inPath, []byte(in), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := workerCmd(append([]string{"-in", inPath, "-out", outPath}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	code := 0
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) {
+			t.Fatalf("run worker: %v", err)
+		}
+		code = exit.ExitCode()
+	}
+	out, err := os.ReadFile(outPath)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	t.Logf("worker %v: exit %d, stderr: %s", args, code, stderr.Bytes())
+	return out, code
+}
+
+// nullPrefix is the JSON of an empty n x n prefix.
+func nullPrefix(n int) string {
+	row := "[" + strings.Repeat("null,", n-1) + "null]"
+	return "[" + strings.Repeat(row+",", n-1) + row + "]"
+}
+
+// readInBytes runs readIn on b written to a temporary file.
+func readInBytes(t *testing.T, b []byte, strict bool) ([]InRequest, bool, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "in.json")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return readIn(path, strict)
+}
+
+func TestMaxSolutions(t *testing.T) {
+	// одна заданная клетка 4x4: 576/4 = 144 дополнения, больше любого max;
+	// distinct=exact — считаем сами квадраты, а не их классы
+	for _, max := range []int{1, 2, 7, 50} {
+		resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,
+			"output":{"max_solutions":`+strconv.Itoa(max)+`,"distinct":"exact"},
+			"payload":{"n":4,"prefix":[[0,null,null,null],[null,null,null,null],[null,null,null,null],[null,null,null,null]]}}`)
+		res, ok := resp.Result.(ResultComplete)
+		if !ok || !res.SolutionFound {
+			t.Fatalf("max=%d: status %q, result %T", max, resp.Status, resp.Result)
+		}
+		if max == 1 {
+			// одно решение — только square, как было до squares
+			if res.Squares != nil || !latin.IsLatinSquare(res.Square) {
+				t.Errorf("max=1: square %v, squares %v", res.Square, res.Squares)
+			}
+			continue
+		}
+		if len(res.Squares) != max {
+			t.Fatalf("max=%d: %d squares", max, len(res.Squares))
+		}
+		if !slices.EqualFunc(res.Square, res.Squares[0], slices.Equal) {
+			t.Errorf("max=%d: square %v is not the first of squares %v", max, res.Square, res.Squares[0])
+		}
+		seen := map[string]bool{}
+		for _, sq := range res.Squares {
+			if !latin.IsLatinSquare(sq) || sq[0][0] != 0 {
+				t.Errorf("max=%d: %v is not a completion", max, sq)
+			}
+			if d := fmt.Sprint(sq); seen[d] {
+				t.Errorf("max=%d: duplicate square %v", max, sq)
+			} else {
+				seen[d] = true
+			}
+		}
+	}
+}
+
+func TestCompleteNodeLimit(t *testing.T) {
+	// дополнению 6x6 нужно больше 35 узлов, max_nodes кончается раньше:
+	// node_limit, а не timeout, и код выхода как у timeout. Одна заданная
+	// клетка — пустой префикс дополняется без поиска
+	prefix := strings.Replace(nullPrefix(6), "null", "0", 1)
+	in := `{"problem":"complete_latin_square_from_prefix","budget":{"max_nodes":5},
+		"payload":{"n":6,"prefix":` + prefix + `}}`
+	for _, engine := range []string{"dfs", "dlx"} {
+		t.Run(engine, func(t *testing.T) {
+			out, code := worker(t, strings.Replace(in, `"n":6`, `"n":6,"engine":"`+engine+`"`, 1), "-no-min-runtime")
+			var resp struct {
+				OutResponse
+				Debug DebugInfo `json:"debug"`
+			}
+			if err := json.Unmarshal(out, &resp); err != nil {
+				t.Fatalf("out.json %q: %v", out, err)
+			}
+			if resp.Status != "node_limit" || code != exitTimeout || !resp.Ok {
+				t.Fatalf("status %q, exit %d, ok %v; want node_limit, %d, true", resp.Status, code, resp.Ok, exitTimeout)
+			}
+			if !strings.Contains(resp.Debug.Notes, "node budget exhausted (max_nodes=5)") {
+				t.Errorf("notes %q do not name the node budget", resp.Debug.Notes)
+			}
+		})
+	}
+}
+
+func TestMOLSStack(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		found bool
+		k     int
+		note  string // подстрока debug.notes
+	}{
+		{"n=4 k=3", `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":10},
+			"output":{"return_squares":true},"payload":{"n":4,"k":3}}`, true, 3, ""},
+		// n=10 за 2000 шагов не найти — конфликты по парам уходят в notes
+		{"n=10 k=3", `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":10,"max_steps":2000},
+			"output":{"return_squares":true},"payload":{"n":10,"k":3}}`, false, 3, "pair_conflicts: (0,1)="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, tt.in)
+			res, ok := resp.Result.(ResultMOLS)
+			if !ok || res.Found != tt.found || len(res.L) != tt.k {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			if tt.found && (!res.Verified || res.Conflicts != 0) {
+				t.Errorf("verified=%v conflicts=%d", res.Verified, res.Conflicts)
+			}
+			debug, _ := resp.Debug.(DebugInfo)
+			if !strings.Contains(debug.Notes, tt.note) {
+				t.Errorf("notes %q, want %q", debug.Notes, tt.note)
+			}
+		})
+	}
+}
+
+func TestSearchMOLSAnneal(t *testing.T) {
+	// при фиксированном seed отжиг на малых n доходит до ортогональной пары;
+	// когда шагов не хватает, found=false, конфликты остаются и budget_hit
+	// называет шаги
+	tests := []struct {
+		name     string
+		n        int
+		maxSteps int
+		found    bool
+	}{
+		{"n=5", 5, 20_000, true},
+		{"n=7", 7, 200_000, true},
+		{"n=7 short", 7, 2_000, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":30,"max_steps":`+strconv.Itoa(tt.maxSteps)+`},
+				"output":{"return_squares":true},
+				"payload":{"n":`+strconv.Itoa(tt.n)+`,"k":2,"method":"anneal"}}`)
+			res, _ := resp.Result.(ResultMOLS)
+			debug, _ := resp.Debug.(DebugInfo)
+			if resp.Status != "done" || res.Found != tt.found || res.Verified != tt.found || (res.Conflicts == 0) != tt.found {
+				t.Fatalf("status %q, found %v, verified %v, %d conflicts; want found %v",
+					resp.Status, res.Found, res.Verified, res.Conflicts, tt.found)
+			}
+			if tt.found {
+				if ok, msg := latin.VerifyMOLS(res.L); !ok {
+					t.Errorf("returned pair is not orthogonal: %s", msg)
+				}
+				return
+			}
+			if debug.BudgetHit == nil || debug.BudgetHit.Kind != "steps" || debug.Steps != int64(tt.maxSteps) {
+				t.Errorf("budget_hit %+v after %d steps; want steps at %d", debug.BudgetHit, debug.Steps, tt.maxSteps)
+			}
+		})
+	}
+}
+
+func TestSearchMOLSOverlay(t *testing.T) {
+	// overlay — пары (L[0], L[1]) по клеткам; у найденной пары все n^2 пар
+	// различны, у ненайденной повторов ровно conflicts
+	for _, steps := range []int{200_000, 2_000} {
+		resp := solve(t, `{"problem":"search_mols","seed":1,"budget":{"max_steps":`+strconv.Itoa(steps)+`},
+			"output":{"return_squares":true},"payload":{"n":7,"k":2,"method":"anneal"}}`)
+		res, _ := resp.Result.(ResultMOLS)
+		if len(res.L) != 2 || len(res.Overlay) != 7 {
+			t.Fatalf("max_steps %d: %d squares, %d overlay rows", steps, len(res.L), len(res.Overlay))
+		}
+		seen := map[[2]int]bool{}
+		for i, row := range res.Overlay {
+			for j, p := range row {
+				if p != [2]int{res.L[0][i][j], res.L[1][i][j]} {
+					t.Fatalf("max_steps %d: overlay (%d,%d) = %v, squares give (%d,%d)", steps, i, j, p, res.L[0][i][j], res.L[1][i][j])
+				}
+				seen[p] = true
+			}
+		}
+		if dup := 49 - len(seen); dup != res.Conflicts || (dup == 0) != res.Found {
+			t.Errorf("max_steps %d: %d repeated pairs, conflicts %d, found %v", steps, dup, res.Conflicts, res.Found)
+		}
+	}
+
+	// при k > 2 overlay — только первая пара; остальные — в conflict_matrix
+	resp := solve(t, `{"problem":"search_mols","seed":1,"budget":{"max_steps":2000},
+		"output":{"return_squares":true},"payload":{"n":7,"k":3}}`)
+	res, _ := resp.Result.(ResultMOLS)
+	if len(res.L) != 3 || len(res.Overlay) != 7 || len(res.ConflictMatrix) != 3 {
+		t.Fatalf("k=3: %d squares, %d overlay rows, conflict matrix %v", len(res.L), len(res.Overlay), res.ConflictMatrix)
+	}
+	if want := latin.Overlay(res.L[0], res.L[1]); !slices.EqualFunc(res.Overlay, want, slices.Equal) {
+		t.Errorf("k=3: overlay %v, want the pair (L[0], L[1])", res.Overlay)
+	}
+}
+
+func TestVerifyViolation(t *testing.T) {
+	// violation как его видит балансер: для дубликата и символа вне
+	// диапазона — value, для строки не той длины — expected/got без value
+	tests := []struct {
+		name   string
+		square string
+		want   string // JSON violation; "" — квадрат латинский
+	}{
+		{"latin", `[[0,1],[1,0]]`, ""},
+		{"row", `[[0,0],[1,0]]`, `{"row":0,"col":1,"value":0,"kind":"row"}`},
+		{"col", `[[0,1],[0,1]]`, `{"row":1,"col":0,"value":0,"kind":"col"}`},
+		{"range", `[[0,1],[1,2]]`, `{"row":1,"col":1,"value":2,"kind":"range"}`},
+		{"short row", `[[0,1,2],[1,2],[2,0,1]]`, `{"row":1,"col":-1,"kind":"row_length","expected":3,"got":2}`},
+		{"empty row", `[[0,1],[]]`, `{"row":1,"col":-1,"kind":"row_length","expected":2,"got":0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"verify_latin_square","payload":{"square":`+tt.square+`}}`)
+			res, _ := resp.Result.(ResultVerify)
+			if resp.Status != "done" || res.IsLatin != (tt.want == "") {
+				t.Fatalf("status %q, is_latin %v", resp.Status, res.IsLatin)
+			}
+			if tt.want == "" {
+				if res.Violation != nil {
+					t.Errorf("violation %+v on a Latin square", res.Violation)
+				}
+				return
+			}
+			got, err := json.Marshal(res.Violation)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("violation %s, want %s (err %v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestStdinStdout(t *testing.T) {
+	// -in - / -out -: запрос со stdin, в stdout — только JSON ответа,
+	// код выхода тот же, что с файлами
+	tests := []struct {
+		name   string
+		in     []byte
+		status []string
+		code   int
+	}{
+		{"single", []byte(`{"problem":"verify_latin_square","payload":{"square":[[0,1],[1,0]]}}`), []string{"done"}, exitSolved},
+		{"no solution", []byte(`{"problem":"complete_latin_square_from_prefix","payload":{"n":2,"prefix":[[0,null],[null,1]]}}`),
+			[]string{"no_solution"}, exitNoSolution},
+		{"batch", []byte(`[{"problem":"verify_latin_square","payload":{"square":[[0]]}},
+			{"problem":"complete_latin_square_from_prefix","payload":{"n":3,"prefix":` + nullPrefix(3) + `}}]`), []string{"done", "done"}, exitSolved},
+		// gzip на stdin узнаётся по сигнатуре
+		{"gzip", gzipBytes([]byte(`{"problem":"verify_latin_square","payload":{"square":[[0]]}}`)), []string{"done"}, exitSolved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := workerCmd("-in", "-", "-out", "-", "-no-min-runtime", "-log-interval", "10ms")
+			cmd.Stdin = bytes.NewReader(tt.in)
+			var stdout, stderr bytes.Buffer
+			cmd.Stdout, cmd.Stderr = &stdout, &stderr
+			code := 0
+			if err := cmd.Run(); err != nil {
+				var exit *exec.ExitError
+				if !errors.As(err, &exit) {
+					t.Fatalf("run worker: %v", err)
+				}
+				code = exit.ExitCode()
+			}
+			// один JSON-документ, без строк прогресса: они уходят в stderr
+			var resps []OutResponse
+			dec := json.NewDecoder(&stdout)
+			if len(tt.status) > 1 {
+				if err := dec.Decode(&resps); err != nil {
+					t.Fatalf("stdout is not a batch of responses: %v\nstderr: %s", err, stderr.Bytes())
+				}
+			} else {
+				resps = make([]OutResponse, 1)
+				if err := dec.Decode(&resps[0]); err != nil {
+					t.Fatalf("stdout is not a response: %v\nstderr: %s", err, stderr.Bytes())
+				}
+			}
+			if dec.More() {
+				t.Errorf("stdout has more after the response")
+			}
+			if len(resps) != len(tt.status) {
+				t.Fatalf("%d responses, want %d", len(resps), len(tt.status))
+			}
+			for k, r := range resps {
+				if r.Status != tt.status[k] {
+					t.Errorf("response %d: status %q, want %q", k, r.Status, tt.status[k])
+				}
+			}
+			if code != tt.code {
+				t.Errorf("exit %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestMinRuntimeSkippedOnFailure(t *testing.T) {
+	// invalid_input и error не дожигаются до min_runtime_sec, done и
+	// no_solution — дожигаются
+	tests := []struct {
+		name   string
+		in     string
+		status string
+		padded bool
+	}{
+		{"invalid input", `{"problem":"no_such_problem","budget":{"min_runtime_sec":2},"payload":{}}`, "invalid_input", false},
+		{"bad payload", `{"problem":"verify_latin_square","budget":{"min_runtime_sec":2},"payload":{"square":"x"}}`, "invalid_input", false},
+		{"done", `{"problem":"verify_latin_square","budget":{"min_runtime_sec":2},"payload":{"square":[[0]]}}`, "done", true},
+		{"no solution", `{"problem":"complete_latin_square_from_prefix","budget":{"min_runtime_sec":2},
+			"payload":{"n":2,"prefix":[[0,null],[null,1]]}}`, "no_solution", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, _, err := readInBytes(t, []byte(tt.in), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := runRequest(context.Background(), reqs[0], runEnv{host: "test", maxN: 2000})
+			if resp.Status != tt.status {
+				t.Fatalf("status %q, want %q", resp.Status, tt.status)
+			}
+			if padded := resp.Metrics.WallMS >= 2000; padded != tt.padded {
+				t.Errorf("wall_ms %d; padded %v, want %v", resp.Metrics.WallMS, padded, tt.padded)
+			}
+		})
+	}
+	for _, status := range []string{"invalid_input", "error", "cancelled", "resource_exhausted", "valid"} {
+		if padMinRuntime(status) {
+			t.Errorf("padMinRuntime(%q) = true", status)
+		}
+	}
+
+	// BAD_JSON — до min_runtime по умолчанию (5s) тоже не ждём
+	start := time.Now()
+	if _, code := worker(t, `{"problem":`); code != exitInvalid || time.Since(start) > 2*time.Second {
+		t.Errorf("exit %d after %v; want %d without padding", code, time.Since(start), exitInvalid)
+	}
+}
+
+func TestAutoFilled(t *testing.T) {
+	// циклический 4x4 со стёртыми клетками: одиночки и «значение только в
+	// одной клетке» восстанавливают их, кроме интеркалята 0 2 / 2 0 — там два
+	// дополнения и без ветвления не обойтись
+	tests := []struct {
+		name    string
+		problem string
+		prefix  string
+		filled  int
+	}{
+		{"last row", "complete_latin_square_from_prefix", `[[0,1,2,3],[1,2,3,0],[2,3,0,1],[null,null,null,null]]`, 4},
+		{"diagonal", "complete_latin_square_from_prefix", `[[null,1,2,3],[1,null,3,0],[2,3,null,1],[3,0,1,null]]`, 4},
+		{"intercalate", "complete_latin_square_from_prefix", `[[null,1,null,3],[1,2,3,0],[null,3,null,1],[3,0,1,2]]`, 0},
+		{"count last row", "count_latin_completions", `[[0,1,2,3],[1,2,3,0],[2,3,0,1],[null,null,null,null]]`, 4},
+		{"count intercalate", "count_latin_completions", `[[null,1,null,3],[1,2,3,0],[null,3,null,1],[3,0,1,2]]`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"`+tt.problem+`","payload":{"n":4,"prefix":`+tt.prefix+`}}`)
+			debug, _ := resp.Debug.(DebugInfo)
+			if resp.Status != "done" || debug.AutoFilled != tt.filled {
+				t.Errorf("status %q, auto_filled %d; want done, %d", resp.Status, debug.AutoFilled, tt.filled)
+			}
+		})
+	}
+}
+
+func TestThroughputMetrics(t *testing.T) {
+	tests := []struct {
+		name  string
+		work  OutMetrics
+		nodes int64
+		nps   int64
+		util  int
+	}{
+		// меньше миллисекунды: делить не на что — нули, а не Inf/NaN
+		{"zero wall", OutMetrics{WallMS: 0, CPUUserMS: 3, CoresUsed: 4}, 1000, 0, 0},
+		{"negative wall", OutMetrics{WallMS: -1, CoresUsed: 1}, 1000, 0, 0},
+		{"one core", OutMetrics{WallMS: 2000, CPUUserMS: 1500, CPUSysMS: 500, CoresUsed: 1}, 3_000_000, 1_500_000, 100},
+		{"half of four cores", OutMetrics{WallMS: 1000, CPUUserMS: 2000, CoresUsed: 4}, 10, 10, 50},
+		{"coarse rusage", OutMetrics{WallMS: 10, CPUUserMS: 20, CoresUsed: 1}, 0, 0, 100},
+		{"cores unknown", OutMetrics{WallMS: 1000, CPUUserMS: 500}, 1, 1, 50},
+	}
+	for _, tt := range tests {
+		var m OutMetrics
+		addThroughput(&m, tt.work, tt.nodes)
+		if m.NodesPerSec != tt.nps || m.CPUUtilPercent != tt.util {
+			t.Errorf("%s: nodes_per_sec %d, cpu_util_percent %d; want %d, %d", tt.name, m.NodesPerSec, m.CPUUtilPercent, tt.nps, tt.util)
+		}
+	}
+
+	// через runRequest: и мгновенная задача, и задача с узлами дают
+	// сериализуемый JSON без Inf/NaN
+	for _, in := range []string{
+		`{"problem":"verify_latin_square","payload":{"square":[[0]]}}`,
+		`{"problem":"count_latin_completions","payload":{"n":5,"prefix":` + nullPrefix(5) + `}}`,
+	} {
+		reqs, _, err := readInBytes(t, []byte(in), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := runRequest(context.Background(), reqs[0], runEnv{host: "test", maxN: 2000, noMinRuntime: true})
+		out, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", reqs[0].Problem, err)
+		}
+		if bytes.Contains(out, []byte("Inf")) || bytes.Contains(out, []byte("NaN")) {
+			t.Errorf("%s: %s", reqs[0].Problem, out)
+		}
+		m := resp.Metrics
+		if m.NodesPerSec < 0 || m.CPUUtilPercent < 0 || m.CPUUtilPercent > 100 || (m.SolveWallMS == 0 && m.NodesPerSec != 0) {
+			t.Errorf("%s: nodes_per_sec %d, cpu_util_percent %d over solve_wall_ms %d", reqs[0].Problem, m.NodesPerSec, m.CPUUtilPercent, m.SolveWallMS)
+		}
+	}
+}
+
+func TestMOLSStallRestarts(t *testing.T) {
+	// seed 5 без рестартов застревает; со stall_steps=2000 находит пару, и
+	// debug называет число рестартов и рестарт с лучшим набором
+	tests := []struct {
+		stall    int
+		found    bool
+		restarts int
+	}{
+		{-1, false, 0},
+		{2000, true, 15},
+	}
+	for _, tt := range tests {
+		resp := solve(t, `{"problem":"search_mols","seed":5,"budget":{"max_steps":100000},
+			"payload":{"n":7,"k":2,"sideways_prob":0,"stall_steps":`+strconv.Itoa(tt.stall)+`}}`)
+		res, _ := resp.Result.(ResultMOLS)
+		debug, _ := resp.Debug.(DebugInfo)
+		if res.Found != tt.found || debug.Restarts != tt.restarts {
+			t.Errorf("stall_steps=%d: found %v, %d restarts; want %v, %d", tt.stall, res.Found, debug.Restarts, tt.found, tt.restarts)
+		}
+		if tt.found && debug.BestRestart != debug.Restarts {
+			t.Errorf("stall_steps=%d: best_restart %d, want the last restart %d", tt.stall, debug.BestRestart, debug.Restarts)
+		}
+	}
+}
+
+func TestCompleteSymbols(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		symbols string
+		prefix  string
+		code    string // код ошибки; "" — успех
+	}{
+		{"1-based", 3, `[1,2,3]`, `[[1,null,null],[null,3,null],[null,null,null]]`, ""},
+		{"arbitrary labels", 4, `[40,-7,10,20]`, `[[40,null,null,null],[null,-7,null,null],[null,null,null,null],[null,null,null,20]]`, ""},
+		{"too few", 3, `[1,2]`, `[[1,null,null],[null,null,null],[null,null,null]]`, "BAD_SYMBOLS"},
+		{"duplicate", 3, `[1,2,2]`, `[[1,null,null],[null,null,null],[null,null,null]]`, "BAD_SYMBOLS"},
+		{"value not in symbols", 3, `[1,2,3]`, `[[0,null,null],[null,null,null],[null,null,null]]`, "BAD_VALUE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,
+				"payload":{"n":`+strconv.Itoa(tt.n)+`,"symbols":`+tt.symbols+`,"prefix":`+tt.prefix+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, ok := resp.Result.(ResultComplete)
+			if !ok || !res.SolutionFound || !res.VerifiedLatin {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			var symbols []int
+			var prefix [][]*int
+			if err := json.Unmarshal([]byte(tt.symbols), &symbols); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.prefix), &prefix); err != nil {
+				t.Fatal(err)
+			}
+			// наружу — исходные символы: каждая строка и столбец — перестановка symbols
+			want := slices.Sorted(slices.Values(symbols))
+			for i := range res.Square {
+				col := make([]int, len(res.Square))
+				for j := range col {
+					col[j] = res.Square[j][i]
+					if p := prefix[i][j]; p != nil && res.Square[i][j] != *p {
+						t.Errorf("(%d,%d) = %d, prefix says %d", i, j, res.Square[i][j], *p)
+					}
+				}
+				if !slices.Equal(slices.Sorted(slices.Values(res.Square[i])), want) || !slices.Equal(slices.Sorted(slices.Values(col)), want) {
+					t.Errorf("square %v: row or column %d is not a permutation of %v", res.Square, i, symbols)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckEveryHonorsDeadline(t *testing.T) {
+	// даже при редкой сверке с часами дедлайн не проскакивает больше чем на
+	// одно окно check_every узлов, а статус остаётся timeout
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"complete", `{"problem":"complete_latin_square_from_prefix","budget":{"time_limit_sec":1},
+			"payload":{"n":100,"check_every":1000000,"prefix":` + nullPrefix(100) + `}}`},
+		{"count", `{"problem":"count_latin_completions","budget":{"time_limit_sec":1},
+			"payload":{"n":8,"check_every":1000000,"prefix":` + nullPrefix(8) + `}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, _, err := readInBytes(t, []byte(tt.in), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := runRequest(context.Background(), reqs[0], runEnv{host: "test", maxN: 2000, noMinRuntime: true})
+			if resp.Status != "timeout" || exitCode(resp.Status) != exitTimeout {
+				t.Fatalf("status %q (exit %d), want timeout (exit %d)", resp.Status, exitCode(resp.Status), exitTimeout)
+			}
+			if m := resp.Metrics; m.SolveWallMS < 1000 || m.SolveWallMS > 2000 {
+				t.Errorf("solve_wall_ms %d, want about time_limit_sec=1", m.SolveWallMS)
+			}
+		})
+	}
+}
+
+func TestSameSeedSameResult(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"complete", `{"problem":"complete_latin_square_from_prefix","seed":7,"budget":{"time_limit_sec":10},
+			"payload":{"n":9,"prefix":` + nullPrefix(9) + `}}`},
+		{"complete many", `{"problem":"complete_latin_square_from_prefix","seed":7,"budget":{"time_limit_sec":10},
+			"output":{"max_solutions":5},"payload":{"n":6,"prefix":` + nullPrefix(6) + `}}`},
+		{"search_mols", `{"problem":"search_mols","seed":7,"budget":{"time_limit_sec":10,"max_steps":20000},
+			"payload":{"n":7,"k":2}}`},
+		{"search_mols anneal", `{"problem":"search_mols","seed":7,"budget":{"time_limit_sec":10,"max_steps":20000},
+			"payload":{"n":10,"k":2,"method":"anneal"}}`},
+		{"random", `{"problem":"random_latin_square","seed":7,"payload":{"n":12}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := func() []byte {
+				resp := solve(t, tt.in)
+				if resp.Error != nil {
+					t.Fatalf("error %+v", resp.Error)
+				}
+				out, err := json.Marshal(resp.Result)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return out
+			}
+			if first, second := run(), run(); string(first) != string(second) {
+				t.Errorf("same seed, different results:\n%s\n%s", first, second)
+			}
+		})
+	}
+}
+
+func TestMaxRSSToKB(t *testing.T) {
+	tests := []struct {
+		goos   string
+		maxrss int64
+		want   int64
+	}{
+		{"linux", 204800, 204800}, // уже KB
+		{"freebsd", 204800, 204800},
+		{"darwin", 209715200, 204800}, // байты
+		{"ios", 209715200, 204800},
+		{"darwin", 0, 0},
+	}
+	for _, tt := range tests {
+		if got := maxRSSToKB(tt.maxrss, tt.goos); got != tt.want {
+			t.Errorf("maxRSSToKB(%d, %q) = %d, want %d", tt.maxrss, tt.goos, got, tt.want)
+		}
+	}
+}
+
+func TestReadInStrict(t *testing.T) {
+	const extra = `{"problem":" verify_latin_square ","seed":5,"experimental":{"x":[1,2]},"payload":{"square":[[0]]}}`
+	tests := []struct {
+		name    string
+		in      string
+		strict  bool
+		wantErr bool
+		batch   bool
+	}{
+		{"extra key, lenient", extra, false, false, false},
+		{"extra key, strict", extra, true, true, false},
+		{"batch extra key, lenient", "[" + extra + "," + extra + "]", false, false, true},
+		{"batch extra key, strict", "[" + extra + "]", true, true, true},
+		{"known keys, strict", `{"problem":"verify_latin_square","seed":5,"payload":{"square":[[0]]}}`, true, false, false},
+		{"empty batch", `[]`, false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, batch, err := readInBytes(t, []byte(tt.in), tt.strict)
+			if batch != tt.batch {
+				t.Errorf("batch = %v, want %v", batch, tt.batch)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			// известные ключи разобраны и в нестрогом режиме
+			for _, r := range reqs {
+				if r.Problem != "verify_latin_square" || r.Seed != 5 {
+					t.Errorf("decoded %+v", r)
+				}
+			}
+		})
+	}
+}
+
+func TestCompleteResumesCheckpoint(t *testing.T) {
+	// checkpoint пустой доски 12x12, снятый на ~50-м узле
+	s := latin.NewSolver(emptyBoard(12), nil)
+	s.CheckEvery = 1
+	s.OnTick = func(int64) {
+		if s.Nodes >= 50 {
+			s.Deadline = time.Now().Add(-time.Second)
+		}
+	}
+	if _, status, _ := s.Solve(); status != "timeout" || s.Checkpoint() == nil {
+		t.Fatalf("status %q, checkpoint %v", status, s.Checkpoint())
+	}
+	in := `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":{"time_limit_sec":10},
+		"payload":{"n":12,"prefix":` + nullPrefix(12) + `}}`
+	tests := []struct {
+		name  string
+		write func(path string) error
+		code  string // код ошибки; "" — продолжили и дорешали
+	}{
+		{"resume", func(path string) error { return writeCheckpoint(path, s.Checkpoint()) }, ""},
+		{"garbage", func(path string) error { return os.WriteFile(path, []byte("{"), 0o644) }, "BAD_CHECKPOINT"},
+		{"other board", func(path string) error {
+			cp := *s.Checkpoint()
+			cp.Board = emptyBoard(11)
+			return writeCheckpoint(path, &cp)
+		}, "BAD_CHECKPOINT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cp.json")
+			if err := tt.write(path); err != nil {
+				t.Fatal(err)
+			}
+			var req InRequest
+			if err := json.Unmarshal([]byte(in), &req); err != nil {
+				t.Fatal(err)
+			}
+			req.Output.MaxSolutions = 1
+			start := time.Now()
+			resp := handleComplete(context.Background(), req, rand.New(rand.NewSource(req.Seed)), latin.RNGLegacy, start.Add(10*time.Second),
+				start.Unix(), start, "test", nil, path, nil, nil)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, ok := resp.Result.(ResultComplete)
+			if resp.Status != "done" || !ok || !res.VerifiedLatin {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			debug, _ := resp.Debug.(DebugInfo)
+			if !strings.Contains(debug.Notes, "resumed from checkpoint") {
+				t.Errorf("notes %q: not resumed", debug.Notes)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("checkpoint left behind after the search ended: %v", err)
+			}
+		})
+	}
+}
+
+// emptyBoard is an n x n board with every cell empty.
+func emptyBoard(n int) [][]int {
+	b := make([][]int, n)
+	for i := range b {
+		b[i] = slices.Repeat([]int{-1}, n)
+	}
+	return b
+}
+
+func TestCountFixFirstRow(t *testing.T) {
+	const prefix = `[[0,1,2,3,4],[null,null,null,null,null],[null,null,null,null,null],[null,null,null,null,null],[null,null,null,null,null]]`
+	tests := []struct {
+		fix          bool
+		count, total int64
+	}{
+		{fix: false, count: 1344},
+		// 4 пустые строки упорядочены: 1344 / 4! классов, total — все дополнения
+		{fix: true, count: 56, total: 1344},
+	}
+	nodes := map[bool]int64{}
+	for _, tt := range tests {
+		resp := solve(t, `{"problem":"count_latin_completions","budget":{"time_limit_sec":10},
+			"payload":{"n":5,"constraints":{"symmetry_breaking":{"fix_first_row":`+strconv.FormatBool(tt.fix)+`}},"prefix":`+prefix+`}}`)
+		res, ok := resp.Result.(ResultCount)
+		if !ok || !res.Exact || res.Count != tt.count || res.Total != tt.total {
+			t.Fatalf("fix=%v: status %q, result %+v", tt.fix, resp.Status, resp.Result)
+		}
+		debug, _ := resp.Debug.(DebugInfo)
+		nodes[tt.fix] = debug.Nodes
+	}
+	if nodes[true] >= nodes[false] {
+		t.Errorf("fix_first_row: %d nodes, without: %d", nodes[true], nodes[false])
+	}
+}
+
+func TestReduced(t *testing.T) {
+	// число приведённых латинских квадратов порядка n (OEIS A000315)
+	for _, tt := range []struct {
+		n     int
+		count int64
+	}{{1, 1}, {2, 1}, {3, 1}, {4, 4}, {5, 56}} {
+		for _, engine := range []string{"dfs", "dlx"} {
+			resp := solve(t, `{"problem":"count_latin_completions","budget":{"time_limit_sec":10},
+				"payload":{"n":`+strconv.Itoa(tt.n)+`,"engine":"`+engine+`","constraints":{"reduced":true},"prefix":`+nullPrefix(tt.n)+`}}`)
+			res, ok := resp.Result.(ResultCount)
+			if !ok || !res.Exact || res.Count != tt.count {
+				t.Errorf("n=%d %s: status %q, result %+v; want count %d", tt.n, engine, resp.Status, resp.Result, tt.count)
+			}
+		}
+	}
+
+	isReduced := func(sq [][]int) bool {
+		for k := range sq {
+			if sq[0][k] != k || sq[k][0] != k {
+				return false
+			}
+		}
+		return latin.IsLatinSquare(sq)
+	}
+	for _, tt := range []struct {
+		name   string
+		n      int
+		prefix string
+		code   string
+	}{
+		{"empty", 6, nullPrefix(6), ""},
+		{"agreeing prefix", 4, `[[0,null,null,null],[null,null,null,null],[2,3,null,null],[null,null,null,null]]`, ""},
+		{"first row out of order", 4, `[[null,2,null,null],[null,null,null,null],[null,null,null,null],[null,null,null,null]]`, "INVALID_PREFIX"},
+		{"first column out of order", 4, `[[null,null,null,null],[null,null,null,null],[null,null,null,null],[1,null,null,null]]`, "INVALID_PREFIX"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":2,"budget":{"time_limit_sec":10},
+				"payload":{"n":`+strconv.Itoa(tt.n)+`,"constraints":{"reduced":true},"prefix":`+tt.prefix+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultComplete)
+			if resp.Status != "done" || !res.VerifiedLatin || !isReduced(res.Square) {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+		})
+	}
+}
+
+func isSymmetricLatin(sq [][]int) bool { return latin.IsLatinSquare(sq) && latin.IsSymmetric(sq) }
+
+func TestCompleteConstraints(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		cons   string // JSON constraints
+		prefix string // "" — пустой
+		check  func([][]int) bool
+		code   string // код ошибки; "" — успех
+	}{
+		{"diagonal empty 5x5", 5, `{"diagonal":true}`, "", latin.IsDiagonalLatinSquare, ""},
+		{"diagonal 2x2", 2, `{"diagonal":true}`, "", nil, ""},
+		{"diagonal repeat in prefix", 3, `{"diagonal":true}`, `[[0,null,null],[null,0,null],[null,null,null]]`, nil, "INVALID_PREFIX"},
+		{"symmetric empty 4x4", 4, `{"symmetric":true}`, "", isSymmetricLatin, ""},
+		{"symmetric mirrored prefix", 4, `{"symmetric":true}`, `[[null,2,null,null],[null,null,null,null],[null,null,null,3],[null,null,null,null]]`, isSymmetricLatin, ""},
+		// (i,j) и (j,i) заданы одинаково — это не повтор
+		{"symmetric consistent mirror pair", 4, `{"symmetric":true}`, `[[null,2,null,null],[2,null,null,null],[null,null,null,3],[null,null,3,null]]`, isSymmetricLatin, ""},
+		{"symmetric consistent pair and diagonal", 3, `{"symmetric":true}`, `[[0,1,null],[1,2,null],[null,null,null]]`, isSymmetricLatin, ""},
+		{"symmetric asymmetric prefix", 3, `{"symmetric":true}`, `[[null,1,null],[2,null,null],[null,null,null]]`, nil, "INVALID_PREFIX"},
+		{"symmetric mismatched pair 4x4", 4, `{"symmetric":true}`, `[[null,2,null,null],[2,null,null,null],[null,null,null,3],[null,null,1,null]]`, nil, "INVALID_PREFIX"},
+		{"symmetric repeat once mirrored", 3, `{"symmetric":true}`, `[[null,1,null],[null,null,null],[1,null,null]]`, nil, "INVALID_PREFIX"},
+		{"boxes 4x4", 4, `{"boxes":true}`, `[[0,null,null,null],[null,null,2,null],[null,3,null,null],[null,null,null,1]]`, latin.IsSudoku, ""},
+		{"boxes empty 9x9", 9, `{"boxes":true}`, "", latin.IsSudoku, ""},
+		{"boxes n not a square", 5, `{"boxes":true}`, "", nil, "BAD_CONSTRAINTS"},
+		{"boxes repeat in prefix", 4, `{"boxes":true}`, `[[0,null,null,null],[null,0,null,null],[null,null,null,null],[null,null,null,null]]`, nil, "INVALID_PREFIX"},
+		{"symmetric with diagonal", 4, `{"symmetric":true,"diagonal":true}`, "", nil, "BAD_CONSTRAINTS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := tt.prefix
+			if prefix == "" {
+				prefix = nullPrefix(tt.n)
+			}
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":{"time_limit_sec":10},
+				"payload":{"n":`+strconv.Itoa(tt.n)+`,"constraints":`+tt.cons+`,"prefix":`+prefix+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultComplete)
+			if tt.check == nil {
+				// такого квадрата нет
+				if resp.Status != "no_solution" || res.SolutionFound {
+					t.Fatalf("status %q, result %+v; want no_solution", resp.Status, resp.Result)
+				}
+				return
+			}
+			if resp.Status != "done" || !res.VerifiedLatin || !tt.check(res.Square) {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			var cells [][]*int
+			if err := json.Unmarshal([]byte(prefix), &cells); err != nil {
+				t.Fatal(err)
+			}
+			for i := range cells {
+				for j, v := range cells[i] {
+					if v != nil && res.Square[i][j] != *v {
+						t.Errorf("(%d,%d) = %d, prefix says %d", i, j, res.Square[i][j], *v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestUniformRandomNotes(t *testing.T) {
+	const prefix = `[[0,1,2,3],[null,null,null,null],[null,null,null,null],[null,null,null,null]]`
+	tests := []struct {
+		name   string
+		budget string
+		note   string
+	}{
+		{"exhausted", `{"time_limit_sec":10}`, "uniform_random: sampled from all 24 completions"},
+		{"cut by max_nodes", `{"time_limit_sec":10,"max_nodes":30}`, "uniform_random: approximate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":`+tt.budget+`,
+				"output":{"uniform_random":true},"payload":{"n":4,"prefix":`+prefix+`}}`)
+			res, ok := resp.Result.(ResultComplete)
+			if !ok || !res.SolutionFound || !res.VerifiedLatin || !slices.Equal(res.Square[0], []int{0, 1, 2, 3}) {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			debug, _ := resp.Debug.(DebugInfo)
+			if !strings.Contains(debug.Notes, tt.note) {
+				t.Errorf("notes %q, want %q", debug.Notes, tt.note)
+			}
+		})
+	}
+}
+
+func TestMaxN(t *testing.T) {
+	square := func(n int) string {
+		b, _ := json.Marshal(latin.MakeCyclic(n, 1))
+		return string(b)
+	}
+	tests := []struct {
+		name string
+		in   string
+		code string // "" — принят
+	}{
+		{"complete", `{"problem":"complete_latin_square_from_prefix","payload":{"n":1000000000}}`, "N_TOO_LARGE"},
+		{"count", `{"problem":"count_latin_completions","payload":{"n":1000000000}}`, "N_TOO_LARGE"},
+		{"search_mols", `{"problem":"search_mols","payload":{"n":1000000000,"k":2}}`, "N_TOO_LARGE"},
+		{"random", `{"problem":"random_latin_square","payload":{"n":1000000000}}`, "N_TOO_LARGE"},
+		{"just above", `{"problem":"random_latin_square","payload":{"n":11}}`, "N_TOO_LARGE"},
+		{"at the ceiling", `{"problem":"random_latin_square","payload":{"n":10}}`, ""},
+		{"verify", `{"problem":"verify_latin_square","payload":{"square":` + square(11) + `}}`, "N_TOO_LARGE"},
+		{"verify at the ceiling", `{"problem":"verify_latin_square","payload":{"square":` + square(10) + `}}`, ""},
+		{"orthogonal", `{"problem":"check_orthogonal","payload":{"a":` + square(11) + `,"b":` + square(11) + `}}`, "N_TOO_LARGE"},
+		{"orthogonal at the ceiling", `{"problem":"check_orthogonal","payload":{"a":` + square(10) + `,"b":` + square(10) + `}}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, _, err := readInBytes(t, []byte(tt.in), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			resp := runRequest(context.Background(), reqs[0], runEnv{host: "test", maxN: 10, noMinRuntime: true})
+			runtime.ReadMemStats(&after)
+			if tt.code == "" {
+				if resp.Error != nil {
+					t.Fatalf("n at -max-n rejected: %+v", resp.Error)
+				}
+				return
+			}
+			if resp.Status != "invalid_input" || resp.Error == nil || resp.Error.Code != tt.code {
+				t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+			}
+			// отказ до обработчика: никаких n x n
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+				t.Errorf("rejecting n allocated %d bytes", alloc)
+			}
+		})
+	}
+}
+
+func TestMOLSConflictCells(t *testing.T) {
+	tests := []struct {
+		name   string
+		k      int
+		output string
+		want   bool
+	}{
+		{"off by default", 2, `{"return_squares":true}`, false},
+		{"on", 2, `{"return_squares":true,"return_conflict_cells":true}`, true},
+		{"every pair of k=3", 3, `{"return_squares":true,"return_conflict_cells":true}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// n=10 за 500 шагов пару не найти
+			resp := solve(t, `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":10,"max_steps":500},
+				"output":`+tt.output+`,"payload":{"n":10,"k":`+strconv.Itoa(tt.k)+`}}`)
+			res, ok := resp.Result.(ResultMOLS)
+			if !ok || res.Found || len(res.L) != tt.k {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			if !tt.want {
+				if res.ConflictCells != nil {
+					t.Errorf("conflict cells without the flag: %v", res.ConflictCells)
+				}
+				return
+			}
+			if want := latin.SetConflictCells(res.L); len(want) == 0 || !slices.Equal(res.ConflictCells, want) {
+				t.Errorf("conflict cells %v, want %v", res.ConflictCells, want)
+			}
+			// у каждой пары квадратов её конфликтные клетки — в списке
+			for a := range res.L {
+				for b := a + 1; b < len(res.L); b++ {
+					for _, c := range latin.ConflictCells(res.L[a], res.L[b]) {
+						if !slices.Contains(res.ConflictCells, c) {
+							t.Errorf("pair (%d,%d): cell %v missing", a, b, c)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	complete := `{"problem":"complete_latin_square_from_prefix","task_id":"a","seed":1,"payload":{"n":5,"prefix":` + nullPrefix(5) + `}}`
+	mols := `{"problem":"search_mols","task_id":"b","seed":1,"budget":{"time_limit_sec":10},"payload":{"n":5,"k":2}}`
+	bad := `{"problem":"search_mols","task_id":"c","payload":{"n":1,"k":2}}`
+	tests := []struct {
+		name     string
+		in       string
+		problems []string
+		statuses []string
+		code     int
+	}{
+		{"complete and MOLS", "[" + complete + "," + mols + "]",
+			[]string{"complete_latin_square_from_prefix", "search_mols"}, []string{"done", "done"}, exitSolved},
+		{"order kept", "[" + mols + "," + complete + "]",
+			[]string{"search_mols", "complete_latin_square_from_prefix"}, []string{"done", "done"}, exitSolved},
+		// код выхода — первой задачи не с exitSolved
+		{"one invalid", "[" + complete + "," + bad + "," + mols + "]",
+			[]string{"complete_latin_square_from_prefix", "search_mols", "search_mols"}, []string{"done", "invalid_input", "done"}, exitInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, code := worker(t, tt.in)
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d", code, tt.code)
+			}
+			var resps []OutResponse
+			if err := json.Unmarshal(out, &resps); err != nil {
+				t.Fatalf("out.json is not an array of responses: %v\n%s", err, out)
+			}
+			if len(resps) != len(tt.problems) {
+				t.Fatalf("%d responses, want %d", len(resps), len(tt.problems))
+			}
+			for k, r := range resps {
+				if r.Problem != tt.problems[k] || r.Status != tt.statuses[k] {
+					t.Errorf("response %d: %s/%s, want %s/%s", k, r.Problem, r.Status, tt.problems[k], tt.statuses[k])
+				}
+			}
+		})
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		// check разбирает промежуточный result
+		check func(t *testing.T, result json.RawMessage)
+	}{
+		{"complete", `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":{"time_limit_sec":20},
+			"payload":{"n":100,"check_every":64,"prefix":` + nullPrefix(100) + `}}`, func(t *testing.T, result json.RawMessage) {
+			var res ResultComplete
+			if err := json.Unmarshal(result, &res); err != nil || len(res.Partial) != 100 || res.Filled == 0 {
+				t.Errorf("partial board: err %v, %d rows, %d filled", err, len(res.Partial), res.Filled)
+			}
+		}},
+		{"search_mols", `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":20,"max_steps":1000000000},
+			"payload":{"n":10,"k":3}}`, func(t *testing.T, result json.RawMessage) {
+			var res ResultMOLS
+			if err := json.Unmarshal(result, &res); err != nil || len(res.L) != 3 || res.Conflicts == 0 {
+				t.Errorf("best set: err %v, %d squares, %d conflicts", err, len(res.L), res.Conflicts)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			inPath, outPath := filepath.Join(dir, "in.json"), filepath.Join(dir, "out.json")
+			if err := os.WriteFile(inPath, []byte(tt.in), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			cmd := workerCmd("-in", inPath, "-out", outPath, "-flush-interval", "50ms")
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = cmd.Process.Kill()
+				_ = cmd.Wait()
+			}()
+			// снимок должен появиться задолго до time_limit; файл пишется
+			// через rename, поэтому любое прочитанное содержимое — целый JSON
+			var snap struct {
+				Status string          `json:"status"`
+				Result json.RawMessage `json:"result"`
+			}
+			for start := time.Now(); ; time.Sleep(20 * time.Millisecond) {
+				if time.Since(start) > 10*time.Second {
+					t.Fatal("no snapshot written within 10s")
+				}
+				b, err := os.ReadFile(outPath)
+				if os.IsNotExist(err) {
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := json.Unmarshal(b, &snap); err != nil {
+					t.Fatalf("snapshot is not valid JSON: %v\n%s", err, b)
+				}
+				break
+			}
+			if snap.Status != "running" {
+				t.Fatalf("snapshot status %q, want running", snap.Status)
+			}
+			tt.check(t, snap.Result)
+		})
+	}
+}
+
+func TestEnginesAgree(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string // без engine
+	}{
+		{"empty 4x4", `"n":4,"prefix":` + nullPrefix(4)},
+		{"prefix 4x4", `"n":4,"prefix":[[0,null,null,null],[null,null,1,null],[null,null,null,null],[null,3,null,null]]`},
+		{"dead prefix 4x4", `"n":4,"prefix":[[0,1,2,null],[null,null,null,3],[null,null,null,null],[null,null,null,null]]`},
+		{"diagonal 5x5", `"n":5,"constraints":{"diagonal":true},"prefix":` + nullPrefix(5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count := map[string]int64{}
+			found := map[string]bool{}
+			for _, engine := range []string{"dfs", "dlx"} {
+				payload := `{"engine":"` + engine + `",` + tt.payload + `}`
+				resp := solve(t, `{"problem":"count_latin_completions","budget":{"time_limit_sec":10},"payload":`+payload+`}`)
+				res, ok := resp.Result.(ResultCount)
+				if !ok || !res.Exact {
+					t.Fatalf("%s count: status %q, result %+v", engine, resp.Status, resp.Result)
+				}
+				count[engine] = res.Count
+				resp = solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":{"time_limit_sec":10},"payload":`+payload+`}`)
+				c, _ := resp.Result.(ResultComplete)
+				found[engine] = c.SolutionFound
+				if c.SolutionFound && !c.VerifiedLatin {
+					t.Errorf("%s: square %v not verified", engine, c.Square)
+				}
+			}
+			if count["dfs"] != count["dlx"] || found["dfs"] != found["dlx"] || found["dfs"] != (count["dfs"] > 0) {
+				t.Errorf("dfs: %d, found %v; dlx: %d, found %v", count["dfs"], found["dfs"], count["dlx"], found["dlx"])
+			}
+		})
+	}
+}
+
+func TestSolveWallExcludesMinRuntime(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		status string
+		slack  int64 // мс сверх time_limit: дедлайн сверяется раз в CheckEvery узлов
+	}{
+		{"quick", `{"problem":"verify_latin_square","budget":{"time_limit_sec":1,"min_runtime_sec":2},
+			"payload":{"square":[[0,1],[1,0]]}}`, "done", 0},
+		{"timeout", `{"problem":"count_latin_completions","budget":{"time_limit_sec":1,"min_runtime_sec":2},
+			"payload":{"n":8,"prefix":` + nullPrefix(8) + `}}`, "timeout", 250},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, _, err := readInBytes(t, []byte(tt.in), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := runRequest(context.Background(), reqs[0], runEnv{host: "test", maxN: 2000})
+			m := resp.Metrics
+			if resp.Status != tt.status {
+				t.Fatalf("status %q, want %q", resp.Status, tt.status)
+			}
+			if m.SolveWallMS > 1000+tt.slack {
+				t.Errorf("solve_wall_ms %d over time_limit_sec=1", m.SolveWallMS)
+			}
+			if m.WallMS < 2000 || m.WallMS < m.SolveWallMS {
+				t.Errorf("wall_ms %d: min_runtime_sec=2 not padded (solve_wall_ms %d)", m.WallMS, m.SolveWallMS)
+			}
+		})
+	}
+}
+
+func TestNoMinRuntime(t *testing.T) {
+	// и явный min_runtime_sec, и умолчание 5s флаг отменяет
+	for _, budget := range []string{`{"min_runtime_sec":3}`, `{}`} {
+		in := `{"problem":"verify_latin_square","budget":` + budget + `,"payload":{"square":[[0,1],[1,0]]}}`
+		reqs, _, err := readInBytes(t, []byte(in), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := runRequest(context.Background(), reqs[0], runEnv{host: "test", maxN: 2000, noMinRuntime: true})
+		if m := resp.Metrics; resp.Status != "done" || m.WallMS > m.SolveWallMS+100 {
+			t.Errorf("budget %s: status %q, wall_ms %d, solve_wall_ms %d; want no padding", budget, resp.Status, m.WallMS, m.SolveWallMS)
+		}
+	}
+
+	// через флаг командной строки
+	start := time.Now()
+	out, code := worker(t, `{"problem":"verify_latin_square","payload":{"square":[[0,1],[1,0]]}}`, "-no-min-runtime")
+	var resp OutResponse
+	if err := json.Unmarshal(out, &resp); err != nil || code != exitSolved {
+		t.Fatalf("exit %d, out.json %q: %v", code, out, err)
+	}
+	if took := time.Since(start); took > 2*time.Second || resp.Metrics.WallMS > 1000 {
+		t.Errorf("worker took %v, wall_ms %d; want no 5s padding", took, resp.Metrics.WallMS)
+	}
+}
+
+func TestFindOrthogonalMateRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		base   string
+		status string
+		code   string
+	}{
+		{"cyclic 5", 5, `[[0,1,2,3,4],[1,2,3,4,0],[2,3,4,0,1],[3,4,0,1,2],[4,0,1,2,3]]`, "done", ""},
+		{"cyclic 4", 4, `[[0,1,2,3],[1,2,3,0],[2,3,0,1],[3,0,1,2]]`, "no_solution", ""},
+		{"not Latin", 3, `[[0,1,2],[1,2,0],[1,2,0]]`, "invalid_input", "INVALID_BASE"},
+		{"not square", 2, `[[0,1],[1,0],[0,1]]`, "invalid_input", "BAD_BASE_SHAPE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"find_orthogonal_mate","seed":1,"budget":{"time_limit_sec":10},"payload":{"n":`+strconv.Itoa(tt.n)+`,"base":`+tt.base+`}}`)
+			if resp.Status != tt.status {
+				t.Fatalf("status %q, want %q (error %+v)", resp.Status, tt.status, resp.Error)
+			}
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Errorf("error %+v, want %s", resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultMate)
+			if res.Found != (tt.status == "done") || res.Verified != res.Found {
+				t.Errorf("result %+v", res)
+			}
+		})
+	}
+}
+
+func TestRandomLatinSquare(t *testing.T) {
+	tests := []struct {
+		n      int
+		method string
+		code   string
+	}{
+		{6, "cyclic_permute", ""},
+		{6, "mcmc", ""},
+		{6, "", ""}, // по умолчанию
+		{1, "mcmc", ""},
+		{6, "shuffle", "BAD_METHOD"},
+		{0, "mcmc", "BAD_N"},
+		{latin.MaxMCMCN + 1, "mcmc", "N_TOO_LARGE"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("n=%d %s", tt.n, tt.method), func(t *testing.T) {
+			resp := solve(t, fmt.Sprintf(`{"problem":"random_latin_square","seed":3,"payload":{"n":%d,"method":%q}}`, tt.n, tt.method))
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, ok := resp.Result.(ResultRandom)
+			if resp.Status != "done" || !ok || !res.VerifiedLatin || len(res.Square) != tt.n || !latin.IsLatinSquare(res.Square) {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			if tt.method == "mcmc" && tt.n > 1 && res.Steps == 0 {
+				t.Errorf("mcmc made no moves")
+			}
+		})
+	}
+}
+
+func TestPrefixExtremes(t *testing.T) {
+	const full = `[[0,1,2,3],[1,0,3,2],[2,3,0,1],[3,2,1,0]]`
+	tests := []struct {
+		name   string
+		extra  string // доп. поля payload
+		prefix string
+		note   string
+		code   string
+	}{
+		{"complete", "", full, "prefix already complete", ""},
+		{"complete dlx", `"engine":"dlx",`, full, "prefix already complete", ""},
+		{"complete with repeat", "", `[[0,1,2,3],[1,0,3,2],[2,3,0,1],[3,2,0,1]]`, "", "INVALID_PREFIX"},
+		{"empty", "", nullPrefix(4), "prefix is empty", ""},
+		{"empty dlx", `"engine":"dlx",`, nullPrefix(4), "prefix is empty", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":{"time_limit_sec":10},
+				"payload":{`+tt.extra+`"n":4,"prefix":`+tt.prefix+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, ok := resp.Result.(ResultComplete)
+			if resp.Status != "done" || !ok || !res.VerifiedLatin {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			debug, _ := resp.Debug.(DebugInfo)
+			if !strings.Contains(debug.Notes, tt.note) {
+				t.Errorf("notes %q, want %q", debug.Notes, tt.note)
+			}
+			if tt.prefix == full {
+				if debug.Nodes != 0 {
+					t.Errorf("%d nodes for a complete prefix", debug.Nodes)
+				}
+				if b, _ := json.Marshal(res.Square); string(b) != full {
+					t.Errorf("square %s, want the prefix", b)
+				}
+			}
+		})
+	}
+}
+
+func TestPrefixShape(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string // "" — поле prefix не передаётся
+		code    string
+		details map[string]interface{}
+	}{
+		{"missing", "", "PREFIX_NULL", nil},
+		{"null", `null`, "PREFIX_NULL", nil},
+		{"too few rows", `[[0,1,2],[null,null,null]]`, "BAD_PREFIX_SHAPE", nil},
+		{"null row", `[[0,1,2],null,[null,null,null]]`, "PREFIX_ROW_NULL", map[string]interface{}{"row": 1}},
+		{"short row", `[[0,1,2],[null,null,null],[null,null]]`, "PREFIX_ROW_LEN_MISMATCH", map[string]interface{}{"row": 2, "len": 2, "n": 3}},
+		{"long row", `[[0,1,2,null],[null,null,null],[null,null,null]]`, "PREFIX_ROW_LEN_MISMATCH", map[string]interface{}{"row": 0, "len": 4, "n": 3}},
+		{"empty row", `[[],[null,null,null],[null,null,null]]`, "PREFIX_ROW_LEN_MISMATCH", map[string]interface{}{"row": 0, "len": 0, "n": 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := `{"n":3}`
+			if tt.prefix != "" {
+				payload = `{"n":3,"prefix":` + tt.prefix + `}`
+			}
+			for _, problem := range []string{"complete_latin_square_from_prefix", "count_latin_completions"} {
+				resp := solve(t, `{"problem":"`+problem+`","payload":`+payload+`}`)
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("%s: status %q, error %+v; want %s", problem, resp.Status, resp.Error, tt.code)
+				}
+				if fmt.Sprint(resp.Error.Details) != fmt.Sprint(tt.details) {
+					t.Errorf("%s: details %v, want %v", problem, resp.Error.Details, tt.details)
+				}
+			}
+		})
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string // "" — поля нет
+		echo    int
+		status  string
+		exit    int
+	}{
+		{"missing", "", 1, "done", exitSolved},
+		{"zero", `"schema_version":0,`, 1, "done", exitSolved},
+		{"current", `"schema_version":1,`, 1, "done", exitSolved},
+		{"too new", `"schema_version":2,`, 2, "invalid_input", exitInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, code := worker(t, `{`+tt.version+`"problem":"verify_latin_square","payload":{"square":[[0,1],[1,0]]}}`)
+			var resp struct {
+				SchemaVersion int       `json:"schema_version"`
+				Status        string    `json:"status"`
+				Error         *OutError `json:"error"`
+			}
+			if err := json.Unmarshal(out, &resp); err != nil {
+				t.Fatalf("out.json: %v\n%s", err, out)
+			}
+			if code != tt.exit || resp.Status != tt.status || resp.SchemaVersion != tt.echo {
+				t.Errorf("exit %d, status %q, schema_version %d; want %d, %q, %d", code, resp.Status, resp.SchemaVersion, tt.exit, tt.status, tt.echo)
+			}
+			if tt.status == "invalid_input" && (resp.Error == nil || resp.Error.Code != "UNSUPPORTED_SCHEMA") {
+				t.Errorf("error %+v, want UNSUPPORTED_SCHEMA", resp.Error)
+			}
+		})
+	}
+}
+
+func TestRootCandidates(t *testing.T) {
+	// (0,2) может быть только 2, а 2 уже в столбце: клетка мертва с корня
+	const dead = `[[0,1,null],[null,null,2],[null,null,null]]`
+	tests := []struct {
+		name   string
+		output string
+		extra  string
+		want   string // JSON root_candidates; "" — их нет
+	}{
+		{"off by default", `{}`, "", ""},
+		{"on", `{"return_root_candidates":true}`, "", `[[null,null,[]],[[1],[0],null],[[1,2],[0,2],[0,1]]]`},
+		{"on, symbols", `{"return_root_candidates":true}`, `"symbols":[5,6,7],`, `[[null,null,[]],[[6],[5],null],[[6,7],[5,7],[5,6]]]`},
+		// (1,0) = (2,2) = 0, а в symmetric и зеркальная (0,1); в (0,0) запрещены 1 и 2,
+		// остаётся 0 — повтор в строке 0, дополнения нет
+		{"on, symmetric", `{"return_root_candidates":true}`,
+			`"constraints":{"symmetric":true},"equal":[[[1,0],[2,2]]],"forbidden":[[[1,2],[],[]],[[],[],[]],[[],[],[]]],`,
+			`[[[0],[0],[1,2]],[[0],[0,1,2],[1,2]],[[1,2],[1,2],null]]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := dead
+			switch {
+			case strings.Contains(tt.extra, "symmetric"):
+				prefix = `[[null,null,null],[null,null,null],[null,null,0]]`
+			case tt.extra != "":
+				prefix = `[[5,6,null],[null,null,7],[null,null,null]]`
+			}
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","output":`+tt.output+`,
+				"payload":{`+tt.extra+`"n":3,"prefix":`+prefix+`}}`)
+			if resp.Status != "no_solution" {
+				t.Fatalf("status %q, want no_solution", resp.Status)
+			}
+			debug, _ := resp.Debug.(DebugInfo)
+			got := ""
+			if debug.RootCandidates != nil {
+				b, _ := json.Marshal(debug.RootCandidates)
+				got = string(b)
+			}
+			if got != tt.want {
+				t.Errorf("root_candidates %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	const in = `{"problem":"complete_latin_square_from_prefix","seed":1,"output":{"max_solutions":3},
+		"payload":{"n":4,"prefix":[[0,null,null,null],[null,null,null,null],[null,null,null,null],[null,null,null,null]]}}`
+	tests := []struct {
+		name           string
+		inGz           bool
+		inName, output string
+	}{
+		{"gzip in, plain out", true, "in.json.gz", "out.json"},
+		{"plain in, gzip out", false, "in.json", "out.json.gz"},
+		// вход узнаётся по сигнатуре, а не по суффиксу
+		{"gzip in without suffix", true, "in.json", "out.json.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			inPath, outPath := filepath.Join(dir, tt.inName), filepath.Join(dir, tt.output)
+			b := []byte(in)
+			if tt.inGz {
+				b = gzipBytes(b)
+			}
+			if err := os.WriteFile(inPath, b, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := workerCmd("-in", inPath, "-out", outPath).Run(); err != nil {
+				t.Fatalf("worker: %v", err)
+			}
+			out, err := os.ReadFile(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasSuffix(tt.output, ".gz") {
+				if out, err = gunzip(out); err != nil {
+					t.Fatalf("out is not gzip: %v", err)
+				}
+			}
+			var resp struct {
+				Status string         `json:"status"`
+				Result ResultComplete `json:"result"`
+			}
+			if err := json.Unmarshal(out, &resp); err != nil {
+				t.Fatalf("out is not JSON: %v\n%s", err, out)
+			}
+			if resp.Status != "solution_limit" || len(resp.Result.Squares) != 3 {
+				t.Errorf("status %q, %d squares", resp.Status, len(resp.Result.Squares))
+			}
+		})
+	}
+}
+
+func TestMOLSSeedSquaresRequest(t *testing.T) {
+	const a, b = `[[0,1,2],[1,2,0],[2,0,1]]`, `[[0,1,2],[2,0,1],[1,2,0]]` // ортогональны
+	tests := []struct {
+		name string
+		seed string
+		code string
+	}{
+		{"orthogonal pair", "[" + a + "," + b + "]", ""},
+		{"too many", "[" + a + "," + b + "," + a + "]", "BAD_SEED_SQUARES"},
+		{"wrong size", `[[[0,1],[1,0]]]`, "BAD_SEED_SQUARES"},
+		{"not Latin", `[[[0,1,2],[1,2,0],[1,2,0]]]`, "BAD_SEED_SQUARES"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":10},
+				"payload":{"n":3,"k":2,"seed_squares":`+tt.seed+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultMOLS)
+			debug, _ := resp.Debug.(DebugInfo)
+			if !res.Found || res.Conflicts != 0 || debug.Steps != 0 {
+				t.Errorf("found %v, conflicts %d, steps %d: want an instant hit", res.Found, res.Conflicts, debug.Steps)
+			}
+		})
+	}
+}
+
+func TestCompleteForbidden(t *testing.T) {
+	// forbidden: n x n списков; пустой список — без запретов
+	forbid := func(n int, cells map[[2]int][]int) string {
+		f := make([][][]int, n)
+		for i := range f {
+			f[i] = make([][]int, n)
+			for j := range f[i] {
+				f[i][j] = append([]int{}, cells[[2]int{i, j}]...)
+			}
+		}
+		b, _ := json.Marshal(f)
+		return string(b)
+	}
+	req := func(extra string) OutResponse {
+		return solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":{"time_limit_sec":10},
+			"payload":{"n":4,"prefix":`+nullPrefix(4)+extra+`}}`)
+	}
+	free, _ := req("").Result.(ResultComplete)
+	if !free.SolutionFound {
+		t.Fatal("no completion without forbidden values")
+	}
+	tests := []struct {
+		name    string
+		extra   string // добавка к payload
+		cells   map[[2]int][]int
+		symbols []int
+		found   bool
+		code    string // код ошибки; "" — успех
+	}{
+		{"value of the free completion", "", map[[2]int][]int{{1, 0}: {free.Square[1][0]}}, nil, true, ""},
+		{"whole cell", "", map[[2]int][]int{{2, 2}: {0, 1, 2, 3}}, nil, false, ""},
+		{"with symbols", `,"symbols":[1,2,3,4]`, map[[2]int][]int{{0, 0}: {1, 2}}, []int{1, 2, 3, 4}, true, ""},
+		{"out of range", "", map[[2]int][]int{{0, 1}: {4}}, nil, false, "BAD_FORBIDDEN"},
+		{"not in symbols", `,"symbols":[1,2,3,4]`, map[[2]int][]int{{0, 1}: {0}}, nil, false, "BAD_FORBIDDEN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := req(tt.extra + `,"forbidden":` + forbid(4, tt.cells))
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultComplete)
+			if !tt.found {
+				if resp.Status != "no_solution" || res.SolutionFound {
+					t.Fatalf("status %q, result %+v; want no_solution", resp.Status, resp.Result)
+				}
+				return
+			}
+			if resp.Status != "done" || !res.VerifiedLatin {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			for c, vs := range tt.cells {
+				if slices.Contains(vs, res.Square[c[0]][c[1]]) {
+					t.Errorf("(%d,%d) = %d, forbidden %v", c[0], c[1], res.Square[c[0]][c[1]], vs)
+				}
+			}
+			if tt.symbols == nil && slices.EqualFunc(res.Square, free.Square, slices.Equal) {
+				t.Errorf("same completion %v as without forbidden values", res.Square)
+			}
+		})
+	}
+
+	// не n x n
+	for _, bad := range []string{`[[[]]]`, `[[],[],[],[]]`} {
+		if resp := req(`,"forbidden":` + bad); resp.Error == nil || resp.Error.Code != "BAD_FORBIDDEN" {
+			t.Errorf("forbidden %s: status %q, error %+v; want BAD_FORBIDDEN", bad, resp.Status, resp.Error)
+		}
+	}
+}
+
+func TestCompleteCellPairs(t *testing.T) {
+	// (0,0)=2; (1,1) без связей свободна, equal с (0,0) делает её 2
+	const prefix = `[[2,null,null,null],[null,null,null,null],[null,null,null,null],[null,null,null,null]]`
+	req := func(problem, extra string) OutResponse {
+		return solve(t, `{"problem":"`+problem+`","seed":1,"budget":{"time_limit_sec":10},
+			"payload":{"n":4,"prefix":`+prefix+extra+`}}`)
+	}
+	count := func(extra string) int64 {
+		t.Helper()
+		res, ok := req("count_latin_completions", extra).Result.(ResultCount)
+		if !ok || !res.Exact {
+			t.Fatalf("count %s: result %+v", extra, res)
+		}
+		return res.Count
+	}
+
+	if rc := rootCandidates(PayloadComplete{N: 4}, [][]int{{2, -1, -1, -1}, {-1, -1, -1, -1}, {-1, -1, -1, -1}, {-1, -1, -1, -1}}, nil); len(rc[1][1]) < 2 {
+		t.Fatalf("(1,1) is not free without the pair: candidates %v", rc[1][1])
+	}
+	forced := `,"equal":[[[1,1],[0,0]]]`
+	resp := req("complete_latin_square_from_prefix", forced)
+	res, _ := resp.Result.(ResultComplete)
+	if resp.Status != "done" || !res.VerifiedLatin || res.Square[1][1] != 2 {
+		t.Fatalf("equal: status %q, result %+v; want (1,1) = 2", resp.Status, resp.Result)
+	}
+	// ровно столько дополнений, сколько с 2 в (1,1) прямо в префиксе
+	all, withEqual := count(""), count(forced)
+	fixed := solve(t, `{"problem":"count_latin_completions","budget":{"time_limit_sec":10},
+		"payload":{"n":4,"prefix":[[2,null,null,null],[null,2,null,null],[null,null,null,null],[null,null,null,null]]}}`)
+	if want := fixed.Result.(ResultCount).Count; withEqual != want || withEqual >= all {
+		t.Errorf("equal: %d completions, want %d (of %d)", withEqual, want, all)
+	}
+	if withNot := count(`,"not_equal":[[[1,1],[0,0]]]`); withNot != all-withEqual {
+		t.Errorf("not_equal: %d completions, want %d", withNot, all-withEqual)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		extra string
+		code  string
+	}{
+		{"off the board", `,"equal":[[[0,4],[1,1]]]`, "BAD_CELL_PAIRS"},
+		{"not_equal to itself", `,"not_equal":[[[2,3],[2,3]]]`, "BAD_CELL_PAIRS"},
+		{"equal in one row", `,"equal":[[[1,1],[2,2]],[[2,2],[1,3]]]`, "BAD_CELL_PAIRS"},
+		{"not_equal inside equal", `,"equal":[[[1,1],[2,2]]],"not_equal":[[[2,2],[1,1]]]`, "BAD_CELL_PAIRS"},
+		{"prefix breaks equal", `,"equal":[[[0,0],[1,1]]],"prefix":[[2,null,null,null],[null,3,null,null],[null,null,null,null],[null,null,null,null]]`, "INVALID_PREFIX"},
+		{"dlx", `,"engine":"dlx","equal":[[[1,1],[0,0]]]`, "BAD_ENGINE"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := req("complete_latin_square_from_prefix", tt.extra)
+			if resp.Error == nil || resp.Error.Code != tt.code {
+				t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+			}
+		})
+	}
+}
+
+func TestValidateFlag(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		status string
+		code   string // код ошибки для invalid_input
+		exit   int
+	}{
+		// подсчёт для пустого 8x8 не кончился бы за время теста — значит, не решаем
+		{"valid prefix", `{"problem":"count_latin_completions","payload":{"n":8,"prefix":` + nullPrefix(8) + `}}`, "valid", "", exitSolved},
+		{"repeat in row", `{"problem":"complete_latin_square_from_prefix","payload":{"n":3,"prefix":[[0,0,null],[null,null,null],[null,null,null]]}}`, "invalid_input", "INVALID_PREFIX", exitInvalid},
+		{"value out of range", `{"problem":"complete_latin_square_from_prefix","payload":{"n":3,"prefix":[[3,null,null],[null,null,null],[null,null,null]]}}`, "invalid_input", "BAD_VALUE", exitInvalid},
+		{"valid mols", `{"problem":"search_mols","payload":{"n":5,"k":2}}`, "valid", "", exitSolved},
+		{"k out of bounds", `{"problem":"search_mols","payload":{"n":5,"k":5}}`, "invalid_input", "BAD_K", exitInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			out, exit := worker(t, tt.in, "-validate")
+			if exit != tt.exit {
+				t.Errorf("exit %d, want %d", exit, tt.exit)
+			}
+			var resp struct {
+				Status string          `json:"status"`
+				Result json.RawMessage `json:"result"`
+				Error  *struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(out, &resp); err != nil {
+				t.Fatalf("out.json %q: %v", out, err)
+			}
+			if resp.Status != tt.status || len(resp.Result) != 0 && string(resp.Result) != "null" {
+				t.Fatalf("status %q, result %s; want %s without a result", resp.Status, resp.Result, tt.status)
+			}
+			if tt.code != "" && (resp.Error == nil || resp.Error.Code != tt.code) {
+				t.Errorf("error %+v, want %s", resp.Error, tt.code)
+			}
+			if d := time.Since(start); d > 10*time.Second {
+				t.Errorf("validation took %v", d)
+			}
+		})
+	}
+}
+
+func TestSolutionLimitStatus(t *testing.T) {
+	// у пустого 3x3 ровно 12 дополнений (distinct=exact — все, не по классам)
+	tests := []struct {
+		max    int
+		status string
+		count  int
+	}{
+		{1, "done", 1}, // одно решение — как раньше, done
+		{2, "solution_limit", 2},
+		{11, "solution_limit", 11},
+		{20, "done", 12},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.max), func(t *testing.T) {
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":{"time_limit_sec":10},
+				"output":{"max_solutions":`+strconv.Itoa(tt.max)+`,"distinct":"exact"},"payload":{"n":3,"prefix":`+nullPrefix(3)+`}}`)
+			res, _ := resp.Result.(ResultComplete)
+			debug, _ := resp.Debug.(DebugInfo)
+			count := len(res.Squares)
+			if tt.max == 1 && res.SolutionFound {
+				count = 1
+			}
+			if resp.Status != tt.status || count != tt.count {
+				t.Fatalf("status %q, %d squares; want %s, %d", resp.Status, count, tt.status, tt.count)
+			}
+			if limited := strings.Contains(debug.Notes, "max_solutions="); limited != (tt.status == "solution_limit") {
+				t.Errorf("notes %q", debug.Notes)
+			}
+			if code := exitCode(resp.Status); code != exitSolved {
+				t.Errorf("exit code %d, want %d", code, exitSolved)
+			}
+		})
+	}
+}
+
+func TestSignalDuringMinRuntimePad(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no SIGINT/SIGTERM delivery to child processes on windows")
+	}
+	tests := []struct {
+		name string
+		sig  os.Signal
+	}{
+		{"SIGINT", os.Interrupt},
+		{"SIGTERM", syscall.SIGTERM},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			inPath, outPath := filepath.Join(dir, "in.json"), filepath.Join(dir, "out.json")
+			in := `{"problem":"verify_latin_square","budget":{"min_runtime_sec":30},"payload":{"square":[[0,1],[1,0]]}}`
+			if err := os.WriteFile(inPath, []byte(in), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			// без -no-min-runtime: ответ готов сразу, дальше 30 с дожигания
+			cmd := workerCmd("-in", inPath, "-out", outPath)
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(500 * time.Millisecond)
+			start := time.Now()
+			if err := cmd.Process.Signal(tt.sig); err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error, 1)
+			go func() { done <- cmd.Wait() }()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				cmd.Process.Kill()
+				t.Fatal("worker still padding 10s after the signal")
+			}
+			out, err := os.ReadFile(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var resp struct {
+				Status  string `json:"status"`
+				Metrics struct {
+					WallMS int64 `json:"wall_ms"`
+				} `json:"metrics"`
+			}
+			if err := json.Unmarshal(out, &resp); err != nil {
+				t.Fatal(err)
+			}
+			// результат уже был посчитан — пишется он, а не cancelled
+			if resp.Status != "done" || resp.Metrics.WallMS >= 30000 {
+				t.Errorf("status %q, wall_ms %d after %v", resp.Status, resp.Metrics.WallMS, time.Since(start))
+			}
+		})
+	}
+}
+
+func TestCanonicalFormOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		form   string
+		prefix string
+		code   string // код ошибки; "" — успех
+	}{
+		{"off", "", nullPrefix(5), ""},
+		{"isotopy", "isotopy", nullPrefix(5), ""},
+		{"main class", "main_class", `[[null,null,null,null,null],[null,null,null,null,null],[null,null,3,null,null],[null,null,null,null,null],[1,null,null,null,null]]`, ""},
+		{"unknown", "paratopy", nullPrefix(5), "BAD_CANONICAL_FORM"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":{"time_limit_sec":10},
+				"output":{"canonical_form":"`+tt.form+`"},"payload":{"n":5,"prefix":`+tt.prefix+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultComplete)
+			if resp.Status != "done" || !res.SolutionFound {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			if tt.form == "" {
+				if res.CanonicalForm != nil {
+					t.Errorf("canonical_form %v without output.canonical_form", res.CanonicalForm)
+				}
+				return
+			}
+			// форма та же, что у любого изотопа найденного квадрата
+			iso := latin.DeepCopy(res.Square)
+			latin.RandomPermute(iso, rand.New(rand.NewSource(3)))
+			want, _ := latin.CanonicalForm(iso, tt.form == "main_class")
+			if !slices.EqualFunc(res.CanonicalForm, want, slices.Equal) {
+				t.Errorf("canonical_form %v, want %v", res.CanonicalForm, want)
+			}
+		})
+	}
+}
+
+func TestMaxCores(t *testing.T) {
+	// одна заданная клетка 5x5: 161280/5 = 32256 дополнений
+	const prefix = `[[0,null,null,null,null],[null,null,null,null,null],[null,null,null,null,null],[null,null,null,null,null],[null,null,null,null,null]]`
+	count := func(cores int, parallel bool) OutResponse {
+		return solve(t, `{"problem":"count_latin_completions","seed":1,"budget":{"time_limit_sec":30,"max_cores":`+strconv.Itoa(cores)+`},
+			"payload":{"n":5,"parallel":`+strconv.FormatBool(parallel)+`,"prefix":`+prefix+`}}`)
+	}
+	seq := count(0, false)
+	seqDebug, _ := seq.Debug.(DebugInfo)
+	procs := runtime.GOMAXPROCS(0)
+	tests := []struct {
+		cores    int
+		parallel bool
+		used     int
+	}{
+		{0, false, procs},
+		{1, false, 1},
+		{1, true, 1}, // одна горутина — тот же обход, что без parallel
+		{3, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d cores parallel %v", tt.cores, tt.parallel), func(t *testing.T) {
+			resp := count(tt.cores, tt.parallel)
+			res, _ := resp.Result.(ResultCount)
+			debug, _ := resp.Debug.(DebugInfo)
+			if resp.Status != "done" || !res.Exact || res.Count != 32256 {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			if resp.Metrics.CoresUsed != tt.used {
+				t.Errorf("cores_used %d, want %d", resp.Metrics.CoresUsed, tt.used)
+			}
+			if tt.used == 1 && debug.Nodes != seqDebug.Nodes {
+				t.Errorf("nodes %d, single-threaded run has %d", debug.Nodes, seqDebug.Nodes)
+			}
+			if got := runtime.GOMAXPROCS(0); got != procs {
+				t.Errorf("GOMAXPROCS %d after the request, was %d", got, procs)
+			}
+		})
+	}
+}
+
+func TestCheckOrthogonal(t *testing.T) {
+	const (
+		a  = `[[0,1,2],[1,2,0],[2,0,1]]`
+		b  = `[[0,1,2],[2,0,1],[1,2,0]]` // ортогонален a
+		a4 = `[[0,1,2,3],[1,0,3,2],[2,3,0,1],[3,2,1,0]]`
+	)
+	tests := []struct {
+		name       string
+		a, b       string
+		orthogonal bool
+		conflicts  int
+		unique     int
+		code       string // код ошибки; "" — успех
+	}{
+		{"orthogonal 3x3", a, b, true, 0, 9, ""},
+		{"square with itself", a, a, false, 6, 3, ""},
+		{"4x4 with itself", a4, a4, false, 12, 4, ""},
+		{"size mismatch", a, a4, false, 0, 0, "SIZE_MISMATCH"},
+		{"a not Latin", `[[0,1,2],[1,2,0],[1,2,0]]`, b, false, 0, 0, "INVALID_SQUARE"},
+		{"empty b", a, `[]`, false, 0, 0, "BAD_SQUARE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"check_orthogonal","payload":{"a":`+tt.a+`,"b":`+tt.b+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code || exitCode(resp.Status) != exitInvalid {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultOrthogonal)
+			if resp.Status != "done" || res.Orthogonal != tt.orthogonal || res.Conflicts != tt.conflicts || res.UniquePairs != tt.unique {
+				t.Errorf("status %q, result %+v; want orthogonal %v, conflicts %d, unique %d", resp.Status, resp.Result, tt.orthogonal, tt.conflicts, tt.unique)
+			}
+		})
+	}
+}
+
+func TestMOLSSidewaysProbRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string // добавка к payload
+		want  float64
+		code  string // код ошибки; "" — успех
+		msg   string // сообщение: число в нём без %v
+	}{
+		{"default", "", latin.DefaultSidewaysProb, "", ""},
+		{"greedy", `,"sideways_prob":0`, 0, "", ""},
+		{"always", `,"sideways_prob":1`, 1, "", ""},
+		{"above 1", `,"sideways_prob":1.5`, 0, "BAD_SIDEWAYS_PROB", "sideways_prob=1.5 must be in [0, 1]"},
+		{"negative", `,"sideways_prob":-0.1`, 0, "BAD_SIDEWAYS_PROB", "sideways_prob=-0.1 must be in [0, 1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":10},
+				"payload":{"n":5,"k":2`+tt.field+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code || resp.Error.Message != tt.msg {
+					t.Fatalf("status %q, error %+v; want %s %q", resp.Status, resp.Error, tt.code, tt.msg)
+				}
+				return
+			}
+			debug, _ := resp.Debug.(DebugInfo)
+			if resp.Status != "done" || debug.SidewaysProb == nil || *debug.SidewaysProb != tt.want {
+				t.Errorf("status %q, debug.sideways_prob %v; want %v", resp.Status, debug.SidewaysProb, tt.want)
+			}
+		})
+	}
+}
+
+func TestHallEarlyExit(t *testing.T) {
+	// в строке 0 клетки (0,1..3) не могут взять 3, 4, 5 (они ниже в их
+	// столбцах): трём клеткам остаются 1 и 2. У каждой клетки и каждого символа
+	// не меньше двух вариантов — арк-консистентность этого не видит
+	const row = `[[0,null,null,null,null,null],[null,3,null,null,null,null],[null,4,5,null,null,null],[null,5,3,4,null,null],[null,null,4,5,null,null],[null,null,null,3,null,null]]`
+	const col = `[[0,null,null,null,null,null],[null,3,4,5,null,null],[null,null,5,3,4,null],[null,null,null,4,5,3],[null,null,null,null,null,null],[null,null,null,null,null,null]]`
+	tests := []struct {
+		name    string
+		problem string
+		prefix  string
+		status  string
+		note    string // "" — проверка Холла проходит
+	}{
+		{"row", "complete_latin_square_from_prefix", row, "no_solution", "row 0"},
+		{"col", "complete_latin_square_from_prefix", col, "no_solution", "col 0"},
+		{"count", "count_latin_completions", row, "done", "row 0"}, // точный ноль
+		{"feasible", "complete_latin_square_from_prefix", nullPrefix(6), "done", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"`+tt.problem+`","seed":1,"budget":{"time_limit_sec":10},"payload":{"n":6,"prefix":`+tt.prefix+`}}`)
+			debug, _ := resp.Debug.(DebugInfo)
+			hall := strings.Contains(debug.Notes, "Hall's condition")
+			if resp.Status != tt.status {
+				t.Fatalf("status %q, want %s; notes %q", resp.Status, tt.status, debug.Notes)
+			}
+			if tt.note == "" {
+				if hall {
+					t.Errorf("notes %q", debug.Notes)
+				}
+				return
+			}
+			// ни одного узла перебора: ответ до DFS
+			if !hall || !strings.Contains(debug.Notes, tt.note) || debug.Nodes != 0 {
+				t.Errorf("nodes %d, notes %q; want a Hall note naming %s", debug.Nodes, debug.Notes, tt.note)
+			}
+			if res, ok := resp.Result.(ResultCount); ok && (res.Count != 0 || !res.Exact) {
+				t.Errorf("count %+v, want exactly 0", res)
+			}
+		})
+	}
+}
+
+func TestBudgetHit(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		kind  string
+		limit int64
+	}{
+		{"completion done", `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":{"time_limit_sec":10},
+			"payload":{"n":5,"prefix":` + nullPrefix(5) + `}}`, "none", 0},
+		{"count nodes", `{"problem":"count_latin_completions","seed":1,"budget":{"time_limit_sec":10,"max_nodes":500},
+			"payload":{"n":6,"prefix":` + nullPrefix(6) + `}}`, "nodes", 500},
+		{"count time", `{"problem":"count_latin_completions","seed":1,"budget":{"time_limit_sec":1},
+			"payload":{"n":8,"prefix":` + nullPrefix(8) + `}}`, "time", 1},
+		{"mols done", `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":10},"payload":{"n":5,"k":2}}`, "none", 0},
+		// у 10 MOLS(3) локальный поиск за такой бюджет не находит
+		{"mols steps", `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":10,"max_steps":1000},"payload":{"n":10,"k":3}}`, "steps", 1000},
+		{"mols time", `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":1},"payload":{"n":10,"k":3}}`, "time", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, tt.in)
+			debug, _ := resp.Debug.(DebugInfo)
+			hit := debug.BudgetHit
+			if hit == nil || hit.Kind != tt.kind || hit.Limit != tt.limit {
+				t.Errorf("status %q, budget_hit %+v; want %s %d", resp.Status, hit, tt.kind, tt.limit)
+			}
+		})
+	}
+}
+
+func TestMOLSReturnOA(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		oa      bool
+		want    bool // ждём OA в ответе
+	}{
+		{"pair", `{"n":5,"k":2}`, true, true},
+		{"galois k=3", `{"n":4,"k":3,"method":"galois"}`, true, true},
+		{"not requested", `{"n":5,"k":2}`, false, false},
+		{"not found", `{"n":6,"k":2}`, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":10},
+				"output":{"return_squares":true,"return_oa":`+strconv.FormatBool(tt.oa)+`},"payload":`+tt.payload+`}`)
+			res, _ := resp.Result.(ResultMOLS)
+			if !tt.want {
+				if res.OA != nil {
+					t.Errorf("status %q: unexpected oa with %d rows", resp.Status, len(res.OA))
+				}
+				return
+			}
+			if !res.Found || len(res.OA) != res.N*res.N {
+				t.Fatalf("found %v, %d oa rows; want %d", res.Found, len(res.OA), res.N*res.N)
+			}
+			for r, row := range res.OA {
+				// (i, j, L[0][i][j], ..., L[k-1][i][j]), клетки по строкам
+				i, j := r/res.N, r%res.N
+				if len(row) != res.K+2 || row[0] != i || row[1] != j {
+					t.Fatalf("oa row %d = %v", r, row)
+				}
+				for m := range res.K {
+					if row[m+2] != res.L[m][i][j] {
+						t.Fatalf("oa row %d = %v, L[%d][%d][%d] = %d", r, row, m, i, j, res.L[m][i][j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMOLSMaxRestarts(t *testing.T) {
+	// 7x7 пара за 200 шагов находится не с каждого сида; попытки с seed+attempt
+	// добирают остальные
+	const seeds = 10
+	tests := []struct {
+		restarts int
+		minHits  int
+	}{
+		{0, 0},
+		{5, seeds - 1},
+	}
+	hits := make([]int, len(tests))
+	for x, tt := range tests {
+		for seed := 1; seed <= seeds; seed++ {
+			resp := solve(t, `{"problem":"search_mols","seed":`+strconv.Itoa(seed)+`,
+				"budget":{"time_limit_sec":10,"max_steps":200,"max_restarts":`+strconv.Itoa(tt.restarts)+`},"payload":{"n":7,"k":2}}`)
+			res, _ := resp.Result.(ResultMOLS)
+			debug, _ := resp.Debug.(DebugInfo)
+			if debug.Attempts < 1 || debug.Attempts > tt.restarts+1 {
+				t.Errorf("restarts %d, seed %d: %d attempts", tt.restarts, seed, debug.Attempts)
+			}
+			// попытки кончаются на первой удачной, неудачный запрос исчерпал все
+			if !res.Found && debug.Attempts != tt.restarts+1 {
+				t.Errorf("restarts %d, seed %d: not found after %d attempts", tt.restarts, seed, debug.Attempts)
+			}
+			if res.Found {
+				hits[x]++
+			}
+		}
+		if hits[x] < tt.minHits {
+			t.Errorf("restarts %d: %d of %d seeds found a pair", tt.restarts, hits[x], seeds)
+		}
+	}
+	if hits[1] <= hits[0] {
+		t.Errorf("restarts did not help: %d hits without, %d with", hits[0], hits[1])
+	}
+}
+
+func TestCompleteEngineHintConjugates(t *testing.T) {
+	// с seed 1 прямой DFS тратит на этот префикс ~3000 узлов, а транспонированный — ~50
+	const skewed = `[[6,null,null,null,1,4,null,null,null],[null,null,null,null,2,null,null,null,null],[0,5,null,null,3,null,8,2,null],[null,1,null,null,null,null,null,3,null],[1,null,null,null,null,3,null,null,8],[4,6,null,3,null,null,null,0,7],[null,null,0,7,null,null,null,null,null],[7,3,2,null,null,null,0,8,null],[8,null,null,2,null,null,null,null,3]]`
+	tests := []struct {
+		name   string
+		extra  string // добавка к payload
+		prefix string
+		status string
+		note   string
+		code   string // код ошибки; "" — успех
+	}{
+		{"direct runs out", `,"engine_hint":"direct"`, skewed, "node_limit", "", ""},
+		{"conjugate solves", `,"engine_hint":"conjugates"`, skewed, "done", "solved on conjugate", ""},
+		{"easy directly", `,"engine_hint":"conjugates"`, nullPrefix(9), "done", "", ""},
+		{"ignored with constraints", `,"engine_hint":"conjugates","constraints":{"diagonal":true}`, nullPrefix(9), "done", "engine_hint=conjugates ignored", ""},
+		{"not dfs", `,"engine_hint":"conjugates","engine":"dlx"`, skewed, "", "", "BAD_ENGINE_HINT"},
+		{"unknown", `,"engine_hint":"sideways"`, skewed, "", "", "BAD_ENGINE_HINT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":{"time_limit_sec":10,"max_nodes":2000},
+				"payload":{"n":9,"prefix":`+tt.prefix+tt.extra+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultComplete)
+			debug, _ := resp.Debug.(DebugInfo)
+			if resp.Status != tt.status {
+				t.Fatalf("status %q, want %s; notes %q", resp.Status, tt.status, debug.Notes)
+			}
+			if tt.note != "" && !strings.Contains(debug.Notes, tt.note) || tt.note == "" && strings.Contains(debug.Notes, "conjugate") {
+				t.Errorf("notes %q, want %q", debug.Notes, tt.note)
+			}
+			if resp.Status != "done" {
+				return
+			}
+			// решение сопряжённого переведено обратно: префикс на месте
+			var cells [][]*int
+			if err := json.Unmarshal([]byte(tt.prefix), &cells); err != nil {
+				t.Fatal(err)
+			}
+			if !res.VerifiedLatin || !latin.IsLatinSquare(res.Square) {
+				t.Fatalf("square %v is not Latin", res.Square)
+			}
+			for i := range cells {
+				for j, v := range cells[i] {
+					if v != nil && res.Square[i][j] != *v {
+						t.Errorf("(%d,%d) = %d, prefix says %d", i, j, res.Square[i][j], *v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCompleteSearchSeed(t *testing.T) {
+	square := func(engine string, seed int, searchSeed string) [][]int {
+		extra := ""
+		if searchSeed != "" {
+			extra = `,"search_seed":` + searchSeed
+		}
+		resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":`+strconv.Itoa(seed)+`,"budget":{"time_limit_sec":10},
+			"payload":{"n":6,"engine":"`+engine+`","prefix":`+nullPrefix(6)+extra+`}}`)
+		res, _ := resp.Result.(ResultComplete)
+		if resp.Status != "done" || !res.VerifiedLatin {
+			t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+		}
+		return res.Square
+	}
+	tests := []struct {
+		name string
+		a, b func(engine string) [][]int
+		same bool
+	}{
+		// без search_seed порядок кандидатов задаёт seed, как раньше
+		{"absent means seed", func(e string) [][]int { return square(e, 1, "") }, func(e string) [][]int { return square(e, 1, "1") }, true},
+		{"search_seed varies", func(e string) [][]int { return square(e, 1, "1") }, func(e string) [][]int { return square(e, 1, "2") }, false},
+		{"seed varies", func(e string) [][]int { return square(e, 1, "5") }, func(e string) [][]int { return square(e, 9, "5") }, true},
+	}
+	for _, engine := range []string{"dfs", "dlx"} {
+		for _, tt := range tests {
+			a, b := tt.a(engine), tt.b(engine)
+			if same := slices.EqualFunc(a, b, slices.Equal); same != tt.same {
+				t.Errorf("%s, %s: squares %v and %v, same %v, want %v", engine, tt.name, a, b, same, tt.same)
+			}
+		}
+	}
+}
+
+func TestCellOrder(t *testing.T) {
+	// 7x7, заполнена треть: дополнений много, дерево не тривиальное
+	sq := latin.MakeCyclic(7, 1)
+	latin.JacobsonMatthews(sq, rand.New(rand.NewSource(2)), 343, nil)
+	cells := make([][]*int, 7)
+	for i := range cells {
+		cells[i] = make([]*int, 7)
+		for j := range cells[i] {
+			if (i*7+j)%3 == 0 {
+				cells[i][j] = &sq[i][j]
+			}
+		}
+	}
+	b, _ := json.Marshal(cells)
+	prefix := string(b)
+	tests := []struct {
+		order  string
+		engine string
+		note   string
+		code   string // код ошибки; "" — успех
+	}{
+		{"", "dfs", "", ""},
+		{"mrv", "dfs", "", ""},
+		{"row_dense", "dfs", "", ""},
+		{"row_dense", "dlx", "cell_order=row_dense", ""},
+		{"densest", "dfs", "", "BAD_CELL_ORDER"},
+	}
+	var base ResultCount
+	var baseNodes int64
+	for _, tt := range tests {
+		t.Run(tt.order+" "+tt.engine, func(t *testing.T) {
+			resp := solve(t, `{"problem":"count_latin_completions","seed":1,"budget":{"time_limit_sec":10},
+				"payload":{"n":7,"engine":"`+tt.engine+`","cell_order":"`+tt.order+`","prefix":`+prefix+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultCount)
+			debug, _ := resp.Debug.(DebugInfo)
+			if resp.Status != "done" || !res.Exact || res.Count < 2 {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			if tt.note != "" && !strings.Contains(debug.Notes, tt.note) {
+				t.Errorf("notes %q, want %q", debug.Notes, tt.note)
+			}
+			if tt.order == "" {
+				base, baseNodes = res, debug.Nodes
+				return
+			}
+			// другой порядок клеток — то же число дополнений
+			if res.Count != base.Count {
+				t.Errorf("count %d, default order gives %d", res.Count, base.Count)
+			}
+			if tt.order == "mrv" && debug.Nodes != baseNodes {
+				t.Errorf("mrv: %d nodes, default %d", debug.Nodes, baseNodes)
+			}
+			t.Logf("nodes %d, default %d", debug.Nodes, baseNodes)
+		})
+	}
+}
+
+func TestBudgetFloatCounts(t *testing.T) {
+	tests := []struct {
+		budget    string
+		strict    bool
+		nodes     int64
+		steps     int64
+		budgetErr bool // *budgetError — BAD_BUDGET
+		jsonErr   bool // прочие ошибки разбора — BAD_JSON
+	}{
+		{`{"max_nodes":3000000}`, true, 3_000_000, 0, false, false},
+		{`{"max_nodes":3000000.0}`, true, 3_000_000, 0, false, false},
+		{`{"max_nodes":3e6}`, true, 3_000_000, 0, false, false},
+		{`{"max_nodes":3E+6,"max_steps":1.5e3}`, true, 3_000_000, 1500, false, false},
+		{`{"max_steps":2.9}`, true, 0, 2, false, false}, // дробная часть отбрасывается
+		{`{"max_nodes":-1}`, true, 0, 0, true, false},
+		{`{"max_steps":-0.5}`, true, 0, 0, true, false},
+		{`{"max_nodes":1e19}`, true, 0, 0, true, false},
+		{`{"max_nodes":"5"}`, true, 0, 0, true, false}, // строка — не число
+		{`{"max_nodes":null}`, true, 0, 0, false, false},
+		{`{"max_steps":[1]}`, true, 0, 0, true, false},
+		{`{"max_nodes":3e6,"max_cpu":1}`, true, 0, 0, false, true},
+		{`{"max_nodes":3e6,"max_cpu":1}`, false, 3_000_000, 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.budget, func(t *testing.T) {
+			reqs, _, err := readInBytes(t, []byte(`{"problem":"verify_latin_square","budget":`+tt.budget+`,"payload":{"square":[[0]]}}`), tt.strict)
+			var be *budgetError
+			if errors.As(err, &be) != tt.budgetErr || (err != nil && !tt.budgetErr) != tt.jsonErr {
+				t.Fatalf("err %v; want budget error %v, other error %v", err, tt.budgetErr, tt.jsonErr)
+			}
+			if err != nil {
+				return
+			}
+			if b := reqs[0].Budget; b.MaxNodes != tt.nodes || b.MaxSteps != tt.steps {
+				t.Errorf("max_nodes %d, max_steps %d; want %d, %d", b.MaxNodes, b.MaxSteps, tt.nodes, tt.steps)
+			}
+		})
+	}
+
+	// через процесс: отрицательный счётчик — invalid_input с BAD_BUDGET
+	out, code := worker(t, `{"problem":"verify_latin_square","budget":{"max_nodes":-3e6},"payload":{"square":[[0]]}}`)
+	var resp OutResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if code != exitInvalid || resp.Status != "invalid_input" || resp.Error == nil || resp.Error.Code != "BAD_BUDGET" {
+		t.Errorf("exit %d, status %q, error %+v; want BAD_BUDGET", code, resp.Status, resp.Error)
+	}
+}
+
+func TestCompleteReturnTrace(t *testing.T) {
+	const prefix = `[[1,null,null,null,null],[null,null,3,null,null],[null,null,null,null,null],[null,4,null,null,null],[null,null,null,null,5]]`
+	tests := []struct {
+		name  string
+		extra string // добавка к payload
+		trace bool
+		code  string // код ошибки; "" — успех
+	}{
+		{"dfs", `,"engine":"dfs"`, true, ""},
+		{"dlx", `,"engine":"dlx"`, true, ""},
+		{"parallel", `,"parallel":true`, true, ""},
+		{"off", "", false, ""},
+		{"local", `,"engine":"local"`, true, "BAD_ENGINE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":{"time_limit_sec":10},
+				"output":{"return_trace":`+strconv.FormatBool(tt.trace)+`},"payload":{"n":5,"symbols":[1,2,3,4,5],"prefix":`+prefix+tt.extra+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultComplete)
+			if resp.Status != "done" || !res.VerifiedLatin {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			if !tt.trace {
+				if res.Trace != nil {
+					t.Errorf("trace %v without output.return_trace", res.Trace)
+				}
+				return
+			}
+			// префикс плюс trace (в исходных символах) — ровно square, без откатов
+			var cells [][]*int
+			if err := json.Unmarshal([]byte(prefix), &cells); err != nil {
+				t.Fatal(err)
+			}
+			got := make([][]int, 5)
+			empty := 0
+			for i := range got {
+				got[i] = make([]int, 5)
+				for j, v := range cells[i] {
+					if v != nil {
+						got[i][j] = *v
+					} else {
+						empty++
+					}
+				}
+			}
+			if len(res.Trace) != empty {
+				t.Fatalf("%d trace steps for %d empty cells", len(res.Trace), empty)
+			}
+			for _, step := range res.Trace {
+				if got[step[0]][step[1]] != 0 {
+					t.Fatalf("step %v: cell already holds %d", step, got[step[0]][step[1]])
+				}
+				got[step[0]][step[1]] = step[2]
+			}
+			if !slices.EqualFunc(got, res.Square, slices.Equal) {
+				t.Errorf("replayed %v, square %v", got, res.Square)
+			}
+		})
+	}
+}
+
+func TestCompleteFillUntilEmpty(t *testing.T) {
+	tests := []struct {
+		name   string
+		extra  string // добавка к payload
+		empty  int    // ожидаемое число пустых
+		unique bool
+		code   string // код ошибки; "" — успех
+	}{
+		{"17 empties", `,"fill_until_empty":17`, 17, false, ""},
+		{"17 empties unique", `,"fill_until_empty":17,"fill_unique":true`, 17, true, ""},
+		{"full square", `,"fill_until_empty":0`, 0, false, ""},
+		{"dlx", `,"fill_until_empty":17,"engine":"dlx"`, 17, false, ""},
+		{"symbols", `,"fill_until_empty":17,"symbols":[8,7,6,5,4,3,2,1,0]`, 17, false, ""},
+		{"negative", `,"fill_until_empty":-1`, 0, false, "BAD_FILL_UNTIL_EMPTY"},
+		{"more than empty", `,"fill_until_empty":80`, 0, false, "BAD_FILL_UNTIL_EMPTY"},
+		{"unique alone", `,"fill_unique":true`, 0, false, "BAD_FILL_UNTIL_EMPTY"},
+	}
+	// префикс: первая строка задана, её клетки стирать нельзя
+	prefix := `[[0,1,2,3,4,5,6,7,8]` + strings.Repeat(`,[null,null,null,null,null,null,null,null,null]`, 8) + `]`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":3,"budget":{"time_limit_sec":20},
+				"payload":{"n":9,"prefix":`+prefix+tt.extra+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultComplete)
+			if resp.Status != "done" || !res.SolutionFound || res.Empty == nil || *res.Empty != tt.empty || res.Filled != 81-tt.empty {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			// частичная доска — подмножество square с нетронутой первой строкой
+			partial := make([][]int, 9)
+			empty := 0
+			for i, row := range res.Partial {
+				partial[i] = make([]int, 9)
+				for j, c := range row {
+					partial[i][j] = -1
+					switch {
+					case c == nil:
+						empty++
+						if i == 0 {
+							t.Errorf("prefix cell (0,%d) erased", j)
+						}
+					case *c != res.Square[i][j]:
+						t.Errorf("partial (%d,%d)=%d, square has %d", i, j, *c, res.Square[i][j])
+					default:
+						partial[i][j] = *c
+					}
+				}
+			}
+			if empty != tt.empty {
+				t.Errorf("%d empty cells in partial, want %d", empty, tt.empty)
+			}
+			if !tt.unique {
+				return
+			}
+			// единственность: у частичной доски ровно одно дополнение
+			s := latin.NewSolver(partial, nil)
+			s.MaxSolutions = 2
+			s.Solve()
+			if s.Found != 1 || !s.Exhausted() {
+				t.Errorf("partial has %d completions (exhausted %v), want exactly one", s.Found, s.Exhausted())
+			}
+		})
+	}
+}
+
+func TestCompleteLexMin(t *testing.T) {
+	tests := []struct {
+		name  string
+		extra string // добавка к payload
+		want  string
+		code  string // код ошибки; "" — успех
+	}{
+		{"empty", "", `[[0,1,2,3],[1,0,3,2],[2,3,0,1],[3,2,1,0]]`, ""},
+		{"parallel", `,"parallel":true`, `[[0,1,2,3],[1,0,3,2],[2,3,0,1],[3,2,1,0]]`, ""},
+		// порядок — по symbols, не по значениям символов
+		{"symbols", `,"symbols":[3,2,1,0]`, `[[3,2,1,0],[2,3,0,1],[1,0,3,2],[0,1,2,3]]`, ""},
+		{"dlx", `,"engine":"dlx"`, "", "BAD_LEX_MIN"},
+		{"lcv", `,"value_order":"lcv"`, "", "BAD_LEX_MIN"},
+		{"row_dense", `,"cell_order":"row_dense"`, "", "BAD_LEX_MIN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":5,"budget":{"max_cores":2},
+				"payload":{"n":4,"prefix":`+nullPrefix(4)+`,"lex_min":true`+tt.extra+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultComplete)
+			if resp.Status != "done" || !res.VerifiedLatin {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			if b, _ := json.Marshal(res.Square); string(b) != tt.want {
+				t.Errorf("square %s, want %s", b, tt.want)
+			}
+		})
+	}
+
+	// префикс посложнее: сверяем с минимумом по всем дополнениям
+	prefix := [][]int{{-1, 2, -1, -1, -1}, {-1, -1, -1, 3, -1}, {4, -1, -1, -1, -1}, {-1, -1, -1, -1, -1}, {-1, -1, 0, -1, -1}}
+	all := latin.NewSolver(prefix, nil)
+	all.MaxSolutions = 1 << 30
+	var want [][]int
+	all.OnSolution = func(_ int, sq [][]int) {
+		if want == nil || slices.CompareFunc(sq, want, slices.Compare[[]int]) < 0 {
+			want = latin.DeepCopy(sq)
+		}
+	}
+	all.Solve()
+	b, _ := json.Marshal(partialCells(prefix))
+	resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":5,"payload":{"n":5,"prefix":`+string(b)+`,"lex_min":true}}`)
+	if res, _ := resp.Result.(ResultComplete); !slices.EqualFunc(res.Square, want, slices.Equal) {
+		t.Errorf("square %v, lex-min of %d completions is %v", res.Square, all.Found, want)
+	}
+}
+
+func TestCompletePartialOnBudget(t *testing.T) {
+	// символы 100..129
+	syms := make([]string, 30)
+	for k := range syms {
+		syms[k] = strconv.Itoa(100 + k)
+	}
+	for _, tt := range []struct {
+		name, extra string
+	}{
+		{"dfs", ""},
+		{"parallel", `,"parallel":true`},
+		{"symbols", `,"symbols":[` + strings.Join(syms, ",") + `]`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":{"max_nodes":300,"max_cores":2},
+				"payload":{"n":30,"prefix":`+nullPrefix(30)+tt.extra+`}}`)
+			res, _ := resp.Result.(ResultComplete)
+			if resp.Status != "node_limit" || res.SolutionFound || res.Square != nil {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			// доска согласована: без повторов в строках и столбцах
+			board := make([][]int, 30)
+			cells := 0
+			for i, row := range res.Partial {
+				board[i] = make([]int, 30)
+				for j, c := range row {
+					board[i][j] = -1
+					if c != nil {
+						board[i][j] = *c
+						if tt.name == "symbols" {
+							board[i][j] -= 100
+						}
+						cells++
+					}
+				}
+			}
+			if len(res.Partial) != 30 || cells != res.Filled || cells < 250 || cells == 900 {
+				t.Fatalf("partial has %d cells, filled=%d; want a deep but incomplete board", cells, res.Filled)
+			}
+			if err := latin.ValidatePartial(board); err != nil {
+				t.Errorf("partial is not consistent: %v", err)
+			}
+		})
+	}
+}
+
+func TestExtendLatinRectangle(t *testing.T) {
+	// первые 5 строк случайного 12x12
+	big := latin.MakeCyclic(12, 1)
+	latin.JacobsonMatthews(big, rand.New(rand.NewSource(4)), 1728, nil)
+	bigRect, _ := json.Marshal(big[:5])
+	tests := []struct {
+		name string
+		n    int
+		rect string
+		note string
+		code string // код ошибки; "" — успех
+	}{
+		{"2x4", 4, `[[0,1,2,3],[1,0,3,2]]`, "", ""},
+		{"1x5", 5, `[[4,3,2,1,0]]`, "", ""},
+		{"5x12", 12, string(bigRect), "", ""},
+		{"already a square", 3, `[[0,1,2],[1,2,0],[2,0,1]]`, "rectangle is already a square", ""},
+		{"no rows", 4, `[]`, "", "BAD_RECTANGLE"},
+		{"too many rows", 2, `[[0,1],[1,0],[0,1]]`, "", "BAD_RECTANGLE"},
+		{"short row", 4, `[[0,1,2,3],[1,0,3]]`, "", "BAD_RECTANGLE"},
+		{"out of range", 4, `[[0,1,2,4]]`, "", "BAD_RECTANGLE"},
+		{"row repeat", 4, `[[0,1,1,3]]`, "", "INVALID_RECTANGLE"},
+		{"column repeat", 4, `[[0,1,2,3],[0,2,3,1]]`, "", "INVALID_RECTANGLE"},
+		{"bad n", 0, `[[0]]`, "", "BAD_N"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"extend_latin_rectangle","seed":1,"budget":{"time_limit_sec":10},
+				"payload":{"n":`+strconv.Itoa(tt.n)+`,"rectangle":`+tt.rect+`}}`)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultComplete)
+			debug, _ := resp.Debug.(DebugInfo)
+			if resp.Status != "done" || !res.SolutionFound || !res.VerifiedLatin || len(res.Square) != tt.n {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			var rect [][]int
+			if err := json.Unmarshal([]byte(tt.rect), &rect); err != nil {
+				t.Fatal(err)
+			}
+			// строки прямоугольника на месте
+			for i, row := range rect {
+				if !slices.Equal(res.Square[i], row) {
+					t.Errorf("row %d = %v, rectangle has %v", i, res.Square[i], row)
+				}
+			}
+			if tt.note != "" && !strings.Contains(debug.Notes, tt.note) {
+				t.Errorf("notes %q, want %q", debug.Notes, tt.note)
+			}
+		})
+	}
+}
+
+func TestWriteOutAtomic(t *testing.T) {
+	// ответ побольше, чтобы запись не укладывалась в один write
+	big := func(k int) OutResponse {
+		sq := latin.MakeCyclic(200, 1+k%3)
+		return OutResponse{Ok: true, Status: "running", Result: ResultComplete{N: 200, Square: sq}}
+	}
+	tests := []struct {
+		name    string
+		file    string // относительно временного каталога
+		preset  bool   // файл уже есть
+		wantErr bool
+	}{
+		{"new file", "out.json", false, false},
+		{"overwrite", "out.json", true, false},
+		{"gzip", "out.json.gz", false, false},
+		{"missing dir", "nope/out.json", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, tt.file)
+			if tt.preset {
+				if err := os.WriteFile(path, []byte(`{"status":"old"}`), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			b, _ := json.MarshalIndent(big(0), "", "  ")
+			if gzipped(path) {
+				b = gzipBytes(b)
+			}
+			err := writeFileAtomic(path, b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, want error %v", err, tt.wantErr)
+			}
+			// временные файлы не остаются ни после успеха, ни после ошибки
+			entries, _ := os.ReadDir(dir)
+			for _, e := range entries {
+				if strings.HasSuffix(e.Name(), ".tmp") {
+					t.Errorf("temp file %s left behind", e.Name())
+				}
+			}
+			if err != nil {
+				return
+			}
+			if b, err = os.ReadFile(path); err != nil {
+				t.Fatal(err)
+			}
+			if gzipped(path) {
+				if b, err = gunzip(b); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var resp OutResponse
+			if err := json.Unmarshal(b, &resp); err != nil || resp.Status != "running" {
+				t.Errorf("status %q, err %v", resp.Status, err)
+			}
+		})
+	}
+
+	// читатель, опрашивающий файл во время перезаписей, видит только целый JSON
+	path := filepath.Join(t.TempDir(), "out.json")
+	writeOut(path, big(0))
+	done := make(chan struct{})
+	bad := make(chan string, 1)
+	go func() {
+		defer close(bad)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			b, err := os.ReadFile(path)
+			if err != nil || !json.Valid(b) {
+				bad <- fmt.Sprintf("read %d bytes, err %v", len(b), err)
+				return
+			}
+		}
+	}()
+	for k := 1; k <= 200; k++ {
+		writeOut(path, big(k))
+	}
+	close(done)
+	if msg, ok := <-bad; ok {
+		t.Errorf("reader saw a partial file: %s", msg)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	// таблица статус → код; код — производное от status, он в JSON главный
+	statuses := map[string]int{
+		"done": exitSolved, "solution_limit": exitSolved, "valid": exitSolved,
+		"invalid_input": exitInvalid,
+		"timeout":       exitTimeout, "node_limit": exitTimeout,
+		"no_solution": exitNoSolution,
+		"error":       exitError, "cancelled": exitError, "resource_exhausted": exitError,
+		"running": exitError,
+	}
+	for status, want := range statuses {
+		if got := exitCode(status); got != want {
+			t.Errorf("exitCode(%q) = %d, want %d", status, got, want)
+		}
+	}
+
+	// через процесс: по задаче на класс
+	tests := []struct {
+		name   string
+		in     string
+		status string
+		code   int
+	}{
+		{"solved", `{"problem":"verify_latin_square","payload":{"square":[[0,1],[1,0]]}}`, "done", exitSolved},
+		{"solution limit", `{"problem":"complete_latin_square_from_prefix","output":{"max_solutions":2,"distinct":"exact"},
+			"payload":{"n":3,"prefix":` + nullPrefix(3) + `}}`, "solution_limit", exitSolved},
+		{"invalid input", `{"problem":"no_such_problem","payload":{}}`, "invalid_input", exitInvalid},
+		{"bad json", `{"problem":`, "invalid_input", exitInvalid},
+		{"node limit", `{"problem":"count_latin_completions","budget":{"max_nodes":100},"payload":{"n":7,"prefix":` + nullPrefix(7) + `}}`, "node_limit", exitTimeout},
+		{"timeout", `{"problem":"count_latin_completions","budget":{"time_limit_sec":1},"payload":{"n":8,"prefix":` + nullPrefix(8) + `}}`, "timeout", exitTimeout},
+		{"no solution", `{"problem":"complete_latin_square_from_prefix","payload":{"n":2,"prefix":[[0,null],[null,1]]}}`, "no_solution", exitNoSolution},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, code := worker(t, tt.in)
+			var resp OutResponse
+			if err := json.Unmarshal(out, &resp); err != nil {
+				t.Fatalf("out.json %q: %v", out, err)
+			}
+			if resp.Status != tt.status || code != tt.code {
+				t.Errorf("status %q, exit %d; want %s, %d", resp.Status, code, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+func TestMinCostCompletion(t *testing.T) {
+	// первая строка 0 1 2 оставляет два дополнения: строки 1 2 0 / 2 0 1 или
+	// 2 0 1 / 1 2 0. Дешевле второе: 2 в (1,0) стоит 1, а 1 там же — 10
+	cost := `[[[0,0,0],[0,0,0],[0,0,0]],
+		[[0,10,1],[0,0,0],[0,0,0]],
+		[[0,0,0],[0,0,0],[0,0,0]]]`
+	want := [][]int{{0, 1, 2}, {2, 0, 1}, {1, 2, 0}}
+	for seed := 1; seed <= 5; seed++ {
+		resp := solve(t, `{"problem":"min_cost_completion","seed":`+strconv.Itoa(seed)+`,
+			"payload":{"n":3,"prefix":[[0,1,2],[null,null,null],[null,null,null]],"cost":`+cost+`}}`)
+		res, _ := resp.Result.(ResultMinCost)
+		if resp.Status != "done" || !res.SolutionFound || !res.Optimal || !res.VerifiedLatin {
+			t.Fatalf("seed %d: status %q, result %+v", seed, resp.Status, resp.Result)
+		}
+		if !slices.EqualFunc(res.Square, want, slices.Equal) {
+			t.Errorf("seed %d: square %v, want the cheaper %v", seed, res.Square, want)
+		}
+		if res.Cost == nil || *res.Cost != 1 {
+			t.Errorf("seed %d: cost %v, want 1", seed, res.Cost)
+		}
+	}
+
+	for _, tt := range []struct {
+		name, payload, code string
+	}{
+		{"short cost", `{"n":2,"prefix":[[null,null],[null,null]],"cost":[[[0,0],[0,0]]]}`, "BAD_COST"},
+		{"short cell", `{"n":2,"prefix":[[null,null],[null,null]],"cost":[[[0,0],[0]],[[0,0],[0,0]]]}`, "BAD_COST"},
+		{"dlx", `{"n":2,"prefix":[[null,null],[null,null]],"engine":"dlx","cost":[[[0,0],[0,0]],[[0,0],[0,0]]]}`, "BAD_ENGINE"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"min_cost_completion","payload":`+tt.payload+`}`)
+			if resp.Error == nil || resp.Error.Code != tt.code {
+				t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+			}
+		})
+	}
+}
+
+func TestProgressSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix sockets")
+	}
+	// путь сокета ограничен ~100 байтами — t.TempDir() бывает длиннее
+	dir, err := os.MkdirTemp("", "lsw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name   string
+		in     string
+		listen bool
+		check  func(progressLine) bool
+	}{
+		{"count", `{"problem":"count_latin_completions","task_id":"c1","budget":{"min_runtime_sec":1,"time_limit_sec":1},"payload":{"n":8,"prefix":` + nullPrefix(8) + `}}`,
+			true, func(l progressLine) bool { return l.Nodes > 0 }},
+		{"mols", `{"problem":"search_mols","task_id":"m1","budget":{"min_runtime_sec":1,"time_limit_sec":1},"payload":{"n":10,"k":3}}`,
+			true, func(l progressLine) bool { return l.Steps > 0 && l.BestConflicts != nil }},
+		// сокета нет — воркер работает как обычно
+		{"no listener", `{"problem":"search_mols","task_id":"m2","budget":{"min_runtime_sec":1,"time_limit_sec":1},"payload":{"n":10,"k":3}}`, false, nil},
+	}
+	for k, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strconv.Itoa(k)+".sock")
+			frames := make(chan []progressLine, 1)
+			if tt.listen {
+				ln, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer ln.Close()
+				go func() {
+					var got []progressLine
+					defer func() { frames <- got }()
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+					dec := json.NewDecoder(conn)
+					for {
+						var l progressLine
+						if dec.Decode(&l) != nil {
+							return
+						}
+						got = append(got, l)
+					}
+				}()
+			}
+			out, code := worker(t, tt.in, "-progress-socket", path, "-log-interval", "200ms")
+			var resp OutResponse
+			if err := json.Unmarshal(out, &resp); err != nil || code != exitTimeout && code != exitSolved {
+				t.Fatalf("exit %d, out.json %q: %v", code, out, err)
+			}
+			if !tt.listen {
+				return
+			}
+			got := <-frames
+			if len(got) == 0 {
+				t.Fatal("no frames on the socket")
+			}
+			var req struct {
+				TaskID  string `json:"task_id"`
+				Problem string `json:"problem"`
+			}
+			json.Unmarshal([]byte(tt.in), &req)
+			for _, l := range got {
+				if l.TaskID != req.TaskID || l.Problem != req.Problem || l.TS == "" || l.ElapsedMS <= 0 {
+					t.Errorf("frame %+v", l)
+				}
+			}
+			if last := got[len(got)-1]; !tt.check(last) {
+				t.Errorf("last frame %+v has no progress", last)
+			}
+		})
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	for _, n := range []int{8, 40} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			start := time.Now()
+			resp := solve(t, `{"problem":"benchmark","seed":1,"payload":{"n":`+strconv.Itoa(n)+`,"window_sec":0.5}}`)
+			took := time.Since(start)
+			res, _ := resp.Result.(ResultBenchmark)
+			if resp.Status != "done" || res.Ops <= 0 || res.Nodes < res.Ops || res.NodesPerSec <= 0 {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			if res.WindowMS < 500 || took > 1500*time.Millisecond {
+				t.Errorf("window_ms=%d, call took %v; want about 500ms", res.WindowMS, took)
+			}
+		})
+	}
+	for _, payload := range []string{`{"n":5}`, `{"n":5,"window_sec":-1}`} {
+		resp := solve(t, `{"problem":"benchmark","payload":`+payload+`}`)
+		if resp.Error == nil || resp.Error.Code != "BAD_WINDOW" {
+			t.Errorf("%s: status %q, error %+v; want BAD_WINDOW", payload, resp.Status, resp.Error)
+		}
+	}
+}
+
+func TestMOLSSeedScan(t *testing.T) {
+	resp := solve(t, `{"problem":"mols_seed_scan","budget":{"time_limit_sec":30},
+		"payload":{"n":5,"seed_from":1,"seed_to":8,"buckets":3}}`)
+	res, _ := resp.Result.(ResultSeedScan)
+	if resp.Status != "done" || res.Scanned != 8 || res.Solved+len(res.Unsolved) != 8 || res.Solved == 0 {
+		t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+	}
+	if res.Easiest.Steps != res.MinSteps || res.Hardest.Steps != res.MaxSteps || res.MinSteps > res.MaxSteps {
+		t.Errorf("easiest %+v, hardest %+v, steps [%d, %d]", res.Easiest, res.Hardest, res.MinSteps, res.MaxSteps)
+	}
+	if res.MeanSteps < float64(res.MinSteps) || res.MeanSteps > float64(res.MaxSteps) ||
+		res.MedianSteps < res.MinSteps || res.MedianSteps > res.MaxSteps {
+		t.Errorf("mean %v, median %d outside [%d, %d]", res.MeanSteps, res.MedianSteps, res.MinSteps, res.MaxSteps)
+	}
+	// корзины подряд покрывают [min, max] и вместе держат все решённые seed
+	count, next := 0, res.MinSteps
+	for _, b := range res.Histogram {
+		if b.Lo != next || b.Hi < b.Lo {
+			t.Errorf("bucket %+v, want it to start at %d", b, next)
+		}
+		count += b.Count
+		next = b.Hi + 1
+	}
+	if len(res.Histogram) == 0 || len(res.Histogram) > 3 || count != res.Solved || next != res.MaxSteps+1 {
+		t.Errorf("histogram %+v for %d solved seeds in [%d, %d]", res.Histogram, res.Solved, res.MinSteps, res.MaxSteps)
+	}
+
+	// одинаковый диапазон — одинаковый ответ
+	again, _ := solve(t, `{"problem":"mols_seed_scan","budget":{"time_limit_sec":30},
+		"payload":{"n":5,"seed_from":1,"seed_to":8,"buckets":3}}`).Result.(ResultSeedScan)
+	if *again.Hardest != *res.Hardest || *again.Easiest != *res.Easiest {
+		t.Errorf("rerun: hardest %+v easiest %+v, first run %+v %+v", again.Hardest, again.Easiest, res.Hardest, res.Easiest)
+	}
+
+	for _, tt := range []struct{ payload, code string }{
+		{`{"n":5,"seed_from":3,"seed_to":1}`, "BAD_SEED_RANGE"},
+		{`{"n":5,"seed_from":0,"seed_to":10000}`, "BAD_SEED_RANGE"},
+		{`{"n":5,"k":3,"seed_to":1}`, "BAD_K"},
+		{`{"n":6,"seed_to":1}`, "BAD_N"},
+		{`{"n":5,"seed_to":1,"method":"tabu"}`, "BAD_METHOD"},
+	} {
+		resp := solve(t, `{"problem":"mols_seed_scan","payload":`+tt.payload+`}`)
+		if resp.Error == nil || resp.Error.Code != tt.code {
+			t.Errorf("%s: status %q, error %+v; want %s", tt.payload, resp.Status, resp.Error, tt.code)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	srv := httptest.NewServer(newServeMux(false, 4, runEnv{host: "test", maxN: 2000, shared: true},
+		&serveStats{byStatus: map[string]int64{}}))
+	defer srv.Close()
+
+	scrape := func() string {
+		t.Helper()
+		r, err := http.Get(srv.URL + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		b, _ := io.ReadAll(r.Body)
+		return string(b)
+	}
+	post := func(body string) (int, OutResponse) {
+		t.Helper()
+		r, err := http.Post(srv.URL+"/", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		var resp OutResponse
+		if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return r.StatusCode, resp
+	}
+
+	if m := scrape(); !strings.Contains(m, "ls_worker_tasks_solved_total 0\n") {
+		t.Fatalf("fresh server metrics:\n%s", m)
+	}
+	code, resp := post(`{"problem":"complete_latin_square_from_prefix","seed":1,"payload":{"n":3,"prefix":[[0,null,null],[null,null,null],[null,null,null]]}}`)
+	if code != http.StatusOK || resp.Status != "done" {
+		t.Fatalf("POST: http %d, status %q, error %+v", code, resp.Status, resp.Error)
+	}
+	m := scrape()
+	for _, want := range []string{
+		`ls_worker_tasks_total{status="done"} 1`,
+		"ls_worker_tasks_solved_total 1\n",
+	} {
+		if !strings.Contains(m, want) {
+			t.Errorf("metrics lack %q:\n%s", want, m)
+		}
+	}
+	if strings.Contains(m, "ls_worker_nodes_total 0\n") {
+		t.Errorf("nodes not counted:\n%s", m)
+	}
+
+	// min_runtime в -serve не дожигается
+	if code, resp := post(`{"problem":"verify_latin_square","budget":{"min_runtime_sec":5},"payload":{"square":[[0]]}}`); code != http.StatusOK || resp.Metrics.WallMS >= 1000 {
+		t.Errorf("min_runtime_sec=5: http %d, wall_ms %d; want no padding", code, resp.Metrics.WallMS)
+	}
+
+	// RSS процесса общий на все запросы: max_rss_kb меньше него не отменяет
+	// задачу и не трогает лимит памяти GC
+	code, resp = post(`{"problem":"count_latin_completions","budget":{"max_rss_kb":1024},"payload":{"n":5,"prefix":` + nullPrefix(5) + `}}`)
+	if code != http.StatusOK || resp.Status != "done" {
+		t.Errorf("max_rss_kb: http %d, status %q, error %+v; want done", code, resp.Status, resp.Error)
+	}
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+		t.Errorf("max_rss_kb set the GC memory limit to %d", limit)
+	}
+
+	if code, resp := post(`{"problem":`); code != http.StatusBadRequest || resp.Error == nil || resp.Error.Code != "BAD_JSON" {
+		t.Errorf("bad JSON: http %d, error %+v", code, resp.Error)
+	}
+	if code, resp := post(`[{"problem":"verify_latin_square","payload":{"square":[[0]]}}]`); code != http.StatusBadRequest || resp.Status != "invalid_input" {
+		t.Errorf("batch: http %d, status %q", code, resp.Status)
+	}
+	if m := scrape(); !strings.Contains(m, `ls_worker_tasks_total{status="invalid_input"} 2`) {
+		t.Errorf("rejected bodies not counted:\n%s", m)
+	}
+
+	r, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /: http %d, want 405", r.StatusCode)
+	}
+}
+
+func TestServeMaxInflight(t *testing.T) {
+	// одно место, каждый запрос держит его, пока считает 5x5 — остальные получают 429
+	st := &serveStats{byStatus: map[string]int64{}}
+	srv := httptest.NewServer(newServeMux(false, 1, runEnv{host: "test", maxN: 2000, shared: true}, st))
+	defer srv.Close()
+
+	const fired = 4
+	codes := make(chan int, fired)
+	for i := 0; i < fired; i++ {
+		go func() {
+			r, err := http.Post(srv.URL+"/", "application/json", strings.NewReader(
+				`{"problem":"count_latin_completions","payload":{"n":5,"prefix":`+nullPrefix(5)+`}}`))
+			if err != nil {
+				t.Error(err)
+				codes <- 0
+				return
+			}
+			_, _ = io.Copy(io.Discard, r.Body)
+			r.Body.Close()
+			if r.StatusCode == http.StatusTooManyRequests && r.Header.Get("Retry-After") == "" {
+				t.Error("429 without Retry-After")
+			}
+			codes <- r.StatusCode
+		}()
+	}
+	n := map[int]int{}
+	for i := 0; i < fired; i++ {
+		n[<-codes]++
+	}
+	if n[http.StatusOK] < 1 || n[http.StatusTooManyRequests] < 1 || n[http.StatusOK]+n[http.StatusTooManyRequests] != fired {
+		t.Fatalf("status codes %v, want some 200 and some 429", n)
+	}
+
+	var b strings.Builder
+	st.writeTo(&b)
+	for _, want := range []string{
+		fmt.Sprintf("ls_worker_rejected_total %d\n", n[http.StatusTooManyRequests]),
+		fmt.Sprintf(`ls_worker_tasks_total{status="done"} %d`, n[http.StatusOK]),
+		"ls_worker_inflight 0\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestDistinctSolutions(t *testing.T) {
+	tests := []struct {
+		distinct string
+		want     func(k int) bool
+	}{
+		// первая строка 0..3 фиксирована: 24 дополнения, по CanonicalHash их меньше
+		{distinct: "", want: func(k int) bool { return k > 1 && k < 24 }},
+		{distinct: "canonical", want: func(k int) bool { return k > 1 && k < 24 }},
+		{distinct: "exact", want: func(k int) bool { return k == 24 }},
+	}
+	for _, tt := range tests {
+		resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":3,
+			"budget":{"time_limit_sec":10},
+			"output":{"max_solutions":100,"distinct":"`+tt.distinct+`"},
+			"payload":{"n":4,"prefix":[[0,1,2,3],[null,null,null,null],[null,null,null,null],[null,null,null,null]]}}`)
+		res, ok := resp.Result.(ResultComplete)
+		if resp.Status != "done" || !ok {
+			t.Fatalf("distinct=%q: status %q, result %T", tt.distinct, resp.Status, resp.Result)
+		}
+		if !tt.want(len(res.Squares)) {
+			t.Errorf("distinct=%q: %d squares", tt.distinct, len(res.Squares))
+		}
+		key := latin.CanonicalHash
+		if tt.distinct == "exact" {
+			key = latin.DigestSquare
+		}
+		seen := map[string]bool{}
+		for _, sq := range res.Squares {
+			if !latin.IsLatinSquare(sq) {
+				t.Errorf("distinct=%q: %v is not Latin", tt.distinct, sq)
+			}
+			if k := key(sq); seen[k] {
+				t.Errorf("distinct=%q: duplicate square %v", tt.distinct, sq)
+			} else {
+				seen[k] = true
+			}
+		}
+	}
+}
+
+func TestDistinctUnknown(t *testing.T) {
+	resp := solve(t, `{"problem":"complete_latin_square_from_prefix","output":{"max_solutions":5,"distinct":"paratopy"},
+		"payload":{"n":3,"prefix":`+nullPrefix(3)+`}}`)
+	if resp.Error == nil || resp.Error.Code != "BAD_DISTINCT" {
+		t.Fatalf("status %q, error %+v; want BAD_DISTINCT", resp.Status, resp.Error)
+	}
+}
+
+func TestMOLSPartialSet(t *testing.T) {
+	// порядка 10 нет девяти MOLS (нет проективной плоскости порядка 10), а
+	// ортогональная пара есть: затравка — случайный квадрат и его пара
+	base := latin.MakeCyclic(10, 1)
+	latin.JacobsonMatthews(base, latin.NewRand(latin.RNGLegacy, 1), 2000, nil)
+	mate := latin.FindOrthogonalMate(base, latin.MateOptions{Deadline: time.Now().Add(10 * time.Second)})
+	if mate.Status != "done" {
+		t.Fatalf("no mate for the seed square: %s", mate.Status)
+	}
+	pair, _ := json.Marshal([][][]int{base, mate.Mate})
+	tests := []struct {
+		name    string
+		payload string
+		squares bool // output.return_squares
+		found   bool
+		minK    int // не меньше стольких квадратов в поднаборе
+	}{
+		{"n=10 k=9 squares", `{"n":10,"k":9,"seed_squares":` + string(pair) + `}`, true, false, 2},
+		{"n=10 k=9 hashes", `{"n":10,"k":9,"seed_squares":` + string(pair) + `}`, false, false, 2},
+		{"n=6 no pair", `{"n":6,"k":2}`, true, false, 1},
+		{"n=5 found", `{"n":5,"k":2}`, true, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":10,"max_steps":50000},
+				"output":{"return_squares":`+strconv.FormatBool(tt.squares)+`},"payload":`+tt.payload+`}`)
+			res, ok := resp.Result.(ResultMOLS)
+			if !ok || res.Found != tt.found || res.MaxFoundK < tt.minK {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			if tt.found {
+				// полный набор — поднабор не дублируется
+				if res.MaxFoundK != res.K || res.PartialL != nil || res.PartialHash != nil {
+					t.Errorf("max_found_k %d, partial_L %d, partial_hash %d", res.MaxFoundK, len(res.PartialL), len(res.PartialHash))
+				}
+				return
+			}
+			if res.MaxFoundK >= res.K {
+				t.Fatalf("max_found_k %d with found=false", res.MaxFoundK)
+			}
+			if res.MaxFoundK < 2 {
+				if res.PartialL != nil || res.PartialHash != nil {
+					t.Errorf("partial set of %d squares reported", res.MaxFoundK)
+				}
+				return
+			}
+			if !tt.squares {
+				if res.PartialL != nil || len(res.PartialHash) != res.MaxFoundK {
+					t.Errorf("partial_L %d, partial_hash %v", len(res.PartialL), res.PartialHash)
+				}
+				return
+			}
+			if len(res.PartialL) != res.MaxFoundK {
+				t.Fatalf("partial_L has %d squares, max_found_k %d", len(res.PartialL), res.MaxFoundK)
+			}
+			if ok, msg := latin.VerifyMOLS(res.PartialL); !ok {
+				t.Errorf("partial_L: %s", msg)
+			}
+		})
+	}
+}
+
+func TestMaxResultBytes(t *testing.T) {
+	// обрезка — в runRequest, поэтому мимо solve
+	run := func(in string) OutResponse {
+		t.Helper()
+		var req InRequest
+		if err := json.Unmarshal([]byte(in), &req); err != nil {
+			t.Fatalf("decode %s: %v", in, err)
+		}
+		return runRequest(context.Background(), req, runEnv{host: "test", maxN: 2000, noMinRuntime: true})
+	}
+	const big = `{"problem":"random_latin_square","seed":3,"payload":{"n":1000}}`
+	full := run(big)
+	sq, _ := json.Marshal(full.Result.(ResultRandom).Square)
+	sum := sha256.Sum256(sq)
+	tests := []struct {
+		name  string
+		in    string
+		cap   int
+		trunc bool   // ждём truncated и хэш вместо square
+		over  bool   // одни скаляры длиннее cap
+		hash  string // "" — хэш не сверяем
+		code  string // код ошибки; "" — успех
+	}{
+		{"n=1000 truncated", big, 4096, true, false, hex.EncodeToString(sum[:]), ""},
+		{"small fits", `{"problem":"random_latin_square","seed":3,"payload":{"n":5}}`, 4096, false, false, "", ""},
+		// скаляры остаются, даже если не влезают
+		{"cap below scalars", `{"problem":"random_latin_square","seed":3,"payload":{"n":5}}`, 10, true, true, "", ""},
+		{"negative", `{"problem":"random_latin_square","seed":3,"payload":{"n":5}}`, -1, false, false, "", "BAD_MAX_RESULT_BYTES"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := strings.Replace(tt.in, `"payload"`, `"output":{"max_result_bytes":`+strconv.Itoa(tt.cap)+`},"payload"`, 1)
+			resp := run(in)
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.code)
+				}
+				return
+			}
+			if resp.Status != "done" || !resp.Ok {
+				t.Fatalf("status %q, error %+v", resp.Status, resp.Error)
+			}
+			b, err := json.Marshal(resp.Result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var res map[string]json.RawMessage
+			if err := json.Unmarshal(b, &res); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.trunc {
+				if _, ok := res["truncated"]; ok || res["square"] == nil {
+					t.Errorf("result %s", b)
+				}
+				return
+			}
+			if string(res["truncated"]) != "true" || res["square"] != nil || string(res["verified_latin"]) != "true" {
+				t.Fatalf("result %s", b)
+			}
+			if !tt.over && len(b) > tt.cap {
+				t.Errorf("result is %d bytes, cap %d", len(b), tt.cap)
+			}
+			if tt.hash != "" && string(res["square_sha256"]) != `"`+tt.hash+`"` {
+				t.Errorf("square_sha256 %s, want %q", res["square_sha256"], tt.hash)
+			}
+		})
+	}
+}
+
+func TestFindTransversalRequest(t *testing.T) {
+	sq := func(L [][]int) string {
+		b, _ := json.Marshal(L)
+		return string(b)
+	}
+	tests := []struct {
+		name    string
+		payload string
+		status  string
+		note    string // подстрока debug.notes; "" — не сверяем
+		code    string // код ошибки; "" — успех
+	}{
+		{"odd cyclic", `{"n":7,"square":` + sq(latin.MakeCyclic(7, 1)) + `}`, "done", "", ""},
+		{"cyclic 2", `{"n":2,"square":` + sq(latin.MakeCyclic(2, 1)) + `}`, "no_solution", "no search needed", ""},
+		{"cyclic 6", `{"n":6,"square":` + sq(latin.MakeCyclic(6, 1)) + `}`, "no_solution", "no search needed", ""},
+		{"not Latin", `{"n":2,"square":[[0,1],[0,1]]}`, "invalid_input", "", "INVALID_SQUARE"},
+		{"wrong shape", `{"n":3,"square":[[0,1],[1,0]]}`, "invalid_input", "", "BAD_SQUARE_SHAPE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"find_transversal","seed":1,"budget":{"time_limit_sec":10},"payload":`+tt.payload+`}`)
+			if resp.Status != tt.status {
+				t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.status)
+			}
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("error %+v; want %s", resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultTransversal)
+			debug, _ := resp.Debug.(DebugInfo)
+			if tt.note != "" && !strings.Contains(debug.Notes, tt.note) {
+				t.Errorf("notes %q, want %q", debug.Notes, tt.note)
+			}
+			if tt.status != "done" {
+				if resp.Ok || res.Found || res.Cells != nil {
+					t.Errorf("ok %v, result %+v", resp.Ok, res)
+				}
+				return
+			}
+			if !resp.Ok || !res.Found || !res.Verified || len(res.Cells) != res.N || len(res.Symbols) != res.N {
+				t.Fatalf("ok %v, result %+v", resp.Ok, res)
+			}
+			var p struct {
+				Square [][]int `json:"square"`
+			}
+			json.Unmarshal([]byte(tt.payload), &p)
+			L := p.Square
+			rows, cols, syms := map[int]bool{}, map[int]bool{}, map[int]bool{}
+			for k, c := range res.Cells {
+				if L[c[0]][c[1]] != res.Symbols[k] {
+					t.Errorf("cell %v holds %d, symbols say %d", c, L[c[0]][c[1]], res.Symbols[k])
+				}
+				rows[c[0]], cols[c[1]], syms[res.Symbols[k]] = true, true, true
+			}
+			if len(rows) != res.N || len(cols) != res.N || len(syms) != res.N {
+				t.Errorf("cells %v are not a transversal", res.Cells)
+			}
+		})
+	}
+}
+
+func TestUnwritableOut(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		out  string
+		in   string
+	}{
+		{"no such directory", filepath.Join(dir, "nope", "out.json"), `{"problem":"verify_latin_square","payload":{"square":[[0]]}}`},
+		{"out is a directory", dir, `{"problem":"verify_latin_square","payload":{"square":[[0]]}}`},
+		// код 5 при любом статусе: балансер должен знать, что ответа нет
+		{"no solution", filepath.Join(dir, "nope", "out.json"), `{"problem":"complete_latin_square_from_prefix","payload":{"n":2,"prefix":[[0,null],[null,1]]}}`},
+		{"invalid input", filepath.Join(dir, "nope", "out.json"), `{"problem":"no_such_problem","payload":{}}`},
+		{"batch", filepath.Join(dir, "nope", "out.json"), `[{"problem":"verify_latin_square","payload":{"square":[[0]]}}]`},
+		{"gzip", filepath.Join(dir, "nope", "out.json.gz"), `{"problem":"verify_latin_square","payload":{"square":[[0]]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := workerCmd("-in", "-", "-out", tt.out, "-no-min-runtime")
+			cmd.Stdin = strings.NewReader(tt.in)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			err := cmd.Run()
+			var exit *exec.ExitError
+			if !errors.As(err, &exit) || exit.ExitCode() != exitWriteFailed {
+				t.Fatalf("exit %v, want %d; stderr %q", err, exitWriteFailed, stderr.String())
+			}
+			if !strings.Contains(stderr.String(), "write "+tt.out) {
+				t.Errorf("stderr %q does not name %s", stderr.String(), tt.out)
+			}
+		})
+	}
+
+	// сама writeOut возвращает ошибку, а не глотает её
+	if err := writeOut(filepath.Join(dir, "nope", "out.json"), OutResponse{}); err == nil {
+		t.Error("writeOut to a missing directory: no error")
+	}
+	if err := writeOut(filepath.Join(dir, "ok.json"), OutResponse{}); err != nil {
+		t.Errorf("writeOut: %v", err)
+	}
+}
+
+func TestCompleteEngineLocal(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		prefix string // "" — пустой
+		extra  string // добавка к payload
+		budget string
+		status string
+		note   string // подстрока debug.notes
+		code   string // код ошибки; "" — без ошибки
+	}{
+		{"empty 400x400", 400, "", "", `{"time_limit_sec":30}`, "done", "prefix is empty", ""},
+		{"prefix kept", 5, `[[0,null,null,null,null],[null,null,null,null,null],[null,null,3,null,null],[null,null,null,null,null],[null,4,null,null,1]]`, "", `{"time_limit_sec":10}`, "done", "", ""},
+		{"incomplete", 2, `[[0,null],[null,1]]`, "", `{"time_limit_sec":10,"max_steps":1000}`, "no_solution", "engine=local is incomplete", ""},
+		{"diagonal", 5, "", `,"constraints":{"diagonal":true}`, `{"time_limit_sec":10}`, "invalid_input", "", "BAD_ENGINE"},
+		{"forbidden", 3, "", `,"forbidden":[[[0],[],[]],[[],[],[]],[[],[],[]]]`, `{"time_limit_sec":10}`, "invalid_input", "", "BAD_ENGINE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := tt.prefix
+			if prefix == "" {
+				prefix = nullPrefix(tt.n)
+			}
+			resp := solve(t, `{"problem":"complete_latin_square_from_prefix","seed":1,"budget":`+tt.budget+`,
+				"payload":{"n":`+strconv.Itoa(tt.n)+`,"engine":"local","prefix":`+prefix+tt.extra+`}}`)
+			if resp.Status != tt.status {
+				t.Fatalf("status %q, error %+v; want %s", resp.Status, resp.Error, tt.status)
+			}
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("error %+v; want %s", resp.Error, tt.code)
+				}
+				return
+			}
+			res, _ := resp.Result.(ResultComplete)
+			debug, _ := resp.Debug.(DebugInfo)
+			if !strings.Contains(debug.Notes, tt.note) {
+				t.Errorf("notes %q, want %q", debug.Notes, tt.note)
+			}
+			if tt.status != "done" {
+				if resp.Ok || res.SolutionFound || debug.BudgetHit == nil || debug.BudgetHit.Kind != "steps" {
+					t.Errorf("ok %v, found %v, budget_hit %+v", resp.Ok, res.SolutionFound, debug.BudgetHit)
+				}
+				return
+			}
+			if !resp.Ok || !res.SolutionFound || !res.VerifiedLatin || !latin.IsLatinSquare(res.Square) {
+				t.Fatalf("ok %v, found %v, verified %v", resp.Ok, res.SolutionFound, res.VerifiedLatin)
+			}
+			var cells [][]*int
+			if err := json.Unmarshal([]byte(prefix), &cells); err != nil {
+				t.Fatal(err)
+			}
+			for i := range cells {
+				for j, v := range cells[i] {
+					if v != nil && res.Square[i][j] != *v {
+						t.Errorf("(%d,%d) = %d, prefix says %d", i, j, res.Square[i][j], *v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSearchStatsDebug(t *testing.T) {
+	// 0 1 в углу 5x5: у части ветвей нет дополнений, без откатов не обойтись
+	const prefix = `[[0,1,null,null,null],[1,null,null,null,null],[null,null,null,null,null],[null,null,null,null,null],[null,null,null,null,null]]`
+	for _, problem := range []string{"count_latin_completions", "complete_latin_square_from_prefix"} {
+		resp := solve(t, `{"problem":"`+problem+`","seed":1,"budget":{"time_limit_sec":10},"payload":{"n":5,"prefix":`+prefix+`}}`)
+		debug, _ := resp.Debug.(DebugInfo)
+		if resp.Status != "done" || debug.MaxDepth < 1 || debug.AvgBranch < 1 {
+			t.Fatalf("%s: status %q, debug %+v", problem, resp.Status, debug)
+		}
+		if problem == "count_latin_completions" && debug.Backtracks == 0 {
+			t.Errorf("%s: no backtracks over the whole tree, debug %+v", problem, debug)
+		}
+		if debug.Backtracks > debug.Nodes {
+			t.Errorf("%s: %d backtracks over %d nodes", problem, debug.Backtracks, debug.Nodes)
+		}
+	}
+}
+
+func TestOrderOne(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		status string
+		code   string // код ошибки; "" — успех
+	}{
+		{"complete empty", `{"problem":"complete_latin_square_from_prefix","payload":{"n":1,"prefix":[[null]]}}`, "done", ""},
+		{"complete filled", `{"problem":"complete_latin_square_from_prefix","payload":{"n":1,"prefix":[[0]]}}`, "done", ""},
+		{"complete dlx", `{"problem":"complete_latin_square_from_prefix","payload":{"n":1,"prefix":[[null]],"engine":"dlx"}}`, "done", ""},
+		{"complete local", `{"problem":"complete_latin_square_from_prefix","payload":{"n":1,"prefix":[[null]],"engine":"local"}}`, "done", ""},
+		{"complete diagonal boxes", `{"problem":"complete_latin_square_from_prefix","payload":{"n":1,"prefix":[[null]],"constraints":{"diagonal":true,"boxes":true}}}`, "done", ""},
+		{"search_mols", `{"problem":"search_mols","payload":{"n":1,"k":2}}`, "invalid_input", "BAD_K"},
+		{"mols_seed_scan", `{"problem":"mols_seed_scan","payload":{"n":1,"seed_from":1,"seed_to":2}}`, "invalid_input", "BAD_K"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, tt.in)
+			if resp.Status != tt.status {
+				t.Fatalf("status %q, want %q (error %+v)", resp.Status, tt.status, resp.Error)
+			}
+			if tt.code != "" {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Fatalf("error %+v, want code %s", resp.Error, tt.code)
+				}
+				return
+			}
+			res, ok := resp.Result.(ResultComplete)
+			if !ok || !res.SolutionFound || !res.VerifiedLatin {
+				t.Fatalf("result %+v", resp.Result)
+			}
+			if len(res.Square) != 1 || len(res.Square[0]) != 1 || res.Square[0][0] != 0 {
+				t.Errorf("square %v, want [[0]]", res.Square)
+			}
+		})
+	}
+
+	// одно дополнение у пустой 1x1
+	resp := solve(t, `{"problem":"count_latin_completions","payload":{"n":1,"prefix":[[null]]}}`)
+	if res, ok := resp.Result.(ResultCount); !ok || resp.Status != "done" || res.Count != 1 || !res.Exact {
+		t.Errorf("count: status %q, result %+v", resp.Status, resp.Result)
+	}
+}
+
+func TestMOLSConflictMatrix(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		matrix  bool // ждём conflict_matrix в ответе
+	}{
+		{"k=3 not found", `{"n":10,"k":3}`, true},
+		{"k=4 found", `{"n":5,"k":4}`, true},
+		{"galois k=3", `{"n":4,"k":3,"method":"galois"}`, true},
+		// при k = 2 матрица — это просто conflicts
+		{"k=2", `{"n":5,"k":2}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"search_mols","seed":1,"budget":{"time_limit_sec":10,"max_steps":20000},
+				"output":{"return_squares":true},"payload":`+tt.payload+`}`)
+			res, ok := resp.Result.(ResultMOLS)
+			if !ok || len(res.L) != res.K {
+				t.Fatalf("status %q, result %+v", resp.Status, resp.Result)
+			}
+			if !tt.matrix {
+				if res.ConflictMatrix != nil {
+					t.Errorf("conflict_matrix %v for k=%d", res.ConflictMatrix, res.K)
+				}
+				return
+			}
+			m := res.ConflictMatrix
+			if len(m) != res.K {
+				t.Fatalf("conflict_matrix has %d rows, k=%d", len(m), res.K)
+			}
+			total := 0
+			for a := range m {
+				if len(m[a]) != res.K || m[a][a] != 0 {
+					t.Fatalf("row %d = %v", a, m[a])
+				}
+				for b := range m[a] {
+					if m[a][b] != m[b][a] {
+						t.Errorf("m[%d][%d] = %d, m[%d][%d] = %d", a, b, m[a][b], b, a, m[b][a])
+					}
+					if a < b {
+						want, _ := latin.OrthConflicts(res.L[a], res.L[b])
+						if m[a][b] != want {
+							t.Errorf("m[%d][%d] = %d, recomputed %d", a, b, m[a][b], want)
+						}
+						total += m[a][b]
+					}
+				}
+			}
+			if res.Found != (total == 0) {
+				t.Errorf("found %v with %d conflicts in the matrix", res.Found, total)
+			}
+		})
+	}
+}
+
+func TestCountModulus(t *testing.T) {
+	// 576 латинских квадратов 4x4 и 161280 — 5x5
+	tests := []struct {
+		name    string
+		n       int
+		extra   string
+		modulus int64
+		want    int64
+	}{
+		{"n=4", 4, "", 7, 576 % 7},
+		{"n=5", 5, "", 101, 161280 % 101},
+		{"n=5 dlx", 5, `,"engine":"dlx"`, 101, 161280 % 101},
+		{"modulus above count", 4, "", 1_000_000_007, 576},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"count_latin_completions","budget":{"time_limit_sec":30},
+				"payload":{"n":`+strconv.Itoa(tt.n)+`,"modulus":`+strconv.FormatInt(tt.modulus, 10)+tt.extra+`,"prefix":`+nullPrefix(tt.n)+`}}`)
+			res, _ := resp.Result.(ResultCount)
+			if resp.Status != "done" || !res.Exact || res.CountMod == nil || *res.CountMod != tt.want {
+				t.Fatalf("status %q, result %+v; want count_mod %d", resp.Status, resp.Result, tt.want)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name    string
+		problem string
+		modulus string
+	}{
+		{"composite", "count_latin_completions", "100"},
+		{"square of a prime", "count_latin_completions", "49"},
+		{"one", "count_latin_completions", "1"},
+		{"negative", "count_latin_completions", "-7"},
+		{"not a count", "complete_latin_square_from_prefix", "7"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := solve(t, `{"problem":"`+tt.problem+`","payload":{"n":4,"modulus":`+tt.modulus+`,"prefix":`+nullPrefix(4)+`}}`)
+			if resp.Error == nil || resp.Error.Code != "BAD_MODULUS" {
+				t.Fatalf("status %q, error %+v; want BAD_MODULUS", resp.Status, resp.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRequestBytes ограничивает тело POST в -serve (после gunzip не проверяется)
+const maxRequestBytes = 64 << 20
+
+// serveStats are the counters /metrics exports. They only grow while the
+// process lives, as Prometheus counters should.
+type serveStats struct {
+	mu       sync.Mutex
+	byStatus map[string]int64
+	nodes    int64
+	steps    int64
+	rejected int64 // 429: все -max-inflight мест заняты
+	inflight int64
+}
+
+// reject counts one request turned away with 429.
+func (st *serveStats) reject() {
+	st.mu.Lock()
+	st.rejected++
+	st.mu.Unlock()
+}
+
+// running moves the in-flight gauge by delta.
+func (st *serveStats) running(delta int64) {
+	st.mu.Lock()
+	st.inflight += delta
+	st.mu.Unlock()
+}
+
+// add counts one finished request.
+func (st *serveStats) add(resp OutResponse) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.byStatus[resp.Status]++
+	if d, ok := resp.Debug.(DebugInfo); ok {
+		st.nodes += d.Nodes
+		st.steps += d.Steps
+	}
+}
+
+// writeTo renders the counters in the Prometheus text format.
+func (st *serveStats) writeTo(w io.Writer) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	statuses := make([]string, 0, len(st.byStatus))
+	for s := range st.byStatus {
+		statuses = append(statuses, s)
+	}
+	sort.Strings(statuses)
+
+	var b strings.Builder
+	b.WriteString("# HELP ls_worker_tasks_total Requests finished, by response status.\n")
+	b.WriteString("# TYPE ls_worker_tasks_total counter\n")
+	for _, s := range statuses {
+		fmt.Fprintf(&b, "ls_worker_tasks_total{status=%q} %d\n", s, st.byStatus[s])
+	}
+	counter := func(name, help string, v int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+	counter("ls_worker_tasks_solved_total", "Requests that ended done or solution_limit.", st.byStatus["done"]+st.byStatus["solution_limit"])
+	counter("ls_worker_timeouts_total", "Requests that ran out of time or nodes.", st.byStatus["timeout"]+st.byStatus["node_limit"])
+	counter("ls_worker_nodes_total", "Search nodes over all requests (DFS, DLX).", st.nodes)
+	counter("ls_worker_steps_total", "Local search steps over all requests (MOLS, MCMC).", st.steps)
+	counter("ls_worker_rejected_total", "Requests answered 429 because -max-inflight solves were running.", st.rejected)
+	fmt.Fprintf(&b, "# HELP ls_worker_inflight Requests being solved now.\n# TYPE ls_worker_inflight gauge\nls_worker_inflight %d\n", st.inflight)
+	_, _ = io.WriteString(w, b.String())
+}
+
+// serve runs the worker as an HTTP service on addr, with the handlers of
+// newServeMux, until ctx is cancelled.
+//
+// Requests run concurrently in one process and share no mutable state: the
+// search state and the CPU baseline of the metrics are built per request.
+// What the process has only one of is reported or left alone: cpu_* and
+// max_rss_kb in the metrics cover every request in flight, and
+// budget.max_cores and budget.max_rss_kb are ignored rather than changing
+// GOMAXPROCS or the memory limit under the other requests. min_runtime_sec
+// is ignored too: padding would only hold a -max-inflight slot.
+func serve(ctx context.Context, addr string, strict bool, maxInflight int, env runEnv) error {
+	if maxInflight < 1 {
+		return fmt.Errorf("-max-inflight=%d, want >= 1", maxInflight)
+	}
+	mux := newServeMux(strict, maxInflight, env, &serveStats{byStatus: map[string]int64{}})
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "serving on %s\n", ln.Addr())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// запросы уже отменены через BaseContext — ждём, пока они допишут ответы
+		shutCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutCtx)
+	}
+}
+
+// readServeRequest reads and decodes the body of POST /: a single request,
+// gzip detected by its magic bytes as in readIn. Batches are rejected — one
+// HTTP request per task keeps the responses and the counters simple.
+func readServeRequest(w http.ResponseWriter, r *http.Request, strict bool) (InRequest, error) {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		return InRequest{}, fmt.Errorf("read body: %w", err)
+	}
+	if bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		if b, err = gunzip(b); err != nil {
+			return InRequest{}, fmt.Errorf("gunzip body: %w", err)
+		}
+	}
+	reqs, batch, err := decodeIn(b, strict)
+	if err != nil {
+		return InRequest{}, err
+	}
+	if batch {
+		return InRequest{}, errors.New("batches are not accepted by -serve, POST one request at a time")
+	}
+	return reqs[0], nil
+}
+
+// newServeMux builds the -serve handlers: POST / takes one request (the
+// same JSON as -in, gzip allowed) and answers with its response, GET
+// /metrics exports st. The HTTP status is 200 whenever there is an
+// OutResponse to send, whatever its status; 400 for a body that is not a
+// request at all and 429 while maxInflight requests are already being
+// solved.
+func newServeMux(strict bool, maxInflight int, env runEnv, st *serveStats) *http.ServeMux {
+	slots := make(chan struct{}, maxInflight)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "POST a request", http.StatusMethodNotAllowed)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		default:
+			st.reject()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("busy: %d requests in flight (-max-inflight)", maxInflight), http.StatusTooManyRequests)
+			return
+		}
+		st.running(1)
+		defer st.running(-1)
+		startWall, cpuStart := time.Now(), processCPU()
+		req, err := readServeRequest(w, r, strict)
+		if err != nil {
+			code := "BAD_JSON"
+			var be *budgetError
+			if errors.As(err, &be) {
+				code = "BAD_BUDGET"
+			}
+			resp := OutResponse{
+				Ok:      false,
+				Status:  "invalid_input",
+				Metrics: finishMetrics(startWall.Unix(), startWall, env.host),
+				Error:   &OutError{Code: code, Message: err.Error()},
+			}
+			resp.Metrics.sinceCPU(cpuStart)
+			st.add(resp)
+			writeServeResponse(w, http.StatusBadRequest, resp)
+			return
+		}
+		// r.Context() отменяется и при разрыве соединения, и при остановке сервера
+		resp := runRequest(r.Context(), req, env)
+		st.add(resp)
+		writeServeResponse(w, http.StatusOK, resp)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		st.writeTo(w)
+	})
+	return mux
+}
+
+func writeServeResponse(w http.ResponseWriter, code int, resp OutResponse) {
+	b, _ := json.MarshalIndent(resp, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(append(b, '\n'))
+}